@@ -0,0 +1,337 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	au "github.com/rafa-mori/smart_plane/internal/authentication"
+	"github.com/spf13/cobra"
+)
+
+// authOptions holds the flags shared by the auth commands.
+type authOptions struct {
+	keystore string
+	output   string
+	// ttl is the configured token validity, used when --ttl is not given.
+	ttl time.Duration
+}
+
+// tokenResult is the output of the token commands.
+type tokenResult struct {
+	Token  string                 `json:"token,omitempty"`
+	Valid  *bool                  `json:"valid,omitempty"`
+	Header map[string]interface{} `json:"header,omitempty"`
+	Claims *au.TokenClaims        `json:"claims"`
+}
+
+func defaultKeystoreDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".", ".smart_plane", "keystore")
+	}
+	return filepath.Join(home, ".smart_plane", "keystore")
+}
+
+// AuthCmd returns the command group managing the token signing keys and the tokens.
+func AuthCmd() *cobra.Command {
+	opts := &authOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Manage the token signing keys and issue, inspect and verify tokens",
+		Long: "Manage the RSA keys of the local keystore and the JWTs signed with them.\n" +
+			"The keystore directory and its keys must only be accessible by their owner.\n" +
+			"Exit codes: 2 usage, 4 invalid token, 6 keystore unavailable.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd, authBindings)
+			if err != nil {
+				return err
+			}
+			opts.keystore, opts.ttl = cfg.Auth.Keystore, cfg.Auth.TokenTTL
+			return nil
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&opts.keystore, "keystore", "k", defaultKeystoreDir(), "Keystore directory")
+	cmd.PersistentFlags().StringVarP(&opts.output, "output", "o", OutputTable, "Output format (json, yaml, table)")
+
+	keys := &cobra.Command{
+		Use:   "keys",
+		Short: "Generate, show and rotate the signing keys",
+	}
+	keys.AddCommand(authKeysGenerateCmd(opts), authKeysShowCmd(opts), authKeysRotateCmd(opts))
+
+	token := &cobra.Command{
+		Use:   "token",
+		Short: "Issue, inspect and verify tokens",
+	}
+	token.AddCommand(authTokenIssueCmd(opts), authTokenInspectCmd(opts), authTokenVerifyCmd(opts))
+
+	cmd.AddCommand(keys, token)
+	return cmd
+}
+
+func authKeysGenerateCmd(opts *authOptions) *cobra.Command {
+	var bits int
+
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate the first signing key of the keystore",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ks, err := opts.open()
+			if err != nil {
+				return err
+			}
+			info, err := ks.Generate(bits)
+			if err != nil {
+				return err
+			}
+			return printOutput(cmd, opts.output, info, nil)
+		},
+	}
+	cmd.Flags().IntVar(&bits, "bits", au.DefaultKeyBits, "RSA key size")
+	return cmd
+}
+
+func authKeysShowCmd(opts *authOptions) *cobra.Command {
+	var public bool
+
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "List the keys of the keystore, or print the current public key",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ks, err := opts.open()
+			if err != nil {
+				return err
+			}
+			if public {
+				key, err := ks.GetPublicKey()
+				if err != nil {
+					return err
+				}
+				data, err := au.PublicKeyPEM(key)
+				if err != nil {
+					return err
+				}
+				_, err = cmd.OutOrStdout().Write(data)
+				return err
+			}
+			keys, err := ks.Keys()
+			if err != nil {
+				return err
+			}
+			return printOutput(cmd, opts.output, keys, func(w io.Writer) error {
+				_, _ = fmt.Fprintln(w, "ID\tCURRENT\tBITS\tCREATED\tRETIRED\tFINGERPRINT")
+				for _, info := range keys {
+					retired := "-"
+					if info.RetiredAt != nil {
+						retired = info.RetiredAt.Format(time.RFC3339)
+					}
+					if _, err := fmt.Fprintf(w, "%s\t%t\t%d\t%s\t%s\t%s\n", info.ID, info.Current, info.Bits,
+						info.CreatedAt.Format(time.RFC3339), retired, info.Fingerprint); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&public, "public", false, "Print the current public key as PEM")
+	return cmd
+}
+
+func authKeysRotateCmd(opts *authOptions) *cobra.Command {
+	var bits, retain int
+
+	cmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Make a new key current, keeping the previous ones to verify their tokens",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ks, err := opts.open()
+			if err != nil {
+				return err
+			}
+			info, err := ks.Rotate(bits, retain)
+			if err != nil {
+				return err
+			}
+			return printOutput(cmd, opts.output, info, nil)
+		},
+	}
+	cmd.Flags().IntVar(&bits, "bits", au.DefaultKeyBits, "RSA key size")
+	cmd.Flags().IntVar(&retain, "retain", 2, "Retired keys to keep for verification (-1 keeps all)")
+	return cmd
+}
+
+func authTokenIssueCmd(opts *authOptions) *cobra.Command {
+	var subject string
+	var roles []string
+
+	cmd := &cobra.Command{
+		Use:     "issue",
+		Short:   "Issue a token signed with the current key",
+		Example: "smart_plane auth token issue --sub alice --roles admin,auditor --ttl 1h",
+		Args:    usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if subject == "" {
+				return &UsageError{Err: fmt.Errorf("--sub is required")}
+			}
+			if opts.ttl <= 0 {
+				return &UsageError{Err: fmt.Errorf("--ttl must be positive")}
+			}
+			am, err := opts.manager()
+			if err != nil {
+				return err
+			}
+			token, claims, err := am.IssueToken(subject, roles, opts.ttl)
+			if err != nil {
+				return err
+			}
+			if opts.output == OutputTable {
+				_, err = fmt.Fprintln(cmd.OutOrStdout(), token)
+				return err
+			}
+			return printOutput(cmd, opts.output, tokenResult{Token: token, Claims: claims}, nil)
+		},
+	}
+	cmd.Flags().StringVar(&subject, "sub", "", "Subject of the token")
+	cmd.Flags().StringSliceVar(&roles, "roles", nil, "Comma separated roles of the subject")
+	cmd.Flags().Duration("ttl", time.Hour, "Validity of the token, overriding auth.token_ttl")
+	return cmd
+}
+
+func authTokenInspectCmd(opts *authOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "inspect <jwt>",
+		Short: "Decode a token without verifying it (- reads it from stdin)",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutputFormat(opts.output); err != nil {
+				return err
+			}
+			token, err := readToken(cmd, args[0])
+			if err != nil {
+				return err
+			}
+			header, claims, err := au.InspectToken(token)
+			if err != nil {
+				return err
+			}
+			return printClaims(cmd, opts.output, tokenResult{Header: header, Claims: claims})
+		},
+	}
+}
+
+func authTokenVerifyCmd(opts *authOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify <jwt>",
+		Short: "Verify the signature and the validity of a token (- reads it from stdin)",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutputFormat(opts.output); err != nil {
+				return err
+			}
+			token, err := readToken(cmd, args[0])
+			if err != nil {
+				return err
+			}
+			am, err := opts.manager()
+			if err != nil {
+				return err
+			}
+			claims, err := am.VerifyToken(token)
+			if err != nil {
+				return err
+			}
+			valid := true
+			return printClaims(cmd, opts.output, tokenResult{Valid: &valid, Claims: claims})
+		},
+	}
+}
+
+// open opens the keystore, mapping its errors to ExitUnavailable.
+func (opts *authOptions) open() (*au.KeyStore, error) {
+	if err := checkOutputFormat(opts.output); err != nil {
+		return nil, err
+	}
+	ks, err := au.OpenKeyStore(opts.keystore)
+	if err != nil {
+		return nil, &UnavailableError{Err: err}
+	}
+	return ks, nil
+}
+
+// manager creates the AuthManager of the keystore.
+func (opts *authOptions) manager() (*au.AuthManager, error) {
+	ks, err := opts.open()
+	if err != nil {
+		return nil, err
+	}
+	am, err := au.NewAuthManagerWithKeyStore(ks)
+	if err != nil {
+		return nil, &UnavailableError{Err: err}
+	}
+	return am, nil
+}
+
+// printClaims prints a decoded token; the table format lists the claims one per row.
+func printClaims(cmd *cobra.Command, format string, result tokenResult) error {
+	return printOutput(cmd, format, result, func(w io.Writer) error {
+		if result.Valid != nil {
+			_, _ = fmt.Fprintf(w, "valid\t%t\n", *result.Valid)
+		}
+		if alg, ok := result.Header["alg"]; ok {
+			_, _ = fmt.Fprintf(w, "alg\t%v\n", alg)
+		}
+		if kid, ok := result.Header["kid"]; ok {
+			_, _ = fmt.Fprintf(w, "kid\t%v\n", kid)
+		}
+		claims := result.Claims
+		_, _ = fmt.Fprintf(w, "sub\t%s\n", claims.Subject)
+		_, _ = fmt.Fprintf(w, "roles\t%s\n", strings.Join(claims.Roles, ","))
+		_, _ = fmt.Fprintf(w, "jti\t%s\n", claims.ID)
+		if claims.IssuedAt != nil {
+			_, _ = fmt.Fprintf(w, "iat\t%s\n", claims.IssuedAt.Format(time.RFC3339))
+		}
+		if claims.ExpiresAt != nil {
+			state := "expires"
+			if claims.ExpiresAt.Before(time.Now()) {
+				state = "expired"
+			}
+			_, _ = fmt.Fprintf(w, "exp\t%s (%s %s)\n", claims.ExpiresAt.Format(time.RFC3339), state,
+				humanDuration(time.Until(claims.ExpiresAt.Time)))
+		}
+		return nil
+	})
+}
+
+// humanDuration formats the distance to a point in time, such as "in 59m0s" or "3h0m0s ago".
+func humanDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d < 0 {
+		return (-d).String() + " ago"
+	}
+	return "in " + d.String()
+}
+
+// readToken returns the token argument, reading it from stdin for "-".
+func readToken(cmd *cobra.Command, arg string) (string, error) {
+	if arg != "-" {
+		return strings.TrimSpace(arg), nil
+	}
+	data, err := io.ReadAll(cmd.InOrStdin())
+	if err != nil {
+		return "", &UnavailableError{Err: err}
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", &UsageError{Err: fmt.Errorf("no token on stdin")}
+	}
+	return token, nil
+}
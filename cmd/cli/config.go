@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"sort"
+	"strings"
+
+	cf "github.com/rafa-mori/smart_plane/internal/config"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// ConfigFlag is the root flag naming the configuration file.
+const ConfigFlag = "config"
+
+// Flag bindings of the commands reading the configuration, from flag name to configuration key.
+var (
+	ledgerBindings = map[string]string{
+		"backend":    "ledger.backend",
+		"ledger-dir": "ledger.dir",
+		"schema-dir": "ledger.schema_dir",
+	}
+	authBindings = map[string]string{
+		"keystore": "auth.keystore",
+		"ttl":      "auth.token_ttl",
+	}
+)
+
+// loadConfig loads and validates the configuration, the flags of cmd named in bindings
+// overriding the file and the environment, and applies its logging level.
+func loadConfig(cmd *cobra.Command, bindings map[string]string) (*cf.Config, error) {
+	cfg, err := loadConfigFile(configOptions(cmd, bindings))
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	gl.SetDebug(cfg.Logging.Level == "debug")
+	return cfg, nil
+}
+
+// loadConfigFile loads the configuration; errors other than invalid contents map to
+// ExitUnavailable.
+func loadConfigFile(opts cf.Options) (*cf.Config, error) {
+	cfg, err := cf.Load(opts)
+	if err != nil {
+		var invalid *cf.ValidationError
+		if errors.As(err, &invalid) || errors.Is(err, cf.ErrUnsupportedFormat) {
+			return nil, err
+		}
+		return nil, &UnavailableError{Err: err}
+	}
+	return cfg, nil
+}
+
+// configOptions returns the sources of the configuration of cmd: the --config file and the
+// flags of cmd named in bindings.
+func configOptions(cmd *cobra.Command, bindings map[string]string) cf.Options {
+	opts := cf.Options{File: configFile(cmd), Flags: make(map[string]*pflag.Flag, len(bindings))}
+	for name, key := range bindings {
+		if flag := cmd.Flags().Lookup(name); flag != nil {
+			opts.Flags[key] = flag
+		}
+	}
+	return opts
+}
+
+// overriddenKeys returns the sorted configuration keys that the flags set on the command line
+// override.
+func overriddenKeys(opts cf.Options) []string {
+	keys := make([]string, 0, len(opts.Flags))
+	for key, flag := range opts.Flags {
+		if flag.Changed {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// addOverrideFlags adds the flags of every command reading the configuration, so that the
+// config commands see the settings those commands would use, and returns their bindings.
+func addOverrideFlags(cmd *cobra.Command) map[string]string {
+	defaults := cf.Default()
+	cmd.Flags().StringP("backend", "b", defaults.Ledger.Backend, "Ledger backend ("+lg.BackendFile+", "+lg.BackendMemory+")")
+	cmd.Flags().StringP("ledger-dir", "l", defaults.Ledger.Dir, "Ledger directory for the file backend")
+	cmd.Flags().String("schema-dir", defaults.Ledger.SchemaDir, "Directory of the contract payload schemas")
+	cmd.Flags().StringP("keystore", "k", defaults.Auth.Keystore, "Keystore directory")
+	cmd.Flags().Duration("ttl", defaults.Auth.TokenTTL, "Validity of the tokens")
+
+	bindings := maps.Clone(ledgerBindings)
+	maps.Copy(bindings, authBindings)
+	return bindings
+}
+
+// configFile returns the value of the --config flag, if the command has one.
+func configFile(cmd *cobra.Command) string {
+	if flag := cmd.Flags().Lookup(ConfigFlag); flag != nil {
+		return flag.Value.String()
+	}
+	return ""
+}
+
+// ConfigCmd returns the command group inspecting and creating the configuration file.
+func ConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show, validate and create the configuration",
+		Long: "The configuration is layered: built-in defaults, then the configuration file (YAML, TOML\n" +
+			"or JSON), then " + cf.EnvPrefix + "_ environment variables (ledger.dir is " + cf.EnvPrefix + "_LEDGER_DIR),\n" +
+			"then command line flags. The file is --config, " + cf.EnvConfigFile + ", or config.* in " + cf.Dir() + ".\n" +
+			"show and validate take the flags of the other commands, to check the settings they would use.",
+	}
+	cmd.AddCommand(configShowCmd(), configValidateCmd(), configInitCmd())
+	return cmd
+}
+
+func configShowCmd() *cobra.Command {
+	var output string
+	var bindings map[string]string
+
+	cmd := &cobra.Command{
+		Use:     "show",
+		Short:   "Show the effective configuration",
+		Example: "smart_plane config show\n  smart_plane config show --ledger-dir /var/lib/smart_plane -o yaml",
+		Args:    usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutputFormat(output); err != nil {
+				return err
+			}
+			opts := configOptions(cmd, bindings)
+			cfg, err := loadConfigFile(opts)
+			if err != nil {
+				return err
+			}
+			settings := cfg.RedactedSettings()
+			return printOutput(cmd, output, settings, func(w io.Writer) error {
+				file := cfg.File()
+				if file == "" {
+					file = "(none, defaults)"
+				}
+				_, _ = fmt.Fprintf(w, "# file: %s\n", file)
+				if keys := overriddenKeys(opts); len(keys) > 0 {
+					_, _ = fmt.Fprintf(w, "# flags: %s\n", strings.Join(keys, ", "))
+				}
+				_, _ = fmt.Fprintln(w, "KEY\tVALUE")
+				for _, row := range flattenSettings("", settings) {
+					if _, err := fmt.Fprintf(w, "%s\t%s\n", row[0], row[1]); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", OutputTable, "Output format (json, yaml, table)")
+	bindings = addOverrideFlags(cmd)
+	return cmd
+}
+
+func configValidateCmd() *cobra.Command {
+	var bindings map[string]string
+
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the effective configuration",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfigFile(configOptions(cmd, bindings))
+			if err != nil {
+				return err
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			file := cfg.File()
+			if file == "" {
+				file = "defaults"
+			}
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "configuration is valid (%s)\n", file)
+			return err
+		},
+	}
+	bindings = addOverrideFlags(cmd)
+	return cmd
+}
+
+func configInitCmd() *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:     "init [path]",
+		Short:   "Write a configuration file with the default settings",
+		Example: "smart_plane config init\n  smart_plane config init ./smart_plane.toml --force",
+		Args:    usageArgs(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := cf.DefaultFile()
+			if len(args) > 0 {
+				path = args[0]
+			} else if file := configFile(cmd); file != "" {
+				path = file
+			}
+			if err := cf.Default().WriteFile(path, force); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintf(cmd.OutOrStdout(), "configuration written to %s\n", path)
+			return err
+		},
+	}
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Replace an existing file")
+	return cmd
+}
+
+// flattenSettings returns the settings as sorted dotted KEY/VALUE rows.
+func flattenSettings(prefix string, settings map[string]any) [][2]string {
+	var rows [][2]string
+	for key, value := range settings {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			rows = append(rows, flattenSettings(key, nested)...)
+			continue
+		}
+		rows = append(rows, [2]string{key, formatValue(value)})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return rows
+}
@@ -0,0 +1,259 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	cf "github.com/rafa-mori/smart_plane/internal/config"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	sc "github.com/rafa-mori/smart_plane/internal/smart_contracts"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	"github.com/spf13/cobra"
+)
+
+// documentOptions holds the flags shared by the document commands.
+type documentOptions struct {
+	backend   string
+	ledgerDir string
+	schemaDir string
+	contract  string
+	output    string
+	// enabled restricts the manager to these contracts; empty enables all of them.
+	enabled []string
+	// webhooks and broker receive the ledger events committed by the commands.
+	webhooks cf.WebhooksConfig
+	broker   cf.BrokerConfig
+}
+
+// documentResult is the output of the document write commands.
+type documentResult struct {
+	Contract string `json:"contract"`
+	ID       string `json:"id"`
+	Action   string `json:"action"`
+	Status   string `json:"status"`
+}
+
+// DocumentCmd returns the command group operating on contract documents.
+func DocumentCmd() *cobra.Command {
+	opts := &documentOptions{}
+
+	cmd := &cobra.Command{
+		Use:     "document",
+		Aliases: []string{"doc"},
+		Short:   "Register, query, approve, sign and delete contract documents",
+		Long: "Operate on the documents of the smart contracts through the blockchain manager.\n" +
+			"Exit codes: 2 usage, 3 not found, 4 invalid payload, 5 conflict, 6 ledger unavailable.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd, ledgerBindings)
+			if err != nil {
+				return err
+			}
+			opts.backend, opts.ledgerDir, opts.schemaDir = cfg.Ledger.Backend, cfg.Ledger.Dir, cfg.Ledger.SchemaDir
+			opts.enabled = cfg.Contracts.Enabled
+			opts.webhooks, opts.broker = cfg.Webhooks, cfg.Broker
+			return nil
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&opts.backend, "backend", "b", lg.BackendFile, "Ledger backend (file, memory)")
+	cmd.PersistentFlags().StringVarP(&opts.ledgerDir, "ledger-dir", "l", defaultLedgerDir(), "Ledger directory for the file backend")
+	cmd.PersistentFlags().StringVar(&opts.schemaDir, "schema-dir", defaultSchemaDir(), "Directory of the contract payload schemas")
+	cmd.PersistentFlags().StringVarP(&opts.contract, "contract", "c", "ApprovalContract", "Contract (ApprovalContract, SignatureContract, TrafficContract)")
+	cmd.PersistentFlags().StringVarP(&opts.output, "output", "o", OutputTable, "Output format (json, yaml, table)")
+
+	cmd.AddCommand(
+		documentRegisterCmd(opts),
+		documentGetCmd(opts),
+		documentHistoryCmd(opts),
+		documentApproveCmd(opts),
+		documentSignCmd(opts),
+		documentDeleteCmd(opts),
+	)
+	return cmd
+}
+
+func documentRegisterCmd(opts *documentOptions) *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "register <id>",
+		Short: "Register a document, reading its content from a file or stdin",
+		Example: "smart_plane document register doc-1 --file doc.json\n" +
+			"  cat doc.json | smart_plane document register doc-1 --contract TrafficContract",
+		Args: usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			content, err := readContent(cmd, file)
+			if err != nil {
+				return err
+			}
+			return opts.write(cmd, args[0], sc.DocumentActionRegistered, func(bm *sc.BlockchainManager) error {
+				return bm.RegisterDocument(opts.contract, args[0], content)
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "File with the document content (- or empty for stdin)")
+	return cmd
+}
+
+func documentGetCmd(opts *documentOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <id>",
+		Short: "Show the current state of a document",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(cmd, func(bm *sc.BlockchainManager) error {
+				state, err := bm.GetDocumentState(opts.contract, args[0])
+				if err != nil {
+					return err
+				}
+				return printOutput(cmd, opts.output, state, nil)
+			})
+		},
+	}
+}
+
+func documentHistoryCmd(opts *documentOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "history <id>",
+		Short: "Show the history of a document",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(cmd, func(bm *sc.BlockchainManager) error {
+				history, err := bm.GetDocumentHistory(opts.contract, args[0])
+				if err != nil {
+					return err
+				}
+				return printOutput(cmd, opts.output, history, func(w io.Writer) error {
+					_, _ = fmt.Fprintln(w, "#\tENTRY")
+					for i, entry := range history {
+						if _, err := fmt.Fprintf(w, "%d\t%s\n", i+1, formatValue(entry)); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			})
+		},
+	}
+}
+
+func documentApproveCmd(opts *documentOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "approve <id>",
+		Short: "Approve a document",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.write(cmd, args[0], sc.DocumentActionApproved, func(bm *sc.BlockchainManager) error {
+				return bm.ApproveDocument(opts.contract, args[0])
+			})
+		},
+	}
+}
+
+func documentSignCmd(opts *documentOptions) *cobra.Command {
+	var signature string
+
+	cmd := &cobra.Command{
+		Use:     "sign <id>",
+		Short:   "Sign a document",
+		Example: "smart_plane document sign doc-1 --contract SignatureContract --signature <signature>",
+		Args:    usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if signature == "" {
+				return &UsageError{Err: fmt.Errorf("--signature is required")}
+			}
+			return opts.write(cmd, args[0], sc.DocumentActionSigned, func(bm *sc.BlockchainManager) error {
+				return bm.SignDocument(opts.contract, args[0], signature)
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&signature, "signature", "s", "", "Signature of the document")
+	return cmd
+}
+
+func documentDeleteCmd(opts *documentOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <id>",
+		Short: "Delete the state of a document",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.write(cmd, args[0], sc.DocumentActionDeleted, func(bm *sc.BlockchainManager) error {
+				return bm.DeleteDocumentState(opts.contract, args[0])
+			})
+		},
+	}
+}
+
+// run opens the manager on the configured ledger and calls fn with it.
+func (opts *documentOptions) run(cmd *cobra.Command, fn func(bm *sc.BlockchainManager) error) error {
+	if err := checkOutputFormat(opts.output); err != nil {
+		return err
+	}
+	ledger, err := lg.Open(opts.backend, opts.ledgerDir)
+	if err != nil {
+		gl.Log("error", fmt.Sprintf("Failed to open ledger: %v", err))
+		return &UnavailableError{Err: err}
+	}
+	defer func() { _ = ledger.Close() }()
+
+	if _, err := sc.LoadPayloadSchemas(opts.schemaDir); err != nil {
+		return &UnavailableError{Err: err}
+	}
+	bm := sc.NewBlockchainManagerWithLedger(ledger)
+	if len(opts.enabled) > 0 {
+		if err := bm.SetEnabledContracts(opts.enabled...); err != nil {
+			return err
+		}
+	}
+	stop, err := startEvents(opts, ledger, bm)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	return fn(bm)
+}
+
+// write runs a document write and prints its result.
+func (opts *documentOptions) write(cmd *cobra.Command, id, action string, fn func(bm *sc.BlockchainManager) error) error {
+	return opts.run(cmd, func(bm *sc.BlockchainManager) error {
+		if err := fn(bm); err != nil {
+			return err
+		}
+		return printOutput(cmd, opts.output, documentResult{
+			Contract: opts.contract,
+			ID:       id,
+			Action:   action,
+			Status:   "ok",
+		}, nil)
+	})
+}
+
+// readContent reads the document content from a file, or from stdin for "-" or an empty
+// name. Reading an interactive terminal is refused.
+func readContent(cmd *cobra.Command, file string) (string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if file != "" && file != "-" {
+		if data, err = os.ReadFile(file); err != nil {
+			return "", &UnavailableError{Err: err}
+		}
+	} else {
+		in := cmd.InOrStdin()
+		if f, ok := in.(*os.File); ok {
+			if info, statErr := f.Stat(); statErr == nil && info.Mode()&os.ModeCharDevice != 0 {
+				return "", &UsageError{Err: fmt.Errorf("no document content: use --file or pipe it to stdin")}
+			}
+		}
+		if data, err = io.ReadAll(in); err != nil {
+			return "", &UnavailableError{Err: err}
+		}
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return "", &UsageError{Err: fmt.Errorf("document content is empty")}
+	}
+	return string(data), nil
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rafa-mori/smart_plane/internal/broker"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	sc "github.com/rafa-mori/smart_plane/internal/smart_contracts"
+	"github.com/rafa-mori/smart_plane/internal/webhooks"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	t "github.com/rafa-mori/smart_plane/types"
+)
+
+// eventsDrainTimeout bounds the publication of the pending events when a command ends.
+const eventsDrainTimeout = 30 * time.Second
+
+// startEvents publishes the ledger events of the manager to the configured webhooks and
+// broker. The events go through the ledger outbox, committed with the documents, and a relay
+// publishes them; events left by an earlier run are published too. The returned function
+// publishes the pending events and stops the relay, and must be called before the ledger is
+// closed.
+func startEvents(opts *documentOptions, ledger lg.ILedger, bm *sc.BlockchainManager) (func(), error) {
+	var (
+		publishers []broker.IPublisher
+		hooks      *webhooks.Manager
+		store      *webhooks.FileStore
+	)
+	if opts.broker.Kind != "" {
+		publisher, err := broker.NewPublisher(broker.Config{
+			Kind:        opts.broker.Kind,
+			URL:         opts.broker.URL,
+			Exchange:    opts.broker.Exchange,
+			TopicPrefix: opts.broker.TopicPrefix,
+		})
+		if err != nil {
+			return nil, &UnavailableError{Err: err}
+		}
+		publishers = append(publishers, publisher)
+	}
+	if len(opts.webhooks.Endpoints) > 0 {
+		var err error
+		if store, err = webhooks.NewFileStore(opts.webhooks.DeadLetterDir, 0); err != nil {
+			closePublishers(publishers)
+			return nil, &UnavailableError{Err: err}
+		}
+		hooks = webhooks.NewManager(webhooks.Config{MaxAttempts: opts.webhooks.MaxAttempts, Store: store})
+		for _, endpoint := range opts.webhooks.Endpoints {
+			if _, err := hooks.RegisterEndpoint(endpoint.URL, endpoint.Secret, endpoint.Events...); err != nil {
+				closePublishers(publishers)
+				return nil, err
+			}
+		}
+		publishers = append(publishers, hooks.Publisher())
+	}
+	if len(publishers) == 0 {
+		return func() {}, nil
+	}
+
+	publisher := broker.NewMultiPublisher(publishers...)
+	relay := broker.NewRelay(ledger, publisher)
+	listener := t.NewValidationListener()
+	if _, err := relay.Attach(listener); err != nil {
+		closePublishers(publishers)
+		return nil, err
+	}
+	bm.SetEventListener(listener)
+	bm.SetOutbox(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = relay.Run(ctx)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+
+		drain, cancelDrain := context.WithTimeout(context.Background(), eventsDrainTimeout)
+		defer cancelDrain()
+		if _, err := relay.Flush(drain); err != nil {
+			gl.Log("warn", fmt.Sprintf("Ledger events left in the outbox: %v", err))
+		}
+		if err := relay.Ack(); err != nil {
+			gl.Log("warn", fmt.Sprintf("Published ledger events left in the outbox: %v", err))
+		}
+		if err := publisher.Close(); err != nil {
+			gl.Log("warn", fmt.Sprintf("Failed to close the event publisher: %v", err))
+		}
+		if hooks != nil {
+			if err := hooks.Close(drain); err != nil {
+				gl.Log("warn", fmt.Sprintf("Webhook deliveries dead-lettered in %s: %v", store.Dir(), err))
+			}
+		}
+		_ = listener.Close(drain)
+	}, nil
+}
+
+// closePublishers closes the publishers of a pipeline that failed to start.
+func closePublishers(publishers []broker.IPublisher) {
+	for _, publisher := range publishers {
+		_ = publisher.Close()
+	}
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+
+	au "github.com/rafa-mori/smart_plane/internal/authentication"
+	cf "github.com/rafa-mori/smart_plane/internal/config"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	sc "github.com/rafa-mori/smart_plane/internal/smart_contracts"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the CLI commands.
+const (
+	ExitOK          = 0
+	ExitFailure     = 1 // Unclassified failure
+	ExitUsage       = 2 // Invalid flags or arguments
+	ExitNotFound    = 3 // Unknown contract or document
+	ExitInvalid     = 4 // Payload, request, token, configuration, archive, snapshot, chain or proof rejected by validation
+	ExitConflict    = 5 // Document busy or already registered, or archive already imported
+	ExitUnavailable = 6 // Ledger or input could not be read or written
+)
+
+// UsageError marks an error caused by the command line itself.
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string { return e.Err.Error() }
+func (e *UsageError) Unwrap() error { return e.Err }
+
+// UnavailableError marks an error caused by the ledger or the input not being usable.
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string { return e.Err.Error() }
+func (e *UnavailableError) Unwrap() error { return e.Err }
+
+// usageArgs wraps a cobra argument validator so its errors map to ExitUsage.
+func usageArgs(args func(cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, a []string) error {
+		if err := args(cmd, a); err != nil {
+			return &UsageError{Err: err}
+		}
+		return nil
+	}
+}
+
+// ExitCode maps an error returned by a command to the process exit code.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var usageErr *UsageError
+	var unavailableErr *UnavailableError
+	var contractErr *sc.ContractNotFoundError
+	var documentErr *sc.DocumentNotFoundError
+	var payloadErr *sc.PayloadValidationError
+	var statusErr interface{ ContractStatus() string }
+	var configErr *cf.ValidationError
+	var conflictErr *lg.ConflictError
+	var chainErr *lg.ChainError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &usageErr), errors.Is(err, cf.ErrUnsupportedFormat):
+		return ExitUsage
+	case errors.As(err, &unavailableErr):
+		return ExitUnavailable
+	case errors.As(err, &contractErr), errors.As(err, &documentErr), errors.Is(err, lg.ErrNotFound),
+		errors.Is(err, au.ErrKeyNotFound):
+		return ExitNotFound
+	case errors.As(err, &payloadErr), errors.As(err, &configErr), errors.Is(err, au.ErrInvalidToken),
+		errors.Is(err, lg.ErrInvalidArchive), errors.Is(err, lg.ErrCorruptedSnapshot), errors.As(err, &chainErr),
+		errors.Is(err, lg.ErrInvalidProof):
+		return ExitInvalid
+	case errors.As(err, &conflictErr), errors.Is(err, sc.ErrDocumentExists), errors.Is(err, sc.ErrTransactionFinished),
+		errors.Is(err, cf.ErrConfigExists), errors.Is(err, au.ErrKeyExists):
+		return ExitConflict
+	case errors.As(err, &statusErr) && (statusErr.ContractStatus() == sc.ContractStatusInvalid ||
+		statusErr.ContractStatus() == sc.ContractStatusBadRequest):
+		return ExitInvalid
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return ExitConflict
+	case errors.As(err, &pathErr):
+		return ExitUnavailable
+	}
+
+	return ExitFailure
+}
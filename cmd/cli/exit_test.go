@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	au "github.com/rafa-mori/smart_plane/internal/authentication"
+	cf "github.com/rafa-mori/smart_plane/internal/config"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	sc "github.com/rafa-mori/smart_plane/internal/smart_contracts"
+)
+
+func TestExitCode(t *testing.T) {
+	wrap := func(err error) error { return fmt.Errorf("command failed: %w", err) }
+	failedStatus := func(status string) error { return sc.NewContractError[string](status, "rejected", nil) }
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "no error", err: nil, want: ExitOK},
+		{name: "unclassified", err: errors.New("boom"), want: ExitFailure},
+		{name: "usage", err: &UsageError{Err: errors.New("bad flag")}, want: ExitUsage},
+		{name: "unsupported config format", err: wrap(cf.ErrUnsupportedFormat), want: ExitUsage},
+		{name: "unavailable", err: &UnavailableError{Err: errors.New("disk")}, want: ExitUnavailable},
+		{name: "unknown contract", err: wrap(&sc.ContractNotFoundError{ContractName: "X"}), want: ExitNotFound},
+		{name: "unknown document", err: wrap(&sc.DocumentNotFoundError{ID: "doc"}), want: ExitNotFound},
+		{name: "unknown ledger entry", err: wrap(lg.ErrNotFound), want: ExitNotFound},
+		{name: "unknown key", err: wrap(au.ErrKeyNotFound), want: ExitNotFound},
+		{name: "invalid payload", err: wrap(&sc.PayloadValidationError{ContractName: "X"}), want: ExitInvalid},
+		{name: "invalid token", err: wrap(au.ErrInvalidToken), want: ExitInvalid},
+		{name: "invalid archive", err: wrap(lg.ErrInvalidArchive), want: ExitInvalid},
+		{name: "broken chain", err: wrap(&lg.ChainError{Reason: "previous hash"}), want: ExitInvalid},
+		{name: "invalid proof", err: wrap(lg.ErrInvalidProof), want: ExitInvalid},
+		{name: "invalid request", err: wrap(failedStatus(sc.ContractStatusInvalid)), want: ExitInvalid},
+		{name: "bad request", err: wrap(failedStatus(sc.ContractStatusBadRequest)), want: ExitInvalid},
+		{name: "document exists", err: wrap(&sc.DocumentConflictError{ID: "doc", Err: sc.ErrDocumentExists}), want: ExitConflict},
+		{name: "archive conflict", err: wrap(&lg.ConflictError{Keys: []string{"a"}}), want: ExitConflict},
+		{name: "finished transaction", err: wrap(sc.ErrTransactionFinished), want: ExitConflict},
+		{name: "config exists", err: wrap(cf.ErrConfigExists), want: ExitConflict},
+		{name: "key exists", err: wrap(au.ErrKeyExists), want: ExitConflict},
+		{name: "lock timeout", err: wrap(context.DeadlineExceeded), want: ExitConflict},
+		{name: "canceled", err: wrap(context.Canceled), want: ExitConflict},
+		{name: "path", err: wrap(&fs.PathError{Op: "open", Path: "x", Err: fs.ErrPermission}), want: ExitUnavailable},
+		// A contract status that is not a validation failure leaves the other causes to match.
+		{name: "other status with timeout", err: errors.Join(failedStatus("error"), context.DeadlineExceeded), want: ExitConflict},
+		{name: "other status with path", err: errors.Join(failedStatus("error"), &fs.PathError{Op: "open", Path: "x", Err: fs.ErrNotExist}), want: ExitUnavailable},
+		{name: "other status", err: wrap(failedStatus("error")), want: ExitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Fatalf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,443 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	sc "github.com/rafa-mori/smart_plane/internal/smart_contracts"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	"github.com/spf13/cobra"
+)
+
+// ledgerOptions holds the flags shared by the ledger commands.
+type ledgerOptions struct {
+	backend   string
+	ledgerDir string
+	output    string
+	// retention is the configured snapshot retention.
+	retention lg.SnapshotRetention
+}
+
+// LedgerCmd returns the command group operating on whole ledgers.
+func LedgerCmd() *cobra.Command {
+	opts := &ledgerOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "ledger",
+		Short: "Export, import, snapshot and restore whole ledgers",
+		Long: "Move the state of a ledger between environments through a versioned archive: a gzip\n" +
+			"compressed tar with a manifest and one JSON Lines file per contract holding every key\n" +
+			"with its full history. The manifest records the SHA-256 of each file.\n" +
+			"Snapshot the journal of a file ledger and restore its state as of a transaction or a time.\n" +
+			"Every block of the journal links to the hash of the previous one and carries the Merkle\n" +
+			"root of its writes, and verify walks that chain.\n" +
+			"Exit codes: 2 usage, 3 unknown transaction or snapshot, 4 invalid archive, snapshot or\n" +
+			"chain, 5 conflicting keys, 6 ledger unavailable.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd, ledgerBindings)
+			if err != nil {
+				return err
+			}
+			opts.backend, opts.ledgerDir = cfg.Ledger.Backend, cfg.Ledger.Dir
+			opts.retention = lg.SnapshotRetention{Keep: cfg.Ledger.SnapshotKeep, MaxAge: cfg.Ledger.SnapshotMaxAge}
+			return checkOutputFormat(opts.output)
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&opts.backend, "backend", "b", lg.BackendFile, "Ledger backend (file, memory)")
+	cmd.PersistentFlags().StringVarP(&opts.ledgerDir, "ledger-dir", "l", defaultLedgerDir(), "Ledger directory for the file backend")
+	cmd.PersistentFlags().StringVarP(&opts.output, "output", "o", OutputTable, "Output format (json, yaml, table)")
+
+	snapshot := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Create, list and prune the snapshots of a file ledger",
+		Long: "A snapshot is a consistent copy of the journal of a file ledger, taken while other\n" +
+			"processes keep reading it, under <ledger-dir>/snapshots. After each snapshot the\n" +
+			"ledger.snapshot_keep and ledger.snapshot_max_age retention is applied; the newest\n" +
+			"snapshot is always kept.",
+	}
+	snapshot.AddCommand(ledgerSnapshotCreateCmd(opts), ledgerSnapshotListCmd(opts), ledgerSnapshotPruneCmd(opts))
+
+	cmd.AddCommand(ledgerExportCmd(opts), ledgerImportCmd(opts), snapshot, ledgerRestoreCmd(opts), ledgerVerifyCmd(opts))
+	return cmd
+}
+
+func ledgerExportCmd(opts *ledgerOptions) *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:     "export",
+		Short:   "Export the ledger to an archive",
+		Example: "smart_plane ledger export --file ledger.tar.gz\n  smart_plane ledger export -f - > ledger.tar.gz",
+		Args:    usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" {
+				return &UsageError{Err: fmt.Errorf("--file is required (- for stdout)")}
+			}
+			return opts.run(func(ledger lg.ILedger) error {
+				source := opts.backend
+				if opts.backend == lg.BackendFile {
+					source += ":" + opts.ledgerDir
+				}
+				exportOpts := lg.ExportOptions{Source: source, Partition: sc.RecordContract}
+
+				if file == "-" {
+					_, err := lg.Export(ledger, cmd.OutOrStdout(), exportOpts)
+					return err
+				}
+				manifest, err := exportFile(ledger, file, exportOpts)
+				if err != nil {
+					return err
+				}
+				return printManifest(cmd, opts.output, manifest)
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Archive file to write (- for stdout)")
+	return cmd
+}
+
+func ledgerImportCmd(opts *ledgerOptions) *cobra.Command {
+	var onConflict string
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "import <archive>",
+		Short: "Verify an archive and replay it into the ledger (- reads it from stdin)",
+		Long: "Verify the archive against its manifest, then replay the history of its keys into the\n" +
+			"ledger in the original transaction order. Keys that already exist are handled by\n" +
+			"--on-conflict: fail aborts before writing anything, skip leaves them untouched and\n" +
+			"overwrite writes their archived state on top of their history. The memory and file\n" +
+			"backends commit the whole archive at once, so a failed import writes nothing.",
+		Example: "smart_plane ledger import ledger.tar.gz --dry-run\n" +
+			"  smart_plane ledger import ledger.tar.gz -b file -l /var/lib/smart_plane --on-conflict skip",
+		Args: usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			policy := lg.ConflictPolicy(onConflict)
+			switch policy {
+			case lg.ConflictFail, lg.ConflictSkip, lg.ConflictOverwrite:
+			default:
+				return &UsageError{Err: fmt.Errorf("unknown --on-conflict %q (fail, skip, overwrite)", onConflict)}
+			}
+
+			var in io.Reader = cmd.InOrStdin()
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return &UnavailableError{Err: err}
+				}
+				defer func() { _ = f.Close() }()
+				in = f
+			}
+			return opts.run(func(ledger lg.ILedger) error {
+				report, err := lg.Import(ledger, in, lg.ImportOptions{OnConflict: policy, DryRun: dryRun})
+				if err != nil {
+					return err
+				}
+				return printOutput(cmd, opts.output, report, func(w io.Writer) error {
+					mode := "imported"
+					if report.DryRun {
+						mode = "would import (dry run)"
+					}
+					_, _ = fmt.Fprintf(w, "archive\tversion %d, %d keys, %d records, created %s\n", report.Manifest.Version,
+						report.Manifest.Keys, report.Manifest.Records, report.Manifest.CreatedAt.Format("2006-01-02T15:04:05Z07:00"))
+					_, _ = fmt.Fprintf(w, "%s\t%d keys in %d transactions\n", mode, report.Imported, report.Blocks)
+					_, _ = fmt.Fprintf(w, "overwritten\t%d\n", report.Overwritten)
+					_, err := fmt.Fprintf(w, "skipped\t%d\n", report.Skipped)
+					return err
+				})
+			})
+		},
+	}
+	cmd.Flags().StringVar(&onConflict, "on-conflict", string(lg.ConflictFail), "What to do with existing keys (fail, skip, overwrite)")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Verify the archive and report the import without writing anything")
+	return cmd
+}
+
+func ledgerSnapshotCreateCmd(opts *ledgerOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "create",
+		Short: "Snapshot the journal, then apply the retention",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.runFile(func(ledger *lg.FileLedger) error {
+				info, err := ledger.Snapshot("manual")
+				if err != nil {
+					return err
+				}
+				return printSnapshots(cmd, opts.output, info, []lg.SnapshotInfo{info})
+			})
+		},
+	}
+}
+
+func ledgerSnapshotListCmd(opts *ledgerOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the snapshots, newest first",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.runFile(func(ledger *lg.FileLedger) error {
+				snapshots, err := ledger.Snapshots()
+				if err != nil {
+					return err
+				}
+				if snapshots == nil {
+					snapshots = []lg.SnapshotInfo{}
+				}
+				return printSnapshots(cmd, opts.output, snapshots, snapshots)
+			})
+		},
+	}
+}
+
+func ledgerSnapshotPruneCmd(opts *ledgerOptions) *cobra.Command {
+	var keep int
+	var maxAge time.Duration
+
+	cmd := &cobra.Command{
+		Use:     "prune",
+		Short:   "Remove the snapshots outside of the retention",
+		Example: "smart_plane ledger snapshot prune --keep 3\n  smart_plane ledger snapshot prune --max-age 168h",
+		Args:    usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			retention := opts.retention
+			if cmd.Flags().Changed("keep") {
+				retention.Keep = keep
+			}
+			if cmd.Flags().Changed("max-age") {
+				retention.MaxAge = maxAge
+			}
+			if retention.Keep < 0 || retention.MaxAge < 0 {
+				return &UsageError{Err: fmt.Errorf("--keep and --max-age cannot be negative")}
+			}
+			return opts.runFile(func(ledger *lg.FileLedger) error {
+				removed, err := ledger.PruneSnapshots(retention)
+				if err != nil {
+					return err
+				}
+				if removed == nil {
+					removed = []lg.SnapshotInfo{}
+				}
+				return printSnapshots(cmd, opts.output, removed, removed)
+			})
+		},
+	}
+	cmd.Flags().IntVar(&keep, "keep", 0, "Snapshots to keep, overriding ledger.snapshot_keep (0 keeps all)")
+	cmd.Flags().DurationVar(&maxAge, "max-age", 0, "Remove older snapshots, overriding ledger.snapshot_max_age (0 disables)")
+	return cmd
+}
+
+func ledgerRestoreCmd(opts *ledgerOptions) *cobra.Command {
+	var txID, at, snapshot, mode string
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore the state of a file ledger as of a transaction or a time",
+		Long: "Replay the history of the journal, or of a snapshot with --snapshot, up to the\n" +
+			"transaction given by --tx or up to the time given by --at, and bring the ledger back to\n" +
+			"that state. The revert mode commits one transaction writing back the changed keys and\n" +
+			"keeps the whole history; the truncate mode replaces the journal with one without the\n" +
+			"later blocks, after taking a pre-restore snapshot. Other processes pick up the restored\n" +
+			"state on their next read.",
+		Example: "smart_plane ledger restore --tx 3f2a9c --dry-run\n" +
+			"  smart_plane ledger restore --at 2026-10-18T22:00:00Z\n" +
+			"  smart_plane ledger restore --snapshot 20261018T220000.000000000Z --mode truncate",
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			restoreOpts := lg.RestoreOptions{TxID: txID, Snapshot: snapshot, Mode: lg.RestoreMode(mode), DryRun: dryRun}
+			switch restoreOpts.Mode {
+			case lg.RestoreRevert, lg.RestoreTruncate:
+			default:
+				return &UsageError{Err: fmt.Errorf("unknown --mode %q (revert, truncate)", mode)}
+			}
+			if at != "" {
+				t, err := time.Parse(time.RFC3339Nano, at)
+				if err != nil {
+					return &UsageError{Err: fmt.Errorf("invalid --at %q: expected an RFC 3339 time", at)}
+				}
+				restoreOpts.Time = t
+			}
+			if txID == "" && at == "" && snapshot == "" {
+				return &UsageError{Err: fmt.Errorf("one of --tx, --at or --snapshot is required")}
+			}
+
+			return opts.runFile(func(ledger *lg.FileLedger) error {
+				report, err := ledger.Restore(restoreOpts)
+				if err != nil {
+					return err
+				}
+				return printOutput(cmd, opts.output, report, func(w io.Writer) error {
+					state := "restored"
+					if report.DryRun {
+						state = "would restore (dry run)"
+					}
+					target := report.TargetTxID
+					if target == "" {
+						target = "(empty ledger)"
+					} else {
+						target += " at " + report.TargetTime.Format(time.RFC3339Nano)
+					}
+					_, _ = fmt.Fprintf(w, "%s\t%s mode, to %s\n", state, report.Mode, target)
+					_, _ = fmt.Fprintf(w, "keys\t%d %s\n", len(report.Keys), strings.Join(report.Keys, ","))
+					if report.Mode == lg.RestoreTruncate {
+						_, _ = fmt.Fprintf(w, "dropped\t%d blocks\n", report.Dropped)
+					}
+					if report.TxID != "" {
+						_, _ = fmt.Fprintf(w, "transaction\t%s\n", report.TxID)
+					}
+					if report.Snapshot != "" {
+						_, _ = fmt.Fprintf(w, "snapshot\t%s\n", report.Snapshot)
+					}
+					return nil
+				})
+			})
+		},
+	}
+	cmd.Flags().StringVar(&txID, "tx", "", "Restore the state right after this transaction")
+	cmd.Flags().StringVar(&at, "at", "", "Restore the state as of this RFC 3339 time")
+	cmd.Flags().StringVar(&snapshot, "snapshot", "", "Replay the history of this snapshot instead of the journal")
+	cmd.Flags().StringVar(&mode, "mode", string(lg.RestoreRevert), "Restore mode (revert, truncate)")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Report the restore without writing anything")
+	return cmd
+}
+
+func ledgerVerifyCmd(opts *ledgerOptions) *cobra.Command {
+	var snapshot string
+	var chainOpts lg.ChainOptions
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the hash chain of a file ledger and report the first inconsistent block",
+		Long: "Walk the blocks of the journal, or of a snapshot with --snapshot, checking that each one\n" +
+			"links to the hash of the previous block, that its Merkle root matches its writes and that\n" +
+			"its hash matches its header. Blocks committed before the chain was introduced carry no\n" +
+			"hashes and break the chain, unless --allow-unsealed accepts them as legacy blocks; they\n" +
+			"are reported as unsealed. Publish the head hash to anchor the chain: a ledger rewritten\n" +
+			"with recomputed hashes no longer contains it.",
+		Example: "smart_plane ledger verify\n  smart_plane ledger verify --snapshot 20261018T220000.000000000Z -o json",
+		Args:    usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.backend != lg.BackendFile {
+				return &UsageError{Err: fmt.Errorf("verification needs the %s backend, not %s", lg.BackendFile, opts.backend)}
+			}
+			// The journal is read as is: opening the ledger would replay it, and reject the
+			// malformed blocks verify must report.
+			report, err := lg.VerifyJournal(opts.ledgerDir, snapshot, chainOpts)
+			if errors.Is(err, lg.ErrNotFound) || errors.Is(err, lg.ErrCorruptedSnapshot) {
+				return err
+			}
+			if err != nil {
+				gl.Log("error", fmt.Sprintf("Failed to read ledger: %v", err))
+				return &UnavailableError{Err: err}
+			}
+			err = printOutput(cmd, opts.output, report, func(w io.Writer) error {
+				_, _ = fmt.Fprintf(w, "valid\t%t\n", report.Valid)
+				_, _ = fmt.Fprintf(w, "blocks\t%d verified, %d unsealed\n", report.Blocks, report.Unsealed)
+				if report.Head != "" {
+					_, _ = fmt.Fprintf(w, "head\t%s (transaction %s)\n", report.Head, report.HeadTxID)
+				}
+				if report.Error != nil {
+					_, _ = fmt.Fprintf(w, "broken at\tblock %d, line %d, transaction %s\n", report.Error.Number, report.Error.Line, report.Error.TxID)
+					_, _ = fmt.Fprintf(w, "reason\t%s\n", report.Error.Reason)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if report.Error != nil {
+				return report.Error
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&snapshot, "snapshot", "", "Verify this snapshot instead of the journal")
+	cmd.Flags().BoolVar(&chainOpts.AllowUnsealed, "allow-unsealed", false, "Accept leading blocks without hashes, committed before the chain was introduced")
+	return cmd
+}
+
+// run opens the configured ledger and calls fn with it.
+func (opts *ledgerOptions) run(fn func(ledger lg.ILedger) error) error {
+	ledger, err := lg.Open(opts.backend, opts.ledgerDir)
+	if err != nil {
+		gl.Log("error", fmt.Sprintf("Failed to open ledger: %v", err))
+		return &UnavailableError{Err: err}
+	}
+	defer func() { _ = ledger.Close() }()
+
+	return fn(ledger)
+}
+
+// runFile opens the configured file ledger and calls fn with it; snapshots, restores and
+// verification need the journal of the file backend.
+func (opts *ledgerOptions) runFile(fn func(ledger *lg.FileLedger) error) error {
+	if opts.backend != lg.BackendFile {
+		return &UsageError{Err: fmt.Errorf("snapshots, restores and verification need the %s backend, not %s", lg.BackendFile, opts.backend)}
+	}
+	ledger, err := lg.NewFileLedger(opts.ledgerDir)
+	if err != nil {
+		gl.Log("error", fmt.Sprintf("Failed to open ledger: %v", err))
+		return &UnavailableError{Err: err}
+	}
+	defer func() { _ = ledger.Close() }()
+
+	ledger.SetSnapshotRetention(opts.retention)
+	return fn(ledger)
+}
+
+// exportFile exports the ledger to a file, replacing it only once the archive is complete.
+func exportFile(ledger lg.ILedger, file string, opts lg.ExportOptions) (*lg.ArchiveManifest, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return nil, &UnavailableError{Err: err}
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	manifest, err := lg.Export(ledger, tmp, opts)
+	if err != nil {
+		_ = tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, &UnavailableError{Err: err}
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return nil, &UnavailableError{Err: err}
+	}
+	return manifest, nil
+}
+
+// printManifest prints an archive manifest, one row per file in the table format.
+func printManifest(cmd *cobra.Command, format string, manifest *lg.ArchiveManifest) error {
+	return printOutput(cmd, format, manifest, func(w io.Writer) error {
+		_, _ = fmt.Fprintln(w, "FILE\tCONTRACT\tKEYS\tRECORDS\tSHA256")
+		for _, file := range manifest.Files {
+			if _, err := fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", file.Name, file.Contract, file.Keys, file.Records, file.SHA256); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintf(w, "total\t\t%d\t%d\t\n", manifest.Keys, manifest.Records)
+		return err
+	})
+}
+
+// printSnapshots prints snapshot metadata, one row per snapshot in the table format.
+func printSnapshots(cmd *cobra.Command, format string, value any, snapshots []lg.SnapshotInfo) error {
+	return printOutput(cmd, format, value, func(w io.Writer) error {
+		_, _ = fmt.Fprintln(w, "ID\tCREATED\tBLOCKS\tSIZE\tLAST TX\tREASON")
+		for _, info := range snapshots {
+			if _, err := fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", info.ID, info.CreatedAt.Format(time.RFC3339),
+				info.Blocks, info.Size, info.LastTxID, info.Reason); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	sc "github.com/rafa-mori/smart_plane/internal/smart_contracts"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	"github.com/spf13/cobra"
+)
+
+// defaultLedgerDir returns the default directory of the file ledger backend.
+func defaultLedgerDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".", ".smart_plane", "ledger")
+	}
+	return filepath.Join(home, ".smart_plane", "ledger")
+}
+
+// MigrateCmd returns the command that migrates stored contract state to the current schema versions.
+func MigrateCmd() *cobra.Command {
+	var backend, ledgerDir, fallbackSchema string
+	var dryRun, asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate stored contract state to the current schema versions",
+		Long: "Scan every record of a ledger backend and upgrade it to the current version of its schema.\n" +
+			"Records stored before schema versioning are taken as documents of the built-in contracts,\n" +
+			"unless --schema names another schema. Use --dry-run to only report what would change.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd, ledgerBindings)
+			if err != nil {
+				return err
+			}
+			backend, ledgerDir = cfg.Ledger.Backend, cfg.Ledger.Dir
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ledger, err := lg.Open(backend, ledgerDir)
+			if err != nil {
+				gl.Log("error", fmt.Sprintf("Failed to open ledger: %v", err))
+				return err
+			}
+			defer func() { _ = ledger.Close() }()
+
+			report, migrateErr := sc.MigrateLedger(ledger, fallbackSchema, dryRun)
+			if report != nil {
+				if err := printMigrationReport(cmd, report, asJSON); err != nil {
+					return err
+				}
+			}
+			return migrateErr
+		},
+	}
+
+	cmd.Flags().StringVarP(&backend, "backend", "b", lg.BackendFile, "Ledger backend (file, memory)")
+	cmd.Flags().StringVarP(&ledgerDir, "ledger-dir", "l", defaultLedgerDir(), "Ledger directory for the file backend")
+	cmd.Flags().StringVarP(&fallbackSchema, "schema", "s", sc.DocumentSchemaName, "Schema name assumed for records stored without a schema version")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Report the migration without writing anything")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the report as JSON")
+
+	return cmd
+}
+
+// printMigrationReport writes the migration report to the command output.
+func printMigrationReport(cmd *cobra.Command, report *sc.MigrationReport, asJSON bool) error {
+	out := cmd.OutOrStdout()
+	if asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "KEY\tSCHEMA\tFROM\tTO\tSTATUS\tERROR")
+	for _, e := range report.Entries {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", e.Key, e.Schema, e.FromVersion, e.ToVersion, e.Status, e.Error)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	mode := "applied"
+	if report.DryRun {
+		mode = "dry-run"
+	}
+	_, err := fmt.Fprintf(out, "\n%s: scanned=%d migrated=%d up-to-date=%d skipped=%d failed=%d\n",
+		mode, report.Scanned, report.Migrated, report.UpToDate, report.Skipped, report.Failed)
+	return err
+}
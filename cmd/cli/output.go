@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats of the commands with an --output flag.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// checkOutputFormat validates the value of an --output flag.
+func checkOutputFormat(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return nil
+	default:
+		return &UsageError{Err: fmt.Errorf("unknown output format %q (json, yaml, table)", format)}
+	}
+}
+
+// printOutput writes a value in the requested format. The table format uses table when set,
+// and otherwise prints the fields of the value as KEY/VALUE rows.
+func printOutput(cmd *cobra.Command, format string, value any, table func(w io.Writer) error) error {
+	out := cmd.OutOrStdout()
+	switch format {
+	case OutputJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	case OutputYAML:
+		// Round-trip through JSON so YAML keys follow the json tags.
+		generic, err := toGeneric(value)
+		if err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(out)
+		enc.SetIndent(2)
+		if err := enc.Encode(generic); err != nil {
+			return err
+		}
+		return enc.Close()
+	case OutputTable:
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		if table != nil {
+			if err := table(tw); err != nil {
+				return err
+			}
+		} else if err := printFields(tw, value); err != nil {
+			return err
+		}
+		return tw.Flush()
+	default:
+		return checkOutputFormat(format)
+	}
+}
+
+// printFields writes the top-level fields of a value as KEY/VALUE rows.
+func printFields(w io.Writer, value any) error {
+	generic, err := toGeneric(value)
+	if err != nil {
+		return err
+	}
+	fields, ok := generic.(map[string]any)
+	if !ok {
+		_, err := fmt.Fprintln(w, formatValue(generic))
+		return err
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	_, _ = fmt.Fprintln(w, "KEY\tVALUE")
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", key, formatValue(fields[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toGeneric converts a value to maps, slices and scalars following its JSON encoding.
+func toGeneric(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode output: %w", err)
+	}
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, fmt.Errorf("failed to encode output: %w", err)
+	}
+	return generic, nil
+}
+
+// formatValue renders a table cell: scalars as is, nested values as compact JSON.
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.ReplaceAll(v, "\n", " ")
+	case map[string]any, []any:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	"github.com/spf13/cobra"
+)
+
+// proofResult is the output of proof verify.
+type proofResult struct {
+	Valid bool      `json:"valid"`
+	Proof *lg.Proof `json:"proof"`
+}
+
+// ProofCmd returns the command group creating and verifying Merkle inclusion proofs.
+func ProofCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proof",
+		Short: "Create and verify Merkle inclusion proofs of document states",
+		Long: "A proof shows that a document key had a value in a block of the ledger, to anyone holding\n" +
+			"the hash of a later published block, without the rest of the ledger: it carries the headers\n" +
+			"linking the block to the published one. Publish the head hash (see ledger verify) and hand\n" +
+			"out proofs anchored to it; verify needs no ledger.\n" +
+			"Exit codes: 2 usage, 3 unknown key, transaction or block, 4 invalid proof, 6 ledger unavailable.",
+	}
+	cmd.AddCommand(proofCreateCmd(), proofVerifyCmd())
+	return cmd
+}
+
+func proofCreateCmd() *cobra.Command {
+	opts := &ledgerOptions{}
+	var txID, anchor string
+	var block uint64
+
+	cmd := &cobra.Command{
+		Use:   "create <key>",
+		Short: "Prove the last write of a key, at or before a transaction or block",
+		Long: "Prove the last write of a document key in the journal of a file ledger, or the last one at\n" +
+			"or before --tx or --block. The proof is anchored to the head of the chain, or to the block\n" +
+			"whose published hash is given by --anchor. The table format prints the compact proof; json\n" +
+			"and yaml print it decoded, with the hashes of its headers.",
+		Example: "smart_plane proof create doc-42 > doc-42.proof\n" +
+			"  smart_plane proof create doc-42 --tx 3f2a9c --anchor 60c851b3... -o json",
+		Args: usageArgs(cobra.ExactArgs(1)),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd, ledgerBindings)
+			if err != nil {
+				return err
+			}
+			opts.backend, opts.ledgerDir = cfg.Ledger.Backend, cfg.Ledger.Dir
+			if txID != "" && cmd.Flags().Changed("block") {
+				return &UsageError{Err: fmt.Errorf("--tx and --block are mutually exclusive")}
+			}
+			return checkOutputFormat(opts.output)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			proofOpts := lg.ProofOptions{TxID: txID, Anchor: anchor}
+			if cmd.Flags().Changed("block") {
+				proofOpts.Block = &block
+			}
+			return opts.runFile(func(ledger *lg.FileLedger) error {
+				proof, err := ledger.Prove(args[0], proofOpts)
+				if err != nil {
+					return err
+				}
+				if opts.output == OutputTable {
+					encoded, err := proof.Encode()
+					if err != nil {
+						return err
+					}
+					_, err = fmt.Fprintln(cmd.OutOrStdout(), encoded)
+					return err
+				}
+				return printOutput(cmd, opts.output, proof, nil)
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&opts.backend, "backend", "b", lg.BackendFile, "Ledger backend (only file keeps blocks)")
+	cmd.Flags().StringVarP(&opts.ledgerDir, "ledger-dir", "l", defaultLedgerDir(), "Ledger directory for the file backend")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", OutputTable, "Output format (json, yaml, table)")
+	cmd.Flags().StringVar(&txID, "tx", "", "Prove the last write at or before this transaction")
+	cmd.Flags().Uint64Var(&block, "block", 0, "Prove the last write at or before this block number")
+	cmd.Flags().StringVar(&anchor, "anchor", "", "Published block hash to anchor the proof to (default the head of the chain)")
+	return cmd
+}
+
+func proofVerifyCmd() *cobra.Command {
+	var root, output string
+
+	cmd := &cobra.Command{
+		Use:   "verify <proof>",
+		Short: "Verify a proof against a published root hash (- reads it from stdin, @file from a file)",
+		Long: "Verify a compact or JSON proof against the published hash of its anchor block: the proved\n" +
+			"block must link to it through the headers of the proof. It reads no ledger and no\n" +
+			"configuration.",
+		Example: "smart_plane proof verify @doc-42.proof --root 60c851b3...\n" +
+			"  smart_plane proof create doc-42 | smart_plane proof verify - --root 60c851b3...",
+		Args: usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutputFormat(output); err != nil {
+				return err
+			}
+			if strings.TrimSpace(root) == "" {
+				return &UsageError{Err: fmt.Errorf("--root is required")}
+			}
+			text, err := readProof(cmd, args[0])
+			if err != nil {
+				return err
+			}
+			proof, err := lg.DecodeProof(text)
+			if err != nil {
+				return err
+			}
+			if err := lg.VerifyProof(proof, root); err != nil {
+				return err
+			}
+			return printOutput(cmd, output, proofResult{Valid: true, Proof: proof}, func(w io.Writer) error {
+				value := string(proof.Value)
+				if proof.IsDelete {
+					value = "(deleted)"
+				}
+				_, _ = fmt.Fprintf(w, "valid\t%t\n", true)
+				_, _ = fmt.Fprintf(w, "key\t%s\n", proof.Key)
+				_, _ = fmt.Fprintf(w, "value\t%s\n", formatValue(value))
+				_, _ = fmt.Fprintf(w, "block\t%d\n", proof.Block.Number)
+				_, _ = fmt.Fprintf(w, "transaction\t%s\n", proof.Block.TxID)
+				_, _ = fmt.Fprintf(w, "timestamp\t%s\n", proof.Block.Timestamp.Format(time.RFC3339Nano))
+				_, _ = fmt.Fprintf(w, "block hash\t%s\n", proof.Block.Hash)
+				_, _ = fmt.Fprintf(w, "merkle root\t%s\n", proof.Block.MerkleRoot)
+				anchor := proof.Anchor()
+				_, err := fmt.Fprintf(w, "anchor\tblock %d, %s\n", anchor.Number, anchor.Hash)
+				return err
+			})
+		},
+	}
+	cmd.Flags().StringVar(&root, "root", "", "Published hash of the anchor block")
+	cmd.Flags().StringVarP(&output, "output", "o", OutputTable, "Output format (json, yaml, table)")
+	return cmd
+}
+
+// readProof returns the proof argument, reading it from stdin for "-" and from a file for
+// "@file".
+func readProof(cmd *cobra.Command, arg string) (string, error) {
+	var data []byte
+	var err error
+	switch {
+	case arg == "-":
+		data, err = io.ReadAll(cmd.InOrStdin())
+	case strings.HasPrefix(arg, "@"):
+		data, err = os.ReadFile(arg[1:])
+	default:
+		return arg, nil
+	}
+	if err != nil {
+		return "", &UnavailableError{Err: err}
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return "", &UsageError{Err: fmt.Errorf("no proof in %s", arg)}
+	}
+	return string(data), nil
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	sc "github.com/rafa-mori/smart_plane/internal/smart_contracts"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	vs "github.com/rafa-mori/smart_plane/version"
+	"github.com/spf13/cobra"
+)
+
+// defaultSchemaDir returns the default directory of the contract payload schemas.
+func defaultSchemaDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".", ".smart_plane", "schemas")
+	}
+	return filepath.Join(home, ".smart_plane", "schemas")
+}
+
+// SchemasCmd returns the command group for the contract payload schemas.
+func SchemasCmd() *cobra.Command {
+	var schemaDir string
+
+	cmd := &cobra.Command{
+		Use:   "schemas",
+		Short: "Inspect and export contract payload schemas",
+		Long: "Inspect and export the JSON Schemas enforced on contract payloads.\n" +
+			"Schemas are read from <schema-dir>/<ContractName>" + sc.PayloadSchemaFileSuffix + ".",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd, ledgerBindings)
+			if err != nil {
+				return err
+			}
+			schemaDir = cfg.Ledger.SchemaDir
+			return nil
+		},
+	}
+	cmd.PersistentFlags().StringVar(&schemaDir, "schema-dir", defaultSchemaDir(), "Directory of the contract payload schemas")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the contracts with a payload schema",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			loaded, err := sc.LoadPayloadSchemas(schemaDir)
+			if err != nil {
+				gl.Log("error", fmt.Sprintf("Failed to load payload schemas: %v", err))
+				return err
+			}
+			for _, name := range loaded {
+				_, _ = fmt.Fprintln(cmd.OutOrStdout(), name)
+			}
+			return nil
+		},
+	}
+
+	var output string
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the payload schemas as an OpenAPI document",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := sc.LoadPayloadSchemas(schemaDir); err != nil {
+				gl.Log("error", fmt.Sprintf("Failed to load payload schemas: %v", err))
+				return err
+			}
+			doc, err := json.MarshalIndent(sc.PayloadSchemasOpenAPI("SmartPlane", vs.GetVersion()), "", "  ")
+			if err != nil {
+				return err
+			}
+			if output == "" || output == "-" {
+				_, err = fmt.Fprintln(cmd.OutOrStdout(), string(doc))
+				return err
+			}
+			return os.WriteFile(output, append(doc, '\n'), 0o644)
+		},
+	}
+	exportCmd.Flags().StringVarP(&output, "output", "o", "", "Output file (default: stdout)")
+
+	cmd.AddCommand(listCmd, exportCmd)
+	return cmd
+}
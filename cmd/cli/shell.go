@@ -0,0 +1,553 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+	ds "github.com/rafa-mori/smart_documents/data_structures"
+	cf "github.com/rafa-mori/smart_plane/internal/config"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	sc "github.com/rafa-mori/smart_plane/internal/smart_contracts"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	"github.com/spf13/cobra"
+)
+
+// documentStore is implemented by BlockchainManager and by Tx: the shell writes through the
+// open transaction, or straight to the manager.
+type documentStore interface {
+	RegisterDocument(contractName, id, content string) error
+	GetDocumentState(contractName, id string) (*ds.Document, error)
+	GetDocumentHistory(contractName, id string) ([]string, error)
+	ApproveDocument(contractName, id string) error
+	SignDocument(contractName, id, signature string) error
+	DeleteDocumentState(contractName, id string) error
+}
+
+// shellSession is the state of an interactive shell.
+type shellSession struct {
+	opts   *documentOptions
+	ledger lg.ILedger
+	bm     *sc.BlockchainManager
+	// tx is the open transaction, nil outside of tx begin/commit.
+	tx *sc.Tx
+	// usage is the usage template of the shell command, reused by the shell commands.
+	usage string
+	out   io.Writer
+}
+
+func defaultHistoryFile() string { return filepath.Join(cf.Dir(), "shell_history") }
+
+// ShellCmd returns the interactive shell over the blockchain manager.
+func ShellCmd() *cobra.Command {
+	opts := &documentOptions{}
+	var historyFile string
+
+	cmd := &cobra.Command{
+		Use:   "shell",
+		Short: "Interactive shell to explore and change the ledger documents",
+		Long: "Open a prompt with history and tab completion over contract names and document IDs.\n" +
+			"Type help for the commands; tx begin starts a transaction committed by tx commit.",
+		Args: usageArgs(cobra.NoArgs),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd, ledgerBindings)
+			if err != nil {
+				return err
+			}
+			opts.backend, opts.ledgerDir, opts.schemaDir = cfg.Ledger.Backend, cfg.Ledger.Dir, cfg.Ledger.SchemaDir
+			opts.enabled = cfg.Contracts.Enabled
+			opts.webhooks, opts.broker = cfg.Webhooks, cfg.Broker
+			return checkOutputFormat(opts.output)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ledger, err := lg.Open(opts.backend, opts.ledgerDir)
+			if err != nil {
+				gl.Log("error", fmt.Sprintf("Failed to open ledger: %v", err))
+				return &UnavailableError{Err: err}
+			}
+			defer func() { _ = ledger.Close() }()
+
+			if _, err := sc.LoadPayloadSchemas(opts.schemaDir); err != nil {
+				return &UnavailableError{Err: err}
+			}
+			bm := sc.NewBlockchainManagerWithLedger(ledger)
+			if len(opts.enabled) > 0 {
+				if err := bm.SetEnabledContracts(opts.enabled...); err != nil {
+					return err
+				}
+			}
+			stop, err := startEvents(opts, ledger, bm)
+			if err != nil {
+				return err
+			}
+			defer stop()
+
+			s := &shellSession{opts: opts, ledger: ledger, bm: bm, usage: cmd.UsageTemplate(), out: cmd.OutOrStdout()}
+			return s.loop(historyFile)
+		},
+	}
+	cmd.Flags().StringVarP(&opts.backend, "backend", "b", lg.BackendFile, "Ledger backend (file, memory)")
+	cmd.Flags().StringVarP(&opts.ledgerDir, "ledger-dir", "l", defaultLedgerDir(), "Ledger directory for the file backend")
+	cmd.Flags().StringVar(&opts.schemaDir, "schema-dir", defaultSchemaDir(), "Directory of the contract payload schemas")
+	cmd.Flags().StringVarP(&opts.contract, "contract", "c", "ApprovalContract", "Initial contract of the session")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", OutputYAML, "Output format (json, yaml, table)")
+	cmd.Flags().StringVar(&historyFile, "history", defaultHistoryFile(), "History file (empty disables it)")
+	return cmd
+}
+
+// loop reads and runs the shell lines until exit or end of input.
+func (s *shellSession) loop(historyFile string) error {
+	if historyFile != "" {
+		if err := os.MkdirAll(filepath.Dir(historyFile), 0o700); err != nil {
+			gl.Log("warn", fmt.Sprintf("Failed to create history directory: %v", err))
+			historyFile = ""
+		}
+	}
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:            s.prompt(),
+		HistoryFile:       historyFile,
+		HistoryLimit:      1000,
+		HistorySearchFold: true,
+		AutoComplete:      s.completer(),
+		InterruptPrompt:   "^C",
+		EOFPrompt:         "exit",
+	})
+	if err != nil {
+		return &UnavailableError{Err: err}
+	}
+	defer func() { _ = rl.Close() }()
+	defer s.closeTx()
+
+	_, _ = fmt.Fprintln(s.out, color.New(color.FgCyan).Sprint("SmartPlane shell: type help for the commands, exit to quit."))
+	for {
+		rl.SetPrompt(s.prompt())
+		line, err := rl.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			continue
+		}
+		if err != nil {
+			return nil
+		}
+		args, err := splitShellLine(line)
+		if err != nil {
+			s.printError(err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			return nil
+		}
+		root := s.commands()
+		root.SetArgs(args)
+		if err := root.Execute(); err != nil {
+			s.printError(err)
+		}
+	}
+}
+
+// prompt shows the current contract, and the open transaction.
+func (s *shellSession) prompt() string {
+	prompt := color.New(color.FgGreen).Sprint("smart_plane") + "(" + color.New(color.FgYellow).Sprint(s.opts.contract) + ")"
+	if s.tx != nil {
+		state := "tx"
+		if s.tx.Err() != nil {
+			state = "tx failed"
+		}
+		prompt += color.New(color.FgRed).Sprint("[" + state + "]")
+	}
+	return prompt + "> "
+}
+
+func (s *shellSession) printError(err error) {
+	_, _ = fmt.Fprintln(s.out, color.New(color.FgRed).Sprint("Error: ")+err.Error())
+}
+
+// completer completes the commands, the contract names and the document IDs.
+func (s *shellSession) completer() *readline.PrefixCompleter {
+	ids := func() readline.PrefixCompleterInterface {
+		return readline.PcItemDynamic(func(string) []string { return s.documentIDs() })
+	}
+	return readline.NewPrefixCompleter(
+		readline.PcItem("use", readline.PcItemDynamic(func(string) []string { return s.contractNames() })),
+		readline.PcItem("contracts"),
+		readline.PcItem("ids"),
+		readline.PcItem("register", ids()),
+		readline.PcItem("get", ids()),
+		readline.PcItem("history", ids()),
+		readline.PcItem("approve", ids()),
+		readline.PcItem("sign", ids()),
+		readline.PcItem("delete", ids()),
+		readline.PcItem("tx",
+			readline.PcItem("begin"),
+			readline.PcItem("commit"),
+			readline.PcItem("rollback"),
+			readline.PcItem("status"),
+		),
+		readline.PcItem("output",
+			readline.PcItem(OutputJSON),
+			readline.PcItem(OutputYAML),
+			readline.PcItem(OutputTable),
+		),
+		readline.PcItem("help"),
+		readline.PcItem("exit"),
+	)
+}
+
+// contractNames returns the contracts served in the session.
+func (s *shellSession) contractNames() []string {
+	if len(s.opts.enabled) > 0 {
+		return s.opts.enabled
+	}
+	return sc.ContractNames()
+}
+
+// documentIDs returns the IDs of the documents stored in the ledger.
+func (s *shellSession) documentIDs() []string {
+	keys, err := s.ledger.Keys()
+	if err != nil {
+		return nil
+	}
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !lg.IsSystemKey(key) {
+			ids = append(ids, key)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// store returns the open transaction, or the manager outside of a transaction.
+func (s *shellSession) store() documentStore {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.bm
+}
+
+// closeTx rolls back a transaction left open when the shell exits.
+func (s *shellSession) closeTx() {
+	if s.tx != nil {
+		s.tx.Rollback()
+		s.tx = nil
+		_, _ = fmt.Fprintln(s.out, color.New(color.FgYellow).Sprint("open transaction rolled back"))
+	}
+}
+
+// commands builds the command tree of a shell line. It is rebuilt for every line so flag
+// values do not leak from one line to the next.
+func (s *shellSession) commands() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	root.SetOut(s.out)
+	root.SetErr(s.out)
+	root.SetUsageTemplate(s.usage)
+	root.CompletionOptions.DisableDefaultCmd = true
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error { return &UsageError{Err: err} })
+
+	// contract returns the --contract flag of a command, or the current contract.
+	contract := func(cmd *cobra.Command) string {
+		if name, _ := cmd.Flags().GetString("contract"); name != "" {
+			return name
+		}
+		return s.opts.contract
+	}
+	withContract := func(cmd *cobra.Command) *cobra.Command {
+		cmd.Flags().StringP("contract", "c", "", "Contract, instead of the current one")
+		return cmd
+	}
+	write := func(action string, fn func(cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			if err := fn(cmd, args); err != nil {
+				return err
+			}
+			state := "committed"
+			if s.tx != nil {
+				state = "staged in " + s.tx.ID()
+			}
+			_, err := fmt.Fprintf(s.out, "%s %s %s: %s\n", contract(cmd), args[0], action, state)
+			return err
+		}
+	}
+
+	register := withContract(&cobra.Command{
+		Use:     "register <id> [content]",
+		Short:   "Register a document; quote the content, or read it with --file",
+		Example: `register doc-1 '{"title": "Contract"}'`,
+		Args:    usageArgs(cobra.RangeArgs(1, 2)),
+		RunE: write(sc.DocumentActionRegistered, func(cmd *cobra.Command, args []string) error {
+			content := ""
+			if len(args) == 2 {
+				content = args[1]
+			} else if file, _ := cmd.Flags().GetString("file"); file != "" {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				content = strings.TrimSpace(string(data))
+			}
+			if content == "" {
+				return &UsageError{Err: fmt.Errorf("no document content: pass it or use --file")}
+			}
+			return s.store().RegisterDocument(contract(cmd), args[0], content)
+		}),
+	})
+	register.Flags().StringP("file", "f", "", "File with the document content")
+
+	sign := withContract(&cobra.Command{
+		Use:   "sign <id> <signature>",
+		Short: "Sign a document",
+		Args:  usageArgs(cobra.ExactArgs(2)),
+		RunE: write(sc.DocumentActionSigned, func(cmd *cobra.Command, args []string) error {
+			return s.store().SignDocument(contract(cmd), args[0], args[1])
+		}),
+	})
+
+	root.AddCommand(
+		&cobra.Command{
+			Use:   "use <contract>",
+			Short: "Set the current contract",
+			Args:  usageArgs(cobra.ExactArgs(1)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if !slices.Contains(s.contractNames(), args[0]) {
+					return &sc.ContractNotFoundError{ContractName: args[0]}
+				}
+				s.opts.contract = args[0]
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "contracts",
+			Short: "List the contracts",
+			Args:  usageArgs(cobra.NoArgs),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				for _, name := range s.contractNames() {
+					marker := " "
+					if name == s.opts.contract {
+						marker = "*"
+					}
+					_, _ = fmt.Fprintf(s.out, "%s %s\n", marker, name)
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "ids",
+			Short: "List the document IDs of the ledger",
+			Args:  usageArgs(cobra.NoArgs),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				for _, id := range s.documentIDs() {
+					_, _ = fmt.Fprintln(s.out, id)
+				}
+				return nil
+			},
+		},
+		register,
+		withContract(&cobra.Command{
+			Use:   "get <id>",
+			Short: "Show the current state of a document",
+			Args:  usageArgs(cobra.ExactArgs(1)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				state, err := s.store().GetDocumentState(contract(cmd), args[0])
+				if err != nil {
+					return err
+				}
+				return printOutput(cmd, s.opts.output, state, nil)
+			},
+		}),
+		withContract(&cobra.Command{
+			Use:   "history <id>",
+			Short: "Show the history of a document",
+			Args:  usageArgs(cobra.ExactArgs(1)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				history, err := s.store().GetDocumentHistory(contract(cmd), args[0])
+				if err != nil {
+					return err
+				}
+				return printOutput(cmd, s.opts.output, history, func(w io.Writer) error {
+					_, _ = fmt.Fprintln(w, "#\tENTRY")
+					for i, entry := range history {
+						if _, err := fmt.Fprintf(w, "%d\t%s\n", i+1, formatValue(entry)); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			},
+		}),
+		withContract(&cobra.Command{
+			Use:   "approve <id>",
+			Short: "Approve a document",
+			Args:  usageArgs(cobra.ExactArgs(1)),
+			RunE: write(sc.DocumentActionApproved, func(cmd *cobra.Command, args []string) error {
+				return s.store().ApproveDocument(contract(cmd), args[0])
+			}),
+		}),
+		sign,
+		withContract(&cobra.Command{
+			Use:   "delete <id>",
+			Short: "Delete the state of a document",
+			Args:  usageArgs(cobra.ExactArgs(1)),
+			RunE: write(sc.DocumentActionDeleted, func(cmd *cobra.Command, args []string) error {
+				return s.store().DeleteDocumentState(contract(cmd), args[0])
+			}),
+		}),
+		s.txCmd(),
+		&cobra.Command{
+			Use:   "output <json|yaml|table>",
+			Short: "Set the output format of get and history",
+			Args:  usageArgs(cobra.ExactArgs(1)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := checkOutputFormat(args[0]); err != nil {
+					return err
+				}
+				s.opts.output = args[0]
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "exit",
+			Short: "Leave the shell, rolling back an open transaction",
+			Run:   func(cmd *cobra.Command, args []string) {},
+		},
+	)
+	return root
+}
+
+// txCmd returns the transaction commands: writes between begin and commit are applied
+// together, or not at all.
+func (s *shellSession) txCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tx",
+		Short: "Begin, commit or roll back a transaction",
+	}
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "begin",
+			Short: "Start a transaction; the following writes are staged until commit",
+			Args:  usageArgs(cobra.NoArgs),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if s.tx != nil {
+					return fmt.Errorf("transaction %s already open", s.tx.ID())
+				}
+				s.tx = s.bm.Begin()
+				_, err := fmt.Fprintf(s.out, "transaction %s open\n", s.tx.ID())
+				return err
+			},
+		},
+		&cobra.Command{
+			Use:   "commit",
+			Short: "Commit the staged writes",
+			Args:  usageArgs(cobra.NoArgs),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if s.tx == nil {
+					return fmt.Errorf("no open transaction")
+				}
+				tx := s.tx
+				s.tx = nil
+				if err := tx.Commit(); err != nil {
+					return fmt.Errorf("transaction %s rolled back: %w", tx.ID(), err)
+				}
+				_, err := fmt.Fprintf(s.out, "transaction %s committed\n", tx.ID())
+				return err
+			},
+		},
+		&cobra.Command{
+			Use:   "rollback",
+			Short: "Discard the staged writes",
+			Args:  usageArgs(cobra.NoArgs),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if s.tx == nil {
+					return fmt.Errorf("no open transaction")
+				}
+				s.tx.Rollback()
+				_, err := fmt.Fprintf(s.out, "transaction %s rolled back\n", s.tx.ID())
+				s.tx = nil
+				return err
+			},
+		},
+		&cobra.Command{
+			Use:   "status",
+			Short: "Show the open transaction",
+			Args:  usageArgs(cobra.NoArgs),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				switch {
+				case s.tx == nil:
+					_, _ = fmt.Fprintln(s.out, "no open transaction")
+				case s.tx.Err() != nil:
+					_, _ = fmt.Fprintf(s.out, "transaction %s failed: %v\n", s.tx.ID(), s.tx.Err())
+				default:
+					_, _ = fmt.Fprintf(s.out, "transaction %s open\n", s.tx.ID())
+				}
+				return nil
+			},
+		},
+	)
+	return cmd
+}
+
+// splitShellLine splits a line into words like a POSIX shell: blanks separate words, single
+// quotes keep their content verbatim, double quotes and backslashes escape.
+func splitShellLine(line string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
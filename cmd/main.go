@@ -1,7 +1,10 @@
 package main
 
 import (
+	"os"
+
 	l "github.com/faelmori/logz"
+	cc "github.com/rafa-mori/smart_plane/cmd/cli"
 	gl "github.com/rafa-mori/smart_plane/logger"
 )
 
@@ -10,6 +13,7 @@ var logger l.Logger
 // main initializes the logger and creates a new SmartPlane instance.
 func main() {
 	if err := RegX().Command().Execute(); err != nil {
-		gl.Log("fatal", err.Error())
+		gl.Log("error", err.Error())
+		os.Exit(cc.ExitCode(err))
 	}
 }
@@ -2,10 +2,12 @@ package main
 
 import (
 	cc "github.com/rafa-mori/smart_plane/cmd/cli"
+	cf "github.com/rafa-mori/smart_plane/internal/config"
 	gl "github.com/rafa-mori/smart_plane/logger"
 	vs "github.com/rafa-mori/smart_plane/version"
 	"github.com/spf13/cobra"
 
+	"fmt"
 	"os"
 	"strings"
 )
@@ -53,6 +55,21 @@ func (m *SmartPlane) Command() *cobra.Command {
 	// rtCmd.AddCommand(cc.ServiceCmdList()...)
 
 	rtCmd.AddCommand(vs.CliCommand())
+	rtCmd.AddCommand(cc.MigrateCmd())
+	rtCmd.AddCommand(cc.SchemasCmd())
+	rtCmd.AddCommand(cc.DocumentCmd())
+	rtCmd.AddCommand(cc.AuthCmd())
+	rtCmd.AddCommand(cc.ConfigCmd())
+	rtCmd.AddCommand(cc.ShellCmd())
+	rtCmd.AddCommand(cc.LedgerCmd())
+	rtCmd.AddCommand(cc.ProofCmd())
+
+	rtCmd.PersistentFlags().String(cc.ConfigFlag, "", "Configuration file (YAML, TOML or JSON; default $"+cf.EnvConfigFile+" or "+cf.DefaultFile()+")")
+
+	rtCmd.SilenceUsage = true
+	rtCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &cc.UsageError{Err: err}
+	})
 
 	// Set usage definitions for the command and its subcommands
 	setUsageDefinition(rtCmd)
@@ -83,12 +100,20 @@ func (m *SmartPlane) concatenateExamples() string {
 }
 
 func RegX() *SmartPlane {
-	var printBannerV = os.Getenv("GOBEMIN_PRINT_BANNER")
-	if printBannerV == "" {
-		printBannerV = "true"
+	// The banner is needed before the flags are parsed, so only the file named by
+	// SMART_PLANE_CONFIG or found in the config directories is read here.
+	printBanner := true
+	if cfg, err := cf.Load(cf.Options{}); err != nil {
+		gl.Log("warn", fmt.Sprintf("Failed to load configuration: %v", err))
+	} else {
+		printBanner = cfg.Banner
+	}
+	// GOBEMIN_PRINT_BANNER is kept for compatibility and wins over the configuration.
+	if printBannerV := os.Getenv("GOBEMIN_PRINT_BANNER"); printBannerV != "" {
+		printBanner = strings.ToLower(printBannerV) == "true"
 	}
 
 	return &SmartPlane{
-		printBanner: strings.ToLower(printBannerV) == "true",
+		printBanner: printBanner,
 	}
 }
@@ -2,6 +2,7 @@ package authentication
 
 import (
 	"crypto/rsa"
+	"errors"
 	"fmt"
 	"time"
 
@@ -17,6 +18,21 @@ type AuthManager struct {
 	refreshSecret         string
 	idExpirationSecs      int64
 	refreshExpirationSecs int64
+
+	// keyID is the `kid` header of the issued tokens, empty without a keystore.
+	keyID string
+	// publicKey resolves the verification key of a `kid`, so tokens signed before a
+	// rotation still verify. It is nil without a keystore.
+	publicKey func(kid string) (*rsa.PublicKey, error)
+}
+
+// ErrInvalidToken wraps the errors of tokens that fail verification.
+var ErrInvalidToken = errors.New("invalid token")
+
+// TokenClaims are the claims of the tokens issued by IssueToken.
+type TokenClaims struct {
+	Roles []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
 }
 
 func NewAuthManager(certService fsi.CertService) (*AuthManager, error) {
@@ -41,6 +57,89 @@ func NewAuthManager(certService fsi.CertService) (*AuthManager, error) {
 	}, nil
 }
 
+// NewAuthManagerWithKeyStore creates an AuthManager signing with the current key of a
+// keystore and verifying with any key it still holds.
+func NewAuthManagerWithKeyStore(ks *KeyStore) (*AuthManager, error) {
+	info, privKey, err := ks.Current()
+	if err != nil {
+		logger.Log("error", fmt.Sprintf("Failed to load private key: %v", err))
+		return nil, err
+	}
+
+	return &AuthManager{
+		privKey:               privKey,
+		pubKey:                &privKey.PublicKey,
+		refreshSecret:         "default_refresh_secret", // Replace with a secure secret
+		idExpirationSecs:      3600,                     // 1 hour
+		refreshExpirationSecs: 604800,                   // 7 days
+		keyID:                 info.ID,
+		publicKey:             ks.PublicKey,
+	}, nil
+}
+
+// IssueToken signs an RS256 token for a subject and its roles, valid for ttl (the ID token
+// expiration when ttl is not positive).
+func (am *AuthManager) IssueToken(subject string, roles []string, ttl time.Duration) (string, *TokenClaims, error) {
+	if subject == "" {
+		return "", nil, fmt.Errorf("token subject cannot be empty")
+	}
+	if ttl <= 0 {
+		ttl = time.Duration(am.idExpirationSecs) * time.Second
+	}
+	now := time.Now()
+	claims := &TokenClaims{
+		Roles: roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ID:        uuid.New().String(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if am.keyID != "" {
+		token.Header["kid"] = am.keyID
+	}
+	signed, err := token.SignedString(am.privKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// VerifyToken checks the RS256 signature and the time claims of a token issued by
+// IssueToken. Verification failures wrap ErrInvalidToken.
+func (am *AuthManager) VerifyToken(tokenString string) (*TokenClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" || am.publicKey == nil {
+			return am.pubKey, nil
+		}
+		return am.publicKey(kid)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	claims, ok := token.Claims.(*TokenClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// InspectToken decodes the header and the claims of a token without verifying it.
+func InspectToken(tokenString string) (map[string]interface{}, *TokenClaims, error) {
+	claims := &TokenClaims{}
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, claims)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return token.Header, claims, nil
+}
+
 func (am *AuthManager) GenerateIDToken(userID string) (string, error) {
 	claims := jwt.RegisteredClaims{
 		Subject:   userID,
@@ -0,0 +1,415 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rafa-mori/smart_plane/logger"
+	t "github.com/rafa-mori/smart_plane/types"
+)
+
+const (
+	// DefaultKeyBits is the size of the RSA keys generated by the keystore.
+	DefaultKeyBits = 2048
+	// keyStoreManifest is the name of the keystore index file.
+	keyStoreManifest = "keystore.json"
+	// keyStoreLock is the name of the lock file shared by the processes using the keystore.
+	keyStoreLock = "keystore.lock"
+	// keyStoreDirPerm and keyFilePerm are the permissions of the keystore and its private keys.
+	keyStoreDirPerm = 0o700
+	keyFilePerm     = 0o600
+)
+
+var (
+	// ErrKeyNotFound is returned for a key ID that is not in the keystore.
+	ErrKeyNotFound = errors.New("not found in keystore")
+	// ErrKeyExists is returned when generating the first key of a keystore that has one.
+	ErrKeyExists = errors.New("already has key")
+)
+
+// InsecurePermissionsError is returned when a keystore file can be read by other users.
+type InsecurePermissionsError struct {
+	Path string
+	Mode os.FileMode
+}
+
+func (e *InsecurePermissionsError) Error() string {
+	return fmt.Sprintf("insecure permissions %04o on %s: it must not be accessible by group or others", e.Mode.Perm(), e.Path)
+}
+
+// KeyInfo describes a signing key of the keystore.
+type KeyInfo struct {
+	// ID is the key ID, sent as the `kid` header of the tokens signed with the key.
+	ID        string `json:"id"`
+	Algorithm string `json:"algorithm"`
+	Bits      int    `json:"bits"`
+	// Fingerprint is the SHA-256 of the DER encoded public key.
+	Fingerprint string     `json:"fingerprint"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	RetiredAt   *time.Time `json:"retiredAt,omitempty"`
+	Current     bool       `json:"current"`
+}
+
+// keyStoreIndex is the content of the keystore manifest.
+type keyStoreIndex struct {
+	Current string    `json:"current"`
+	Keys    []KeyInfo `json:"keys"`
+}
+
+// KeyStore keeps the RSA token signing keys in a local directory: one PKCS#8 PEM file per
+// private key and a manifest naming the current key. Rotated keys are kept to verify the
+// tokens they signed. The directory and the private keys must only be accessible by their
+// owner.
+//
+// Several processes may use the same directory: changes hold an exclusive file lock and
+// reads a shared one.
+type KeyStore struct {
+	dir string
+	// lock excludes the other processes using the directory.
+	lock *t.FileMutexes
+}
+
+// OpenKeyStore opens the keystore in dir, creating the directory when needed.
+func OpenKeyStore(dir string) (*KeyStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("keystore directory cannot be empty")
+	}
+	if err := os.MkdirAll(dir, keyStoreDirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create keystore directory: %w", err)
+	}
+	if err := checkPermissions(dir); err != nil {
+		return nil, err
+	}
+	lock, err := t.NewFileMutexes(filepath.Join(dir, keyStoreLock), t.DefaultFileLeaseTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create keystore lock: %w", err)
+	}
+	return &KeyStore{dir: dir, lock: lock}, nil
+}
+
+// Dir returns the keystore directory.
+func (ks *KeyStore) Dir() string { return ks.dir }
+
+// rlock takes the shared keystore lock, for reads.
+func (ks *KeyStore) rlock() error {
+	if err := ks.lock.MuRLockCtx(context.Background()); err != nil {
+		return fmt.Errorf("failed to lock keystore %s: %w", ks.dir, err)
+	}
+	return nil
+}
+
+// wlock takes the exclusive keystore lock, for changes.
+func (ks *KeyStore) wlock() error {
+	if err := ks.lock.MuLockCtx(context.Background()); err != nil {
+		return fmt.Errorf("failed to lock keystore %s: %w", ks.dir, err)
+	}
+	return nil
+}
+
+// Keys returns the keys of the keystore, newest first.
+func (ks *KeyStore) Keys() ([]KeyInfo, error) {
+	if err := ks.rlock(); err != nil {
+		return nil, err
+	}
+	defer ks.lock.MuRUnlock()
+
+	index, err := ks.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	return index.Keys, nil
+}
+
+// Current returns the current signing key.
+func (ks *KeyStore) Current() (KeyInfo, *rsa.PrivateKey, error) {
+	if err := ks.rlock(); err != nil {
+		return KeyInfo{}, nil, err
+	}
+	defer ks.lock.MuRUnlock()
+
+	index, err := ks.readIndex()
+	if err != nil {
+		return KeyInfo{}, nil, err
+	}
+	if index.Current == "" {
+		return KeyInfo{}, nil, fmt.Errorf("keystore %s has no key: generate one first", ks.dir)
+	}
+	for _, info := range index.Keys {
+		if info.ID == index.Current {
+			key, err := ks.readPrivateKey(info.ID)
+			return info, key, err
+		}
+	}
+	return KeyInfo{}, nil, fmt.Errorf("current key %s is missing from keystore %s", index.Current, ks.dir)
+}
+
+// PublicKey returns the public key of a key ID, current or retired.
+func (ks *KeyStore) PublicKey(id string) (*rsa.PublicKey, error) {
+	if err := ks.rlock(); err != nil {
+		return nil, err
+	}
+	defer ks.lock.MuRUnlock()
+
+	index, err := ks.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range index.Keys {
+		if info.ID == id {
+			key, err := ks.readPrivateKey(id)
+			if err != nil {
+				return nil, err
+			}
+			return &key.PublicKey, nil
+		}
+	}
+	return nil, fmt.Errorf("key %s %w", id, ErrKeyNotFound)
+}
+
+// GetPrivateKey returns the current private key.
+func (ks *KeyStore) GetPrivateKey() (*rsa.PrivateKey, error) {
+	_, key, err := ks.Current()
+	return key, err
+}
+
+// GetPublicKey returns the current public key.
+func (ks *KeyStore) GetPublicKey() (*rsa.PublicKey, error) {
+	key, err := ks.GetPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &key.PublicKey, nil
+}
+
+// Generate creates the first key of the keystore. It fails when a key already exists; use
+// Rotate to replace it.
+func (ks *KeyStore) Generate(bits int) (KeyInfo, error) {
+	if err := ks.wlock(); err != nil {
+		return KeyInfo{}, err
+	}
+	defer ks.lock.MuUnlock()
+
+	index, err := ks.readIndex()
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if index.Current != "" {
+		return KeyInfo{}, fmt.Errorf("keystore %s %w %s: use rotate to replace it", ks.dir, ErrKeyExists, index.Current)
+	}
+	return ks.addKey(index, bits, 0)
+}
+
+// Rotate makes a new key current. The previous keys are retired but kept for verification,
+// up to retain of them (all of them when retain is negative).
+func (ks *KeyStore) Rotate(bits, retain int) (KeyInfo, error) {
+	if err := ks.wlock(); err != nil {
+		return KeyInfo{}, err
+	}
+	defer ks.lock.MuUnlock()
+
+	index, err := ks.readIndex()
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	return ks.addKey(index, bits, retain)
+}
+
+// addKey generates a key, makes it current and prunes the retired keys beyond retain. The
+// pruned key files are only removed once the manifest no longer names them. The caller must
+// hold the exclusive lock.
+func (ks *KeyStore) addKey(index *keyStoreIndex, bits, retain int) (KeyInfo, error) {
+	if bits <= 0 {
+		bits = DefaultKeyBits
+	}
+	if bits < 2048 {
+		return KeyInfo{}, fmt.Errorf("RSA keys must have at least 2048 bits, got %d", bits)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("failed to encode key: %w", err)
+	}
+	fingerprint, err := publicKeyFingerprint(&key.PublicKey)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	info := KeyInfo{
+		ID:          uuid.New().String(),
+		Algorithm:   "RS256",
+		Bits:        bits,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now().UTC(),
+		Current:     true,
+	}
+	if err := writeFileAtomic(ks.keyPath(info.ID), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), keyFilePerm); err != nil {
+		return KeyInfo{}, fmt.Errorf("failed to write key: %w", err)
+	}
+
+	now := time.Now().UTC()
+	keys := []KeyInfo{info}
+	pruned := make([]string, 0)
+	retired := 0
+	for _, previous := range index.Keys {
+		if previous.Current {
+			previous.Current = false
+			previous.RetiredAt = &now
+		}
+		if retain >= 0 && retired >= retain {
+			pruned = append(pruned, previous.ID)
+			continue
+		}
+		retired++
+		keys = append(keys, previous)
+	}
+	index.Current = info.ID
+	index.Keys = keys
+	if err := ks.writeIndex(index); err != nil {
+		_ = os.Remove(ks.keyPath(info.ID))
+		return KeyInfo{}, err
+	}
+	for _, id := range pruned {
+		if err := os.Remove(ks.keyPath(id)); err != nil && !os.IsNotExist(err) {
+			logger.Log("warn", fmt.Sprintf("Failed to remove retired key %s: %v", id, err))
+		}
+	}
+	logger.Log("info", fmt.Sprintf("Keystore %s: new current key %s", ks.dir, info.ID))
+	return info, nil
+}
+
+func (ks *KeyStore) keyPath(id string) string { return filepath.Join(ks.dir, id+".pem") }
+
+// readIndex reads the manifest; a missing manifest is an empty keystore. The caller must hold
+// the keystore lock.
+func (ks *KeyStore) readIndex() (*keyStoreIndex, error) {
+	path := filepath.Join(ks.dir, keyStoreManifest)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &keyStoreIndex{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore manifest: %w", err)
+	}
+	var index keyStoreIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("corrupted keystore manifest %s: %w", path, err)
+	}
+	sort.SliceStable(index.Keys, func(i, j int) bool { return index.Keys[i].CreatedAt.After(index.Keys[j].CreatedAt) })
+	return &index, nil
+}
+
+// writeIndex replaces the manifest. The caller must hold the exclusive lock.
+func (ks *KeyStore) writeIndex(index *keyStoreIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode keystore manifest: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(ks.dir, keyStoreManifest), data, keyFilePerm); err != nil {
+		return fmt.Errorf("failed to write keystore manifest: %w", err)
+	}
+	return nil
+}
+
+// readPrivateKey loads a private key after checking its permissions. The caller must hold the
+// keystore lock.
+func (ks *KeyStore) readPrivateKey(id string) (*rsa.PrivateKey, error) {
+	path := ks.keyPath(id)
+	if err := checkPermissions(path); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", id, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("key %s is not a PEM encoded PKCS#8 private key", id)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s: %w", id, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not an RSA key", id)
+	}
+	return key, nil
+}
+
+// PublicKeyPEM encodes a public key as a PKIX PEM block.
+func PublicKeyPEM(key *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// publicKeyFingerprint returns the hex SHA-256 of the DER encoded public key.
+func publicKeyFingerprint(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkPermissions refuses files and directories accessible by group or others. File modes
+// are not meaningful on Windows, where the check is skipped.
+func checkPermissions(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return &InsecurePermissionsError{Path: path, Mode: info.Mode()}
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file through a temporary file in the same directory.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,130 @@
+package authentication
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func openTestKeyStore(t *testing.T) *KeyStore {
+	t.Helper()
+	ks, err := OpenKeyStore(filepath.Join(t.TempDir(), "keys"))
+	if err != nil {
+		t.Fatalf("OpenKeyStore: %v", err)
+	}
+	return ks
+}
+
+func TestKeyStoreGeneratesTheFirstKeyOnce(t *testing.T) {
+	ks := openTestKeyStore(t)
+	if _, _, err := ks.Current(); err == nil {
+		t.Fatal("Current of an empty keystore succeeded")
+	}
+	if _, err := ks.Generate(1024); err == nil {
+		t.Fatal("Generate of a 1024 bits key succeeded")
+	}
+
+	info, err := ks.Generate(0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if !info.Current || info.Bits != DefaultKeyBits || info.Algorithm != "RS256" {
+		t.Fatalf("Generate = %+v, want a current %d bits RS256 key", info, DefaultKeyBits)
+	}
+	current, key, err := ks.Current()
+	if err != nil || current.ID != info.ID {
+		t.Fatalf("Current = %+v, %v, want %s", current, err, info.ID)
+	}
+	fingerprint, err := publicKeyFingerprint(&key.PublicKey)
+	if err != nil || fingerprint != info.Fingerprint {
+		t.Fatalf("fingerprint of the current key = %s, %v, want %s", fingerprint, err, info.Fingerprint)
+	}
+	if runtime.GOOS != "windows" {
+		stat, err := os.Stat(ks.keyPath(info.ID))
+		if err != nil {
+			t.Fatalf("Stat of the key file: %v", err)
+		}
+		if stat.Mode().Perm() != keyFilePerm {
+			t.Fatalf("key file mode = %04o, want %04o", stat.Mode().Perm(), keyFilePerm)
+		}
+	}
+
+	if _, err := ks.Generate(0); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("second Generate = %v, want ErrKeyExists", err)
+	}
+}
+
+func TestKeyStoreRotatesAndPrunesRetiredKeys(t *testing.T) {
+	ks := openTestKeyStore(t)
+	first, err := ks.Generate(0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	second, err := ks.Rotate(0, 1)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	third, err := ks.Rotate(0, 1)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	keys, err := ks.Keys()
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != third.ID || keys[1].ID != second.ID {
+		t.Fatalf("Keys = %+v, want %s then %s", keys, third.ID, second.ID)
+	}
+	if !keys[0].Current || keys[0].RetiredAt != nil || keys[1].Current || keys[1].RetiredAt == nil {
+		t.Fatalf("Keys = %+v, want the newest current and the other retired", keys)
+	}
+	if _, err := ks.PublicKey(second.ID); err != nil {
+		t.Fatalf("PublicKey of the retired key: %v", err)
+	}
+	if _, err := ks.PublicKey(first.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("PublicKey of the pruned key = %v, want ErrKeyNotFound", err)
+	}
+	if _, err := os.Stat(ks.keyPath(first.ID)); !os.IsNotExist(err) {
+		t.Fatalf("file of the pruned key: %v, want it removed", err)
+	}
+
+	if _, err := ks.Rotate(0, -1); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if keys, err := ks.Keys(); err != nil || len(keys) != 3 {
+		t.Fatalf("Keys after a rotation keeping every key = %d, %v, want 3", len(keys), err)
+	}
+}
+
+func TestKeyStoreRefusesFilesAccessibleByOthers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not checked on Windows")
+	}
+
+	dir := filepath.Join(t.TempDir(), "keys")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	var insecure *InsecurePermissionsError
+	if _, err := OpenKeyStore(dir); !errors.As(err, &insecure) || insecure.Path != dir {
+		t.Fatalf("OpenKeyStore of a readable directory = %v, want an InsecurePermissionsError", err)
+	}
+
+	ks := openTestKeyStore(t)
+	info, err := ks.Generate(0)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if err := os.Chmod(ks.keyPath(info.ID), 0o644); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if _, _, err := ks.Current(); !errors.As(err, &insecure) || insecure.Path != ks.keyPath(info.ID) {
+		t.Fatalf("Current with a readable key = %v, want an InsecurePermissionsError", err)
+	}
+	if _, err := ks.PublicKey(info.ID); !errors.As(err, &insecure) {
+		t.Fatalf("PublicKey with a readable key = %v, want an InsecurePermissionsError", err)
+	}
+}
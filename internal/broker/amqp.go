@@ -0,0 +1,144 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	gl "github.com/rafa-mori/smart_plane/logger"
+	"github.com/streadway/amqp"
+)
+
+// DefaultExchange is the AMQP topic exchange ledger events are published to.
+const DefaultExchange = "smart_plane.events"
+
+// AMQPPublisher publishes persistent messages to a durable topic exchange, routed by topic,
+// and waits for the broker confirmation of every message. A lost connection is re-dialed on
+// the next Publish.
+type AMQPPublisher struct {
+	mu       sync.Mutex
+	url      string
+	exchange string
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+	closed   bool
+}
+
+// NewAMQPPublisher connects to an AMQP broker and declares the exchange.
+func NewAMQPPublisher(url, exchange string) (*AMQPPublisher, error) {
+	if url == "" {
+		return nil, fmt.Errorf("amqp url is empty")
+	}
+	if exchange == "" {
+		exchange = DefaultExchange
+	}
+	p := &AMQPPublisher{url: url, exchange: exchange}
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// connect dials the broker and opens a channel in confirm mode. The caller must hold the lock
+// (or own the publisher exclusively).
+func (p *AMQPPublisher) connect() error {
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to amqp broker: %w", err)
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to open amqp channel: %w", err)
+	}
+	if err := channel.ExchangeDeclare(p.exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to declare exchange %s: %w", p.exchange, err)
+	}
+	if err := channel.Confirm(false); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	p.conn = conn
+	p.channel = channel
+	p.confirms = channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	return nil
+}
+
+// reset drops the current connection so the next Publish re-dials.
+func (p *AMQPPublisher) reset() {
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+	p.conn, p.channel, p.confirms = nil, nil, nil
+}
+
+func (p *AMQPPublisher) Publish(ctx context.Context, message Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("amqp publisher is closed")
+	}
+	if p.conn == nil || p.conn.IsClosed() {
+		p.reset()
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	headers := make(amqp.Table, len(message.Headers)+1)
+	for key, value := range message.Headers {
+		headers[key] = value
+	}
+	if message.Key != "" {
+		headers["key"] = message.Key
+	}
+	err := p.channel.Publish(p.exchange, message.Topic, false, false, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    message.ID,
+		Timestamp:    message.Timestamp,
+		Type:         message.Topic,
+		Body:         message.Payload,
+	})
+	if err != nil {
+		p.reset()
+		return fmt.Errorf("failed to publish message %s: %w", message.ID, err)
+	}
+
+	select {
+	case confirm, ok := <-p.confirms:
+		if !ok {
+			p.reset()
+			return fmt.Errorf("amqp channel closed before confirming message %s", message.ID)
+		}
+		if !confirm.Ack {
+			return fmt.Errorf("amqp broker rejected message %s", message.ID)
+		}
+		return nil
+	case <-ctx.Done():
+		// The confirmation may still arrive; drop the channel so it is not read by the next message.
+		p.reset()
+		gl.Log("warn", fmt.Sprintf("Gave up waiting for amqp confirmation of message %s", message.ID))
+		return ctx.Err()
+	}
+}
+
+func (p *AMQPPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.channel, p.confirms = nil, nil, nil
+	return err
+}
@@ -0,0 +1,134 @@
+// Package broker publishes ledger events to message brokers. Events are read from the ledger
+// outbox by a Relay and removed only once the broker accepted them, so every event is
+// delivered at least once; consumers drop redeliveries by message ID.
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+)
+
+const (
+	// KindMemory is the in-process broker.
+	KindMemory = "memory"
+	// KindAMQP publishes to an AMQP 0-9-1 topic exchange.
+	KindAMQP = "amqp"
+	// KindZMQ publishes on a ZeroMQ PUB socket.
+	KindZMQ = "zmq"
+)
+
+// Message is a single event published to a broker.
+type Message struct {
+	// ID identifies the message across redeliveries.
+	ID string `json:"id"`
+	// Topic is the routing topic, e.g. `document.<id>.approved`.
+	Topic string `json:"topic"`
+	// Key is the partition key, e.g. the document ID.
+	Key       string            `json:"key,omitempty"`
+	Payload   json.RawMessage   `json:"payload"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// MessageFromOutbox converts an outbox message into a broker message.
+func MessageFromOutbox(message lg.OutboxMessage) Message {
+	return Message{
+		ID:        message.ID,
+		Topic:     message.Topic,
+		Key:       message.Key,
+		Payload:   message.Payload,
+		Headers:   message.Headers,
+		Timestamp: message.CreatedAt,
+	}
+}
+
+// IPublisher sends messages to a broker. Publish returns once the broker accepted the
+// message, or with an error when it may not have.
+type IPublisher interface {
+	Publish(ctx context.Context, message Message) error
+	Close() error
+}
+
+// Config selects and configures a publisher.
+type Config struct {
+	// Kind is the broker kind: memory, amqp or zmq.
+	Kind string
+	// URL is the broker address: an AMQP URL or a ZeroMQ endpoint.
+	URL string
+	// Exchange is the AMQP topic exchange. Defaults to `smart_plane.events`.
+	Exchange string
+	// TopicPrefix is prepended to every message topic.
+	TopicPrefix string
+}
+
+// NewPublisher creates the publisher of a broker kind.
+func NewPublisher(cfg Config) (IPublisher, error) {
+	var (
+		publisher IPublisher
+		err       error
+	)
+	switch strings.ToLower(cfg.Kind) {
+	case "", KindMemory:
+		publisher = NewMemoryBroker()
+	case KindAMQP:
+		publisher, err = NewAMQPPublisher(cfg.URL, cfg.Exchange)
+	case KindZMQ:
+		publisher, err = NewZMQPublisher(cfg.URL)
+	default:
+		return nil, fmt.Errorf("unknown broker kind: %s", cfg.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TopicPrefix != "" {
+		publisher = &prefixPublisher{IPublisher: publisher, prefix: cfg.TopicPrefix}
+	}
+	return publisher, nil
+}
+
+// prefixPublisher prepends a prefix to the topic of every message.
+type prefixPublisher struct {
+	IPublisher
+	prefix string
+}
+
+func (p *prefixPublisher) Publish(ctx context.Context, message Message) error {
+	message.Topic = p.prefix + message.Topic
+	return p.IPublisher.Publish(ctx, message)
+}
+
+// NewMultiPublisher returns a publisher sending every message to each of publishers, in
+// order. A message is accepted once all of them accepted it; after a failure it is published
+// again to every publisher, whose consumers drop the redeliveries by message ID.
+func NewMultiPublisher(publishers ...IPublisher) IPublisher {
+	if len(publishers) == 1 {
+		return publishers[0]
+	}
+	return multiPublisher(append([]IPublisher(nil), publishers...))
+}
+
+// multiPublisher sends the messages to several publishers.
+type multiPublisher []IPublisher
+
+func (m multiPublisher) Publish(ctx context.Context, message Message) error {
+	for _, publisher := range m {
+		if err := publisher.Publish(ctx, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiPublisher) Close() error {
+	errs := make([]error, 0, len(m))
+	for _, publisher := range m {
+		errs = append(errs, publisher.Close())
+	}
+	return errors.Join(errs...)
+}
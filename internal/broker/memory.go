@@ -0,0 +1,132 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	t "github.com/rafa-mori/smart_plane/types"
+)
+
+// MemoryBroker is an in-process broker. It keeps every accepted message and fans them out
+// to pattern subscribers, and it can be told to reject messages to exercise redelivery.
+type MemoryBroker struct {
+	mu       sync.Mutex
+	messages []Message
+	subs     map[int]*memorySubscription
+	nextSub  int
+	// failures is the number of upcoming Publish calls to reject with failErr.
+	failures int
+	failErr  error
+	closed   bool
+}
+
+type memorySubscription struct {
+	pattern string
+	ch      chan Message
+}
+
+// NewMemoryBroker creates an empty in-process broker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subs: make(map[int]*memorySubscription)}
+}
+
+// Publish accepts a message unless a failure was injected with FailNext. Subscribers whose
+// buffer is full miss the message; it is still recorded.
+func (b *MemoryBroker) Publish(ctx context.Context, message Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("broker is closed")
+	}
+	if b.failures > 0 {
+		b.failures--
+		return b.failErr
+	}
+	b.messages = append(b.messages, message)
+	for _, sub := range b.subs {
+		if !t.MatchEventPattern(sub.pattern, message.Topic) {
+			continue
+		}
+		select {
+		case sub.ch <- message:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a channel receiving the messages whose topic matches the pattern, and
+// a function that ends the subscription.
+func (b *MemoryBroker) Subscribe(pattern string, buffer int) (<-chan Message, func(), error) {
+	if err := t.ValidateEventPattern(pattern); err != nil {
+		return nil, nil, err
+	}
+	if buffer <= 0 {
+		buffer = 64
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, nil, fmt.Errorf("broker is closed")
+	}
+	id := b.nextSub
+	b.nextSub++
+	sub := &memorySubscription{pattern: pattern, ch: make(chan Message, buffer)}
+	b.subs[id] = sub
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, exists := b.subs[id]; exists {
+			delete(b.subs, id)
+			close(sub.ch)
+		}
+	}
+	return sub.ch, cancel, nil
+}
+
+// Messages returns every message accepted so far, in publish order.
+func (b *MemoryBroker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Message(nil), b.messages...)
+}
+
+// FailNext makes the next n Publish calls fail with err.
+func (b *MemoryBroker) FailNext(n int, err error) {
+	if err == nil {
+		err = fmt.Errorf("broker unavailable")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = n
+	b.failErr = err
+}
+
+// Close rejects further messages and ends every subscription.
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for id, sub := range b.subs {
+		delete(b.subs, id)
+		close(sub.ch)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	t "github.com/rafa-mori/smart_plane/types"
+)
+
+// Relay moves messages from the ledger outbox to a publisher. Messages leave the outbox only
+// after the publisher accepted them, in commit order; a failure stops the flush so later
+// messages are not published ahead of the failed one.
+//
+// Published messages are acknowledged in batches, so that the outbox does not cost a ledger
+// block per flush: they leave the outbox once AckBatchSize of them are waiting, on every
+// interval of Run, when Run returns, or on Ack.
+type Relay struct {
+	ledger    lg.ILedger
+	publisher IPublisher
+	// Interval is the polling period of Run. Defaults to 1s.
+	Interval time.Duration
+	// BatchSize bounds the messages read from the outbox at once. Defaults to 100.
+	BatchSize int
+	// AckBatchSize is the number of published messages acknowledged per ledger commit; zero
+	// or less acknowledges after every read. Defaults to 100.
+	AckBatchSize int
+	// flushMu serializes flushes and acknowledgements, so a message is never published by
+	// two flushes at once.
+	flushMu sync.Mutex
+	// unacked holds the published messages not yet acknowledged, and cursor the outbox key
+	// of the last one, which the next flush reads past.
+	unacked []lg.OutboxMessage
+	cursor  string
+	wake    chan struct{}
+}
+
+// NewRelay creates a relay from a ledger outbox to a publisher.
+func NewRelay(ledger lg.ILedger, publisher IPublisher) *Relay {
+	return &Relay{
+		ledger:       ledger,
+		publisher:    publisher,
+		Interval:     time.Second,
+		BatchSize:    100,
+		AckBatchSize: 100,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes a running relay without waiting for the next poll.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Attach wakes the relay whenever the listener receives a document event, so committed
+// events are published right away.
+func (r *Relay) Attach(listener *t.ValidationListener) (*t.Reference, error) {
+	if listener == nil {
+		return nil, fmt.Errorf("listener is nil")
+	}
+	return listener.Subscribe("document.**", func(*t.ValidationResult) { r.Notify() })
+}
+
+// Flush publishes the pending messages of the outbox and returns how many were published.
+// The published messages are acknowledged once AckBatchSize of them are waiting; callers not
+// using Run call Ack when they are done.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+
+	published := 0
+	for {
+		messages, err := lg.PendingOutboxAfter(r.ledger, r.cursor, r.BatchSize)
+		if err != nil {
+			return published, err
+		}
+		if len(messages) == 0 {
+			return published, nil
+		}
+
+		var publishErr error
+		for _, message := range messages {
+			if publishErr = r.publisher.Publish(ctx, MessageFromOutbox(message)); publishErr != nil {
+				publishErr = fmt.Errorf("failed to publish outbox message %s: %w", message.ID, publishErr)
+				break
+			}
+			r.unacked = append(r.unacked, message)
+			r.cursor = message.OutboxKey()
+			published++
+		}
+		if len(r.unacked) >= r.AckBatchSize {
+			if err := r.ack(); err != nil {
+				return published, err
+			}
+		}
+		if publishErr != nil {
+			return published, publishErr
+		}
+		if r.BatchSize <= 0 || len(messages) < r.BatchSize {
+			return published, nil
+		}
+	}
+}
+
+// Ack removes the published messages from the outbox in a single transaction.
+func (r *Relay) Ack() error {
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+
+	return r.ack()
+}
+
+// ack acknowledges the published messages. Messages published but not acknowledged are
+// published again after a restart, which is what makes delivery at-least-once. The caller
+// must hold r.flushMu.
+func (r *Relay) ack() error {
+	if len(r.unacked) == 0 {
+		return nil
+	}
+	if err := lg.AckOutbox(r.ledger, r.unacked...); err != nil {
+		return fmt.Errorf("failed to acknowledge outbox messages: %w", err)
+	}
+	// The acknowledged messages, the cursor included, left the outbox: the pending ones
+	// start it again.
+	r.unacked, r.cursor = nil, ""
+	return nil
+}
+
+// Run flushes the outbox on every interval and notification until ctx is done, and
+// acknowledges the published messages on every interval and before returning.
+func (r *Relay) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := r.Flush(ctx); err != nil {
+			gl.Log("warn", fmt.Sprintf("Outbox relay published %d messages before failing: %v", n, err))
+		} else if n > 0 {
+			gl.Log("debug", fmt.Sprintf("Outbox relay published %d messages", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := r.Ack(); err != nil {
+				gl.Log("warn", fmt.Sprintf("Outbox relay stopped without acknowledging: %v", err))
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			if err := r.Ack(); err != nil {
+				gl.Log("warn", fmt.Sprintf("Outbox relay failed to acknowledge: %v", err))
+			}
+		case <-r.wake:
+		}
+	}
+}
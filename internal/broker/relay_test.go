@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+)
+
+// enqueueEvents commits one outbox message per topic, each in its own block.
+func enqueueEvents(t *testing.T, ledger lg.ILedger, topics ...string) []lg.OutboxMessage {
+	t.Helper()
+	messages := make([]lg.OutboxMessage, 0, len(topics))
+	for _, topic := range topics {
+		message, err := lg.NewOutboxMessage(topic, "doc", map[string]string{"topic": topic})
+		if err != nil {
+			t.Fatalf("NewOutboxMessage: %v", err)
+		}
+		write, err := message.Write()
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := ledger.Commit("tx-"+topic, []lg.Write{write}); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func requireTopics(t *testing.T, b *MemoryBroker, want ...string) {
+	t.Helper()
+	got := b.Messages()
+	if len(got) != len(want) {
+		t.Fatalf("broker received %d messages, want %v", len(got), want)
+	}
+	for i, message := range got {
+		if message.Topic != want[i] {
+			t.Fatalf("broker message %d = %s, want %s", i, message.Topic, want[i])
+		}
+	}
+}
+
+func TestRelayPublishesTheOutboxInOrder(t *testing.T) {
+	ledger := lg.NewMemoryLedger()
+	b := NewMemoryBroker()
+	enqueueEvents(t, ledger, "document.doc.registered", "document.doc.approved")
+
+	relay := NewRelay(ledger, b)
+	relay.AckBatchSize = 10
+	if n, err := relay.Flush(context.Background()); n != 2 || err != nil {
+		t.Fatalf("Flush = %d, %v, want 2 messages", n, err)
+	}
+	requireTopics(t, b, "document.doc.registered", "document.doc.approved")
+
+	// Published messages are not published again before they are acknowledged.
+	enqueueEvents(t, ledger, "document.doc.signed")
+	if n, err := relay.Flush(context.Background()); n != 1 || err != nil {
+		t.Fatalf("second Flush = %d, %v, want 1 message", n, err)
+	}
+	if err := relay.Ack(); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if pending, _ := lg.PendingOutbox(ledger, 0); len(pending) != 0 {
+		t.Fatalf("pending outbox after Ack = %d messages, want none", len(pending))
+	}
+	requireTopics(t, b, "document.doc.registered", "document.doc.approved", "document.doc.signed")
+}
+
+func TestRelayRepublishesWhatWasNotAccepted(t *testing.T) {
+	ledger := lg.NewMemoryLedger()
+	b := NewMemoryBroker()
+	enqueueEvents(t, ledger, "document.doc.registered", "document.doc.approved")
+
+	unavailable := errors.New("unavailable")
+	b.FailNext(1, unavailable)
+	relay := NewRelay(ledger, b)
+	if n, err := relay.Flush(context.Background()); n != 0 || !errors.Is(err, unavailable) {
+		t.Fatalf("Flush with a failing broker = %d, %v, want the broker error", n, err)
+	}
+	if n, err := relay.Flush(context.Background()); n != 2 || err != nil {
+		t.Fatalf("Flush after the failure = %d, %v, want 2 messages", n, err)
+	}
+
+	// A relay stopped before acknowledging leaves the messages to the next one.
+	restarted := NewRelay(ledger, b)
+	if n, err := restarted.Flush(context.Background()); n != 2 || err != nil {
+		t.Fatalf("Flush of a restarted relay = %d, %v, want 2 messages", n, err)
+	}
+	if err := restarted.Ack(); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	requireTopics(t, b, "document.doc.registered", "document.doc.approved", "document.doc.registered", "document.doc.approved")
+}
+
+func TestMultiPublisherNeedsEveryPublisher(t *testing.T) {
+	ledger := lg.NewMemoryLedger()
+	first, second := NewMemoryBroker(), NewMemoryBroker()
+	enqueueEvents(t, ledger, "document.doc.registered")
+
+	second.FailNext(1, nil)
+	relay := NewRelay(ledger, NewMultiPublisher(first, second))
+	if _, err := relay.Flush(context.Background()); err == nil {
+		t.Fatal("Flush with a failing publisher succeeded")
+	}
+	if n, err := relay.Flush(context.Background()); n != 1 || err != nil {
+		t.Fatalf("Flush = %d, %v, want 1 message", n, err)
+	}
+	requireTopics(t, first, "document.doc.registered", "document.doc.registered")
+	requireTopics(t, second, "document.doc.registered")
+}
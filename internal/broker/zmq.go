@@ -0,0 +1,69 @@
+//go:build zmq
+
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+// ZMQPublisher publishes messages on a ZeroMQ PUB socket as two frames: the topic, for
+// subscriber prefix filtering, and the JSON encoded message. PUB sockets have no
+// acknowledgements, so delivery is only guaranteed up to the local socket queue.
+type ZMQPublisher struct {
+	mu     sync.Mutex
+	socket *zmq.Socket
+}
+
+// NewZMQPublisher binds a PUB socket to the endpoint, e.g. `tcp://*:5563`.
+func NewZMQPublisher(endpoint string) (IPublisher, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("zmq endpoint is empty")
+	}
+	socket, err := zmq.NewSocket(zmq.PUB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zmq socket: %w", err)
+	}
+	if err := socket.Bind(endpoint); err != nil {
+		socket.Close()
+		return nil, fmt.Errorf("failed to bind zmq socket to %s: %w", endpoint, err)
+	}
+	return &ZMQPublisher{socket: socket}, nil
+}
+
+func (p *ZMQPublisher) Publish(ctx context.Context, message Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message %s: %w", message.ID, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.socket == nil {
+		return fmt.Errorf("zmq publisher is closed")
+	}
+	if _, err := p.socket.SendMessage(message.Topic, body); err != nil {
+		return fmt.Errorf("failed to publish message %s: %w", message.ID, err)
+	}
+	return nil
+}
+
+func (p *ZMQPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.socket == nil {
+		return nil
+	}
+	err := p.socket.Close()
+	p.socket = nil
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !zmq
+
+package broker
+
+import "fmt"
+
+// NewZMQPublisher is unavailable in builds without the `zmq` tag, which requires cgo and libzmq.
+func NewZMQPublisher(endpoint string) (IPublisher, error) {
+	return nil, fmt.Errorf("zmq publisher unavailable: rebuild with -tags zmq")
+}
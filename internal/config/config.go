@@ -0,0 +1,406 @@
+// Package config loads the configuration of the smart_plane binary from layered sources:
+// built-in defaults, a YAML, TOML or JSON file, SMART_PLANE_ environment variables and
+// command line flags, each overriding the previous ones.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rafa-mori/smart_plane/internal/broker"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	sc "github.com/rafa-mori/smart_plane/internal/smart_contracts"
+	t "github.com/rafa-mori/smart_plane/types"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// EnvPrefix prefixes the environment overrides: ledger.dir is read from SMART_PLANE_LEDGER_DIR.
+	EnvPrefix = "SMART_PLANE"
+	// EnvConfigFile names the configuration file when no --config flag is given.
+	EnvConfigFile = EnvPrefix + "_CONFIG"
+	// configName is the base name of the configuration file searched in the config directories.
+	configName = "config"
+)
+
+// Formats are the configuration file formats, chosen by file extension.
+var Formats = []string{"yaml", "yml", "toml", "json"}
+
+// LogLevels are the accepted logging levels.
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// ErrUnsupportedFormat is returned for configuration files of an unknown format.
+var ErrUnsupportedFormat = errors.New("unsupported configuration format")
+
+// ErrConfigExists is returned when writing a configuration file that exists, without force.
+var ErrConfigExists = errors.New("already exists")
+
+// Config is the configuration of the smart_plane binary.
+type Config struct {
+	// Banner prints the banner in the command descriptions.
+	Banner    bool            `mapstructure:"banner"`
+	Ledger    LedgerConfig    `mapstructure:"ledger"`
+	Auth      AuthConfig      `mapstructure:"auth"`
+	Logging   LoggingConfig   `mapstructure:"logging"`
+	Contracts ContractsConfig `mapstructure:"contracts"`
+	Webhooks  WebhooksConfig  `mapstructure:"webhooks"`
+	Broker    BrokerConfig    `mapstructure:"broker"`
+
+	// file is the configuration file that was read, if any.
+	file string
+}
+
+// LedgerConfig selects the ledger backend and the contract payload schemas.
+type LedgerConfig struct {
+	Backend   string `mapstructure:"backend"`
+	Dir       string `mapstructure:"dir"`
+	SchemaDir string `mapstructure:"schema_dir"`
+	// SnapshotKeep and SnapshotMaxAge bound the snapshots of the file backend; zero disables
+	// a bound.
+	SnapshotKeep   int           `mapstructure:"snapshot_keep"`
+	SnapshotMaxAge time.Duration `mapstructure:"snapshot_max_age"`
+}
+
+// AuthConfig holds the token signing settings.
+type AuthConfig struct {
+	Keystore string        `mapstructure:"keystore"`
+	TokenTTL time.Duration `mapstructure:"token_ttl"`
+}
+
+// LoggingConfig holds the logging settings.
+type LoggingConfig struct {
+	Level string `mapstructure:"level"`
+}
+
+// ContractsConfig selects the contracts served by the blockchain manager.
+type ContractsConfig struct {
+	// Enabled lists the enabled contracts; empty enables all of them.
+	Enabled []string `mapstructure:"enabled"`
+}
+
+// WebhooksConfig lists the webhook endpoints receiving the ledger events. With at least one
+// endpoint, committed events go through the ledger outbox.
+type WebhooksConfig struct {
+	Endpoints []WebhookEndpoint `mapstructure:"endpoints"`
+	// DeadLetterDir keeps the deliveries that could not be delivered.
+	DeadLetterDir string `mapstructure:"dead_letter_dir"`
+	// MaxAttempts is the number of attempts of a delivery before it is dead-lettered.
+	MaxAttempts int `mapstructure:"max_attempts"`
+}
+
+// WebhookEndpoint is a webhook receiver and the event patterns it accepts, e.g.
+// `document.*.approved`; no pattern accepts every event.
+type WebhookEndpoint struct {
+	URL    string   `mapstructure:"url"`
+	Secret string   `mapstructure:"secret"`
+	Events []string `mapstructure:"events"`
+}
+
+// BrokerConfig selects the message broker receiving the ledger events. With a broker,
+// committed events go through the ledger outbox.
+type BrokerConfig struct {
+	// Kind is the broker kind, amqp or zmq; empty disables the broker.
+	Kind string `mapstructure:"kind"`
+	// URL is the AMQP URL or the ZeroMQ endpoint of the broker.
+	URL string `mapstructure:"url"`
+	// Exchange is the AMQP topic exchange; empty uses the default one.
+	Exchange    string `mapstructure:"exchange"`
+	TopicPrefix string `mapstructure:"topic_prefix"`
+}
+
+// ValidationError lists the problems of an invalid configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Options select the sources of Load.
+type Options struct {
+	// File is the configuration file. When empty, SMART_PLANE_CONFIG is used, then config.*
+	// is searched in the config directories; a missing searched file is not an error.
+	File string
+	// Flags binds command line flags to configuration keys, such as "ledger.dir". Only
+	// flags set on the command line override the other sources.
+	Flags map[string]*pflag.Flag
+}
+
+// Dir returns the directory of the smart_plane files, ~/.smart_plane.
+func Dir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".", ".smart_plane")
+	}
+	return filepath.Join(home, ".smart_plane")
+}
+
+// DefaultFile returns the default configuration file path.
+func DefaultFile() string { return filepath.Join(Dir(), configName+".yaml") }
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		Banner: true,
+		Ledger: LedgerConfig{
+			Backend:      lg.BackendFile,
+			Dir:          filepath.Join(Dir(), "ledger"),
+			SchemaDir:    filepath.Join(Dir(), "schemas"),
+			SnapshotKeep: 10,
+		},
+		Auth: AuthConfig{
+			Keystore: filepath.Join(Dir(), "keystore"),
+			TokenTTL: time.Hour,
+		},
+		Logging: LoggingConfig{
+			Level: "info",
+		},
+		Contracts: ContractsConfig{
+			Enabled: []string{},
+		},
+		Webhooks: WebhooksConfig{
+			Endpoints:     []WebhookEndpoint{},
+			DeadLetterDir: filepath.Join(Dir(), "webhooks"),
+			MaxAttempts:   5,
+		},
+	}
+}
+
+// Load reads the configuration from its layered sources. It does not validate it.
+func Load(opts Options) (*Config, error) {
+	v := viper.New()
+	for key, value := range Default().Settings() {
+		v.SetDefault(key, value)
+	}
+
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	for key, flag := range opts.Flags {
+		if flag == nil {
+			continue
+		}
+		if err := v.BindPFlag(key, flag); err != nil {
+			return nil, fmt.Errorf("failed to bind flag %s: %w", flag.Name, err)
+		}
+	}
+
+	file := opts.File
+	if file == "" {
+		file = os.Getenv(EnvConfigFile)
+	}
+	if file != "" {
+		if err := checkFormat(file); err != nil {
+			return nil, err
+		}
+		v.SetConfigFile(file)
+	} else {
+		v.SetConfigName(configName)
+		v.AddConfigPath(Dir())
+		v.AddConfigPath("/etc/smart_plane")
+	}
+	if err := v.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		var parseErr viper.ConfigParseError
+		switch {
+		case errors.As(err, &parseErr):
+			return nil, &ValidationError{Problems: []string{err.Error()}}
+		case file != "" || !errors.As(err, &notFound):
+			return nil, fmt.Errorf("failed to read configuration: %w", err)
+		}
+	}
+
+	// Unknown keys and values of the wrong type are reported as validation problems.
+	cfg := &Config{}
+	if err := v.UnmarshalExact(cfg); err != nil {
+		return nil, &ValidationError{Problems: []string{fmt.Sprintf("%s: %v", v.ConfigFileUsed(), err)}}
+	}
+	cfg.file = v.ConfigFileUsed()
+	return cfg, nil
+}
+
+// File returns the configuration file that was read, or an empty string.
+func (c *Config) File() string { return c.file }
+
+// Validate checks the configuration, reporting every problem at once.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...any) { problems = append(problems, fmt.Sprintf(format, args...)) }
+
+	switch c.Ledger.Backend {
+	case lg.BackendMemory:
+	case lg.BackendFile:
+		if c.Ledger.Dir == "" {
+			add("ledger.dir is required by the %s backend", lg.BackendFile)
+		}
+	default:
+		add("ledger.backend %q is not one of %s, %s", c.Ledger.Backend, lg.BackendFile, lg.BackendMemory)
+	}
+	if c.Ledger.SchemaDir == "" {
+		add("ledger.schema_dir is required")
+	}
+	if c.Ledger.SnapshotKeep < 0 {
+		add("ledger.snapshot_keep cannot be negative")
+	}
+	if c.Ledger.SnapshotMaxAge < 0 {
+		add("ledger.snapshot_max_age cannot be negative")
+	}
+
+	if c.Auth.Keystore == "" {
+		add("auth.keystore is required")
+	}
+	if c.Auth.TokenTTL <= 0 {
+		add("auth.token_ttl must be positive")
+	}
+
+	if !slices.Contains(LogLevels, c.Logging.Level) {
+		add("logging.level %q is not one of %s", c.Logging.Level, strings.Join(LogLevels, ", "))
+	}
+
+	known := sc.ContractNames()
+	for _, name := range c.Contracts.Enabled {
+		if !slices.Contains(known, name) {
+			add("contracts.enabled: unknown contract %q (%s)", name, strings.Join(known, ", "))
+		}
+	}
+
+	for i, endpoint := range c.Webhooks.Endpoints {
+		if u, err := url.Parse(endpoint.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("webhooks.endpoints[%d].url %q is not an http or https URL", i, endpoint.URL)
+		}
+		if endpoint.Secret == "" {
+			add("webhooks.endpoints[%d].secret is required", i)
+		}
+		for _, pattern := range endpoint.Events {
+			if err := t.ValidateEventPattern(pattern); err != nil {
+				add("webhooks.endpoints[%d].events: %v", i, err)
+			}
+		}
+	}
+	if len(c.Webhooks.Endpoints) > 0 && c.Webhooks.DeadLetterDir == "" {
+		add("webhooks.dead_letter_dir is required with webhook endpoints")
+	}
+	if c.Webhooks.MaxAttempts < 0 {
+		add("webhooks.max_attempts cannot be negative")
+	}
+
+	switch c.Broker.Kind {
+	case "":
+	case broker.KindAMQP, broker.KindZMQ:
+		if c.Broker.URL == "" {
+			add("broker.url is required by the %s broker", c.Broker.Kind)
+		}
+	default:
+		add("broker.kind %q is not one of %s, %s", c.Broker.Kind, broker.KindAMQP, broker.KindZMQ)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Settings returns the configuration as nested maps keyed like the configuration file,
+// with durations as strings.
+func (c *Config) Settings() map[string]any {
+	return map[string]any{
+		"banner": c.Banner,
+		"ledger": map[string]any{
+			"backend":          c.Ledger.Backend,
+			"dir":              c.Ledger.Dir,
+			"schema_dir":       c.Ledger.SchemaDir,
+			"snapshot_keep":    c.Ledger.SnapshotKeep,
+			"snapshot_max_age": c.Ledger.SnapshotMaxAge.String(),
+		},
+		"auth": map[string]any{
+			"keystore":  c.Auth.Keystore,
+			"token_ttl": c.Auth.TokenTTL.String(),
+		},
+		"logging": map[string]any{
+			"level": c.Logging.Level,
+		},
+		"contracts": map[string]any{
+			"enabled": c.Contracts.Enabled,
+		},
+		"webhooks": c.webhookSettings(false),
+		"broker": map[string]any{
+			"kind":         c.Broker.Kind,
+			"url":          c.Broker.URL,
+			"exchange":     c.Broker.Exchange,
+			"topic_prefix": c.Broker.TopicPrefix,
+		},
+	}
+}
+
+// RedactedSettings returns the settings with the webhook secrets masked, for display.
+func (c *Config) RedactedSettings() map[string]any {
+	settings := c.Settings()
+	settings["webhooks"] = c.webhookSettings(true)
+	return settings
+}
+
+// webhookSettings returns the webhook settings, with the secrets masked when redact is set.
+func (c *Config) webhookSettings(redact bool) map[string]any {
+	endpoints := make([]any, 0, len(c.Webhooks.Endpoints))
+	for _, endpoint := range c.Webhooks.Endpoints {
+		secret := endpoint.Secret
+		if redact && secret != "" {
+			secret = "********"
+		}
+		endpoints = append(endpoints, map[string]any{
+			"url":    endpoint.URL,
+			"secret": secret,
+			"events": endpoint.Events,
+		})
+	}
+	return map[string]any{
+		"endpoints":       endpoints,
+		"dead_letter_dir": c.Webhooks.DeadLetterDir,
+		"max_attempts":    c.Webhooks.MaxAttempts,
+	}
+}
+
+// WriteFile writes the configuration to path, in the format of its extension. An existing
+// file is only replaced when force is set.
+func (c *Config) WriteFile(path string, force bool) error {
+	if err := checkFormat(path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create configuration directory: %w", err)
+	}
+
+	v := viper.New()
+	for key, value := range c.Settings() {
+		v.Set(key, value)
+	}
+	if force {
+		return v.WriteConfigAs(path)
+	}
+	if err := v.SafeWriteConfigAs(path); err != nil {
+		var exists viper.ConfigFileAlreadyExistsError
+		if errors.As(err, &exists) {
+			return fmt.Errorf("configuration file %s %w", path, ErrConfigExists)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkFormat checks that the extension of a configuration file is a supported format.
+func checkFormat(path string) error {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if !slices.Contains(Formats, ext) {
+		return fmt.Errorf("%w %q of %s (%s)", ErrUnsupportedFormat, ext, path, strings.Join(Formats, ", "))
+	}
+	return nil
+}
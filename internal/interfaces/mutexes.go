@@ -1,6 +1,9 @@
 package interfaces
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type IMutexes interface {
 	MuLock()
@@ -20,6 +23,10 @@ type IMutexes interface {
 	SetMuSharedCtxValidate(validate func(any) (bool, error))
 	MuWaitCondWithTimeout(timeout time.Duration) bool
 
+	MuLockCtx(ctx context.Context) error
+	MuRLockCtx(ctx context.Context) error
+	MuWaitCondCtx(ctx context.Context) error
+
 	MuAdd(delta int)
 	MuDone()
 	MuWait()
@@ -0,0 +1,5 @@
+package interfaces
+
+type IRequestValidator interface {
+	ValidateRequest(method string, payload []byte) error
+}
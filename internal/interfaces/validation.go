@@ -1,8 +1,11 @@
 package interfaces
 
+import "context"
+
 type IValidation[T any] interface {
 	CheckIfWillValidate() bool
 	Validate(value *T, args ...any) IValidationResult
+	ValidateContext(ctx context.Context, value *T, args ...any) IValidationResult
 	AddValidator(validator IValidationFunc[T]) error
 	RemoveValidator(priority int) error
 	GetValidator(priority int) (any, error)
@@ -10,4 +13,8 @@ type IValidation[T any] interface {
 	GetResults() map[int]IValidationResult
 	ClearResults()
 	IsValid() bool
+	SetFailFast(failFast bool)
+	IsFailFast() bool
+	SetConcurrency(concurrency int)
+	GetConcurrency() int
 }
@@ -0,0 +1,14 @@
+package interfaces
+
+import (
+	"context"
+	"time"
+)
+
+type IContextValidationFunc[T any] interface {
+	IValidationFunc[T]
+	GetContextFunction() func(ctx context.Context, value *T, args ...any) IValidationResult
+	SetContextFunction(function func(ctx context.Context, value *T, args ...any) IValidationResult)
+	GetTimeout() time.Duration
+	SetTimeout(timeout time.Duration)
+}
@@ -0,0 +1,553 @@
+package ledger
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ArchiveFormat identifies the ledger archives in their manifest.
+	ArchiveFormat = "smart_plane-ledger-archive"
+	// ArchiveVersion is the version of the archive layout written by Export.
+	ArchiveVersion = 1
+	// DefaultPartition holds the records that no partition function claims.
+	DefaultPartition = "default"
+
+	archiveManifestName = "manifest.json"
+	archiveRecordsDir   = "contracts"
+)
+
+// ErrInvalidArchive wraps the errors of archives that cannot be trusted: unknown format or
+// version, missing files, or contents that do not match the manifest.
+var ErrInvalidArchive = errors.New("invalid ledger archive")
+
+// IHistoryKeys is implemented by the backends that can list deleted keys, so that their
+// history is archived too.
+type IHistoryKeys interface {
+	HistoryKeys() ([]string, error)
+}
+
+// IHistories is implemented by the backends that can return the history of every key at a
+// single point in time, so that an export is consistent while other commits go on.
+type IHistories interface {
+	Histories() (map[string][]Record, error)
+}
+
+// IBlockCommitter is implemented by the backends that can commit a block as is, keeping its
+// timestamp. Imports into other backends are stamped with the import time.
+type IBlockCommitter interface {
+	CommitBlock(block Block) error
+}
+
+// IBatchCommitter is implemented by the backends that can commit several blocks as is, all
+// or none of them, so that a failed import leaves the ledger untouched.
+type IBatchCommitter interface {
+	CommitBlocks(blocks []Block) error
+}
+
+// ArchiveFile describes a JSON Lines file of an archive.
+type ArchiveFile struct {
+	Name string `json:"name"`
+	// Contract is the partition of the records in the file.
+	Contract string `json:"contract"`
+	Keys     int    `json:"keys"`
+	Records  int    `json:"records"`
+	// SHA256 is the hex digest of the file content.
+	SHA256 string `json:"sha256"`
+}
+
+// ArchiveManifest is the first entry of an archive.
+type ArchiveManifest struct {
+	Format    string        `json:"format"`
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Source    string        `json:"source,omitempty"`
+	Keys      int           `json:"keys"`
+	Records   int           `json:"records"`
+	Files     []ArchiveFile `json:"files"`
+}
+
+// ArchiveEntry is one line of an archive file: a key and its whole history, oldest first.
+type ArchiveEntry struct {
+	Key      string   `json:"key"`
+	Contract string   `json:"contract"`
+	History  []Record `json:"history"`
+}
+
+// State returns the last value of the entry, and whether the key was deleted.
+func (e ArchiveEntry) State() ([]byte, bool) {
+	if len(e.History) == 0 {
+		return nil, true
+	}
+	last := e.History[len(e.History)-1]
+	return last.Value, last.IsDelete
+}
+
+// ExportOptions configure Export.
+type ExportOptions struct {
+	// Source describes the exported ledger in the manifest.
+	Source string
+	// Partition returns the contract of a key from its last value; an empty result or a
+	// nil function selects DefaultPartition.
+	Partition func(key string, value []byte) string
+}
+
+// Export writes the whole ledger to w as a gzip compressed tar archive: a manifest, then one
+// JSON Lines file per contract with every key and its full history. System keys are not
+// exported. Backends implementing IHistories are exported as of a single point in time;
+// the others key by key.
+func Export(ledger ILedger, w io.Writer, opts ExportOptions) (*ArchiveManifest, error) {
+	if ledger == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	histories, err := readHistories(ledger)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(histories))
+	for key := range histories {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	manifest := &ArchiveManifest{
+		Format:    ArchiveFormat,
+		Version:   ArchiveVersion,
+		CreatedAt: time.Now().UTC(),
+		Source:    opts.Source,
+	}
+	buffers := make(map[string]*bytes.Buffer)
+	files := make(map[string]*ArchiveFile)
+	for _, key := range keys {
+		history := histories[key]
+		if IsSystemKey(key) || len(history) == 0 {
+			continue
+		}
+
+		entry := ArchiveEntry{Key: key, Contract: DefaultPartition, History: history}
+		if opts.Partition != nil {
+			if contract := opts.Partition(key, lastValue(history)); contract != "" {
+				entry.Contract = contract
+			}
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", key, err)
+		}
+
+		buf, exists := buffers[entry.Contract]
+		if !exists {
+			buf = &bytes.Buffer{}
+			buffers[entry.Contract] = buf
+			files[entry.Contract] = &ArchiveFile{
+				Name:     path.Join(archiveRecordsDir, archiveFileName(entry.Contract)+".jsonl"),
+				Contract: entry.Contract,
+			}
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		files[entry.Contract].Keys++
+		files[entry.Contract].Records += len(history)
+		manifest.Keys++
+		manifest.Records += len(history)
+	}
+
+	contracts := make([]string, 0, len(files))
+	for contract := range files {
+		contracts = append(contracts, contract)
+	}
+	sort.Strings(contracts)
+	for _, contract := range contracts {
+		sum := sha256.Sum256(buffers[contract].Bytes())
+		files[contract].SHA256 = hex.EncodeToString(sum[:])
+		manifest.Files = append(manifest.Files, *files[contract])
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := writeTarFile(tw, archiveManifestName, data, manifest.CreatedAt); err != nil {
+		return nil, err
+	}
+	for _, file := range manifest.Files {
+		if err := writeTarFile(tw, file.Name, buffers[file.Contract].Bytes(), manifest.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return manifest, nil
+}
+
+// readHistories returns the history of every key of the ledger, deleted keys included when
+// the backend can list them.
+func readHistories(ledger ILedger) (map[string][]Record, error) {
+	if h, ok := ledger.(IHistories); ok {
+		histories, err := h.Histories()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ledger history: %w", err)
+		}
+		return histories, nil
+	}
+
+	var keys []string
+	var err error
+	if hk, ok := ledger.(IHistoryKeys); ok {
+		keys, err = hk.HistoryKeys()
+	} else {
+		keys, err = ledger.Keys()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ledger keys: %w", err)
+	}
+	histories := make(map[string][]Record, len(keys))
+	for _, key := range keys {
+		if histories[key], err = ledger.GetHistory(key); err != nil {
+			return nil, fmt.Errorf("failed to read history of %s: %w", key, err)
+		}
+	}
+	return histories, nil
+}
+
+// ReadArchive reads and verifies an archive: format and version, file digests and counts.
+// Verification failures wrap ErrInvalidArchive.
+func ReadArchive(r io.Reader) (*ArchiveManifest, []ArchiveEntry, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer func() { _ = gz.Close() }()
+
+	var manifest *ArchiveManifest
+	contents := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if header.Name == archiveManifestName {
+			manifest = &ArchiveManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, fmt.Errorf("%w: manifest: %v", ErrInvalidArchive, err)
+			}
+			continue
+		}
+		contents[header.Name] = data
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("%w: no %s", ErrInvalidArchive, archiveManifestName)
+	}
+	if manifest.Format != ArchiveFormat {
+		return nil, nil, fmt.Errorf("%w: format %q is not %q", ErrInvalidArchive, manifest.Format, ArchiveFormat)
+	}
+	if manifest.Version < 1 || manifest.Version > ArchiveVersion {
+		return nil, nil, fmt.Errorf("%w: version %d is not supported (up to %d)", ErrInvalidArchive, manifest.Version, ArchiveVersion)
+	}
+
+	var entries []ArchiveEntry
+	seen := make(map[string]string)
+	keys, records := 0, 0
+	for _, file := range manifest.Files {
+		data, exists := contents[file.Name]
+		if !exists {
+			return nil, nil, fmt.Errorf("%w: missing %s", ErrInvalidArchive, file.Name)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != file.SHA256 {
+			return nil, nil, fmt.Errorf("%w: %s does not match its sha256", ErrInvalidArchive, file.Name)
+		}
+
+		fileKeys, fileRecords := 0, 0
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var entry ArchiveEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return nil, nil, fmt.Errorf("%w: %s line %d: %v", ErrInvalidArchive, file.Name, fileKeys+1, err)
+			}
+			if entry.Key == "" || IsSystemKey(entry.Key) || len(entry.History) == 0 {
+				return nil, nil, fmt.Errorf("%w: %s line %d: invalid entry", ErrInvalidArchive, file.Name, fileKeys+1)
+			}
+			if other, dup := seen[entry.Key]; dup {
+				return nil, nil, fmt.Errorf("%w: key %s is in %s and %s", ErrInvalidArchive, entry.Key, other, file.Name)
+			}
+			seen[entry.Key] = file.Name
+			fileKeys++
+			fileRecords += len(entry.History)
+			entries = append(entries, entry)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, file.Name, err)
+		}
+		if fileKeys != file.Keys || fileRecords != file.Records {
+			return nil, nil, fmt.Errorf("%w: %s has %d keys and %d records, the manifest says %d and %d",
+				ErrInvalidArchive, file.Name, fileKeys, fileRecords, file.Keys, file.Records)
+		}
+		keys += fileKeys
+		records += fileRecords
+	}
+	if keys != manifest.Keys || records != manifest.Records {
+		return nil, nil, fmt.Errorf("%w: %d keys and %d records, the manifest says %d and %d",
+			ErrInvalidArchive, keys, records, manifest.Keys, manifest.Records)
+	}
+	return manifest, entries, nil
+}
+
+// ConflictPolicy selects what Import does with the keys that already exist in the ledger.
+type ConflictPolicy string
+
+const (
+	// ConflictFail aborts the import, before writing anything.
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictSkip leaves the existing keys untouched.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite writes the archived state of the existing keys on top of their history.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+)
+
+// ConflictError lists the archived keys that already exist in the ledger.
+type ConflictError struct {
+	Keys []string
+}
+
+func (e *ConflictError) Error() string {
+	const shown = 5
+	keys := e.Keys
+	suffix := ""
+	if len(keys) > shown {
+		keys, suffix = keys[:shown], fmt.Sprintf(" and %d more", len(e.Keys)-shown)
+	}
+	return fmt.Sprintf("%d archived keys already exist in the ledger: %s%s", len(e.Keys), strings.Join(keys, ", "), suffix)
+}
+
+// ImportOptions configure Import.
+type ImportOptions struct {
+	OnConflict ConflictPolicy
+	// DryRun verifies the archive and reports the import without writing anything.
+	DryRun bool
+}
+
+// ImportReport is the result of an import.
+type ImportReport struct {
+	Manifest *ArchiveManifest `json:"manifest"`
+	DryRun   bool             `json:"dryRun"`
+	// Imported counts the keys replayed with their history.
+	Imported int `json:"imported"`
+	// Overwritten counts the existing keys given their archived state.
+	Overwritten int `json:"overwritten"`
+	// Skipped counts the existing keys left untouched.
+	Skipped int `json:"skipped"`
+	// Blocks counts the transactions committed, or to be committed in dry-run mode.
+	Blocks int `json:"blocks"`
+}
+
+// Import verifies an archive and replays it into the ledger. The history of the new keys is
+// replayed transaction by transaction, in the original order and with the original
+// transaction IDs; backends implementing IBlockCommitter also keep the original timestamps.
+// The existing keys are handled by the conflict policy; overwritten keys get their archived
+// state in one final transaction. After the replay, the state of every imported key is
+// compared with the archive.
+//
+// Backends implementing IBatchCommitter commit the whole import at once, so a failure leaves
+// the ledger untouched. The others are replayed block by block, and a failure leaves the
+// blocks already committed: importing the archive again with ConflictSkip resumes it.
+func Import(ledger ILedger, r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	if ledger == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	switch opts.OnConflict {
+	case "":
+		opts.OnConflict = ConflictFail
+	case ConflictFail, ConflictSkip, ConflictOverwrite:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %q (fail, skip, overwrite)", opts.OnConflict)
+	}
+
+	manifest, entries, err := ReadArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	report := &ImportReport{Manifest: manifest, DryRun: opts.DryRun}
+
+	var conflicts []string
+	replay := make([]ArchiveEntry, 0, len(entries))
+	overwrite := make([]Write, 0)
+	// verify holds the entries whose state must match the archive after the import.
+	verify := make([]ArchiveEntry, 0, len(entries))
+	for _, entry := range entries {
+		history, err := ledger.GetHistory(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history of %s: %w", entry.Key, err)
+		}
+		if len(history) == 0 {
+			replay = append(replay, entry)
+			verify = append(verify, entry)
+			continue
+		}
+		switch opts.OnConflict {
+		case ConflictFail:
+			conflicts = append(conflicts, entry.Key)
+		case ConflictSkip:
+			report.Skipped++
+		case ConflictOverwrite:
+			value, deleted := entry.State()
+			overwrite = append(overwrite, Write{Key: entry.Key, Value: value, IsDelete: deleted})
+			verify = append(verify, entry)
+		}
+	}
+	if len(conflicts) > 0 {
+		return report, &ConflictError{Keys: conflicts}
+	}
+
+	blocks := replayBlocks(replay)
+	report.Imported = len(replay)
+	report.Overwritten = len(overwrite)
+	report.Blocks = len(blocks)
+	if len(overwrite) > 0 {
+		report.Blocks++
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+
+	if batch, ok := ledger.(IBatchCommitter); ok {
+		if len(overwrite) > 0 {
+			txID := fmt.Sprintf("import-%d", time.Now().UnixNano())
+			blocks = append(blocks, Block{TxID: txID, Timestamp: time.Now().UTC(), Writes: overwrite})
+		}
+		if err := batch.CommitBlocks(blocks); err != nil {
+			return report, fmt.Errorf("failed to import the archive: %w", err)
+		}
+	} else if err := replayEach(ledger, blocks, overwrite); err != nil {
+		return report, err
+	}
+
+	for _, entry := range verify {
+		value, deleted := entry.State()
+		current, err := ledger.GetState(entry.Key)
+		if err != nil {
+			return report, fmt.Errorf("failed to verify %s: %w", entry.Key, err)
+		}
+		if deleted != (current == nil) || !bytes.Equal(current, value) {
+			return report, fmt.Errorf("imported state of %s does not match the archive", entry.Key)
+		}
+	}
+	return report, nil
+}
+
+// replayEach commits the blocks one by one, then the overwritten keys.
+func replayEach(ledger ILedger, blocks []Block, overwrite []Write) error {
+	committer, keepsTime := ledger.(IBlockCommitter)
+	for _, block := range blocks {
+		var err error
+		if keepsTime {
+			err = committer.CommitBlock(block)
+		} else {
+			err = ledger.Commit(block.TxID, block.Writes)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay transaction %s: %w", block.TxID, err)
+		}
+	}
+	if len(overwrite) > 0 {
+		txID := fmt.Sprintf("import-%d", time.Now().UnixNano())
+		if err := ledger.Commit(txID, overwrite); err != nil {
+			return fmt.Errorf("failed to overwrite existing keys: %w", err)
+		}
+	}
+	return nil
+}
+
+// replayBlocks regroups the history of the entries into their original transactions, ordered
+// by timestamp and, within a timestamp, by first appearance.
+func replayBlocks(entries []ArchiveEntry) []Block {
+	index := make(map[string]int)
+	var blocks []Block
+	for _, entry := range entries {
+		for _, record := range entry.History {
+			i, exists := index[record.TxID]
+			if !exists {
+				i = len(blocks)
+				index[record.TxID] = i
+				blocks = append(blocks, Block{TxID: record.TxID, Timestamp: record.Timestamp})
+			}
+			blocks[i].Writes = append(blocks[i].Writes, Write{Key: record.Key, Value: record.Value, IsDelete: record.IsDelete})
+		}
+	}
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].Timestamp.Before(blocks[j].Timestamp) })
+	return blocks
+}
+
+// archiveFileName turns a contract name into a portable file name.
+func archiveFileName(contract string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, contract)
+}
+
+// lastValue returns the last value written in a history, ignoring deletions.
+func lastValue(history []Record) []byte {
+	for i := len(history) - 1; i >= 0; i-- {
+		if !history[i].IsDelete {
+			return history[i].Value
+		}
+	}
+	return nil
+}
+
+// writeTarFile adds a regular file to a tar archive.
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0o640,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	t "github.com/rafa-mori/smart_plane/types"
+)
+
+// BlockHash returns the hash of a block header: its number, the previous hash, its
+// transaction ID and timestamp, and the Merkle root of its writes.
+func BlockHash(block Block) []byte {
+	h := sha256.New()
+	var number [8]byte
+	binary.BigEndian.PutUint64(number[:], block.Number)
+	h.Write(number[:])
+	writeField(h, []byte(block.PrevHash))
+	writeField(h, []byte(block.TxID))
+	writeField(h, []byte(block.Timestamp.UTC().Format(time.RFC3339Nano)))
+	writeField(h, []byte(block.MerkleRoot))
+	return h.Sum(nil)
+}
+
+// sealBlock chains a block at a height after the block with the head hash.
+func sealBlock(block Block, height uint64, head string) Block {
+	block.Number = height
+	block.PrevHash = head
+	block.MerkleRoot = hex.EncodeToString(writesRoot(block.Writes))
+	block.Hash = hex.EncodeToString(BlockHash(block))
+	return block
+}
+
+// ChainError reports the first inconsistent block of a chain.
+type ChainError struct {
+	// Number is the height of the block, and Line its line in the journal.
+	Number uint64 `json:"number"`
+	Line   int    `json:"line"`
+	TxID   string `json:"txId,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e *ChainError) Error() string {
+	if e.TxID == "" {
+		return fmt.Sprintf("ledger chain broken at block %d (line %d): %s", e.Number, e.Line, e.Reason)
+	}
+	return fmt.Sprintf("ledger chain broken at block %d (line %d, transaction %s): %s", e.Number, e.Line, e.TxID, e.Reason)
+}
+
+// ChainOptions configure a chain verification.
+type ChainOptions struct {
+	// AllowUnsealed accepts leading blocks without hashes, committed before the chain was
+	// introduced. Nothing proves those blocks were not rewritten, so by default the first
+	// one breaks the chain.
+	AllowUnsealed bool
+}
+
+// ChainReport is the result of a chain verification.
+type ChainReport struct {
+	Valid bool `json:"valid"`
+	// Blocks counts the blocks verified before the first inconsistent one.
+	Blocks int `json:"blocks"`
+	// Unsealed counts the leading blocks committed before the chain was introduced, which
+	// carry no hashes; they are only accepted with ChainOptions.AllowUnsealed.
+	Unsealed int `json:"unsealed"`
+	// Head is the hash of the last verified block; publishing it anchors the whole chain.
+	Head     string      `json:"head,omitempty"`
+	HeadTxID string      `json:"headTxId,omitempty"`
+	Error    *ChainError `json:"error,omitempty"`
+}
+
+// VerifyChain walks the blocks of a journal, checking that each one follows the previous
+// block, that its Merkle root matches its writes and that its hash matches its header. It
+// stops at the first inconsistent block, reported in ChainReport.Error; the returned error
+// is only set when the journal cannot be read. A trailing line without newline is an
+// unfinished write and is ignored.
+func VerifyChain(r io.Reader, opts ChainOptions) (*ChainReport, error) {
+	report := &ChainReport{}
+	reader := bufio.NewReaderSize(r, 64*1024)
+	var prev *Block
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read journal: %w", err)
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		number := uint64(report.Blocks)
+		fail := func(txID, format string, args ...any) {
+			report.Error = &ChainError{Number: number, Line: line, TxID: txID, Reason: fmt.Sprintf(format, args...)}
+		}
+		var block Block
+		if err := json.Unmarshal(data, &block); err != nil {
+			fail("", "malformed block: %v", err)
+			return report, nil
+		}
+		if reason := checkBlock(block, number, prev, opts.AllowUnsealed); reason != "" {
+			fail(block.TxID, "%s", reason)
+			return report, nil
+		}
+
+		if block.Hash == "" {
+			report.Unsealed++
+		}
+		report.Blocks++
+		report.Head, report.HeadTxID = block.Hash, block.TxID
+		prev = &block
+	}
+	report.Valid = true
+	return report, nil
+}
+
+// checkBlock returns why a block does not follow prev at a height, or an empty string.
+// Leading blocks without hashes only follow when allowUnsealed is set.
+func checkBlock(block Block, number uint64, prev *Block, allowUnsealed bool) string {
+	if block.Hash == "" {
+		if prev != nil && prev.Hash != "" {
+			return "block has no hash after a sealed block"
+		}
+		if !allowUnsealed {
+			return "block has no hash; unsealed blocks are only accepted as legacy blocks"
+		}
+		return ""
+	}
+	if block.Number != number {
+		return fmt.Sprintf("block number is %d, expected %d", block.Number, number)
+	}
+	expected := ""
+	if prev != nil {
+		expected = prev.Hash
+	}
+	if block.PrevHash != expected {
+		return fmt.Sprintf("previous hash %q does not match %q", block.PrevHash, expected)
+	}
+	if root := hex.EncodeToString(writesRoot(block.Writes)); block.MerkleRoot != root {
+		return fmt.Sprintf("merkle root %q does not match the writes (%s)", block.MerkleRoot, root)
+	}
+	if hash := hex.EncodeToString(BlockHash(block)); block.Hash != hash {
+		return fmt.Sprintf("hash %q does not match the header (%s)", block.Hash, hash)
+	}
+	return ""
+}
+
+// Verify verifies the chain of the journal, or of a snapshot journal when snapshot is set,
+// reading the journal under the shared lock.
+func (fl *FileLedger) Verify(snapshot string, opts ChainOptions) (*ChainReport, error) {
+	if snapshot != "" {
+		data, err := readSnapshot(fl.dir, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return VerifyChain(bytes.NewReader(data), opts)
+	}
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.journal == nil {
+		return nil, fmt.Errorf("ledger is closed")
+	}
+	if err := fl.lock.MuRLockCtx(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to lock ledger: %w", err)
+	}
+	defer fl.lock.MuRUnlock()
+
+	if err := fl.catchUp(); err != nil {
+		return nil, err
+	}
+	return VerifyChain(io.NewSectionReader(fl.journal, 0, fl.offset), opts)
+}
+
+// VerifyJournal verifies the chain of the journal of the file ledger in dir, or of a snapshot
+// journal when snapshot is set, without replaying it: a journal the ledger refuses to open
+// is still reported block by block. The journal is read under the shared lock.
+func VerifyJournal(dir, snapshot string, opts ChainOptions) (*ChainReport, error) {
+	if snapshot != "" {
+		data, err := readSnapshot(dir, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return VerifyChain(bytes.NewReader(data), opts)
+	}
+
+	lock, err := t.NewFileMutexes(filepath.Join(dir, lockFileName), t.DefaultFileLeaseTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ledger lock: %w", err)
+	}
+	if err := lock.MuRLockCtx(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to lock ledger: %w", err)
+	}
+	defer lock.MuRUnlock()
+
+	journal, err := os.Open(filepath.Join(dir, journalFileName))
+	if os.IsNotExist(err) {
+		return VerifyChain(bytes.NewReader(nil), opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger journal: %w", err)
+	}
+	defer func() { _ = journal.Close() }()
+
+	return VerifyChain(journal, opts)
+}
@@ -0,0 +1,203 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// chainJournal returns the journal of a file ledger with one block per key.
+func chainJournal(t *testing.T, keys ...string) []byte {
+	t.Helper()
+	fl, err := NewFileLedger(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	defer func() { _ = fl.Close() }()
+	for _, key := range keys {
+		if err := fl.Commit("tx-"+key, []Write{{Key: key, Value: []byte("value of " + key)}}); err != nil {
+			t.Fatalf("Commit %s: %v", key, err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(fl.Dir(), journalFileName))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return data
+}
+
+// journalLines splits a journal into its lines, without the newlines.
+func journalLines(data []byte) []string {
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// joinLines joins journal lines back into a journal.
+func joinLines(lines []string) []byte {
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// editBlock decodes the block of a journal line, edits it and encodes it back.
+func editBlock(t *testing.T, line string, edit func(block *Block)) string {
+	t.Helper()
+	var block Block
+	if err := json.Unmarshal([]byte(line), &block); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	edit(&block)
+	data, err := json.Marshal(block)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return string(data)
+}
+
+func TestVerifyChainAcceptsACommittedJournal(t *testing.T) {
+	data := chainJournal(t, "a", "b", "c")
+	// A trailing line without newline is an unfinished write.
+	data = append(data, `{"txId":"partial"`...)
+
+	report, err := VerifyChain(bytes.NewReader(data), ChainOptions{})
+	if err != nil {
+		t.Fatalf("VerifyChain: %v", err)
+	}
+	if !report.Valid || report.Blocks != 3 || report.Unsealed != 0 || report.HeadTxID != "tx-c" {
+		t.Fatalf("VerifyChain = %+v, want a valid chain of 3 blocks ending at tx-c", report)
+	}
+}
+
+func TestVerifyChainReportsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, lines []string) []string
+		// block and line locate the first inconsistent block, and reason is part of its report.
+		block  uint64
+		line   int
+		reason string
+	}{
+		{
+			name: "changed value",
+			tamper: func(t *testing.T, lines []string) []string {
+				lines[1] = editBlock(t, lines[1], func(block *Block) { block.Writes[0].Value = []byte("forged") })
+				return lines
+			},
+			block: 1, line: 2, reason: "merkle root",
+		},
+		{
+			name: "changed value with recomputed root",
+			tamper: func(t *testing.T, lines []string) []string {
+				lines[1] = editBlock(t, lines[1], func(block *Block) {
+					block.Writes[0].Value = []byte("forged")
+					*block = sealBlock(*block, block.Number, block.PrevHash)
+				})
+				return lines
+			},
+			block: 2, line: 3, reason: "previous hash",
+		},
+		{
+			name: "changed header",
+			tamper: func(t *testing.T, lines []string) []string {
+				lines[0] = editBlock(t, lines[0], func(block *Block) { block.TxID = "tx-forged" })
+				return lines
+			},
+			block: 0, line: 1, reason: "does not match the header",
+		},
+		{
+			name: "removed block",
+			tamper: func(t *testing.T, lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			block: 1, line: 2, reason: "block number",
+		},
+		{
+			name: "swapped blocks",
+			tamper: func(t *testing.T, lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			block: 1, line: 2, reason: "block number",
+		},
+		{
+			name: "unsealed block after a sealed one",
+			tamper: func(t *testing.T, lines []string) []string {
+				lines[2] = editBlock(t, lines[2], func(block *Block) { block.Hash = "" })
+				return lines
+			},
+			block: 2, line: 3, reason: "no hash",
+		},
+		{
+			name: "malformed block",
+			tamper: func(t *testing.T, lines []string) []string {
+				lines[1] = `{"txId":`
+				return lines
+			},
+			block: 1, line: 2, reason: "malformed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := tt.tamper(t, journalLines(chainJournal(t, "a", "b", "c")))
+			report, err := VerifyChain(bytes.NewReader(joinLines(lines)), ChainOptions{})
+			if err != nil {
+				t.Fatalf("VerifyChain: %v", err)
+			}
+			if report.Valid || report.Error == nil {
+				t.Fatalf("VerifyChain = %+v, want a broken chain", report)
+			}
+			if report.Error.Number != tt.block || report.Error.Line != tt.line || !strings.Contains(report.Error.Reason, tt.reason) {
+				t.Fatalf("VerifyChain error = %+v, want block %d on line %d: %s", report.Error, tt.block, tt.line, tt.reason)
+			}
+		})
+	}
+}
+
+func TestVerifyJournalReportsAJournalTheLedgerRejects(t *testing.T) {
+	dir := t.TempDir()
+	lines := journalLines(chainJournal(t, "a", "b"))
+	lines[1] = "not a block"
+	if err := os.WriteFile(filepath.Join(dir, journalFileName), joinLines(lines), 0o640); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := NewFileLedger(dir); err == nil {
+		t.Fatal("NewFileLedger replayed a malformed journal")
+	}
+
+	report, err := VerifyJournal(dir, "", ChainOptions{})
+	if err != nil {
+		t.Fatalf("VerifyJournal: %v", err)
+	}
+	if report.Valid || report.Blocks != 1 || report.Error == nil || report.Error.Line != 2 {
+		t.Fatalf("VerifyJournal = %+v, want one valid block and a malformed line 2", report)
+	}
+}
+
+func TestVerifyChainRejectsUnsealedBlocksUnlessAllowed(t *testing.T) {
+	lines := journalLines(chainJournal(t, "a", "b", "c"))
+	// A tampered journal stripped of every hash looks like a journal of legacy blocks.
+	for i := range lines {
+		lines[i] = editBlock(t, lines[i], func(block *Block) {
+			block.Writes[0].Value = []byte("forged")
+			block.Hash, block.PrevHash, block.MerkleRoot = "", "", ""
+		})
+	}
+	data := joinLines(lines)
+
+	report, err := VerifyChain(bytes.NewReader(data), ChainOptions{})
+	if err != nil {
+		t.Fatalf("VerifyChain: %v", err)
+	}
+	if report.Valid || report.Error == nil || report.Error.Line != 1 || !strings.Contains(report.Error.Reason, "no hash") {
+		t.Fatalf("VerifyChain = %+v, want the first unsealed block to break the chain", report)
+	}
+
+	report, err = VerifyChain(bytes.NewReader(data), ChainOptions{AllowUnsealed: true})
+	if err != nil {
+		t.Fatalf("VerifyChain: %v", err)
+	}
+	if !report.Valid || report.Blocks != 3 || report.Unsealed != 3 {
+		t.Fatalf("VerifyChain allowing unsealed blocks = %+v, want 3 unsealed blocks", report)
+	}
+}
@@ -0,0 +1,380 @@
+package ledger
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	gl "github.com/rafa-mori/smart_plane/logger"
+	t "github.com/rafa-mori/smart_plane/types"
+)
+
+const (
+	// journalFileName is the name of the append-only journal inside the ledger directory.
+	journalFileName = "journal.jsonl"
+	// lockFileName is the name of the lock file shared by the processes using the directory.
+	lockFileName = "ledger.lock"
+)
+
+// FileLedger is a persistent ledger backend. Every committed block is appended as one
+// JSON line to a journal file, and the state is rebuilt by replaying the journal on open.
+//
+// Several processes may open the same directory: commits hold an exclusive file lock and
+// reads a shared one, and both first apply the blocks other processes appended since. The
+// journal is only ever appended to, or replaced as a whole by a truncating restore; a
+// replaced journal is told apart by its file identity and replayed from the start.
+type FileLedger struct {
+	*MemoryLedger
+
+	mu sync.Mutex
+	// dir is the ledger directory.
+	dir string
+	// journal is the open journal file, in append mode, and identity its file info when
+	// opened, compared with the journal path to notice a replaced journal.
+	journal  *os.File
+	identity os.FileInfo
+	// lock excludes the other processes using the directory.
+	lock *t.FileMutexes
+	// offset is the journal size already applied to the state.
+	offset int64
+	// retention is the snapshot retention applied after every snapshot.
+	retention SnapshotRetention
+}
+
+// NewFileLedger opens (or creates) a file ledger in the given directory.
+func NewFileLedger(dir string) (*FileLedger, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("ledger directory cannot be empty")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create ledger directory: %w", err)
+	}
+	lock, err := t.NewFileMutexes(filepath.Join(dir, lockFileName), t.DefaultFileLeaseTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ledger lock: %w", err)
+	}
+
+	fl := &FileLedger{
+		MemoryLedger: newMemoryLedger(),
+		dir:          dir,
+		lock:         lock,
+	}
+	if err := fl.openJournal(); err != nil {
+		return nil, err
+	}
+	if err := fl.refresh(); err != nil {
+		_ = fl.journal.Close()
+		return nil, err
+	}
+	return fl, nil
+}
+
+// journalPath returns the path of the journal file.
+func (fl *FileLedger) journalPath() string { return filepath.Join(fl.dir, journalFileName) }
+
+// openJournal opens the journal file, creating it when needed, and records its identity.
+func (fl *FileLedger) openJournal() error {
+	journal, err := os.OpenFile(fl.journalPath(), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open ledger journal: %w", err)
+	}
+	identity, err := journal.Stat()
+	if err != nil {
+		_ = journal.Close()
+		return fmt.Errorf("failed to read ledger journal: %w", err)
+	}
+	fl.journal, fl.identity = journal, identity
+	return nil
+}
+
+// Dir returns the ledger directory.
+func (fl *FileLedger) Dir() string { return fl.dir }
+
+// GetState returns the current value of a key, including the commits of other processes.
+func (fl *FileLedger) GetState(key string) ([]byte, error) {
+	if err := fl.refresh(); err != nil {
+		return nil, err
+	}
+	return fl.MemoryLedger.GetState(key)
+}
+
+// GetHistory returns every change recorded for a key, including the commits of other processes.
+func (fl *FileLedger) GetHistory(key string) ([]Record, error) {
+	if err := fl.refresh(); err != nil {
+		return nil, err
+	}
+	return fl.MemoryLedger.GetHistory(key)
+}
+
+// Keys returns the sorted list of keys, including the commits of other processes.
+func (fl *FileLedger) Keys() ([]string, error) {
+	if err := fl.refresh(); err != nil {
+		return nil, err
+	}
+	return fl.MemoryLedger.Keys()
+}
+
+// KeysWithPrefix returns the sorted list of keys starting with prefix, including the commits
+// of other processes.
+func (fl *FileLedger) KeysWithPrefix(prefix string) ([]string, error) {
+	if err := fl.refresh(); err != nil {
+		return nil, err
+	}
+	return fl.MemoryLedger.KeysWithPrefix(prefix)
+}
+
+// SystemKeysInCommitOrder returns the system keys starting with prefix in commit order,
+// including the commits of other processes.
+func (fl *FileLedger) SystemKeysInCommitOrder(prefix string) ([]string, error) {
+	if err := fl.refresh(); err != nil {
+		return nil, err
+	}
+	return fl.MemoryLedger.SystemKeysInCommitOrder(prefix)
+}
+
+// HistoryKeys returns the sorted list of keys with a history, including the commits of
+// other processes.
+func (fl *FileLedger) HistoryKeys() ([]string, error) {
+	if err := fl.refresh(); err != nil {
+		return nil, err
+	}
+	return fl.MemoryLedger.HistoryKeys()
+}
+
+// Histories returns the history of every key, deleted keys included, caught up with the
+// journal and copied under a single shared lock.
+func (fl *FileLedger) Histories() (map[string][]Record, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.journal == nil {
+		return nil, fmt.Errorf("ledger is closed")
+	}
+	if err := fl.lock.MuRLockCtx(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to lock ledger: %w", err)
+	}
+	defer fl.lock.MuRUnlock()
+
+	if err := fl.catchUp(); err != nil {
+		return nil, err
+	}
+	return fl.MemoryLedger.Histories()
+}
+
+// Commit appends the transaction to the journal and then applies it to the state.
+func (fl *FileLedger) Commit(txID string, writes []Write) error {
+	block, err := newBlock(txID, writes)
+	if err != nil {
+		return err
+	}
+	return fl.commitBlock(block)
+}
+
+// CommitBlock appends a block as is, keeping its transaction ID and timestamp.
+func (fl *FileLedger) CommitBlock(block Block) error {
+	if err := validateBlock(block); err != nil {
+		return err
+	}
+	return fl.commitBlock(block)
+}
+
+// CommitBlocks appends blocks as is, keeping their transaction IDs and timestamps. They are
+// validated first and written with a single append under one exclusive lock, so a failure
+// commits none of them; only a crash during the write can leave the first ones committed.
+func (fl *FileLedger) CommitBlocks(blocks []Block) error {
+	for _, block := range blocks {
+		if err := validateBlock(block); err != nil {
+			return err
+		}
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.journal == nil {
+		return fmt.Errorf("ledger is closed")
+	}
+	if err := fl.lock.MuLockCtx(context.Background()); err != nil {
+		return fmt.Errorf("failed to lock ledger: %w", err)
+	}
+	defer fl.lock.MuUnlock()
+
+	if err := fl.catchUp(); err != nil {
+		return err
+	}
+	blocks = fl.MemoryLedger.sealAll(blocks)
+	var data []byte
+	for _, block := range blocks {
+		line, err := json.Marshal(block)
+		if err != nil {
+			return fmt.Errorf("failed to serialize block %s: %w", block.TxID, err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if err := fl.appendLines(data); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err := fl.MemoryLedger.apply(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitBlock chains a valid block after the last one of the journal, appends it and then
+// applies it to the state.
+func (fl *FileLedger) commitBlock(block Block) error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.journal == nil {
+		return fmt.Errorf("ledger is closed")
+	}
+	if err := fl.lock.MuLockCtx(context.Background()); err != nil {
+		return fmt.Errorf("failed to lock ledger: %w", err)
+	}
+	defer fl.lock.MuUnlock()
+
+	if err := fl.catchUp(); err != nil {
+		return err
+	}
+	block = fl.MemoryLedger.seal(block)
+	line, err := json.Marshal(block)
+	if err != nil {
+		return fmt.Errorf("failed to serialize block: %w", err)
+	}
+	if err := fl.appendLines(append(line, '\n')); err != nil {
+		return err
+	}
+	return fl.MemoryLedger.apply(block)
+}
+
+// appendLines appends complete journal lines and syncs them, first dropping the partial line
+// a crashed writer may have left behind. The caller must hold fl.mu and the exclusive file lock.
+func (fl *FileLedger) appendLines(data []byte) error {
+	if err := fl.journal.Truncate(fl.offset); err != nil {
+		return fmt.Errorf("failed to truncate ledger journal: %w", err)
+	}
+	if _, err := fl.journal.Write(data); err != nil {
+		return fmt.Errorf("failed to write ledger journal: %w", err)
+	}
+	if err := fl.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync ledger journal: %w", err)
+	}
+	fl.offset += int64(len(data))
+	return nil
+}
+
+// Close closes the journal file and releases the ledger lock file.
+func (fl *FileLedger) Close() error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.journal == nil {
+		return nil
+	}
+	err := errors.Join(fl.journal.Close(), fl.lock.Close())
+	fl.journal = nil
+	return err
+}
+
+// refresh applies the blocks appended to the journal since the last read, under the shared
+// lock. The lock is skipped when the journal did not change since the last read.
+func (fl *FileLedger) refresh() error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.journal == nil {
+		return fmt.Errorf("ledger is closed")
+	}
+	if fl.unchanged() {
+		return nil
+	}
+	if err := fl.lock.MuRLockCtx(context.Background()); err != nil {
+		return fmt.Errorf("failed to lock ledger: %w", err)
+	}
+	defer fl.lock.MuRUnlock()
+
+	return fl.catchUp()
+}
+
+// unchanged reports whether the journal path still names the open journal, with the applied
+// size. Commits and restores only change either under the exclusive lock. The caller must
+// hold fl.mu.
+func (fl *FileLedger) unchanged() bool {
+	info, err := os.Stat(fl.journalPath())
+	return err == nil && os.SameFile(info, fl.identity) && info.Size() == fl.offset
+}
+
+// reopen replaces the open journal with the one at the journal path when a restore renamed a
+// new journal over it, and then drops the applied state, to be replayed from the start. The
+// caller must hold fl.mu and the file lock.
+func (fl *FileLedger) reopen() error {
+	info, err := os.Stat(fl.journalPath())
+	if err != nil {
+		return fmt.Errorf("failed to read ledger journal: %w", err)
+	}
+	if os.SameFile(info, fl.identity) {
+		return nil
+	}
+	gl.Log("warn", fmt.Sprintf("Ledger journal in %s was replaced, replaying it", fl.dir))
+	previous := fl.journal
+	if err := fl.openJournal(); err != nil {
+		return err
+	}
+	_ = previous.Close()
+	fl.MemoryLedger.reset()
+	fl.offset = 0
+	return nil
+}
+
+// catchUp applies the complete journal lines past the applied offset. A replaced journal is
+// reopened and replayed from the start. The caller must hold fl.mu and the file lock.
+func (fl *FileLedger) catchUp() error {
+	if err := fl.reopen(); err != nil {
+		return err
+	}
+	info, err := fl.journal.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read ledger journal: %w", err)
+	}
+	if info.Size() < fl.offset {
+		return fmt.Errorf("ledger journal in %s shrank below the %d bytes already applied", fl.dir, fl.offset)
+	}
+	if info.Size() == fl.offset {
+		return nil
+	}
+
+	reader := bufio.NewReaderSize(io.NewSectionReader(fl.journal, fl.offset, info.Size()-fl.offset), 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A trailing line without newline is an unfinished write, not a committed block.
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read ledger journal: %w", err)
+		}
+		if len(line) > 1 {
+			var block Block
+			if err := json.Unmarshal(line, &block); err != nil {
+				gl.Log("error", fmt.Sprintf("Corrupted ledger journal at offset %d: %v", fl.offset, err))
+				return fmt.Errorf("corrupted ledger journal at offset %d: %w", fl.offset, err)
+			}
+			if err := fl.MemoryLedger.apply(block); err != nil {
+				return err
+			}
+		}
+		fl.offset += int64(len(line))
+	}
+}
@@ -0,0 +1,79 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Write is a single staged state change inside a ledger transaction.
+type Write struct {
+	// Key is the world state key being written.
+	Key string `json:"key"`
+	// Value is the new value for the key. It is ignored when IsDelete is true.
+	Value []byte `json:"value,omitempty"`
+	// IsDelete marks the write as a key deletion.
+	IsDelete bool `json:"isDelete,omitempty"`
+}
+
+// Block is a committed transaction, the unit persisted by the ledger backends.
+type Block struct {
+	// TxID is the identifier of the transaction that produced this block.
+	TxID string `json:"txId"`
+	// Timestamp is the commit time of the transaction.
+	Timestamp time.Time `json:"timestamp"`
+	// Number is the height of the block in the ledger, starting at 0.
+	Number uint64 `json:"number,omitempty"`
+	// PrevHash is the hash of the previous block, empty for the first one.
+	PrevHash string `json:"prevHash,omitempty"`
+	// MerkleRoot is the root of the Merkle tree of the writes.
+	MerkleRoot string `json:"merkleRoot,omitempty"`
+	// Hash covers the header of the block, and its writes through MerkleRoot. Blocks
+	// committed before the chain was introduced have none.
+	Hash string `json:"hash,omitempty"`
+	// Writes is the ordered set of state changes of the transaction.
+	Writes []Write `json:"writes"`
+}
+
+// Record is a single entry in the history of a key.
+type Record struct {
+	TxID      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value,omitempty"`
+	IsDelete  bool      `json:"isDelete,omitempty"`
+}
+
+// ErrNotFound is wrapped by the errors about keys, transactions, blocks and snapshots that
+// are not in the ledger.
+var ErrNotFound = errors.New("not found")
+
+// ILedger is the contract implemented by the in-process ledger backends.
+// Every Commit is applied all-or-nothing.
+type ILedger interface {
+	GetState(key string) ([]byte, error)
+	GetHistory(key string) ([]Record, error)
+	Keys() ([]string, error)
+	Commit(txID string, writes []Write) error
+	Close() error
+}
+
+const (
+	// BackendMemory is the volatile, in-process ledger backend.
+	BackendMemory = "memory"
+	// BackendFile is the journal file ledger backend.
+	BackendFile = "file"
+)
+
+// Open opens a ledger backend by name. The path is only used by persistent backends.
+func Open(backend, path string) (ILedger, error) {
+	switch strings.ToLower(backend) {
+	case "", BackendMemory:
+		return NewMemoryLedger(), nil
+	case BackendFile:
+		return NewFileLedger(path)
+	default:
+		return nil, fmt.Errorf("unknown ledger backend: %s", backend)
+	}
+}
@@ -0,0 +1,334 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryLedger is a volatile ledger backend that keeps state and history in memory. The
+// history of a system key ends with its deletion, so acknowledged outbox messages do not
+// accumulate in memory; the blocks that wrote them stay in the chain.
+type MemoryLedger struct {
+	mu sync.RWMutex
+	// state is the current world state.
+	state map[string][]byte
+	// history is the ordered list of changes per key.
+	history map[string][]Record
+	// system indexes the system keys present in the state by the position of their last
+	// write, so that the outbox is listed in commit order without scanning the documents.
+	system map[string]commitPosition
+	// height is the number of blocks applied, and head the hash of the last one.
+	height uint64
+	head   string
+}
+
+func newMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{
+		state:   make(map[string][]byte),
+		history: make(map[string][]Record),
+		system:  make(map[string]commitPosition),
+	}
+}
+
+// commitPosition is the position of a write in the ledger: the height of its block, then its
+// index in the block.
+type commitPosition struct {
+	height uint64
+	index  int
+}
+
+func (p commitPosition) before(other commitPosition) bool {
+	return p.height < other.height || (p.height == other.height && p.index < other.index)
+}
+
+// NewMemoryLedger creates a new in-memory ledger backend.
+func NewMemoryLedger() ILedger { return newMemoryLedger() }
+
+// GetState returns the current value of a key, or nil if the key does not exist.
+func (ml *MemoryLedger) GetState(key string) ([]byte, error) {
+	if ml == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	value, ok := ml.state[key]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// GetHistory returns every change recorded for a key, oldest first.
+func (ml *MemoryLedger) GetHistory(key string) ([]Record, error) {
+	if ml == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	history := make([]Record, len(ml.history[key]))
+	copy(history, ml.history[key])
+	return history, nil
+}
+
+// Keys returns the sorted list of keys present in the world state.
+func (ml *MemoryLedger) Keys() ([]string, error) {
+	if ml == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	keys := make([]string, 0, len(ml.state))
+	for key := range ml.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// KeysWithPrefix returns the sorted list of keys of the world state starting with prefix.
+// System key prefixes are served from an index.
+func (ml *MemoryLedger) KeysWithPrefix(prefix string) ([]string, error) {
+	if ml == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	keys := make([]string, 0)
+	if IsSystemKey(prefix) {
+		for key := range ml.system {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+	} else {
+		for key := range ml.state {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// SystemKeysInCommitOrder returns the system keys of the state starting with prefix, in the
+// order of their last write: by block height, then by position in the block.
+func (ml *MemoryLedger) SystemKeysInCommitOrder(prefix string) ([]string, error) {
+	if ml == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	if !IsSystemKey(prefix) {
+		return nil, fmt.Errorf("%q is not a system key prefix", prefix)
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	keys := make([]string, 0)
+	for key := range ml.system {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return ml.system[keys[i]].before(ml.system[keys[j]]) })
+	return keys, nil
+}
+
+// Commit applies all writes of a transaction atomically.
+func (ml *MemoryLedger) Commit(txID string, writes []Write) error {
+	block, err := newBlock(txID, writes)
+	if err != nil {
+		return err
+	}
+	return ml.commit(block)
+}
+
+// CommitBlock applies a block keeping its transaction ID and timestamp; it is chained
+// after the current head like any other block.
+func (ml *MemoryLedger) CommitBlock(block Block) error {
+	if err := validateBlock(block); err != nil {
+		return err
+	}
+	return ml.commit(block)
+}
+
+// CommitBlocks applies blocks as is, keeping their transaction IDs and timestamps, all or
+// none of them. They are chained after the current head, in order.
+func (ml *MemoryLedger) CommitBlocks(blocks []Block) error {
+	if ml == nil {
+		return fmt.Errorf("ledger is nil")
+	}
+	for _, block := range blocks {
+		if err := validateBlock(block); err != nil {
+			return err
+		}
+	}
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	for _, block := range blocks {
+		ml.applyLocked(ml.sealLocked(block))
+	}
+	return nil
+}
+
+// commit chains a valid block after the head and applies it.
+func (ml *MemoryLedger) commit(block Block) error {
+	if ml == nil {
+		return fmt.Errorf("ledger is nil")
+	}
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	ml.applyLocked(ml.sealLocked(block))
+	return nil
+}
+
+// seal chains a block after the head without applying it.
+func (ml *MemoryLedger) seal(block Block) Block {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	return ml.sealLocked(block)
+}
+
+// sealAll chains blocks after the head, one after the other, without applying them.
+func (ml *MemoryLedger) sealAll(blocks []Block) []Block {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	sealed := make([]Block, len(blocks))
+	height, head := ml.height, ml.head
+	for i, block := range blocks {
+		sealed[i] = sealBlock(block, height, head)
+		height, head = height+1, sealed[i].Hash
+	}
+	return sealed
+}
+
+// sealLocked chains a block after the head. The caller must hold ml.mu.
+func (ml *MemoryLedger) sealLocked(block Block) Block {
+	return sealBlock(block, ml.height, ml.head)
+}
+
+// HistoryKeys returns the sorted list of keys with a history, deleted keys included.
+func (ml *MemoryLedger) HistoryKeys() ([]string, error) {
+	if ml == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	keys := make([]string, 0, len(ml.history))
+	for key := range ml.history {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Histories returns the history of every key, deleted keys included, as of a single point
+// in time.
+func (ml *MemoryLedger) Histories() (map[string][]Record, error) {
+	if ml == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	histories := make(map[string][]Record, len(ml.history))
+	for key, history := range ml.history {
+		histories[key] = append([]Record(nil), history...)
+	}
+	return histories, nil
+}
+
+// Close is a no-op for the in-memory backend.
+func (ml *MemoryLedger) Close() error { return nil }
+
+// reset drops the whole state and history.
+func (ml *MemoryLedger) reset() {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	ml.state = make(map[string][]byte)
+	ml.history = make(map[string][]Record)
+	ml.system = make(map[string]commitPosition)
+	ml.height, ml.head = 0, ""
+}
+
+// apply writes a block into the in-memory state and history.
+func (ml *MemoryLedger) apply(block Block) error {
+	if ml == nil {
+		return fmt.Errorf("ledger is nil")
+	}
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	ml.applyLocked(block)
+	return nil
+}
+
+// applyLocked writes a block into the state and history. The caller must hold ml.mu.
+func (ml *MemoryLedger) applyLocked(block Block) {
+	for i, w := range block.Writes {
+		record := Record{
+			TxID:      block.TxID,
+			Timestamp: block.Timestamp,
+			Key:       w.Key,
+			IsDelete:  w.IsDelete,
+		}
+		if w.IsDelete {
+			delete(ml.state, w.Key)
+			if IsSystemKey(w.Key) {
+				delete(ml.system, w.Key)
+				delete(ml.history, w.Key)
+				continue
+			}
+		} else {
+			record.Value = append([]byte(nil), w.Value...)
+			ml.state[w.Key] = record.Value
+			if IsSystemKey(w.Key) {
+				ml.system[w.Key] = commitPosition{height: ml.height, index: i}
+			}
+		}
+		ml.history[w.Key] = append(ml.history[w.Key], record)
+	}
+	ml.height++
+	ml.head = block.Hash
+}
+
+// newBlock validates the writes of a transaction and wraps them in a block.
+func newBlock(txID string, writes []Write) (Block, error) {
+	block := Block{
+		TxID:      txID,
+		Timestamp: time.Now().UTC(),
+		Writes:    writes,
+	}
+	if err := validateBlock(block); err != nil {
+		return Block{}, err
+	}
+	return block, nil
+}
+
+// validateBlock checks that a block has a transaction ID and writes with keys.
+func validateBlock(block Block) error {
+	if block.TxID == "" {
+		return fmt.Errorf("transaction ID cannot be empty")
+	}
+	if len(block.Writes) == 0 {
+		return fmt.Errorf("transaction %s has no writes", block.TxID)
+	}
+	for _, w := range block.Writes {
+		if w.Key == "" {
+			return fmt.Errorf("transaction %s has a write with an empty key", block.TxID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package ledger
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+)
+
+// The Merkle tree of the writes of a block follows RFC 6962: leaves and interior nodes are
+// hashed with distinct prefixes, so a leaf can never be passed off as a node, and a tree of
+// n leaves splits at the largest power of two smaller than n.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// LeafHash returns the Merkle leaf hash of a write. The value of a delete is ignored.
+func LeafHash(w Write) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	writeField(h, []byte(w.Key))
+	if w.IsDelete {
+		h.Write([]byte{1})
+		writeField(h, nil)
+	} else {
+		h.Write([]byte{0})
+		writeField(h, w.Value)
+	}
+	return h.Sum(nil)
+}
+
+// nodeHash returns the hash of an interior node.
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// MerkleRoot returns the root of the Merkle tree of leaf hashes; the root of no leaves is
+// the hash of the empty string.
+func MerkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return nodeHash(MerkleRoot(leaves[:k]), MerkleRoot(leaves[k:]))
+}
+
+// writesRoot returns the Merkle root of the writes of a block.
+func writesRoot(writes []Write) []byte {
+	leaves := make([][]byte, len(writes))
+	for i, w := range writes {
+		leaves[i] = LeafHash(w)
+	}
+	return MerkleRoot(leaves)
+}
+
+// splitPoint returns the largest power of two smaller than n, for n > 1.
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// writeField writes a length prefixed field, so that concatenated fields are unambiguous.
+func writeField(h hash.Hash, data []byte) {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	h.Write(size[:])
+	h.Write(data)
+}
@@ -0,0 +1,161 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SystemKeyPrefix starts the keys reserved by the platform. As with Fabric composite keys,
+// system keys are left out of range queries and state migrations.
+const SystemKeyPrefix = "\x00"
+
+// OutboxPrefix starts the keys of the outbox, the queue of messages committed together with
+// the state changes they describe and published afterwards.
+const OutboxPrefix = SystemKeyPrefix + "outbox" + SystemKeyPrefix
+
+// IsSystemKey reports whether a key is reserved by the platform.
+func IsSystemKey(key string) bool { return strings.HasPrefix(key, SystemKeyPrefix) }
+
+// OutboxMessage is a message waiting in the outbox to be published.
+type OutboxMessage struct {
+	// ID identifies the message; consumers use it to drop redeliveries.
+	ID string `json:"id"`
+	// Topic is the routing topic of the message.
+	Topic string `json:"topic"`
+	// Key is the partition key of the message, e.g. the document ID.
+	Key       string            `json:"key,omitempty"`
+	Payload   json.RawMessage   `json:"payload"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// NewOutboxMessage creates an outbox message with a new ID.
+func NewOutboxMessage(topic, key string, payload any) (OutboxMessage, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return OutboxMessage{}, fmt.Errorf("failed to encode outbox payload of %s: %w", topic, err)
+	}
+	return OutboxMessage{
+		ID:        uuid.New().String(),
+		Topic:     topic,
+		Key:       key,
+		Payload:   body,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// OutboxKey returns the ledger key of a message. Keys sort in creation order; the outbox is
+// read in commit order, see PendingOutbox.
+func (m OutboxMessage) OutboxKey() string {
+	return fmt.Sprintf("%s%020d%s%s", OutboxPrefix, m.CreatedAt.UnixNano(), SystemKeyPrefix, m.ID)
+}
+
+// Write returns the ledger write that enqueues the message, to be committed in the same
+// transaction as the state changes it describes.
+func (m OutboxMessage) Write() (Write, error) {
+	value, err := json.Marshal(m)
+	if err != nil {
+		return Write{}, fmt.Errorf("failed to encode outbox message %s: %w", m.ID, err)
+	}
+	return Write{Key: m.OutboxKey(), Value: value}, nil
+}
+
+// IKeyIndex is implemented by the ledgers listing the keys of a prefix without scanning
+// the whole state.
+type IKeyIndex interface {
+	KeysWithPrefix(prefix string) ([]string, error)
+}
+
+// ICommitOrder is implemented by the ledgers listing system keys in commit order: by the
+// height of the block that wrote them, then by position in the block.
+type ICommitOrder interface {
+	SystemKeysInCommitOrder(prefix string) ([]string, error)
+}
+
+// PendingOutbox returns up to limit messages of the outbox in commit order, so that events
+// are published in the order of the changes they describe even when concurrent transactions
+// created them in another order. Ledgers without ICommitOrder list the outbox in creation
+// order. A limit of zero or less returns every pending message.
+func PendingOutbox(ledger ILedger, limit int) ([]OutboxMessage, error) {
+	return PendingOutboxAfter(ledger, "", limit)
+}
+
+// PendingOutboxAfter is PendingOutbox skipping the messages up to the one of outbox key after,
+// so that a relay can read past messages it published but has not acknowledged yet. When
+// after is no longer in the outbox, every pending message is returned.
+func PendingOutboxAfter(ledger ILedger, after string, limit int) ([]OutboxMessage, error) {
+	if ledger == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	keys, err := outboxKeys(ledger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox: %w", err)
+	}
+
+	if after != "" {
+		keys = keys[slices.Index(keys, after)+1:]
+	}
+
+	messages := make([]OutboxMessage, 0)
+	for _, key := range keys {
+		value, err := ledger.GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read outbox message %q: %w", key, err)
+		}
+		if value == nil {
+			continue
+		}
+		var message OutboxMessage
+		if err := json.Unmarshal(value, &message); err != nil {
+			return nil, fmt.Errorf("failed to decode outbox message %q: %w", key, err)
+		}
+		messages = append(messages, message)
+		if limit > 0 && len(messages) == limit {
+			break
+		}
+	}
+	return messages, nil
+}
+
+// outboxKeys returns the keys of the outbox in commit order, or in creation order from the key
+// index or the whole state of ledgers that do not track the commit order.
+func outboxKeys(ledger ILedger) ([]string, error) {
+	if ordered, ok := ledger.(ICommitOrder); ok {
+		return ordered.SystemKeysInCommitOrder(OutboxPrefix)
+	}
+	if index, ok := ledger.(IKeyIndex); ok {
+		return index.KeysWithPrefix(OutboxPrefix)
+	}
+	keys, err := ledger.Keys()
+	if err != nil {
+		return nil, err
+	}
+	outbox := make([]string, 0)
+	for _, key := range keys {
+		if strings.HasPrefix(key, OutboxPrefix) {
+			outbox = append(outbox, key)
+		}
+	}
+	return outbox, nil
+}
+
+// AckOutbox removes published messages from the outbox in a single transaction. Ledgers
+// drop the history of acknowledged messages, see MemoryLedger.
+func AckOutbox(ledger ILedger, messages ...OutboxMessage) error {
+	if ledger == nil {
+		return fmt.Errorf("ledger is nil")
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	writes := make([]Write, 0, len(messages))
+	for _, message := range messages {
+		writes = append(writes, Write{Key: message.OutboxKey(), IsDelete: true})
+	}
+	return ledger.Commit("outbox-ack-"+uuid.New().String(), writes)
+}
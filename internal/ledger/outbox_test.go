@@ -0,0 +1,99 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+)
+
+// enqueue commits the writes of messages in a single block.
+func enqueue(t *testing.T, ledger ILedger, txID string, messages ...OutboxMessage) {
+	t.Helper()
+	writes := make([]Write, 0, len(messages))
+	for _, message := range messages {
+		write, err := message.Write()
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		writes = append(writes, write)
+	}
+	if err := ledger.Commit(txID, writes); err != nil {
+		t.Fatalf("Commit %s: %v", txID, err)
+	}
+}
+
+func requireOutbox(t *testing.T, ledger ILedger, want ...OutboxMessage) {
+	t.Helper()
+	pending, err := PendingOutbox(ledger, 0)
+	if err != nil {
+		t.Fatalf("PendingOutbox: %v", err)
+	}
+	if len(pending) != len(want) {
+		t.Fatalf("PendingOutbox = %d messages, want %d", len(pending), len(want))
+	}
+	for i := range want {
+		if pending[i].ID != want[i].ID {
+			t.Fatalf("PendingOutbox[%d] = %s, want %s", i, pending[i].Topic, want[i].Topic)
+		}
+	}
+}
+
+func TestOutboxIsReadInCommitOrder(t *testing.T) {
+	dir := t.TempDir()
+	fl, err := NewFileLedger(dir)
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	defer func() { _ = fl.Close() }()
+
+	created := time.Now().UTC()
+	message := func(topic string, offset time.Duration) OutboxMessage {
+		m, err := NewOutboxMessage(topic, "", nil)
+		if err != nil {
+			t.Fatalf("NewOutboxMessage: %v", err)
+		}
+		m.CreatedAt = created.Add(offset)
+		return m
+	}
+	// The transaction that created its events first commits last.
+	late := message("late", 0)
+	first, second := message("first", time.Second), message("second", -time.Second)
+	enqueue(t, fl, "tx1", first, second)
+	enqueue(t, fl, "tx2", late)
+	requireOutbox(t, fl, first, second, late)
+
+	after, err := PendingOutboxAfter(fl, first.OutboxKey(), 0)
+	if err != nil || len(after) != 2 || after[0].ID != second.ID {
+		t.Fatalf("PendingOutboxAfter(first) = %v, %v, want second and late", after, err)
+	}
+
+	reopened, err := NewFileLedger(dir)
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	requireOutbox(t, reopened, first, second, late)
+}
+
+func TestAckOutboxCompactsTheAcknowledgedMessages(t *testing.T) {
+	ledger := NewMemoryLedger()
+	first, err := NewOutboxMessage("first", "", nil)
+	if err != nil {
+		t.Fatalf("NewOutboxMessage: %v", err)
+	}
+	second, err := NewOutboxMessage("second", "", nil)
+	if err != nil {
+		t.Fatalf("NewOutboxMessage: %v", err)
+	}
+	enqueue(t, ledger, "tx1", first, second)
+
+	if err := AckOutbox(ledger, first); err != nil {
+		t.Fatalf("AckOutbox: %v", err)
+	}
+	requireOutbox(t, ledger, second)
+	if history, err := ledger.GetHistory(first.OutboxKey()); err != nil || len(history) != 0 {
+		t.Fatalf("history of an acknowledged message = %v, %v, want none", history, err)
+	}
+	if history, err := ledger.GetHistory(second.OutboxKey()); err != nil || len(history) != 1 {
+		t.Fatalf("history of a pending message = %v, %v, want its write", history, err)
+	}
+}
@@ -0,0 +1,560 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ProofVersion is the version of the proof format. Version 1 proofs have no header chain and
+// are still verified.
+const ProofVersion = 2
+
+// proofMagic starts the binary encoding of a proof.
+var proofMagic = []byte("SPP")
+
+// ErrInvalidProof is returned when a proof is malformed or does not match the root hash.
+var ErrInvalidProof = errors.New("invalid ledger proof")
+
+// ProofHeader is the header of a block of a proof.
+type ProofHeader struct {
+	Number    uint64    `json:"number"`
+	PrevHash  string    `json:"prevHash,omitempty"`
+	TxID      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+	// MerkleRoot and Hash are recomputed by the verifier, which rejects a proof claiming
+	// other values. In the header chain, MerkleRoot is given and only Hash is recomputed.
+	MerkleRoot string `json:"merkleRoot,omitempty"`
+	Hash       string `json:"hash,omitempty"`
+}
+
+// Proof is a Merkle inclusion proof of a write in a block: with the block header and the
+// headers of the blocks after it up to a published one, the anchor, it shows to anyone
+// holding the hash of the anchor that the key had the value at the time of the block,
+// without the rest of the ledger. The anchor is usually the head of the chain, as reported
+// by Verify.
+//
+// A proof does not show that the key kept the value after the block; prove the latest block
+// writing the key for that.
+type Proof struct {
+	Version  int    `json:"version"`
+	Key      string `json:"key"`
+	Value    []byte `json:"value,omitempty"`
+	IsDelete bool   `json:"isDelete,omitempty"`
+	// LeafIndex is the index of the write in the block, and TreeSize the number of writes.
+	LeafIndex uint64 `json:"leafIndex"`
+	TreeSize  uint64 `json:"treeSize"`
+	// Path lists the hex sibling hashes from the leaf up to the Merkle root.
+	Path  []string    `json:"path"`
+	Block ProofHeader `json:"block"`
+	// Chain lists the headers of the blocks following Block, up to the anchor. It is empty
+	// when Block is the anchor.
+	Chain []ProofHeader `json:"chain,omitempty"`
+}
+
+// Anchor returns the header of the block the proof verifies against: the last header of the
+// chain, or the block of the write.
+func (p *Proof) Anchor() ProofHeader {
+	if len(p.Chain) > 0 {
+		return p.Chain[len(p.Chain)-1]
+	}
+	return p.Block
+}
+
+// NewProof returns the proof of the last write of a key in a sealed block.
+func NewProof(block Block, key string) (*Proof, error) {
+	if block.Hash == "" {
+		return nil, fmt.Errorf("transaction %s was committed before the chain and cannot be proved", block.TxID)
+	}
+	index := -1
+	leaves := make([][]byte, len(block.Writes))
+	for i, w := range block.Writes {
+		leaves[i] = LeafHash(w)
+		if w.Key == key {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("key %s %w in block %d", key, ErrNotFound, block.Number)
+	}
+
+	w := block.Writes[index]
+	proof := &Proof{
+		Version:   ProofVersion,
+		Key:       w.Key,
+		IsDelete:  w.IsDelete,
+		LeafIndex: uint64(index),
+		TreeSize:  uint64(len(leaves)),
+		Path:      []string{},
+		Block:     proofHeader(block),
+	}
+	if !w.IsDelete {
+		proof.Value = append([]byte(nil), w.Value...)
+	}
+	for _, node := range auditPath(leaves, index) {
+		proof.Path = append(proof.Path, hex.EncodeToString(node))
+	}
+	return proof, nil
+}
+
+// proofHeader returns the header of a block, for a proof.
+func proofHeader(block Block) ProofHeader {
+	return ProofHeader{
+		Number:     block.Number,
+		PrevHash:   block.PrevHash,
+		TxID:       block.TxID,
+		Timestamp:  block.Timestamp,
+		MerkleRoot: block.MerkleRoot,
+		Hash:       block.Hash,
+	}
+}
+
+// ProofOptions select the block of a proof and its anchor. Without TxID and Block, the latest
+// block writing the key as of the anchor is proved.
+type ProofOptions struct {
+	// TxID proves the last write of the key at or before the transaction.
+	TxID string
+	// Block proves the last write of the key at or before the block number.
+	Block *uint64
+	// Anchor is the published hash of the block the proof is anchored to, which must not
+	// precede the proved block. It defaults to the head of the chain.
+	Anchor string
+}
+
+// Prove returns the proof of the last write of a key at or before the selected block, chained
+// up to the anchor, read from the journal under the shared lock.
+func (fl *FileLedger) Prove(key string, opts ProofOptions) (*Proof, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.journal == nil {
+		return nil, fmt.Errorf("ledger is closed")
+	}
+	if err := fl.lock.MuRLockCtx(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to lock ledger: %w", err)
+	}
+	err := fl.catchUp()
+	var journal []byte
+	if err == nil {
+		journal, err = fl.readJournal()
+	}
+	fl.lock.MuRUnlock()
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := readBlocks(bytes.NewReader(journal))
+	if err != nil {
+		return nil, err
+	}
+
+	anchor := len(blocks) - 1
+	if opts.Anchor != "" {
+		for anchor >= 0 && !strings.EqualFold(blocks[anchor].Hash, strings.TrimSpace(opts.Anchor)) {
+			anchor--
+		}
+		if anchor < 0 {
+			return nil, fmt.Errorf("anchor block %s %w in the ledger history", opts.Anchor, ErrNotFound)
+		}
+	}
+
+	last := anchor
+	switch {
+	case opts.TxID != "":
+		for last >= 0 && blocks[last].TxID != opts.TxID {
+			last--
+		}
+		if last < 0 {
+			return nil, fmt.Errorf("transaction %s %w in the ledger history", opts.TxID, ErrNotFound)
+		}
+	case opts.Block != nil:
+		if *opts.Block >= uint64(len(blocks)) {
+			return nil, fmt.Errorf("block %d %w in the ledger history of %d blocks", *opts.Block, ErrNotFound, len(blocks))
+		}
+		last = int(*opts.Block)
+	}
+	if last > anchor {
+		return nil, fmt.Errorf("block %d follows the anchor block %d", last, anchor)
+	}
+	for i := last; i >= 0; i-- {
+		for _, w := range blocks[i].Writes {
+			if w.Key != key {
+				continue
+			}
+			proof, err := NewProof(blocks[i], key)
+			if err != nil {
+				return nil, err
+			}
+			for _, block := range blocks[i+1 : anchor+1] {
+				proof.Chain = append(proof.Chain, proofHeader(block))
+			}
+			return proof, nil
+		}
+	}
+	return nil, fmt.Errorf("key %s %w in the ledger history", key, ErrNotFound)
+}
+
+// VerifyProof checks a proof against the published hash of its anchor block, and then fills
+// in the recomputed MerkleRoot and Hash of its headers. The block of the write must link to
+// the anchor through the header chain. The errors wrap ErrInvalidProof.
+func VerifyProof(proof *Proof, root string) error {
+	if proof == nil {
+		return fmt.Errorf("%w: no proof", ErrInvalidProof)
+	}
+	if proof.Version < 1 || proof.Version > ProofVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidProof, proof.Version)
+	}
+	path := make([][]byte, len(proof.Path))
+	for i, node := range proof.Path {
+		data, err := hex.DecodeString(node)
+		if err != nil || len(data) != sha256.Size {
+			return fmt.Errorf("%w: audit path node %d is not a sha256 hash", ErrInvalidProof, i)
+		}
+		path[i] = data
+	}
+
+	leaf := LeafHash(Write{Key: proof.Key, Value: proof.Value, IsDelete: proof.IsDelete})
+	merkleRoot, err := rootFromPath(leaf, proof.LeafIndex, proof.TreeSize, path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	header := Block{
+		Number:     proof.Block.Number,
+		PrevHash:   proof.Block.PrevHash,
+		TxID:       proof.Block.TxID,
+		Timestamp:  proof.Block.Timestamp,
+		MerkleRoot: hex.EncodeToString(merkleRoot),
+	}
+	hash := hex.EncodeToString(BlockHash(header))
+	if proof.Block.MerkleRoot != "" && !strings.EqualFold(proof.Block.MerkleRoot, header.MerkleRoot) {
+		return fmt.Errorf("%w: the path leads to merkle root %s, not %s", ErrInvalidProof, header.MerkleRoot, proof.Block.MerkleRoot)
+	}
+	if proof.Block.Hash != "" && !strings.EqualFold(proof.Block.Hash, hash) {
+		return fmt.Errorf("%w: the header hashes to %s, not %s", ErrInvalidProof, hash, proof.Block.Hash)
+	}
+
+	hashes := make([]string, len(proof.Chain))
+	prevNumber, prevHash := header.Number, hash
+	for i, link := range proof.Chain {
+		if link.Number != prevNumber+1 || !strings.EqualFold(link.PrevHash, prevHash) {
+			return fmt.Errorf("%w: chain header %d does not follow block %d", ErrInvalidProof, i, prevNumber)
+		}
+		if data, err := hex.DecodeString(link.MerkleRoot); err != nil || len(data) != sha256.Size {
+			return fmt.Errorf("%w: chain header %d has no valid merkle root", ErrInvalidProof, i)
+		}
+		hashes[i] = hex.EncodeToString(BlockHash(Block{
+			Number:     link.Number,
+			PrevHash:   link.PrevHash,
+			TxID:       link.TxID,
+			Timestamp:  link.Timestamp,
+			MerkleRoot: link.MerkleRoot,
+		}))
+		if link.Hash != "" && !strings.EqualFold(link.Hash, hashes[i]) {
+			return fmt.Errorf("%w: chain header %d hashes to %s, not %s", ErrInvalidProof, i, hashes[i], link.Hash)
+		}
+		prevNumber, prevHash = link.Number, hashes[i]
+	}
+
+	root = strings.TrimSpace(root)
+	if !strings.EqualFold(root, prevHash) {
+		return fmt.Errorf("%w: root %s is not the hash %s of the anchor block %d", ErrInvalidProof, root, prevHash, prevNumber)
+	}
+	proof.Block.MerkleRoot, proof.Block.Hash = header.MerkleRoot, hash
+	for i := range proof.Chain {
+		proof.Chain[i].Hash = hashes[i]
+	}
+	return nil
+}
+
+// Encode returns the compact form of a proof: its binary encoding in unpadded base64url.
+func (p *Proof) Encode() (string, error) {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// MarshalBinary encodes a proof without its informative hashes, which the decoder recomputes.
+// A proof with a header chain needs version 2.
+func (p *Proof) MarshalBinary() ([]byte, error) {
+	if len(p.Chain) > 0 && p.Version < 2 {
+		return nil, fmt.Errorf("version %d proofs have no header chain", p.Version)
+	}
+	prevHash, err := hex.DecodeString(p.Block.PrevHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid previous hash: %w", err)
+	}
+	data := append([]byte(nil), proofMagic...)
+	data = append(data, byte(p.Version))
+	data = binary.AppendUvarint(data, p.Block.Number)
+	data = appendBytes(data, prevHash)
+	data = appendBytes(data, []byte(p.Block.TxID))
+	data = appendTime(data, p.Block.Timestamp)
+	data = appendBytes(data, []byte(p.Key))
+	var flags byte
+	if p.IsDelete {
+		flags |= 1
+	}
+	data = append(data, flags)
+	data = appendBytes(data, p.Value)
+	data = binary.AppendUvarint(data, p.LeafIndex)
+	data = binary.AppendUvarint(data, p.TreeSize)
+	data = binary.AppendUvarint(data, uint64(len(p.Path)))
+	for i, node := range p.Path {
+		hash, err := hex.DecodeString(node)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("audit path node %d is not a sha256 hash", i)
+		}
+		data = append(data, hash...)
+	}
+	if p.Version < 2 {
+		return data, nil
+	}
+
+	data = binary.AppendUvarint(data, uint64(len(p.Chain)))
+	for i, link := range p.Chain {
+		prevHash, err := hex.DecodeString(link.PrevHash)
+		if err != nil {
+			return nil, fmt.Errorf("chain header %d has an invalid previous hash: %w", i, err)
+		}
+		merkleRoot, err := hex.DecodeString(link.MerkleRoot)
+		if err != nil || len(merkleRoot) != sha256.Size {
+			return nil, fmt.Errorf("chain header %d has no valid merkle root", i)
+		}
+		data = binary.AppendUvarint(data, link.Number)
+		data = appendBytes(data, prevHash)
+		data = appendBytes(data, []byte(link.TxID))
+		data = appendTime(data, link.Timestamp)
+		data = append(data, merkleRoot...)
+	}
+	return data, nil
+}
+
+// DecodeProof parses a proof in its compact form or as JSON. The errors wrap ErrInvalidProof.
+func DecodeProof(text string) (*Proof, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") {
+		proof := &Proof{}
+		if err := json.Unmarshal([]byte(text), proof); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+		}
+		return proof, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not a compact proof: %v", ErrInvalidProof, err)
+	}
+	return UnmarshalProof(data)
+}
+
+// UnmarshalProof decodes the binary encoding of a proof, recomputing its Merkle root and
+// block hash. The errors wrap ErrInvalidProof.
+func UnmarshalProof(data []byte) (*Proof, error) {
+	r := &proofReader{data: data}
+	if !bytes.HasPrefix(data, proofMagic) {
+		return nil, fmt.Errorf("%w: not a proof", ErrInvalidProof)
+	}
+	r.data = r.data[len(proofMagic):]
+
+	p := &Proof{Version: int(r.byte())}
+	if p.Version < 1 || p.Version > ProofVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidProof, p.Version)
+	}
+	p.Block.Number = r.uvarint()
+	if prevHash := r.bytes(); len(prevHash) > 0 {
+		p.Block.PrevHash = hex.EncodeToString(prevHash)
+	}
+	p.Block.TxID = string(r.bytes())
+	p.Block.Timestamp = r.time()
+	p.Key = string(r.bytes())
+	p.IsDelete = r.byte()&1 == 1
+	if value := r.bytes(); len(value) > 0 {
+		p.Value = value
+	}
+	p.LeafIndex = r.uvarint()
+	p.TreeSize = r.uvarint()
+	count := r.uvarint()
+	if r.err == nil && count > uint64(len(r.data)/sha256.Size) {
+		r.err = fmt.Errorf("audit path of %d nodes is truncated", count)
+	}
+	p.Path = make([]string, 0, count)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		p.Path = append(p.Path, hex.EncodeToString(r.next(sha256.Size)))
+	}
+	if p.Version >= 2 {
+		count := r.uvarint()
+		// A chain header takes at least its number, two lengths, two timestamp fields and
+		// its merkle root.
+		if r.err == nil && count > uint64(len(r.data)/(sha256.Size+5)) {
+			r.err = fmt.Errorf("header chain of %d blocks is truncated", count)
+		}
+		for i := uint64(0); i < count && r.err == nil; i++ {
+			link := ProofHeader{Number: r.uvarint()}
+			if prevHash := r.bytes(); len(prevHash) > 0 {
+				link.PrevHash = hex.EncodeToString(prevHash)
+			}
+			link.TxID = string(r.bytes())
+			link.Timestamp = r.time()
+			link.MerkleRoot = hex.EncodeToString(r.next(sha256.Size))
+			p.Chain = append(p.Chain, link)
+		}
+	}
+	if r.err == nil && len(r.data) > 0 {
+		r.err = fmt.Errorf("%d trailing bytes", len(r.data))
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProof, r.err)
+	}
+
+	path := make([][]byte, len(p.Path))
+	for i, node := range p.Path {
+		path[i], _ = hex.DecodeString(node)
+	}
+	leaf := LeafHash(Write{Key: p.Key, Value: p.Value, IsDelete: p.IsDelete})
+	if root, err := rootFromPath(leaf, p.LeafIndex, p.TreeSize, path); err == nil {
+		p.Block.MerkleRoot = hex.EncodeToString(root)
+		p.Block.Hash = hex.EncodeToString(BlockHash(Block{
+			Number:     p.Block.Number,
+			PrevHash:   p.Block.PrevHash,
+			TxID:       p.Block.TxID,
+			Timestamp:  p.Block.Timestamp,
+			MerkleRoot: p.Block.MerkleRoot,
+		}))
+	}
+	for i, link := range p.Chain {
+		p.Chain[i].Hash = hex.EncodeToString(BlockHash(Block{
+			Number:     link.Number,
+			PrevHash:   link.PrevHash,
+			TxID:       link.TxID,
+			Timestamp:  link.Timestamp,
+			MerkleRoot: link.MerkleRoot,
+		}))
+	}
+	return p, nil
+}
+
+// appendTime appends a timestamp as its unix seconds and nanoseconds.
+func appendTime(data []byte, at time.Time) []byte {
+	data = binary.AppendVarint(data, at.Unix())
+	return binary.AppendUvarint(data, uint64(at.Nanosecond()))
+}
+
+// appendBytes appends a length prefixed byte string.
+func appendBytes(data, field []byte) []byte {
+	return append(binary.AppendUvarint(data, uint64(len(field))), field...)
+}
+
+// proofReader decodes the fields of a binary proof, keeping the first error.
+type proofReader struct {
+	data []byte
+	err  error
+}
+
+func (r *proofReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = fmt.Errorf("truncated proof")
+		return nil
+	}
+	field := r.data[:n]
+	r.data = r.data[n:]
+	return field
+}
+
+func (r *proofReader) byte() byte {
+	if field := r.next(1); field != nil {
+		return field[0]
+	}
+	return 0
+}
+
+func (r *proofReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("truncated proof")
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *proofReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("truncated proof")
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *proofReader) time() time.Time {
+	sec := r.varint()
+	return time.Unix(sec, int64(r.uvarint())).UTC()
+}
+
+func (r *proofReader) bytes() []byte {
+	size := r.uvarint()
+	if r.err == nil && size > uint64(len(r.data)) {
+		r.err = fmt.Errorf("truncated proof")
+		return nil
+	}
+	return append([]byte(nil), r.next(int(size))...)
+}
+
+// auditPath returns the sibling hashes from a leaf up to the root, as in RFC 6962.
+func auditPath(leaves [][]byte, index int) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := splitPoint(len(leaves))
+	if index < k {
+		return append(auditPath(leaves[:k], index), MerkleRoot(leaves[k:]))
+	}
+	return append(auditPath(leaves[k:], index-k), MerkleRoot(leaves[:k]))
+}
+
+// rootFromPath returns the Merkle root implied by a leaf and its audit path, as in RFC 9162.
+func rootFromPath(leaf []byte, index, size uint64, path [][]byte) ([]byte, error) {
+	if index >= size {
+		return nil, fmt.Errorf("leaf index %d is out of a tree of %d leaves", index, size)
+	}
+	fn, sn := index, size-1
+	root := leaf
+	for _, node := range path {
+		if sn == 0 {
+			return nil, fmt.Errorf("audit path is too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			root = nodeHash(node, root)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			root = nodeHash(root, node)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return nil, fmt.Errorf("audit path is too short")
+	}
+	return root, nil
+}
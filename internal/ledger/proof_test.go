@@ -0,0 +1,198 @@
+package ledger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testLeaves returns n distinct leaf hashes.
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = LeafHash(Write{Key: fmt.Sprintf("key-%d", i), Value: []byte{byte(i)}})
+	}
+	return leaves
+}
+
+func TestAuditPathLeadsToTheMerkleRoot(t *testing.T) {
+	tests := []struct {
+		size int
+		// depths are the audit path lengths of the leaves, as in RFC 6962.
+		depths []int
+	}{
+		{size: 1, depths: []int{0}},
+		{size: 2, depths: []int{1, 1}},
+		{size: 3, depths: []int{2, 2, 1}},
+		{size: 4, depths: []int{2, 2, 2, 2}},
+		{size: 5, depths: []int{3, 3, 3, 3, 1}},
+		{size: 7, depths: []int{3, 3, 3, 3, 3, 3, 2}},
+		{size: 8, depths: []int{3, 3, 3, 3, 3, 3, 3, 3}},
+		{size: 9, depths: []int{4, 4, 4, 4, 4, 4, 4, 4, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d leaves", tt.size), func(t *testing.T) {
+			leaves := testLeaves(tt.size)
+			want := MerkleRoot(leaves)
+			for index := range leaves {
+				path := auditPath(leaves, index)
+				if len(path) != tt.depths[index] {
+					t.Fatalf("audit path of leaf %d has %d nodes, want %d", index, len(path), tt.depths[index])
+				}
+				root, err := rootFromPath(leaves[index], uint64(index), uint64(tt.size), path)
+				if err != nil {
+					t.Fatalf("rootFromPath of leaf %d: %v", index, err)
+				}
+				if !bytes.Equal(root, want) {
+					t.Fatalf("rootFromPath of leaf %d = %x, want %x", index, root, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRootFromPathRejectsWrongPaths(t *testing.T) {
+	leaves := testLeaves(5)
+	root := MerkleRoot(leaves)
+	path := auditPath(leaves, 1)
+
+	tests := []struct {
+		name  string
+		leaf  []byte
+		index uint64
+		size  uint64
+		path  [][]byte
+		// fails is set when rootFromPath must fail; otherwise the root must differ.
+		fails bool
+	}{
+		{name: "index out of the tree", leaf: leaves[1], index: 5, size: 5, path: path, fails: true},
+		{name: "path too short", leaf: leaves[1], index: 1, size: 5, path: path[:2], fails: true},
+		{name: "path too long", leaf: leaves[1], index: 1, size: 5, path: append(append([][]byte{}, path...), root), fails: true},
+		{name: "other leaf", leaf: leaves[2], index: 1, size: 5, path: path},
+		{name: "other index", leaf: leaves[1], index: 0, size: 5, path: path},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rootFromPath(tt.leaf, tt.index, tt.size, tt.path)
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("rootFromPath = %x, want an error", got)
+				}
+				return
+			}
+			if err == nil && bytes.Equal(got, root) {
+				t.Fatal("rootFromPath led to the merkle root")
+			}
+		})
+	}
+}
+
+// proofLedger returns a file ledger with three blocks: doc is written by the first one, and
+// other keys by the next two.
+func proofLedger(t *testing.T) *FileLedger {
+	t.Helper()
+	fl, err := NewFileLedger(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	t.Cleanup(func() { _ = fl.Close() })
+	writes := [][]Write{
+		{{Key: "a", Value: []byte("1")}, {Key: "doc", Value: []byte("signed")}, {Key: "b", Value: []byte("2")}},
+		{{Key: "c", Value: []byte("3")}},
+		{{Key: "d", Value: []byte("4")}, {Key: "e", Value: []byte("5")}},
+	}
+	for i, w := range writes {
+		if err := fl.Commit(fmt.Sprintf("tx%d", i+1), w); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+	return fl
+}
+
+func TestProofOfAnOlderBlockVerifiesAgainstTheHead(t *testing.T) {
+	fl := proofLedger(t)
+	report, err := fl.Verify("", ChainOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	proof, err := fl.Prove("doc", ProofOptions{})
+	if err != nil {
+		t.Fatalf("Prove: %v", err)
+	}
+	if proof.Block.Number != 0 || len(proof.Chain) != 2 {
+		t.Fatalf("proof of block %d with %d chained headers, want block 0 and 2", proof.Block.Number, len(proof.Chain))
+	}
+
+	encoded, err := proof.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := DecodeProof(encoded)
+	if err != nil {
+		t.Fatalf("DecodeProof: %v", err)
+	}
+	if err := VerifyProof(decoded, report.Head); err != nil {
+		t.Fatalf("VerifyProof against the head: %v", err)
+	}
+	if string(decoded.Value) != "signed" || decoded.Anchor().Hash != report.Head {
+		t.Fatalf("verified proof = %+v, want doc=signed anchored to %s", decoded, report.Head)
+	}
+}
+
+func TestVerifyProofRejects(t *testing.T) {
+	fl := proofLedger(t)
+	report, err := fl.Verify("", ChainOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	anchored, err := fl.Prove("doc", ProofOptions{})
+	if err != nil {
+		t.Fatalf("Prove: %v", err)
+	}
+	unchained, err := fl.Prove("doc", ProofOptions{Anchor: anchored.Block.Hash})
+	if err != nil {
+		t.Fatalf("Prove anchored to its block: %v", err)
+	}
+	if len(unchained.Chain) != 0 {
+		t.Fatalf("proof anchored to its block has %d chained headers", len(unchained.Chain))
+	}
+	if err := VerifyProof(&Proof{}, ""); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("VerifyProof of an empty proof = %v, want an invalid proof", err)
+	}
+	block := *unchained
+	if err := VerifyProof(&block, unchained.Block.Hash); err != nil {
+		t.Fatalf("VerifyProof against its block hash: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		proof  *Proof
+		root   string
+		tamper func(p *Proof)
+	}{
+		{name: "merkle root as root", proof: unchained, root: unchained.Block.MerkleRoot},
+		{name: "block hash of a chained proof", proof: anchored, root: anchored.Block.Hash},
+		{name: "other value", proof: anchored, root: report.Head, tamper: func(p *Proof) { p.Value = []byte("forged") }},
+		{name: "missing header", proof: anchored, root: report.Head, tamper: func(p *Proof) { p.Chain = p.Chain[1:] }},
+		{name: "forged header", proof: anchored, root: report.Head, tamper: func(p *Proof) { p.Chain[0].TxID = "forged" }},
+		{name: "claimed header hash", proof: anchored, root: report.Head, tamper: func(p *Proof) { p.Chain[1].Hash = strings.Repeat("0", 64) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proof := *tt.proof
+			proof.Chain = append([]ProofHeader(nil), tt.proof.Chain...)
+			if tt.tamper != nil {
+				tt.tamper(&proof)
+			}
+			if err := VerifyProof(&proof, tt.root); !errors.Is(err, ErrInvalidProof) {
+				t.Fatalf("VerifyProof = %v, want an invalid proof", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,555 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	gl "github.com/rafa-mori/smart_plane/logger"
+)
+
+const (
+	// snapshotsDirName is the directory of the snapshots inside the ledger directory.
+	snapshotsDirName = "snapshots"
+	// snapshotInfoName is the metadata file of a snapshot.
+	snapshotInfoName = "snapshot.json"
+	// snapshotIDLayout names the snapshots after their creation time, so they sort by age.
+	snapshotIDLayout = "20060102T150405.000000000Z"
+)
+
+// ErrCorruptedSnapshot is returned when a snapshot journal does not match its digest.
+var ErrCorruptedSnapshot = errors.New("corrupted ledger snapshot")
+
+// SnapshotInfo describes a snapshot of a file ledger: a copy of the journal as it was at
+// CreatedAt.
+type SnapshotInfo struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Size is the journal size copied into the snapshot.
+	Size   int64 `json:"size"`
+	Blocks int   `json:"blocks"`
+	// LastTxID, LastTimestamp and LastHash identify the last block of the snapshot.
+	LastTxID      string    `json:"lastTxId,omitempty"`
+	LastTimestamp time.Time `json:"lastTimestamp,omitempty"`
+	LastHash      string    `json:"lastHash,omitempty"`
+	// SHA256 is the hex digest of the snapshot journal.
+	SHA256 string `json:"sha256"`
+	// Reason tells why the snapshot was taken, such as "manual" or "pre-restore".
+	Reason string `json:"reason,omitempty"`
+}
+
+// SnapshotRetention bounds the snapshots kept by a file ledger. The newest snapshot is always
+// kept; zero values disable a bound.
+type SnapshotRetention struct {
+	// Keep is the number of snapshots kept.
+	Keep int `json:"keep"`
+	// MaxAge removes the snapshots older than it.
+	MaxAge time.Duration `json:"maxAge"`
+}
+
+// SetSnapshotRetention sets the retention applied after every snapshot.
+func (fl *FileLedger) SetSnapshotRetention(retention SnapshotRetention) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	fl.retention = retention
+}
+
+// Snapshot copies the committed journal into a new snapshot, while other processes keep
+// reading; commits wait for the copy. The retention policy is applied afterwards.
+func (fl *FileLedger) Snapshot(reason string) (SnapshotInfo, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.journal == nil {
+		return SnapshotInfo{}, fmt.Errorf("ledger is closed")
+	}
+	if err := fl.lock.MuRLockCtx(context.Background()); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to lock ledger: %w", err)
+	}
+	info, err := fl.snapshot(reason)
+	fl.lock.MuRUnlock()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	if _, err := fl.pruneSnapshots(fl.retention); err != nil {
+		gl.Log("warn", fmt.Sprintf("Failed to prune snapshots of %s: %v", fl.dir, err))
+	}
+	return info, nil
+}
+
+// snapshot copies the journal up to the applied offset. The caller must hold fl.mu and the
+// file lock.
+func (fl *FileLedger) snapshot(reason string) (SnapshotInfo, error) {
+	if err := fl.catchUp(); err != nil {
+		return SnapshotInfo{}, err
+	}
+	data, err := fl.readJournal()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	blocks, err := readBlocks(bytes.NewReader(data))
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	now := time.Now().UTC()
+	sum := sha256.Sum256(data)
+	info := SnapshotInfo{
+		ID:        now.Format(snapshotIDLayout),
+		CreatedAt: now,
+		Size:      int64(len(data)),
+		Blocks:    len(blocks),
+		SHA256:    hex.EncodeToString(sum[:]),
+		Reason:    reason,
+	}
+	if len(blocks) > 0 {
+		last := blocks[len(blocks)-1]
+		info.LastTxID, info.LastTimestamp, info.LastHash = last.TxID, last.Timestamp, last.Hash
+	}
+
+	// The snapshot is written aside and renamed into place, so a listed snapshot is complete.
+	dir := filepath.Join(fl.dir, snapshotsDirName)
+	tmp, err := os.MkdirTemp(dir, "."+info.ID+".*")
+	if os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, 0o750); err == nil {
+			tmp, err = os.MkdirTemp(dir, "."+info.ID+".*")
+		}
+	}
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	meta, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := writeSynced(filepath.Join(tmp, journalFileName), data); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := writeSynced(filepath.Join(tmp, snapshotInfoName), meta); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, info.ID)); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	gl.Log("info", fmt.Sprintf("Ledger %s: snapshot %s of %d blocks", fl.dir, info.ID, info.Blocks))
+	return info, nil
+}
+
+// Snapshots returns the snapshots of the ledger, newest first.
+func (fl *FileLedger) Snapshots() ([]SnapshotInfo, error) {
+	dir := filepath.Join(fl.dir, snapshotsDirName)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	snapshots := make([]SnapshotInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name()[0] == '.' {
+			continue
+		}
+		info, err := readSnapshotInfo(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			gl.Log("warn", fmt.Sprintf("Ignoring snapshot %s: %v", entry.Name(), err))
+			continue
+		}
+		snapshots = append(snapshots, info)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// PruneSnapshots removes the snapshots outside of a retention, returning the removed ones.
+func (fl *FileLedger) PruneSnapshots(retention SnapshotRetention) ([]SnapshotInfo, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	return fl.pruneSnapshots(retention)
+}
+
+// pruneSnapshots removes the snapshots outside of a retention. The caller must hold fl.mu.
+func (fl *FileLedger) pruneSnapshots(retention SnapshotRetention) ([]SnapshotInfo, error) {
+	if retention.Keep <= 0 && retention.MaxAge <= 0 {
+		return nil, nil
+	}
+	snapshots, err := fl.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var removed []SnapshotInfo
+	for i, info := range snapshots {
+		if i == 0 {
+			continue
+		}
+		tooMany := retention.Keep > 0 && i >= retention.Keep
+		tooOld := retention.MaxAge > 0 && now.Sub(info.CreatedAt) > retention.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(fl.dir, snapshotsDirName, info.ID)); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot %s: %w", info.ID, err)
+		}
+		removed = append(removed, info)
+	}
+	return removed, nil
+}
+
+// RestoreMode selects how a restore brings the state back.
+type RestoreMode string
+
+const (
+	// RestoreRevert appends a transaction writing back the state as of the target, keeping
+	// the whole history. It is the default.
+	RestoreRevert RestoreMode = "revert"
+	// RestoreTruncate rewrites the journal up to the target, dropping the later blocks. A
+	// pre-restore snapshot of the journal is taken first.
+	RestoreTruncate RestoreMode = "truncate"
+)
+
+// RestoreOptions select the point in time of a restore.
+type RestoreOptions struct {
+	// TxID restores the state right after the transaction.
+	TxID string
+	// Time restores the state as of a time, up to the first block committed after it.
+	Time time.Time
+	// Snapshot reads the history from a snapshot instead of the journal. Without TxID and
+	// Time, the state of the whole snapshot is restored.
+	Snapshot string
+	Mode     RestoreMode
+	// DryRun reports the restore without writing anything.
+	DryRun bool
+}
+
+// RestoreReport is the result of a restore.
+type RestoreReport struct {
+	Mode   RestoreMode `json:"mode"`
+	DryRun bool        `json:"dryRun"`
+	// TargetTxID and TargetTime identify the last block kept.
+	TargetTxID string    `json:"targetTxId"`
+	TargetTime time.Time `json:"targetTime"`
+	// Keys lists the keys whose state changes.
+	Keys []string `json:"keys"`
+	// Dropped counts the blocks removed from the journal in truncate mode.
+	Dropped int `json:"dropped,omitempty"`
+	// TxID is the compensating transaction of revert mode.
+	TxID string `json:"txId,omitempty"`
+	// Snapshot is the pre-restore snapshot of truncate mode.
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// Restore brings the ledger back to its state as of a transaction or a time, replaying the
+// history of the journal or of a snapshot. It holds the exclusive lock, so other processes
+// neither read nor commit meanwhile, and they replay the journal afterwards.
+func (fl *FileLedger) Restore(opts RestoreOptions) (*RestoreReport, error) {
+	switch opts.Mode {
+	case "":
+		opts.Mode = RestoreRevert
+	case RestoreRevert, RestoreTruncate:
+	default:
+		return nil, fmt.Errorf("unknown restore mode %q (revert, truncate)", opts.Mode)
+	}
+	if opts.TxID == "" && opts.Time.IsZero() && opts.Snapshot == "" {
+		return nil, fmt.Errorf("a transaction ID, a time or a snapshot is required")
+	}
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.journal == nil {
+		return nil, fmt.Errorf("ledger is closed")
+	}
+	if err := fl.lock.MuLockCtx(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to lock ledger: %w", err)
+	}
+	defer fl.lock.MuUnlock()
+
+	if err := fl.catchUp(); err != nil {
+		return nil, err
+	}
+	current, err := fl.readJournal()
+	if err != nil {
+		return nil, err
+	}
+	source := current
+	if opts.Snapshot != "" {
+		if source, err = readSnapshot(fl.dir, opts.Snapshot); err != nil {
+			return nil, err
+		}
+	}
+	blocks, err := readBlocks(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+
+	cut, err := restoreCut(blocks, opts)
+	if err != nil {
+		return nil, err
+	}
+	report := &RestoreReport{Mode: opts.Mode, DryRun: opts.DryRun}
+	if cut > 0 {
+		report.TargetTxID, report.TargetTime = blocks[cut-1].TxID, blocks[cut-1].Timestamp
+	}
+
+	target := newMemoryLedger()
+	for _, block := range blocks[:cut] {
+		if err := target.apply(block); err != nil {
+			return nil, err
+		}
+	}
+	writes := fl.stateDiff(target)
+	for _, w := range writes {
+		report.Keys = append(report.Keys, w.Key)
+	}
+
+	switch opts.Mode {
+	case RestoreRevert:
+		if len(writes) == 0 || opts.DryRun {
+			return report, nil
+		}
+		block, err := newBlock(fmt.Sprintf("restore-%d", time.Now().UnixNano()), writes)
+		if err != nil {
+			return nil, err
+		}
+		block = fl.MemoryLedger.seal(block)
+		report.TxID = block.TxID
+		line, err := json.Marshal(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize block: %w", err)
+		}
+		if err := fl.appendLines(append(line, '\n')); err != nil {
+			return nil, err
+		}
+		if err := fl.MemoryLedger.apply(block); err != nil {
+			return nil, err
+		}
+
+	case RestoreTruncate:
+		// Truncate mode only ever cuts the journal, so the blocks kept stay chained as they
+		// were committed.
+		prefix := source[:journalPrefix(source, cut)]
+		if !bytes.HasPrefix(current, prefix) {
+			return nil, fmt.Errorf("snapshot %s diverged from the journal, restore it in revert mode", opts.Snapshot)
+		}
+		dropped, err := readBlocks(bytes.NewReader(current[len(prefix):]))
+		if err != nil {
+			return nil, err
+		}
+		report.Dropped = len(dropped)
+		if report.Dropped == 0 || opts.DryRun {
+			return report, nil
+		}
+		snapshot, err := fl.snapshot("pre-restore")
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot before restore: %w", err)
+		}
+		report.Snapshot = snapshot.ID
+
+		if err := fl.replaceJournal(prefix); err != nil {
+			return nil, err
+		}
+		if err := fl.catchUp(); err != nil {
+			return nil, err
+		}
+	}
+	gl.Log("warn", fmt.Sprintf("Ledger %s restored (%s) to transaction %s: %d keys changed", fl.dir, opts.Mode, report.TargetTxID, len(report.Keys)))
+	return report, nil
+}
+
+// replaceJournal writes a new journal aside and renames it over the journal, so that every
+// process notices the replacement by the file identity, including this one on its next
+// catch up. The caller must hold fl.mu and the exclusive file lock.
+func (fl *FileLedger) replaceJournal(data []byte) error {
+	tmp, err := os.CreateTemp(fl.dir, "."+journalFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create ledger journal: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	err = tmp.Chmod(0o640)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create ledger journal: %w", err)
+	}
+
+	if err := writeSynced(tmp.Name(), data); err != nil {
+		return fmt.Errorf("failed to write ledger journal: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), fl.journalPath()); err != nil {
+		return fmt.Errorf("failed to replace ledger journal: %w", err)
+	}
+	return nil
+}
+
+// restoreCut returns how many blocks are kept for the target of a restore.
+func restoreCut(blocks []Block, opts RestoreOptions) (int, error) {
+	switch {
+	case opts.TxID != "":
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if blocks[i].TxID == opts.TxID {
+				if !opts.Time.IsZero() && blocks[i].Timestamp.After(opts.Time) {
+					return 0, fmt.Errorf("transaction %s was committed after %s", opts.TxID, opts.Time.Format(time.RFC3339))
+				}
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("transaction %s %w in the ledger history", opts.TxID, ErrNotFound)
+	case !opts.Time.IsZero():
+		// Imported blocks keep their original timestamps, so the history is cut at the first
+		// block committed after the time rather than searched.
+		for i, block := range blocks {
+			if block.Timestamp.After(opts.Time) {
+				return i, nil
+			}
+		}
+		return len(blocks), nil
+	default:
+		return len(blocks), nil
+	}
+}
+
+// stateDiff returns the writes turning the current state, system keys aside, into the state
+// of target. The caller must hold fl.mu.
+func (fl *FileLedger) stateDiff(target *MemoryLedger) []Write {
+	fl.MemoryLedger.mu.RLock()
+	defer fl.MemoryLedger.mu.RUnlock()
+
+	var writes []Write
+	for key, value := range target.state {
+		if IsSystemKey(key) {
+			continue
+		}
+		if current, exists := fl.MemoryLedger.state[key]; !exists || !bytes.Equal(current, value) {
+			writes = append(writes, Write{Key: key, Value: append([]byte(nil), value...)})
+		}
+	}
+	for key := range fl.MemoryLedger.state {
+		if IsSystemKey(key) {
+			continue
+		}
+		if _, exists := target.state[key]; !exists {
+			writes = append(writes, Write{Key: key, IsDelete: true})
+		}
+	}
+	sort.Slice(writes, func(i, j int) bool { return writes[i].Key < writes[j].Key })
+	return writes
+}
+
+// readJournal returns the journal up to the applied offset. The caller must hold fl.mu and
+// the file lock.
+func (fl *FileLedger) readJournal() ([]byte, error) {
+	data := make([]byte, fl.offset)
+	if _, err := fl.journal.ReadAt(data, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read ledger journal: %w", err)
+	}
+	return data, nil
+}
+
+// journalPrefix returns the length of the first n blocks of a journal.
+func journalPrefix(data []byte, n int) int {
+	end := 0
+	for n > 0 {
+		i := bytes.IndexByte(data[end:], '\n')
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			n--
+		}
+		end += i + 1
+	}
+	return end
+}
+
+// readSnapshot returns the journal of a snapshot, after checking its digest.
+func readSnapshot(ledgerDir, id string) ([]byte, error) {
+	dir := filepath.Join(ledgerDir, snapshotsDirName, filepath.Base(id))
+	info, err := readSnapshotInfo(dir)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("snapshot %s %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, journalFileName))
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != info.SHA256 {
+		return nil, fmt.Errorf("%w %s: its journal does not match its sha256", ErrCorruptedSnapshot, id)
+	}
+	return data, nil
+}
+
+// readSnapshotInfo reads the metadata of a snapshot directory.
+func readSnapshotInfo(dir string) (SnapshotInfo, error) {
+	var info SnapshotInfo
+	data, err := os.ReadFile(filepath.Join(dir, snapshotInfoName))
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("invalid %s: %w", snapshotInfoName, err)
+	}
+	return info, nil
+}
+
+// readBlocks parses the complete lines of a journal.
+func readBlocks(r io.Reader) ([]Block, error) {
+	var blocks []Block
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read journal: %w", err)
+		}
+		if len(line) <= 1 {
+			continue
+		}
+		var block Block
+		if err := json.Unmarshal(line, &block); err != nil {
+			return nil, fmt.Errorf("corrupted journal at line %d: %w", n, err)
+		}
+		blocks = append(blocks, block)
+	}
+}
+
+// writeSynced writes a file and syncs it to disk.
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,164 @@
+package ledger
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// commitValues commits one block per value, writing key with the transaction ID tx<n>.
+func commitValues(t *testing.T, ledger ILedger, key string, values ...string) {
+	t.Helper()
+	for i, value := range values {
+		txID := fmt.Sprintf("tx%d", i+1)
+		if err := ledger.Commit(txID, []Write{{Key: key, Value: []byte(value)}}); err != nil {
+			t.Fatalf("Commit %s: %v", txID, err)
+		}
+	}
+}
+
+func requireState(t *testing.T, ledger ILedger, key, want string) {
+	t.Helper()
+	value, err := ledger.GetState(key)
+	if err != nil {
+		t.Fatalf("GetState %s: %v", key, err)
+	}
+	if string(value) != want {
+		t.Fatalf("GetState %s = %q, want %q", key, value, want)
+	}
+}
+
+func requireChain(t *testing.T, fl *FileLedger, blocks int) {
+	t.Helper()
+	report, err := fl.Verify("", ChainOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if report.Blocks != blocks {
+		t.Fatalf("Verify found %d blocks, want %d", report.Blocks, blocks)
+	}
+}
+
+func TestRestoreRevertKeepsTheHistory(t *testing.T) {
+	fl, err := NewFileLedger(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	defer func() { _ = fl.Close() }()
+	commitValues(t, fl, "doc", "v1", "v2", "v3")
+
+	report, err := fl.Restore(RestoreOptions{TxID: "tx1"})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if report.TargetTxID != "tx1" || report.TxID == "" || len(report.Keys) != 1 {
+		t.Fatalf("Restore report = %+v, want one key reverted to tx1", report)
+	}
+	requireState(t, fl, "doc", "v1")
+	requireChain(t, fl, 4)
+
+	reopened, err := NewFileLedger(fl.Dir())
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	requireState(t, reopened, "doc", "v1")
+	if history, _ := reopened.GetHistory("doc"); len(history) != 4 {
+		t.Fatalf("history has %d records after revert, want 4", len(history))
+	}
+}
+
+func TestRestoreTruncateIsSeenByOtherLedgers(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := NewFileLedger(dir)
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	defer func() { _ = writer.Close() }()
+	reader, err := NewFileLedger(dir)
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	commitValues(t, writer, "doc", "v1", "v2", "v3")
+	requireState(t, reader, "doc", "v3")
+
+	report, err := writer.Restore(RestoreOptions{TxID: "tx1", Mode: RestoreTruncate})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if report.Dropped != 2 || report.Snapshot == "" {
+		t.Fatalf("Restore report = %+v, want 2 blocks dropped after a snapshot", report)
+	}
+	requireState(t, writer, "doc", "v1")
+	requireState(t, reader, "doc", "v1")
+
+	// The stale ledger commits after the restored head, not after the dropped blocks.
+	if err := reader.Commit("tx4", []Write{{Key: "doc", Value: []byte("v4")}}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	requireState(t, writer, "doc", "v4")
+	requireChain(t, writer, 2)
+	requireChain(t, reader, 2)
+}
+
+func TestRestoreTruncateIsSeenAtTheSameJournalSize(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := NewFileLedger(dir)
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	defer func() { _ = writer.Close() }()
+	reader, err := NewFileLedger(dir)
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	at := time.Date(2026, 10, 18, 22, 0, 0, 0, time.UTC)
+	if err := writer.CommitBlock(Block{TxID: "tx1", Timestamp: at, Writes: []Write{{Key: "doc", Value: []byte("v1")}}}); err != nil {
+		t.Fatalf("CommitBlock: %v", err)
+	}
+	if err := writer.CommitBlock(Block{TxID: "tx2", Timestamp: at, Writes: []Write{{Key: "doc", Value: []byte("v2")}}}); err != nil {
+		t.Fatalf("CommitBlock: %v", err)
+	}
+	requireState(t, reader, "doc", "v2")
+
+	if _, err := writer.Restore(RestoreOptions{TxID: "tx1", Mode: RestoreTruncate}); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	// Same transaction, timestamp and value length: the new journal has the old size.
+	if err := writer.CommitBlock(Block{TxID: "tx2", Timestamp: at, Writes: []Write{{Key: "doc", Value: []byte("w2")}}}); err != nil {
+		t.Fatalf("CommitBlock: %v", err)
+	}
+	requireState(t, reader, "doc", "w2")
+	requireChain(t, reader, 2)
+}
+
+func TestRestoreTruncateFromSnapshot(t *testing.T) {
+	fl, err := NewFileLedger(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileLedger: %v", err)
+	}
+	defer func() { _ = fl.Close() }()
+	commitValues(t, fl, "doc", "v1", "v2")
+	snapshot, err := fl.Snapshot("test")
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if err := fl.Commit("tx3", []Write{{Key: "other", Value: []byte("x")}}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	report, err := fl.Restore(RestoreOptions{Snapshot: snapshot.ID, Mode: RestoreTruncate})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if report.Dropped != 1 {
+		t.Fatalf("Restore dropped %d blocks, want 1", report.Dropped)
+	}
+	requireState(t, fl, "doc", "v2")
+	requireState(t, fl, "other", "")
+	requireChain(t, fl, 2)
+}
@@ -0,0 +1,251 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ChannelID is the channel name reported by in-process stubs.
+const ChannelID = "smart_plane"
+
+// Stub is an in-process implementation of the chaincode stub backed by an ILedger.
+//
+// Writes are staged in the stub and only reach the ledger on Commit, all at once.
+// Unlike Fabric, reads see the writes already staged in the same transaction, so
+// several contract calls can build on each other inside one transaction.
+// Methods not implemented here panic through the embedded nil interface; Tx turns those
+// panics into errors.
+type Stub struct {
+	shim.ChaincodeStubInterface
+
+	mu sync.RWMutex
+	// ledger is the backend the transaction commits to.
+	ledger ILedger
+	// txID is the transaction identifier.
+	txID string
+	// timestamp is the transaction creation time.
+	timestamp time.Time
+	// writes holds the staged writes, by key.
+	writes map[string]Write
+	// order keeps the order in which keys were first written.
+	order []string
+	// closed is set once the transaction is committed or rolled back.
+	closed bool
+}
+
+// NewStub creates a new stub for a transaction against the given ledger.
+func NewStub(ledger ILedger, txID string) *Stub {
+	return &Stub{
+		ledger:    ledger,
+		txID:      txID,
+		timestamp: time.Now().UTC(),
+		writes:    make(map[string]Write),
+		order:     make([]string, 0),
+	}
+}
+
+// NewTransactionContext wraps a stub in a contract transaction context.
+func NewTransactionContext(stub *Stub) contractapi.TransactionContextInterface {
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(stub)
+	return ctx
+}
+
+// GetTxID returns the transaction identifier.
+func (s *Stub) GetTxID() string { return s.txID }
+
+// GetChannelID returns the in-process channel name.
+func (s *Stub) GetChannelID() string { return ChannelID }
+
+// GetTxTimestamp returns the transaction creation time.
+func (s *Stub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(s.timestamp), nil
+}
+
+// GetState returns the staged value of a key, falling back to the committed ledger state.
+func (s *Stub) GetState(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, fmt.Errorf("transaction %s is closed", s.txID)
+	}
+	if w, ok := s.writes[key]; ok {
+		if w.IsDelete {
+			return nil, nil
+		}
+		return append([]byte(nil), w.Value...), nil
+	}
+	return s.ledger.GetState(key)
+}
+
+// PutState stages a write of the key.
+func (s *Stub) PutState(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	return s.stage(Write{Key: key, Value: append([]byte(nil), value...)})
+}
+
+// DelState stages a deletion of the key.
+func (s *Stub) DelState(key string) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	return s.stage(Write{Key: key, IsDelete: true})
+}
+
+// GetHistoryForKey returns the committed history of the key.
+func (s *Stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	history, err := s.ledger.GetHistory(key)
+	if err != nil {
+		return nil, err
+	}
+	return &historyIterator{records: history}, nil
+}
+
+// GetStateByRange returns the keys in [startKey, endKey), including staged writes.
+// Empty bounds are open. System keys are never returned.
+func (s *Stub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	keys, err := s.ledger.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		seen[key] = true
+	}
+	for _, key := range s.order {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	kvs := make([]*queryresult.KV, 0, len(keys))
+	for _, key := range keys {
+		if IsSystemKey(key) || (startKey != "" && key < startKey) || (endKey != "" && key >= endKey) {
+			continue
+		}
+		var value []byte
+		if w, ok := s.writes[key]; ok {
+			if w.IsDelete {
+				continue
+			}
+			value = w.Value
+		} else if value, err = s.ledger.GetState(key); err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, &queryresult.KV{Namespace: ChannelID, Key: key, Value: value})
+	}
+	return &stateIterator{kvs: kvs}, nil
+}
+
+// Writes returns the staged writes in the order the keys were first written.
+func (s *Stub) Writes() []Write {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	writes := make([]Write, 0, len(s.order))
+	for _, key := range s.order {
+		writes = append(writes, s.writes[key])
+	}
+	return writes
+}
+
+// Commit sends all staged writes to the ledger as a single transaction.
+// A transaction without writes commits trivially.
+func (s *Stub) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("transaction %s is closed", s.txID)
+	}
+	s.closed = true
+
+	if len(s.order) == 0 {
+		return nil
+	}
+	writes := make([]Write, 0, len(s.order))
+	for _, key := range s.order {
+		writes = append(writes, s.writes[key])
+	}
+	return s.ledger.Commit(s.txID, writes)
+}
+
+// Rollback discards all staged writes.
+func (s *Stub) Rollback() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.writes = make(map[string]Write)
+	s.order = s.order[:0]
+}
+
+// stage records a write, keeping only the latest write per key.
+func (s *Stub) stage(w Write) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("transaction %s is closed", s.txID)
+	}
+	if _, ok := s.writes[w.Key]; !ok {
+		s.order = append(s.order, w.Key)
+	}
+	s.writes[w.Key] = w
+	return nil
+}
+
+// historyIterator iterates over the history records of a key.
+type historyIterator struct {
+	records []Record
+	pos     int
+}
+
+func (it *historyIterator) HasNext() bool { return it.pos < len(it.records) }
+func (it *historyIterator) Close() error  { return nil }
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more history records")
+	}
+	r := it.records[it.pos]
+	it.pos++
+	return &queryresult.KeyModification{
+		TxId:      r.TxID,
+		Value:     r.Value,
+		Timestamp: timestamppb.New(r.Timestamp),
+		IsDelete:  r.IsDelete,
+	}, nil
+}
+
+// stateIterator iterates over a snapshot of key/value pairs.
+type stateIterator struct {
+	kvs []*queryresult.KV
+	pos int
+}
+
+func (it *stateIterator) HasNext() bool { return it.pos < len(it.kvs) }
+func (it *stateIterator) Close() error  { return nil }
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more state records")
+	}
+	kv := it.kvs[it.pos]
+	it.pos++
+	return kv, nil
+}
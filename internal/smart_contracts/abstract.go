@@ -1,7 +1,6 @@
 package smart_contracts
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
@@ -19,9 +18,9 @@ func (bc *BaseContract[T]) Put(ctx contractapi.TransactionContextInterface, id s
 	}
 	if stateJSON != nil {
 		// If the state already exists, return true and an error
-		return true, fmt.Errorf("data already registered")
+		return true, &DocumentConflictError{ContractName: bc.GetName(), ID: id, Err: ErrDocumentExists}
 	}
-	txJSON, err := json.Marshal(data)
+	txJSON, err := encodeState(bc.GetName(), data)
 	if err != nil {
 		return false, fmt.Errorf("erro ao serializar dados: %v", err)
 	}
@@ -38,14 +37,10 @@ func (bc *BaseContract[T]) Get(ctx contractapi.TransactionContextInterface, id s
 		return zero, fmt.Errorf("erro ao obter item %s: %v", id, err)
 	} else if txJSON == nil {
 		var zero T
-		return zero, fmt.Errorf("item %s não encontrado", id)
+		return zero, &DocumentNotFoundError{ContractName: bc.GetName(), ID: id}
 	} else {
-		var data T
-		err = json.Unmarshal(txJSON, &data)
-		if err != nil {
-			return data, err
-		}
-		return data, nil
+		// Older records are migrated in memory; the migrate command persists them.
+		return decodeState[T](txJSON)
 	}
 }
 
@@ -56,7 +51,7 @@ func (bc *BaseContract[T]) Delete(ctx contractapi.TransactionContextInterface, i
 	if !bc.Exists(ctx, id) {
 		// If the item does not exist, return true and an error
 		// True because the data does not exist, so no deletion is needed
-		return true, fmt.Errorf("item %s não encontrado", id)
+		return true, &DocumentNotFoundError{ContractName: bc.GetName(), ID: id}
 	}
 	if err := ctx.GetStub().DelState(id); err != nil {
 		// If there was an error during deletion, return false and the error
@@ -94,8 +89,11 @@ func (bc *BaseContract[T]) History(ctx contractapi.TransactionContextInterface,
 		if err != nil {
 			return nil, fmt.Errorf("erro ao iterar no histórico: %v", err)
 		}
-		var data T
-		if err = json.Unmarshal(queryResponse.Value, &data); err != nil {
+		if queryResponse.IsDelete {
+			continue
+		}
+		data, err := decodeState[T](queryResponse.Value)
+		if err != nil {
 			return nil, fmt.Errorf("erro ao deserializar histórico: %v", err)
 		}
 		history = append(history, data)
@@ -103,15 +101,11 @@ func (bc *BaseContract[T]) History(ctx contractapi.TransactionContextInterface,
 	return history, nil
 }
 
+// readState returns the stored value of a key, or nil when the key does not exist.
 func readState(ctx contractapi.TransactionContextInterface, id string) ([]byte, error) {
 	assetJSON, err := ctx.GetStub().GetState(id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %w", err)
 	}
-
-	if assetJSON == nil {
-		return nil, fmt.Errorf("the asset %s does not exist", id)
-	}
-
 	return assetJSON, nil
 }
@@ -0,0 +1,113 @@
+package smart_contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	ci "github.com/rafa-mori/smart_plane/internal/interfaces"
+)
+
+// RegisterContractAPI attaches a request validator (usually a *BaseContractInfoAPI[T]) to a
+// contract of the manager. Every method called on that contract through the manager is
+// checked by the validator before dispatch.
+func (bm *BlockchainManager) RegisterContractAPI(contractName string, validator ci.IRequestValidator) error {
+	if validator == nil {
+		return fmt.Errorf("request validator cannot be nil")
+	}
+
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if _, exists := bm.contracts[contractName]; !exists {
+		return &ContractNotFoundError{ContractName: contractName}
+	}
+	if bm.requests == nil {
+		bm.requests = make(map[string]ci.IRequestValidator)
+	}
+	bm.requests[contractName] = validator
+	return nil
+}
+
+// validateRequest runs the request validator of the contract, if any, for a method call.
+func (bm *BlockchainManager) validateRequest(contractName, method string, args ...string) error {
+	bm.mu.RLock()
+	validator, ok := bm.requests[contractName]
+	bm.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return validator.ValidateRequest(method, requestPayload(args...))
+}
+
+// RequestValidationHook returns a BeforeTransaction hook for chaincode contracts that runs the
+// request validator registered for the invoked method. The contracts of a BlockchainManager
+// get it installed when the manager is created.
+func RequestValidationHook(validator ci.IRequestValidator) func(ctx contractapi.TransactionContextInterface) error {
+	return func(ctx contractapi.TransactionContextInterface) error {
+		if validator == nil {
+			return nil
+		}
+		function, params := ctx.GetStub().GetFunctionAndParameters()
+		if idx := strings.LastIndex(function, ":"); idx >= 0 {
+			function = function[idx+1:]
+		}
+		return validator.ValidateRequest(function, requestPayload(params...))
+	}
+}
+
+// requestHook returns the BeforeTransaction hook of a contract: it runs the request validator
+// registered for the contract at the time of the call, so validators attached later with
+// RegisterContractAPI also guard the chaincode entry points.
+func (bm *BlockchainManager) requestHook(contractName string) func(ctx contractapi.TransactionContextInterface) error {
+	return func(ctx contractapi.TransactionContextInterface) error {
+		bm.mu.RLock()
+		validator := bm.requests[contractName]
+		bm.mu.RUnlock()
+		return RequestValidationHook(validator)(ctx)
+	}
+}
+
+// installRequestHook sets the BeforeTransaction hook of a contract embedding
+// contractapi.Contract, keeping a hook the contract already had after the validation.
+func installRequestHook(contract contractapi.ContractInterface, hook func(ctx contractapi.TransactionContextInterface) error) bool {
+	v := reflect.ValueOf(contract)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	field := v.Elem().FieldByName("BeforeTransaction")
+	if !field.IsValid() || !field.CanSet() || field.Kind() != reflect.Interface {
+		return false
+	}
+	if previous, ok := field.Interface().(func(ctx contractapi.TransactionContextInterface) error); ok && previous != nil {
+		validate := hook
+		hook = func(ctx contractapi.TransactionContextInterface) error {
+			if err := validate(ctx); err != nil {
+				return err
+			}
+			return previous(ctx)
+		}
+	}
+	field.Set(reflect.ValueOf(hook))
+	return true
+}
+
+// requestPayload builds the payload given to request validators from the call arguments:
+// the last argument when it is a JSON object (the document content), or otherwise
+// {"id": <first argument>, "args": [<all arguments>]}.
+func requestPayload(args ...string) []byte {
+	if len(args) == 0 {
+		return nil
+	}
+	last := strings.TrimSpace(args[len(args)-1])
+	if strings.HasPrefix(last, "{") && json.Valid([]byte(last)) {
+		return []byte(last)
+	}
+	payload, err := json.Marshal(map[string]any{"id": args[0], "args": args})
+	if err != nil {
+		return nil
+	}
+	return payload
+}
@@ -0,0 +1,52 @@
+package smart_contracts
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTransactionFinished is returned by the operations of a committed or rolled back Tx.
+var ErrTransactionFinished = errors.New("already finished")
+
+// ErrDocumentExists is the cause of the DocumentConflictError returned when a document to
+// register is already in the state.
+var ErrDocumentExists = errors.New("document already exists")
+
+// ContractNotFoundError is returned when a contract name is not known by the manager.
+type ContractNotFoundError struct {
+	ContractName string
+}
+
+func (e *ContractNotFoundError) Error() string {
+	return fmt.Sprintf("contrato %s não encontrado", e.ContractName)
+}
+
+// DocumentNotFoundError is returned when a document is not in the state of a contract.
+type DocumentNotFoundError struct {
+	ContractName string
+	ID           string
+}
+
+func (e *DocumentNotFoundError) Error() string {
+	if e.ContractName == "" {
+		return fmt.Sprintf("documento %s não encontrado", e.ID)
+	}
+	return fmt.Sprintf("documento %s não encontrado no contrato %s", e.ID, e.ContractName)
+}
+
+// DocumentConflictError is returned when a request conflicts with the state of a document.
+type DocumentConflictError struct {
+	ContractName string
+	ID           string
+	// Err is the cause of the conflict, such as ErrDocumentExists.
+	Err error
+}
+
+func (e *DocumentConflictError) Error() string {
+	if e.Err != nil && !errors.Is(e.Err, ErrDocumentExists) {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("documento %s já registrado", e.ID)
+}
+
+func (e *DocumentConflictError) Unwrap() error { return e.Err }
@@ -0,0 +1,131 @@
+package smart_contracts
+
+import (
+	"fmt"
+	"time"
+
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	t "github.com/rafa-mori/smart_plane/types"
+)
+
+const (
+	DocumentActionRegistered = "registered"
+	DocumentActionApproved   = "approved"
+	DocumentActionSigned     = "signed"
+	DocumentActionDeleted    = "deleted"
+)
+
+// LedgerEvent is a document change committed by a transaction of the BlockchainManager.
+type LedgerEvent struct {
+	Name       string    `json:"event"`
+	Action     string    `json:"action"`
+	Contract   string    `json:"contract"`
+	DocumentID string    `json:"documentId"`
+	TxID       string    `json:"txId"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// DocumentEventName returns the event name of a document action: `document.<id>.<action>`.
+// The ID is escaped with t.EscapeEventSegment, so it always fills exactly one segment.
+func DocumentEventName(documentID, action string) string {
+	return fmt.Sprintf("document.%s.%s", t.EscapeEventSegment(documentID), action)
+}
+
+// Metadata returns the event as result metadata.
+func (e LedgerEvent) Metadata() map[string]any {
+	return map[string]any{
+		"event":      e.Name,
+		"action":     e.Action,
+		"contract":   e.Contract,
+		"documentId": e.DocumentID,
+		"txId":       e.TxID,
+		"timestamp":  e.Timestamp.Format(time.RFC3339Nano),
+	}
+}
+
+// SetEventListener sets the listener that receives the ledger events of every committed
+// transaction. Events are triggered by name, so pattern subscriptions such as
+// `document.*.approved` work.
+func (bm *BlockchainManager) SetEventListener(listener *t.ValidationListener) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	bm.events = listener
+}
+
+// GetEventListener returns the ledger event listener of the manager.
+func (bm *BlockchainManager) GetEventListener() *t.ValidationListener {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	return bm.events
+}
+
+// SetOutbox enables or disables the ledger outbox. When enabled, every committed transaction
+// also enqueues its ledger events in the outbox, in the same ledger commit, so a broker relay
+// can publish them at least once even across restarts.
+func (bm *BlockchainManager) SetOutbox(enabled bool) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	bm.outbox = enabled
+}
+
+// IsOutboxEnabled reports whether ledger events are enqueued in the outbox.
+func (bm *BlockchainManager) IsOutboxEnabled() bool {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	return bm.outbox
+}
+
+// OutboxMessage converts the event into an outbox message routed by event name and keyed by
+// document ID.
+func (e LedgerEvent) OutboxMessage() (lg.OutboxMessage, error) {
+	message, err := lg.NewOutboxMessage(e.Name, e.DocumentID, e)
+	if err != nil {
+		return message, err
+	}
+	message.Headers = map[string]string{
+		"action":   e.Action,
+		"contract": e.Contract,
+		"txId":     e.TxID,
+	}
+	return message, nil
+}
+
+// stageOutbox stages the outbox messages of a transaction's events on its stub.
+func (bm *BlockchainManager) stageOutbox(stub *lg.Stub, events []LedgerEvent) error {
+	if !bm.IsOutboxEnabled() {
+		return nil
+	}
+	for _, event := range events {
+		message, err := event.OutboxMessage()
+		if err != nil {
+			return err
+		}
+		write, err := message.Write()
+		if err != nil {
+			return err
+		}
+		if err := stub.PutState(write.Key, write.Value); err != nil {
+			return fmt.Errorf("failed to enqueue event %s: %w", event.Name, err)
+		}
+	}
+	return nil
+}
+
+// publishEvents triggers the events of a committed transaction, in order.
+func (bm *BlockchainManager) publishEvents(events []LedgerEvent) {
+	listener := bm.GetEventListener()
+	if listener == nil || len(events) == 0 {
+		return
+	}
+	for _, event := range events {
+		result := t.NewValidationResult(true, event.Name, event.Metadata(), nil).(*t.ValidationResult)
+		result.SetName(event.Name)
+		listener.Trigger(event.Name, result)
+	}
+	gl.Log("debug", fmt.Sprintf("Published %d ledger events", len(events)))
+}
@@ -2,6 +2,7 @@ package smart_contracts
 
 import (
 	"crypto/rsa"
+	"encoding/json"
 	"fmt"
 	"reflect"
 
@@ -89,6 +90,36 @@ func (cnt *BaseContractInfoAPI[T]) GetRequest(method string) (t.ValidationFunc[*
 	return t.ValidationFunc[*T]{}, false
 }
 
+// ValidateRequest runs the request validator registered for the method on the decoded payload.
+// Methods without a validator are accepted. A rejected request returns a *ContractContent[T] error.
+func (cnt *BaseContractInfoAPI[T]) ValidateRequest(method string, payload []byte) error {
+	if cnt == nil {
+		return fmt.Errorf("BaseContractInfoAPI is nil")
+	}
+	request, ok := cnt.GetRequest(method)
+	if !ok || request.Func == nil {
+		return nil
+	}
+
+	data := new(T)
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, data); err != nil {
+			return NewContractError(ContractStatusBadRequest, fmt.Sprintf("invalid payload for %s: %v", method, err), data)
+		}
+	}
+
+	result := request.Func(&data, method)
+	if result == nil || result.GetIsValid() {
+		return nil
+	}
+	msg := result.GetMessage()
+	if msg == "" && result.GetError() != nil {
+		msg = result.GetError().Error()
+	}
+	gl.Log("warn", fmt.Sprintf("Request %s rejected: %s", method, msg))
+	return NewContractError(ContractStatusInvalid, msg, data)
+}
+
 func (cnt *BaseContractInfoAPI[T]) GetType() reflect.Type {
 	if cnt == nil {
 		return nil
@@ -1,5 +1,7 @@
 package smart_contracts
 
+import "encoding/json"
+
 type BaseContractInfo struct {
 	ContractID          string `json:"contractId"`
 	ContractName        string `json:"contractName"`
@@ -11,6 +13,10 @@ type BaseContractInfo struct {
 	ContractStatus      string `json:"contractStatus"`
 	ContractStartDate   string `json:"contractStartDate"`
 	ContractEndDate     string `json:"contractEndDate"`
+
+	// PayloadSchema is an optional JSON Schema enforced on the payloads of the contract.
+	// See RegisterContractPayloadSchema.
+	PayloadSchema json.RawMessage `json:"payloadSchema,omitempty"`
 }
 
 func NewBaseContractInfo(
@@ -0,0 +1,139 @@
+package smart_contracts
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	gl "github.com/rafa-mori/smart_plane/logger"
+)
+
+// MigrationStatus is the outcome of migrating a single record.
+type MigrationStatus string
+
+const (
+	MigrationStatusMigrated MigrationStatus = "migrated" // Record was (or would be, in dry-run) migrated
+	MigrationStatusUpToDate MigrationStatus = "up-to-date"
+	MigrationStatusSkipped  MigrationStatus = "skipped" // Record has no known schema
+	MigrationStatusFailed   MigrationStatus = "failed"
+)
+
+// MigrationEntry describes what happened to a single ledger key.
+type MigrationEntry struct {
+	Key         string          `json:"key"`
+	Schema      string          `json:"schema,omitempty"`
+	FromVersion int             `json:"fromVersion"`
+	ToVersion   int             `json:"toVersion"`
+	Status      MigrationStatus `json:"status"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// MigrationReport is the result of a bulk migration run.
+type MigrationReport struct {
+	DryRun   bool             `json:"dryRun"`
+	TxID     string           `json:"txId,omitempty"`
+	Scanned  int              `json:"scanned"`
+	Migrated int              `json:"migrated"`
+	UpToDate int              `json:"upToDate"`
+	Skipped  int              `json:"skipped"`
+	Failed   int              `json:"failed"`
+	Entries  []MigrationEntry `json:"entries"`
+}
+
+// MigrateLedger upgrades every record in the ledger to the current version of its schema.
+//
+// Records without an envelope carry no schema name; they are migrated with fallbackSchema
+// when it is set and skipped otherwise. All migrated records are committed in a single
+// transaction, so a failure leaves the ledger untouched. In dry-run mode nothing is written.
+func MigrateLedger(ledger lg.ILedger, fallbackSchema string, dryRun bool) (*MigrationReport, error) {
+	if ledger == nil {
+		return nil, fmt.Errorf("ledger is nil")
+	}
+	keys, err := ledger.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ledger keys: %w", err)
+	}
+
+	report := &MigrationReport{DryRun: dryRun, Entries: make([]MigrationEntry, 0, len(keys))}
+	writes := make([]lg.Write, 0)
+	for _, key := range keys {
+		if lg.IsSystemKey(key) {
+			continue
+		}
+		report.Scanned++
+		entry, value := migrateRecord(ledger, key, fallbackSchema)
+		switch entry.Status {
+		case MigrationStatusMigrated:
+			report.Migrated++
+			writes = append(writes, lg.Write{Key: key, Value: value})
+		case MigrationStatusUpToDate:
+			report.UpToDate++
+		case MigrationStatusSkipped:
+			report.Skipped++
+		case MigrationStatusFailed:
+			report.Failed++
+		}
+		report.Entries = append(report.Entries, entry)
+	}
+
+	if dryRun || len(writes) == 0 {
+		return report, nil
+	}
+	if report.Failed > 0 {
+		return report, fmt.Errorf("%d records failed to migrate, nothing was written", report.Failed)
+	}
+
+	report.TxID = "migrate-" + uuid.New().String()
+	if err := ledger.Commit(report.TxID, writes); err != nil {
+		gl.Log("error", fmt.Sprintf("Failed to commit migration: %v", err))
+		return report, fmt.Errorf("failed to commit migration: %w", err)
+	}
+	return report, nil
+}
+
+// migrateRecord migrates a single key, returning the report entry and the new value.
+func migrateRecord(ledger lg.ILedger, key, fallbackSchema string) (MigrationEntry, []byte) {
+	entry := MigrationEntry{Key: key}
+
+	raw, err := ledger.GetState(key)
+	if err != nil {
+		entry.Status, entry.Error = MigrationStatusFailed, err.Error()
+		return entry, nil
+	}
+	env, err := DecodeEnvelope(raw)
+	if err != nil {
+		entry.Status, entry.Error = MigrationStatusFailed, err.Error()
+		return entry, nil
+	}
+
+	entry.FromVersion, entry.ToVersion = env.Version, env.Version
+	entry.Schema = env.Schema
+	if entry.Schema == "" {
+		entry.Schema = fallbackSchema
+	}
+	schema, ok := GetSchema(entry.Schema)
+	if !ok {
+		entry.Status = MigrationStatusSkipped
+		entry.Error = "no registered schema"
+		return entry, nil
+	}
+
+	migrated, changed, err := schema.Migrate(env)
+	if err != nil {
+		entry.Status, entry.Error = MigrationStatusFailed, err.Error()
+		return entry, nil
+	}
+	entry.ToVersion = migrated.Version
+	if !changed {
+		entry.Status = MigrationStatusUpToDate
+		return entry, nil
+	}
+	value, err := json.Marshal(migrated)
+	if err != nil {
+		entry.Status, entry.Error = MigrationStatusFailed, err.Error()
+		return entry, nil
+	}
+	entry.Status = MigrationStatusMigrated
+	return entry, value
+}
@@ -0,0 +1,211 @@
+package smart_contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	ci "github.com/rafa-mori/smart_plane/internal/interfaces"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	t "github.com/rafa-mori/smart_plane/types"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// PayloadSchemaFileSuffix is the suffix of the payload schema files read by LoadPayloadSchemas.
+const PayloadSchemaFileSuffix = ".schema.json"
+
+// payloadSchema is a compiled JSON Schema for the payloads of a contract.
+type payloadSchema struct {
+	raw      json.RawMessage
+	compiled *gojsonschema.Schema
+}
+
+var (
+	payloadSchemasMu sync.RWMutex
+	payloadSchemas   = make(map[string]*payloadSchema)
+)
+
+// PayloadValidationError is returned when a payload does not match the schema of its contract.
+type PayloadValidationError struct {
+	ContractName string
+	// Results holds one invalid result per schema error, with the JSON pointer in the "pointer" metadata.
+	Results []ci.IValidationResult
+}
+
+func (e *PayloadValidationError) Error() string {
+	messages := make([]string, 0, len(e.Results))
+	for _, r := range e.Results {
+		messages = append(messages, r.GetMessage())
+	}
+	return fmt.Sprintf("payload inválido para o contrato %s: %s", e.ContractName, strings.Join(messages, "; "))
+}
+
+// RegisterPayloadSchema compiles and registers the JSON Schema for the payloads of a contract,
+// replacing any schema already registered for it.
+func RegisterPayloadSchema(contractName string, schema []byte) error {
+	if contractName == "" {
+		return fmt.Errorf("contract name cannot be empty")
+	}
+	compiled, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+	if err != nil {
+		return fmt.Errorf("invalid payload schema for contract %s: %w", contractName, err)
+	}
+
+	payloadSchemasMu.Lock()
+	defer payloadSchemasMu.Unlock()
+
+	payloadSchemas[contractName] = &payloadSchema{
+		raw:      append(json.RawMessage(nil), schema...),
+		compiled: compiled,
+	}
+	return nil
+}
+
+// RegisterContractPayloadSchema registers the payload schema attached to a contract info.
+func RegisterContractPayloadSchema(info *BaseContractInfo) error {
+	if info == nil {
+		return fmt.Errorf("contract info cannot be nil")
+	}
+	if len(info.PayloadSchema) == 0 {
+		return fmt.Errorf("contract %s has no payload schema", info.ContractName)
+	}
+	return RegisterPayloadSchema(info.ContractName, info.PayloadSchema)
+}
+
+// UnregisterPayloadSchema removes the payload schema of a contract.
+func UnregisterPayloadSchema(contractName string) {
+	payloadSchemasMu.Lock()
+	defer payloadSchemasMu.Unlock()
+
+	delete(payloadSchemas, contractName)
+}
+
+// LoadPayloadSchemas registers every `<ContractName>.schema.json` file found in a directory.
+func LoadPayloadSchemas(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+PayloadSchemaFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	loaded := make([]string, 0, len(matches))
+	for _, path := range matches {
+		contractName := strings.TrimSuffix(filepath.Base(path), PayloadSchemaFileSuffix)
+		schema, err := os.ReadFile(path)
+		if err != nil {
+			return loaded, fmt.Errorf("failed to read payload schema %s: %w", path, err)
+		}
+		if err := RegisterPayloadSchema(contractName, schema); err != nil {
+			return loaded, err
+		}
+		loaded = append(loaded, contractName)
+	}
+	return loaded, nil
+}
+
+// GetPayloadSchema returns the raw payload schema of a contract.
+func GetPayloadSchema(contractName string) (json.RawMessage, bool) {
+	payloadSchemasMu.RLock()
+	defer payloadSchemasMu.RUnlock()
+
+	if s, ok := payloadSchemas[contractName]; ok {
+		return s.raw, true
+	}
+	return nil, false
+}
+
+// ValidatePayload checks a payload against the schema of its contract. It returns one invalid
+// result per schema error, or nil when the payload is valid or the contract has no schema.
+func ValidatePayload(contractName string, payload []byte) []ci.IValidationResult {
+	payloadSchemasMu.RLock()
+	s, ok := payloadSchemas[contractName]
+	payloadSchemasMu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	result, err := s.compiled.Validate(gojsonschema.NewBytesLoader(payload))
+	if err != nil {
+		return []ci.IValidationResult{
+			t.NewValidationResult(false, fmt.Sprintf("payload is not valid JSON: %v", err), map[string]any{
+				"contract": contractName,
+				"pointer":  "",
+				"type":     "invalid_json",
+			}, err),
+		}
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	results := make([]ci.IValidationResult, 0, len(result.Errors()))
+	for _, re := range result.Errors() {
+		pointer := jsonPointer(re)
+		err := fmt.Errorf("%s: %s", pointer, re.Description())
+		results = append(results, t.NewValidationResult(false, err.Error(), map[string]any{
+			"contract": contractName,
+			"pointer":  pointer,
+			"type":     re.Type(),
+		}, err))
+	}
+	return results
+}
+
+// checkPayload returns a PayloadValidationError when the payload does not match its contract schema.
+func checkPayload(contractName, content string) error {
+	if results := ValidatePayload(contractName, []byte(content)); len(results) > 0 {
+		gl.Log("warn", fmt.Sprintf("Payload rejected by the %s schema (%d errors)", contractName, len(results)))
+		return &PayloadValidationError{ContractName: contractName, Results: results}
+	}
+	return nil
+}
+
+// jsonPointer converts the context of a schema error into an RFC 6901 JSON pointer.
+// For missing required properties, the pointer addresses the missing property.
+func jsonPointer(re gojsonschema.ResultError) string {
+	const sep = "\x00"
+	tokens := strings.Split(re.Context().String(sep), sep)[1:]
+	if re.Type() == "required" {
+		if property, ok := re.Details()["property"].(string); ok {
+			tokens = append(tokens, property)
+		}
+	}
+
+	var sb strings.Builder
+	for _, token := range tokens {
+		sb.WriteString("/")
+		sb.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return sb.String()
+}
+
+// PayloadSchemasOpenAPI returns an OpenAPI document exposing every registered payload schema
+// as `<ContractName>Payload` under components.schemas, for inclusion in the API docs.
+func PayloadSchemasOpenAPI(title, version string) map[string]any {
+	payloadSchemasMu.RLock()
+	defer payloadSchemasMu.RUnlock()
+
+	names := make([]string, 0, len(payloadSchemas))
+	for name := range payloadSchemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	components := make(map[string]any, len(names))
+	for _, name := range names {
+		components[name+"Payload"] = payloadSchemas[name].raw
+	}
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   title,
+			"version": version,
+		},
+		"paths": map[string]any{},
+		"components": map[string]any{
+			"schemas": components,
+		},
+	}
+}
@@ -0,0 +1,144 @@
+package smart_contracts
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	ds "github.com/rafa-mori/smart_documents/data_structures"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+)
+
+// DocumentSchemaVersion is the current schema version of the documents stored by the
+// built-in contracts.
+const DocumentSchemaVersion = 1
+
+// DocumentSchemaName is the schema name of the documents stored by the built-in contracts.
+// Their migrations are registered on the schema returned by GetSchema(DocumentSchemaName).
+var DocumentSchemaName = SchemaName[ds.Document]()
+
+// documentSchema is the schema of the documents stored by the built-in contracts.
+var documentSchema, _ = RegisterSchemaName(DocumentSchemaName, DocumentSchemaVersion)
+
+// recordStub is the stub the contracts of a manager transaction write through. The document
+// contracts store plain JSON; recordStub stores it wrapped in the envelope of the document
+// schema and stamped with the contract of the running operation, and hands the contracts
+// back plain JSON migrated to the current schema version. Records already in an envelope of another schema, written by a BaseContract, are stored
+// and returned as they are. System keys, such as the outbox, are written to the underlying
+// stub directly.
+type recordStub struct {
+	*lg.Stub
+
+	// contract is the contract of the operation running in the transaction. It is set under
+	// the transaction lock, like every call of the contracts.
+	contract string
+}
+
+// newRecordContext returns the transaction context of the contracts of a transaction.
+func newRecordContext(records *recordStub) contractapi.TransactionContextInterface {
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(records)
+	return ctx
+}
+
+// GetState returns the document of a key, migrated and without its envelope.
+func (s *recordStub) GetState(key string) ([]byte, error) {
+	raw, err := s.Stub.GetState(key)
+	if err != nil || raw == nil {
+		return raw, err
+	}
+	return openRecord(raw)
+}
+
+// PutState stages a document wrapped in the envelope of the current document schema, stamped
+// with the contract writing it.
+func (s *recordStub) PutState(key string, value []byte) error {
+	if sealed(value) {
+		return s.Stub.PutState(key, value)
+	}
+	record, err := encodeRecord(documentSchema, s.contract, value)
+	if err != nil {
+		return fmt.Errorf("failed to encode document %s: %w", key, err)
+	}
+	return s.Stub.PutState(key, record)
+}
+
+// GetHistoryForKey returns the history of a key with every document opened as by GetState.
+func (s *recordStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	it, err := s.Stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &recordHistoryIterator{HistoryQueryIteratorInterface: it}, nil
+}
+
+// GetStateByRange returns the keys in [startKey, endKey) with every document opened as by GetState.
+func (s *recordStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	it, err := s.Stub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return &recordStateIterator{StateQueryIteratorInterface: it}, nil
+}
+
+// recordHistoryIterator opens the documents of a history iterator. The iterators of the stub
+// build new results on every call, so they are opened in place.
+type recordHistoryIterator struct {
+	shim.HistoryQueryIteratorInterface
+}
+
+func (it *recordHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	km, err := it.HistoryQueryIteratorInterface.Next()
+	if err != nil || km.IsDelete || km.Value == nil {
+		return km, err
+	}
+	value, err := openRecord(km.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode history of %s: %w", km.TxId, err)
+	}
+	km.Value = value
+	return km, nil
+}
+
+// recordStateIterator opens the documents of a state iterator.
+type recordStateIterator struct {
+	shim.StateQueryIteratorInterface
+}
+
+func (it *recordStateIterator) Next() (*queryresult.KV, error) {
+	kv, err := it.StateQueryIteratorInterface.Next()
+	if err != nil || kv.Value == nil {
+		return kv, err
+	}
+	value, err := openRecord(kv.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode document %s: %w", kv.Key, err)
+	}
+	kv.Value = value
+	return kv, nil
+}
+
+// sealed reports whether a value is already wrapped in an envelope.
+func sealed(value []byte) bool {
+	env, err := DecodeEnvelope(value)
+	return err == nil && env.Marker == EnvelopeMarker
+}
+
+// openRecord returns the payload of a stored document migrated to the current version of the
+// document schema; documents stored without an envelope are migrated from version 0. Records
+// of other schemas are returned as they are, for their contracts to decode.
+func openRecord(raw []byte) ([]byte, error) {
+	env, err := DecodeEnvelope(raw)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao deserializar envelope: %v", err)
+	}
+	if env.Schema != "" && env.Schema != DocumentSchemaName {
+		return raw, nil
+	}
+	migrated, _, err := documentSchema.Migrate(env)
+	if err != nil {
+		return nil, err
+	}
+	return migrated.Data, nil
+}
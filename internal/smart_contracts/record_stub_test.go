@@ -0,0 +1,125 @@
+package smart_contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+)
+
+func TestRecordStubStoresDocumentsInEnvelopes(t *testing.T) {
+	ledger := lg.NewMemoryLedger()
+	if err := ledger.Commit("legacy", []lg.Write{{Key: "old", Value: []byte(`{"id":"old"}`)}}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	tx := NewBlockchainManagerWithLedger(ledger).Begin()
+	stub := tx.Context().GetStub()
+	if err := stub.PutState("new", []byte(`{"id":"new"}`)); err != nil {
+		t.Fatalf("PutState: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	raw, err := ledger.GetState("new")
+	if err != nil {
+		t.Fatalf("GetState: %v", err)
+	}
+	env, err := DecodeEnvelope(raw)
+	if err != nil {
+		t.Fatalf("DecodeEnvelope: %v", err)
+	}
+	if env.Marker != EnvelopeMarker || env.Schema != DocumentSchemaName || env.Version != DocumentSchemaVersion {
+		t.Fatalf("stored envelope = %+v, want the document schema version %d", env, DocumentSchemaVersion)
+	}
+
+	reader := NewBlockchainManagerWithLedger(ledger).Begin()
+	defer reader.Rollback()
+	for key, want := range map[string]string{"new": `{"id":"new"}`, "old": `{"id":"old"}`} {
+		value, err := reader.Context().GetStub().GetState(key)
+		if err != nil {
+			t.Fatalf("GetState %s: %v", key, err)
+		}
+		if string(value) != want {
+			t.Fatalf("GetState %s = %s, want %s", key, value, want)
+		}
+	}
+
+	history, err := reader.Context().GetStub().GetHistoryForKey("new")
+	if err != nil {
+		t.Fatalf("GetHistoryForKey: %v", err)
+	}
+	record, err := history.Next()
+	if err != nil || string(record.Value) != `{"id":"new"}` {
+		t.Fatalf("history record = %v, %v, want the document without its envelope", record, err)
+	}
+}
+
+type baseRecord struct {
+	Name string `json:"name"`
+}
+
+func TestBaseContractPutRegistersNewRecords(t *testing.T) {
+	if _, err := RegisterSchema[baseRecord](1); err != nil {
+		t.Fatalf("RegisterSchema: %v", err)
+	}
+	tx := NewBlockchainManager().Begin()
+	defer tx.Rollback()
+
+	var contract BaseContract[baseRecord]
+	if ok, err := contract.Put(tx.Context(), "rec", baseRecord{Name: "first"}); !ok || err != nil {
+		t.Fatalf("Put of a new record = %v, %v", ok, err)
+	}
+	var conflict *DocumentConflictError
+	if _, err := contract.Put(tx.Context(), "rec", baseRecord{Name: "second"}); !errors.As(err, &conflict) {
+		t.Fatalf("Put of an existing record = %v, want a conflict", err)
+	}
+	got, err := contract.Get(tx.Context(), "rec")
+	if err != nil || got.Name != "first" {
+		t.Fatalf("Get = %+v, %v, want the first record", got, err)
+	}
+
+	raw, err := tx.stub.GetState("rec")
+	if err != nil {
+		t.Fatalf("GetState: %v", err)
+	}
+	var env SchemaEnvelope
+	if err := json.Unmarshal(raw, &env); err != nil || env.Schema != SchemaName[baseRecord]() {
+		t.Fatalf("stored record %s is not in the envelope of its schema", raw)
+	}
+}
+
+func TestExportPartitionsDocumentsByTheirContract(t *testing.T) {
+	ledger := lg.NewMemoryLedger()
+	bm := NewBlockchainManagerWithLedger(ledger)
+	writes := map[string]string{"a": "ApprovalContract", "b": "TrafficContract", "c": "ApprovalContract"}
+	err := bm.Batch(func(tx *Tx) error {
+		for id, contract := range writes {
+			err := tx.runRecorded(contract, id, DocumentActionRegistered, func() error {
+				return tx.Context().GetStub().PutState(id, []byte(`{"id":"`+id+`"}`))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+
+	manifest, err := lg.Export(ledger, io.Discard, lg.ExportOptions{Partition: RecordContract})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	records := make(map[string]int)
+	for _, file := range manifest.Files {
+		records[file.Contract] = file.Records
+	}
+	if len(records) != 2 || records["ApprovalContract"] != 2 || records["TrafficContract"] != 1 {
+		t.Fatalf("exported partitions = %v, want 2 ApprovalContract and 1 TrafficContract records", records)
+	}
+}
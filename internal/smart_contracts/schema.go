@@ -0,0 +1,242 @@
+package smart_contracts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// EnvelopeMarker is the value of the reserved "$envelope" key that tells an envelope apart
+// from a plain document which happens to have schema, schemaVersion and data keys.
+const EnvelopeMarker = "smart_plane/v1"
+
+// SchemaEnvelope is the on-ledger representation of a versioned state record.
+//
+// Records written before schema versioning existed are plain JSON documents
+// without the envelope; they are decoded as version 0.
+type SchemaEnvelope struct {
+	Marker  string `json:"$envelope"`
+	Schema  string `json:"schema"`
+	Version int    `json:"schemaVersion"`
+	// Contract is the contract that wrote the record, when it is known.
+	Contract string          `json:"contract,omitempty"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// MigrationFunc upgrades the raw JSON payload of a record from version N to N+1.
+type MigrationFunc func(data json.RawMessage) (json.RawMessage, error)
+
+// Schema holds the current version of a record type and its migration chain.
+type Schema struct {
+	mu sync.RWMutex
+	// Name is the schema name stamped into every stored record.
+	Name string
+	// Version is the current schema version. New records are written with it.
+	Version int
+	// migrations maps a version N to the function migrating N to N+1.
+	migrations map[int]MigrationFunc
+}
+
+var (
+	schemasMu sync.RWMutex
+	schemas   = make(map[string]*Schema)
+)
+
+// SchemaName returns the schema name used for the type T.
+func SchemaName[T any]() string {
+	return reflect.TypeFor[T]().String()
+}
+
+// RegisterSchema registers (or updates) the current version of the schema for T.
+func RegisterSchema[T any](version int) (*Schema, error) {
+	return RegisterSchemaName(SchemaName[T](), version)
+}
+
+// RegisterSchemaName registers (or updates) the current version of a named schema.
+func RegisterSchemaName(name string, version int) (*Schema, error) {
+	if name == "" {
+		return nil, fmt.Errorf("schema name cannot be empty")
+	}
+	if version < 1 {
+		return nil, fmt.Errorf("schema version must be greater than or equal to 1")
+	}
+
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+
+	if s, ok := schemas[name]; ok {
+		s.mu.Lock()
+		s.Version = version
+		s.mu.Unlock()
+		return s, nil
+	}
+	s := &Schema{
+		Name:       name,
+		Version:    version,
+		migrations: make(map[int]MigrationFunc),
+	}
+	schemas[name] = s
+	return s, nil
+}
+
+// GetSchema returns a registered schema by name.
+func GetSchema(name string) (*Schema, bool) {
+	schemasMu.RLock()
+	defer schemasMu.RUnlock()
+
+	s, ok := schemas[name]
+	return s, ok
+}
+
+// getOrDefaultSchema returns the registered schema, or an unregistered version 1 schema.
+func getOrDefaultSchema(name string) *Schema {
+	if s, ok := GetSchema(name); ok {
+		return s
+	}
+	return &Schema{Name: name, Version: 1, migrations: map[int]MigrationFunc{}}
+}
+
+// RegisterMigration registers the function migrating records from version `from` to `from+1`.
+func (s *Schema) RegisterMigration(from int, fn MigrationFunc) error {
+	if s == nil {
+		return fmt.Errorf("schema is nil")
+	}
+	if fn == nil {
+		return fmt.Errorf("migration function is nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if from < 0 || from >= s.Version {
+		return fmt.Errorf("migration from version %d is out of range for schema %s (current version %d)", from, s.Name, s.Version)
+	}
+	if _, ok := s.migrations[from]; ok {
+		return fmt.Errorf("migration from version %d already registered for schema %s", from, s.Name)
+	}
+	s.migrations[from] = fn
+	return nil
+}
+
+// GetVersion returns the current version of the schema.
+func (s *Schema) GetVersion() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Version
+}
+
+// Migrate upgrades the envelope to the current schema version, returning whether it changed.
+// A record without a registered migration from version 0 is adopted as version 1 as is. A
+// record stamped with another schema is rejected.
+func (s *Schema) Migrate(env *SchemaEnvelope) (*SchemaEnvelope, bool, error) {
+	if s == nil {
+		return nil, false, fmt.Errorf("schema is nil")
+	}
+	if env == nil {
+		return nil, false, fmt.Errorf("envelope is nil")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if env.Schema != "" && env.Schema != s.Name {
+		return nil, false, fmt.Errorf("record of schema %s cannot be migrated by schema %s", env.Schema, s.Name)
+	}
+	if env.Version > s.Version {
+		return nil, false, fmt.Errorf("record version %d is newer than schema %s version %d", env.Version, s.Name, s.Version)
+	}
+
+	migrated := &SchemaEnvelope{Marker: EnvelopeMarker, Schema: s.Name, Version: env.Version, Contract: env.Contract, Data: env.Data}
+	for migrated.Version < s.Version {
+		fn, ok := s.migrations[migrated.Version]
+		if !ok {
+			if migrated.Version == 0 {
+				migrated.Version = 1
+				continue
+			}
+			return nil, false, fmt.Errorf("no migration registered for schema %s from version %d", s.Name, migrated.Version)
+		}
+		data, err := fn(migrated.Data)
+		if err != nil {
+			return nil, false, fmt.Errorf("migration of schema %s from version %d failed: %w", s.Name, migrated.Version, err)
+		}
+		migrated.Data = data
+		migrated.Version++
+	}
+	return migrated, migrated.Version != env.Version || migrated.Schema != env.Schema, nil
+}
+
+// EncodeState serializes data wrapped in an envelope stamped with the current schema version of T.
+func EncodeState[T any](data T) ([]byte, error) {
+	return encodeState("", data)
+}
+
+// encodeState is EncodeState for a record written by the named contract.
+func encodeState[T any](contract string, data T) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return encodeRecord(getOrDefaultSchema(SchemaName[T]()), contract, payload)
+}
+
+// encodeRecord wraps a JSON payload written by a contract in an envelope stamped with the
+// current version of s.
+func encodeRecord(s *Schema, contract string, payload json.RawMessage) ([]byte, error) {
+	return json.Marshal(SchemaEnvelope{
+		Marker:   EnvelopeMarker,
+		Schema:   s.Name,
+		Version:  s.GetVersion(),
+		Contract: contract,
+		Data:     payload,
+	})
+}
+
+// DecodeEnvelope parses a stored record. Records without the envelope marker are returned as
+// version 0 with an empty schema name.
+func DecodeEnvelope(raw []byte) (*SchemaEnvelope, error) {
+	var probe struct {
+		Marker string `json:"$envelope"`
+	}
+	if err := json.Unmarshal(raw, &probe); err == nil {
+		if probe.Marker == EnvelopeMarker {
+			var env SchemaEnvelope
+			if err := json.Unmarshal(raw, &env); err != nil {
+				return nil, err
+			}
+			return &env, nil
+		}
+	}
+	return &SchemaEnvelope{Version: 0, Data: json.RawMessage(bytes.Clone(raw))}, nil
+}
+
+// RecordContract names the archive partition of a stored record: the contract that wrote it,
+// as stamped in its envelope, or an empty string for records that do not name one. It is the
+// partition function of ledger exports.
+func RecordContract(_ string, raw []byte) string {
+	env, err := DecodeEnvelope(raw)
+	if err != nil {
+		return ""
+	}
+	return env.Contract
+}
+
+// decodeState decodes a stored record into T, migrating it in memory to the current schema
+// version. Migrated records are only written back by the migrate command.
+func decodeState[T any](raw []byte) (T, error) {
+	var data T
+	env, err := DecodeEnvelope(raw)
+	if err != nil {
+		return data, fmt.Errorf("erro ao deserializar envelope: %v", err)
+	}
+	migrated, _, err := getOrDefaultSchema(SchemaName[T]()).Migrate(env)
+	if err != nil {
+		return data, err
+	}
+	if err := json.Unmarshal(migrated.Data, &data); err != nil {
+		return data, err
+	}
+	return data, nil
+}
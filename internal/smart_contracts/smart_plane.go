@@ -2,120 +2,150 @@ package smart_contracts
 
 import (
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	ds "github.com/rafa-mori/smart_documents/data_structures"
 	sd "github.com/rafa-mori/smart_documents/document_base"
+	ci "github.com/rafa-mori/smart_plane/internal/interfaces"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	t "github.com/rafa-mori/smart_plane/types"
 )
 
 type BlockchainManager struct {
+	mu        sync.RWMutex
 	contracts map[string]contractapi.ContractInterface
+	// ledger is the backend every transaction of the manager commits to.
+	ledger lg.ILedger
+	// requests holds the request validators of the contracts, by contract name.
+	requests map[string]ci.IRequestValidator
+	// events receives the ledger events of committed transactions.
+	events *t.ValidationListener
+	// outbox enables the ledger outbox of committed events.
+	outbox bool
+	// locks serializes the transactions writing the same document.
+	locks *t.KeyedMutexes
+	// lockTimeout bounds the wait of a transaction for a document lock.
+	lockTimeout time.Duration
 }
 
+// DefaultDocumentLockTimeout is how long a transaction waits for a document held by another
+// transaction before failing.
+const DefaultDocumentLockTimeout = 30 * time.Second
+
 func NewBlockchainManager() *BlockchainManager {
-	return &BlockchainManager{
+	return NewBlockchainManagerWithLedger(lg.NewMemoryLedger())
+}
+
+// NewBlockchainManagerWithLedger creates a manager that commits to the given ledger backend.
+func NewBlockchainManagerWithLedger(ledger lg.ILedger) *BlockchainManager {
+	if ledger == nil {
+		ledger = lg.NewMemoryLedger()
+	}
+	bm := &BlockchainManager{
 		contracts: map[string]contractapi.ContractInterface{
 			"ApprovalContract":  &sd.ApprovalContract{},
 			"SignatureContract": &sd.SignatureContract{},
 			"TrafficContract":   &sd.TrafficContract{},
 		},
+		ledger:      ledger,
+		requests:    make(map[string]ci.IRequestValidator),
+		locks:       t.NewKeyedMutexes(t.DefaultKeyedMutexesTTL),
+		lockTimeout: DefaultDocumentLockTimeout,
 	}
+	// Served as chaincode, the contracts validate their requests before every transaction.
+	for name, contract := range bm.contracts {
+		installRequestHook(contract, bm.requestHook(name))
+	}
+	return bm
 }
 
-func (bm *BlockchainManager) RegisterDocument(contractName, id, content string) error {
-	contract, exists := bm.contracts[contractName]
-	if !exists {
-		return fmt.Errorf("contrato %s não encontrado", contractName)
-	}
+// SetLockTimeout sets how long a transaction waits for a document lock before failing.
+// Transactions writing several documents can deadlock each other; the timeout breaks it.
+func (bm *BlockchainManager) SetLockTimeout(timeout time.Duration) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
 
-	switch c := contract.(type) {
-	case *sd.ApprovalContract:
-		return c.RegisterDocument(nil, id, content)
-	case *sd.TrafficContract:
-		return c.RegisterTrafficDocument(nil, id, content)
-	default:
-		return fmt.Errorf("contrato %s não suporta registro de documentos", contractName)
+	if timeout <= 0 {
+		timeout = DefaultDocumentLockTimeout
 	}
+	bm.lockTimeout = timeout
 }
 
-func (bm *BlockchainManager) GetDocumentHistory(contractName, id string) ([]string, error) {
-	contract, exists := bm.contracts[contractName]
-	if !exists {
-		return nil, fmt.Errorf("contrato %s não encontrado", contractName)
+// ContractNames returns the names of the contracts a new manager knows, sorted.
+func ContractNames() []string {
+	contracts := NewBlockchainManager().contracts
+	names := make([]string, 0, len(contracts))
+	for name := range contracts {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	switch c := contract.(type) {
-	case *sd.ApprovalContract:
-		return c.GetDocumentHistory(nil, id)
-	case *sd.SignatureContract:
-		return c.GetDocumentHistory(nil, id)
-	case *sd.TrafficContract:
-		return c.GetDocumentHistory(nil, id)
-	default:
-		return nil, fmt.Errorf("contrato %s não suporta consulta de histórico", contractName)
+// SetEnabledContracts restricts the manager to the named contracts; the others answer as
+// unknown contracts. Transactions already begun keep the contracts they began with.
+func (bm *BlockchainManager) SetEnabledContracts(names ...string) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	enabled := make(map[string]contractapi.ContractInterface, len(names))
+	for _, name := range names {
+		contract, exists := bm.contracts[name]
+		if !exists {
+			return &ContractNotFoundError{ContractName: name}
+		}
+		enabled[name] = contract
+	}
+	if len(enabled) == 0 {
+		return fmt.Errorf("at least one contract must be enabled")
 	}
+	bm.contracts = enabled
+	return nil
 }
 
-func (bm *BlockchainManager) DeleteDocumentState(contractName, id string) error {
-	contract, exists := bm.contracts[contractName]
-	if !exists {
-		return fmt.Errorf("contrato %s não encontrado", contractName)
-	}
+// documentLockKey returns the lock key of a document. The contracts share one state key
+// space, where a document is keyed by its ID alone, so its lock is keyed the same way:
+// writers of one ID through different contracts are serialized too.
+func documentLockKey(id string) string { return id }
 
-	switch c := contract.(type) {
-	case *sd.ApprovalContract:
-		return c.DeleteDocumentState(nil, id)
-	case *sd.SignatureContract:
-		return c.DeleteDocumentState(nil, id)
-	case *sd.TrafficContract:
-		return c.DeleteDocumentState(nil, id)
-	default:
-		return fmt.Errorf("contrato %s não suporta exclusão de estado", contractName)
-	}
+// GetLedger returns the ledger backend of the manager.
+func (bm *BlockchainManager) GetLedger() lg.ILedger { return bm.ledger }
+
+func (bm *BlockchainManager) RegisterDocument(contractName, id, content string) error {
+	return bm.Batch(func(tx *Tx) error {
+		return tx.RegisterDocument(contractName, id, content)
+	})
 }
 
-func (bm *BlockchainManager) ApproveDocument(contractName, id string) error {
-	contract, exists := bm.contracts[contractName]
-	if !exists {
-		return fmt.Errorf("contrato %s não encontrado", contractName)
-	}
+func (bm *BlockchainManager) GetDocumentHistory(contractName, id string) ([]string, error) {
+	tx := bm.Begin()
+	defer tx.Rollback()
+	return tx.GetDocumentHistory(contractName, id)
+}
 
-	switch c := contract.(type) {
-	case *sd.ApprovalContract:
-		return c.ApproveDocument(nil, id)
-	default:
-		return fmt.Errorf("contrato %s não suporta aprovação de documentos", contractName)
-	}
+func (bm *BlockchainManager) DeleteDocumentState(contractName, id string) error {
+	return bm.Batch(func(tx *Tx) error {
+		return tx.DeleteDocumentState(contractName, id)
+	})
 }
 
-func (bm *BlockchainManager) SignDocument(contractName, id, signature string) error {
-	contract, exists := bm.contracts[contractName]
-	if !exists {
-		return fmt.Errorf("contrato %s não encontrado", contractName)
-	}
+func (bm *BlockchainManager) ApproveDocument(contractName, id string) error {
+	return bm.Batch(func(tx *Tx) error {
+		return tx.ApproveDocument(contractName, id)
+	})
+}
 
-	switch c := contract.(type) {
-	case *sd.SignatureContract:
-		return c.SignDocument(nil, id, signature)
-	default:
-		return fmt.Errorf("contrato %s não suporta assinatura de documentos", contractName)
-	}
+func (bm *BlockchainManager) SignDocument(contractName, id, signature string) error {
+	return bm.Batch(func(tx *Tx) error {
+		return tx.SignDocument(contractName, id, signature)
+	})
 }
 
 func (bm *BlockchainManager) GetDocumentState(contractName, id string) (*ds.Document, error) {
-	contract, exists := bm.contracts[contractName]
-	if !exists {
-		return nil, fmt.Errorf("contrato %s não encontrado", contractName)
-	}
-
-	switch c := contract.(type) {
-	case *sd.ApprovalContract:
-		return c.GetDocumentState(nil, id)
-	case *sd.SignatureContract:
-		return c.GetDocumentState(nil, id)
-	case *sd.TrafficContract:
-		return c.GetDocumentState(nil, id)
-	default:
-		return nil, fmt.Errorf("contrato %s não suporta consulta de estado", contractName)
-	}
+	tx := bm.Begin()
+	defer tx.Rollback()
+	return tx.GetDocumentState(contractName, id)
 }
@@ -1,6 +1,14 @@
 package smart_contracts
 
-import "reflect"
+import (
+	"fmt"
+	"reflect"
+)
+
+const (
+	ContractStatusInvalid    = "invalid"     // Request rejected by its validator
+	ContractStatusBadRequest = "bad_request" // Request payload could not be decoded
+)
 
 type ContractContent[T any] struct {
 	Status string `json:"status"`
@@ -8,9 +16,30 @@ type ContractContent[T any] struct {
 	Data   *T     `json:"data"`
 }
 
+// NewContractError creates a ContractContent to be returned as an error.
+func NewContractError[T any](status, msg string, data *T) *ContractContent[T] {
+	return &ContractContent[T]{Status: status, Msg: msg, Data: data}
+}
+
 func (cnt *ContractContent[T]) GetType() reflect.Type {
 	if cnt == nil {
 		return nil
 	}
 	return reflect.TypeFor[T]()
 }
+
+// ContractStatus returns the status of a ContractContent used as an error.
+func (cnt *ContractContent[T]) ContractStatus() string {
+	if cnt == nil {
+		return ""
+	}
+	return cnt.Status
+}
+
+// Error makes ContractContent usable as a structured error.
+func (cnt *ContractContent[T]) Error() string {
+	if cnt == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s: %s", cnt.Status, cnt.Msg)
+}
@@ -0,0 +1,465 @@
+package smart_contracts
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	ds "github.com/rafa-mori/smart_documents/data_structures"
+	sd "github.com/rafa-mori/smart_documents/document_base"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	gl "github.com/rafa-mori/smart_plane/logger"
+)
+
+// Tx stages writes across several contracts of a BlockchainManager against a single
+// transaction context. Nothing reaches the ledger until Commit; any failed operation
+// marks the transaction as failed, and a failed transaction can only be rolled back.
+// Failed reads do not fail the transaction. A document written by the transaction stays
+// locked until it ends, so concurrent writers of one document are serialized while
+// transactions on different documents run in parallel.
+type Tx struct {
+	mu sync.Mutex
+	// bm is the manager that owns the transaction.
+	bm *BlockchainManager
+	// contracts are the contracts enabled in the manager when the transaction began.
+	contracts map[string]contractapi.ContractInterface
+	// stub holds the staged writes of the transaction.
+	stub *lg.Stub
+	// records is the stub of the contract calls, storing the documents in schema envelopes.
+	records *recordStub
+	// ctx is the transaction context passed to every contract call.
+	ctx contractapi.TransactionContextInterface
+	// err is the first error returned by an operation of the transaction.
+	err error
+	// events holds the ledger events of the transaction, published after commit.
+	events []LedgerEvent
+	// unlocks releases the document locks held by the transaction, by lock key.
+	unlocks map[string]func()
+	// done is set once the transaction is committed or rolled back.
+	done bool
+}
+
+// Begin starts a new transaction against the manager's ledger.
+func (bm *BlockchainManager) Begin() *Tx {
+	// SetEnabledContracts replaces the map as a whole, so the transaction keeps the one it began with.
+	bm.mu.RLock()
+	contracts := bm.contracts
+	bm.mu.RUnlock()
+
+	stub := lg.NewStub(bm.ledger, uuid.New().String())
+	records := &recordStub{Stub: stub}
+	return &Tx{
+		bm:        bm,
+		contracts: contracts,
+		stub:      stub,
+		records:   records,
+		ctx:       newRecordContext(records),
+		unlocks:   make(map[string]func()),
+	}
+}
+
+// Batch runs fn inside a transaction. The transaction is committed when fn returns nil,
+// and rolled back when fn returns an error or panics.
+func (bm *BlockchainManager) Batch(fn func(tx *Tx) error) (err error) {
+	if fn == nil {
+		return fmt.Errorf("batch function is nil")
+	}
+	tx := bm.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			gl.Log("error", fmt.Sprintf("Transaction %s panicked: %v", tx.ID(), r))
+			err = fmt.Errorf("transaction %s panicked: %v", tx.ID(), r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ID returns the transaction identifier.
+func (tx *Tx) ID() string { return tx.stub.GetTxID() }
+
+// Context returns the transaction context shared by every contract call of the transaction.
+func (tx *Tx) Context() contractapi.TransactionContextInterface { return tx.ctx }
+
+// Err returns the first error recorded by the transaction, if any.
+func (tx *Tx) Err() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	return tx.err
+}
+
+// Commit writes every staged change to the ledger, all-or-nothing. The ledger events are
+// published once the transaction lock and the document locks are released: a listener
+// queue may block the publication until its handlers catch up, and those handlers may run
+// transactions on the same documents.
+func (tx *Tx) Commit() error {
+	events, err := tx.commit()
+	if err != nil {
+		return err
+	}
+	tx.bm.publishEvents(events)
+	return nil
+}
+
+// commit writes the staged changes and ends the transaction, returning its ledger events.
+func (tx *Tx) commit() ([]LedgerEvent, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return nil, fmt.Errorf("transaction %s %w", tx.ID(), ErrTransactionFinished)
+	}
+	tx.done = true
+	defer tx.unlockDocuments()
+	if tx.err != nil {
+		tx.stub.Rollback()
+		return nil, fmt.Errorf("transaction %s rolled back: %w", tx.ID(), tx.err)
+	}
+	if err := tx.bm.stageOutbox(tx.stub, tx.events); err != nil {
+		tx.stub.Rollback()
+		gl.Log("error", fmt.Sprintf("Failed to enqueue events of transaction %s: %v", tx.ID(), err))
+		return nil, fmt.Errorf("failed to enqueue events of transaction %s: %w", tx.ID(), err)
+	}
+	if err := tx.stub.Commit(); err != nil {
+		gl.Log("error", fmt.Sprintf("Failed to commit transaction %s: %v", tx.ID(), err))
+		return nil, fmt.Errorf("failed to commit transaction %s: %w", tx.ID(), err)
+	}
+	return tx.events, nil
+}
+
+// record adds a ledger event to the transaction. The caller must hold the transaction lock.
+func (tx *Tx) record(contractName, documentID, action string) {
+	tx.events = append(tx.events, LedgerEvent{
+		Name:       DocumentEventName(documentID, action),
+		Action:     action,
+		Contract:   contractName,
+		DocumentID: documentID,
+		TxID:       tx.ID(),
+		Timestamp:  time.Now().UTC(),
+	})
+}
+
+// Rollback discards every staged change. It is safe to call after Commit.
+func (tx *Tx) Rollback() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return
+	}
+	tx.done = true
+	tx.stub.Rollback()
+	tx.unlockDocuments()
+}
+
+// lockDocument acquires the lock of a document for the rest of the transaction, so
+// transactions writing the same document run one after the other. The caller must not hold
+// the transaction lock: Rollback and Err stay available while the document lock is awaited.
+// A lock acquired after the transaction ended is released right away.
+func (tx *Tx) lockDocument(contractName, id string) error {
+	key := documentLockKey(id)
+	tx.mu.Lock()
+	_, held := tx.unlocks[key]
+	ended := tx.done || tx.err != nil
+	tx.mu.Unlock()
+	if held || ended {
+		return nil
+	}
+
+	tx.bm.mu.RLock()
+	timeout := tx.bm.lockTimeout
+	tx.bm.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	unlock, err := tx.bm.locks.Lock(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to lock document %s of %s: %w", id, contractName, err)
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if _, held := tx.unlocks[key]; held || tx.done {
+		unlock()
+		return nil
+	}
+	tx.unlocks[key] = unlock
+	return nil
+}
+
+// unlockDocuments releases every document lock of the transaction. The caller must hold the
+// transaction lock.
+func (tx *Tx) unlockDocuments() {
+	for key, unlock := range tx.unlocks {
+		unlock()
+		delete(tx.unlocks, key)
+	}
+}
+
+// run executes a single operation of the transaction, recording its error.
+func (tx *Tx) run(op func() error) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return fmt.Errorf("transaction %s %w", tx.ID(), ErrTransactionFinished)
+	}
+	if tx.err != nil {
+		return fmt.Errorf("transaction %s already failed: %w", tx.ID(), tx.err)
+	}
+	if err := guard(op); err != nil {
+		tx.err = err
+		return err
+	}
+	return nil
+}
+
+// guard calls a contract operation, turning a panic into an error: the in-process stub does
+// not implement every chaincode stub method, and calling a missing one panics.
+func guard(op func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			gl.Log("error", fmt.Sprintf("Contract operation panicked: %v", r))
+			err = fmt.Errorf("contract operation panicked: %v", r)
+		}
+	}()
+	return op()
+}
+
+// runRecorded executes a write operation on a document, holding the document lock until the
+// transaction ends, and records its ledger event when it succeeds. The documents it writes
+// are stamped with the contract. The document lock is awaited before the transaction lock
+// is taken.
+func (tx *Tx) runRecorded(contractName, documentID, action string, op func() error) error {
+	lockErr := tx.lockDocument(contractName, documentID)
+	return tx.run(func() error {
+		if lockErr != nil {
+			return lockErr
+		}
+		tx.records.contract = contractName
+		if err := op(); err != nil {
+			return err
+		}
+		tx.record(contractName, documentID, action)
+		return nil
+	})
+}
+
+// query executes a read-only operation of the transaction. Its errors do not fail the transaction.
+func (tx *Tx) query(op func() error) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return fmt.Errorf("transaction %s %w", tx.ID(), ErrTransactionFinished)
+	}
+	if tx.err != nil {
+		return fmt.Errorf("transaction %s already failed: %w", tx.ID(), tx.err)
+	}
+	return guard(op)
+}
+
+// contract looks up a contract enabled in the manager by name.
+func (tx *Tx) contract(contractName string) (contractapi.ContractInterface, error) {
+	contract, exists := tx.contracts[contractName]
+	if !exists {
+		return nil, &ContractNotFoundError{ContractName: contractName}
+	}
+	return contract, nil
+}
+
+// documentExists reports whether a document is in the state seen by the transaction, its
+// staged writes included.
+func (tx *Tx) documentExists(id string) (bool, error) {
+	value, err := tx.stub.GetState(id)
+	if err != nil {
+		return false, fmt.Errorf("failed to read document %s: %w", id, err)
+	}
+	return value != nil, nil
+}
+
+// requireDocument returns a DocumentNotFoundError when a document is not in the state seen
+// by the transaction.
+func (tx *Tx) requireDocument(contractName, id string) error {
+	exists, err := tx.documentExists(id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return &DocumentNotFoundError{ContractName: contractName, ID: id}
+	}
+	return nil
+}
+
+// registerMethod returns the name of the document registration method of a contract.
+func registerMethod(contract contractapi.ContractInterface) string {
+	if _, ok := contract.(*sd.TrafficContract); ok {
+		return "RegisterTrafficDocument"
+	}
+	return "RegisterDocument"
+}
+
+func (tx *Tx) RegisterDocument(contractName, id, content string) error {
+	return tx.runRecorded(contractName, id, DocumentActionRegistered, func() error {
+		contract, err := tx.contract(contractName)
+		if err != nil {
+			return err
+		}
+		if err := checkPayload(contractName, content); err != nil {
+			return err
+		}
+		if err := tx.bm.validateRequest(contractName, registerMethod(contract), id, content); err != nil {
+			return err
+		}
+		if exists, err := tx.documentExists(id); err != nil {
+			return err
+		} else if exists {
+			return &DocumentConflictError{ContractName: contractName, ID: id, Err: ErrDocumentExists}
+		}
+
+		switch c := contract.(type) {
+		case *sd.ApprovalContract:
+			return c.RegisterDocument(tx.ctx, id, content)
+		case *sd.TrafficContract:
+			return c.RegisterTrafficDocument(tx.ctx, id, content)
+		default:
+			return fmt.Errorf("contrato %s não suporta registro de documentos", contractName)
+		}
+	})
+}
+
+func (tx *Tx) GetDocumentHistory(contractName, id string) (history []string, err error) {
+	err = tx.query(func() error {
+		contract, err := tx.contract(contractName)
+		if err != nil {
+			return err
+		}
+		if err := tx.bm.validateRequest(contractName, "GetDocumentHistory", id); err != nil {
+			return err
+		}
+
+		switch c := contract.(type) {
+		case *sd.ApprovalContract:
+			history, err = c.GetDocumentHistory(tx.ctx, id)
+		case *sd.SignatureContract:
+			history, err = c.GetDocumentHistory(tx.ctx, id)
+		case *sd.TrafficContract:
+			history, err = c.GetDocumentHistory(tx.ctx, id)
+		default:
+			err = fmt.Errorf("contrato %s não suporta consulta de histórico", contractName)
+		}
+		if err == nil && len(history) == 0 {
+			err = &DocumentNotFoundError{ContractName: contractName, ID: id}
+		}
+		return err
+	})
+	return history, err
+}
+
+func (tx *Tx) DeleteDocumentState(contractName, id string) error {
+	return tx.runRecorded(contractName, id, DocumentActionDeleted, func() error {
+		contract, err := tx.contract(contractName)
+		if err != nil {
+			return err
+		}
+		if err := tx.bm.validateRequest(contractName, "DeleteDocumentState", id); err != nil {
+			return err
+		}
+		if err := tx.requireDocument(contractName, id); err != nil {
+			return err
+		}
+
+		switch c := contract.(type) {
+		case *sd.ApprovalContract:
+			return c.DeleteDocumentState(tx.ctx, id)
+		case *sd.SignatureContract:
+			return c.DeleteDocumentState(tx.ctx, id)
+		case *sd.TrafficContract:
+			return c.DeleteDocumentState(tx.ctx, id)
+		default:
+			return fmt.Errorf("contrato %s não suporta exclusão de estado", contractName)
+		}
+	})
+}
+
+func (tx *Tx) ApproveDocument(contractName, id string) error {
+	return tx.runRecorded(contractName, id, DocumentActionApproved, func() error {
+		contract, err := tx.contract(contractName)
+		if err != nil {
+			return err
+		}
+		if err := tx.bm.validateRequest(contractName, "ApproveDocument", id); err != nil {
+			return err
+		}
+		if err := tx.requireDocument(contractName, id); err != nil {
+			return err
+		}
+
+		switch c := contract.(type) {
+		case *sd.ApprovalContract:
+			return c.ApproveDocument(tx.ctx, id)
+		default:
+			return fmt.Errorf("contrato %s não suporta aprovação de documentos", contractName)
+		}
+	})
+}
+
+func (tx *Tx) SignDocument(contractName, id, signature string) error {
+	return tx.runRecorded(contractName, id, DocumentActionSigned, func() error {
+		contract, err := tx.contract(contractName)
+		if err != nil {
+			return err
+		}
+		if err := tx.bm.validateRequest(contractName, "SignDocument", id, signature); err != nil {
+			return err
+		}
+		if err := tx.requireDocument(contractName, id); err != nil {
+			return err
+		}
+
+		switch c := contract.(type) {
+		case *sd.SignatureContract:
+			return c.SignDocument(tx.ctx, id, signature)
+		default:
+			return fmt.Errorf("contrato %s não suporta assinatura de documentos", contractName)
+		}
+	})
+}
+
+func (tx *Tx) GetDocumentState(contractName, id string) (state *ds.Document, err error) {
+	err = tx.query(func() error {
+		contract, err := tx.contract(contractName)
+		if err != nil {
+			return err
+		}
+		if err := tx.bm.validateRequest(contractName, "GetDocumentState", id); err != nil {
+			return err
+		}
+		if err := tx.requireDocument(contractName, id); err != nil {
+			return err
+		}
+
+		switch c := contract.(type) {
+		case *sd.ApprovalContract:
+			state, err = c.GetDocumentState(tx.ctx, id)
+		case *sd.SignatureContract:
+			state, err = c.GetDocumentState(tx.ctx, id)
+		case *sd.TrafficContract:
+			state, err = c.GetDocumentState(tx.ctx, id)
+		default:
+			err = fmt.Errorf("contrato %s não suporta consulta de estado", contractName)
+		}
+		return err
+	})
+	return state, err
+}
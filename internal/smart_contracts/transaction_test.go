@@ -0,0 +1,94 @@
+package smart_contracts
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rafa-mori/smart_plane/types"
+)
+
+func TestDocumentLocksSpanContracts(t *testing.T) {
+	bm := NewBlockchainManager()
+	bm.SetLockTimeout(50 * time.Millisecond)
+
+	holder := bm.Begin()
+	if err := holder.lockDocument("ApprovalContract", "doc"); err != nil {
+		t.Fatalf("lockDocument: %v", err)
+	}
+
+	other := bm.Begin()
+	defer other.Rollback()
+	if err := other.lockDocument("TrafficContract", "doc"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("lockDocument of the same ID through another contract = %v, want a timeout", err)
+	}
+	if err := other.lockDocument("TrafficContract", "other"); err != nil {
+		t.Fatalf("lockDocument of another ID: %v", err)
+	}
+
+	holder.Rollback()
+	if err := other.lockDocument("TrafficContract", "doc"); err != nil {
+		t.Fatalf("lockDocument after the holder ended: %v", err)
+	}
+}
+
+func TestTransactionsKeepTheContractsTheyBeganWith(t *testing.T) {
+	bm := NewBlockchainManager()
+	tx := bm.Begin()
+	defer tx.Rollback()
+
+	done := make(chan error)
+	go func() { done <- bm.SetEnabledContracts("SignatureContract") }()
+	if _, err := tx.contract("ApprovalContract"); err != nil {
+		t.Fatalf("contract of a running transaction: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("SetEnabledContracts: %v", err)
+	}
+
+	var notFound *ContractNotFoundError
+	if _, err := bm.Begin().contract("ApprovalContract"); !errors.As(err, &notFound) {
+		t.Fatalf("contract of a disabled contract = %v, want not found", err)
+	}
+}
+
+func TestCommitPublishesOnceTheDocumentsAreReleased(t *testing.T) {
+	bm := NewBlockchainManager()
+	bm.SetLockTimeout(2 * time.Second)
+	listener := types.NewValidationListenerWithConfig(types.DispatcherConfig{Workers: 1, QueueSize: 1, Policy: types.DispatchPolicyBlock})
+	defer listener.Close(context.Background())
+	bm.SetEventListener(listener)
+
+	locked := make(chan error, 1)
+	var events atomic.Int32
+	_, err := listener.Subscribe("document.**", func(*types.ValidationResult) {
+		if events.Add(1) > 1 {
+			return
+		}
+		// The first handler writes the document again while the other events wait in line.
+		tx := bm.Begin()
+		defer tx.Rollback()
+		locked <- tx.lockDocument("ApprovalContract", "doc")
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	tx := bm.Begin()
+	if err := tx.lockDocument("ApprovalContract", "doc"); err != nil {
+		t.Fatalf("lockDocument: %v", err)
+	}
+	tx.mu.Lock()
+	for _, action := range []string{"registered", "approved", "signed"} {
+		tx.record("ApprovalContract", "doc", action)
+	}
+	tx.mu.Unlock()
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if err := <-locked; err != nil {
+		t.Fatalf("lockDocument from a handler: %v", err)
+	}
+}
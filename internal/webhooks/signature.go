@@ -0,0 +1,52 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderEvent     = "X-SmartPlane-Event"
+	HeaderDelivery  = "X-SmartPlane-Delivery"
+	HeaderTimestamp = "X-SmartPlane-Timestamp"
+	HeaderSignature = "X-SmartPlane-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+// Sign returns the signature of a delivery: the hex HMAC-SHA256 of `<timestamp>.<body>`
+// keyed with the endpoint secret, prefixed with `sha256=`.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a delivery signature as a receiver would. A tolerance greater
+// than zero also rejects timestamps older or newer than the tolerance, to limit replays.
+func VerifySignature(secret, timestamp, signature string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp %q: %w", timestamp, err)
+	}
+	if tolerance > 0 {
+		age := time.Since(time.Unix(ts, 0))
+		if age > tolerance || age < -tolerance {
+			return fmt.Errorf("signature timestamp outside tolerance of %s", tolerance)
+		}
+	}
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return fmt.Errorf("unsupported signature format")
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attempt is the record of a single delivery attempt.
+type Attempt struct {
+	DeliveryID string        `json:"deliveryId"`
+	EndpointID string        `json:"endpointId"`
+	Event      string        `json:"event"`
+	Number     int           `json:"attempt"`
+	StatusCode int           `json:"statusCode,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	Timestamp  time.Time     `json:"timestamp"`
+}
+
+// Succeeded reports whether the attempt was accepted by the endpoint.
+func (a Attempt) Succeeded() bool { return a.Error == "" && a.StatusCode >= 200 && a.StatusCode < 300 }
+
+// DeadLetter is a delivery that exhausted its attempts or was rejected by the endpoint.
+type DeadLetter struct {
+	Delivery  Delivery  `json:"delivery"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError"`
+	FailedAt  time.Time `json:"failedAt"`
+}
+
+// IStore records delivery attempts and holds dead-lettered deliveries.
+type IStore interface {
+	RecordAttempt(attempt Attempt) error
+	Attempts(deliveryID string) ([]Attempt, error)
+	PutDeadLetter(letter DeadLetter) error
+	DeadLetters() ([]DeadLetter, error)
+	RemoveDeadLetter(deliveryID string) (DeadLetter, error)
+}
+
+// MemoryStore is an in-process IStore.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	attempts map[string][]Attempt
+	dead     map[string]DeadLetter
+	// maxDeliveries bounds the number of deliveries whose attempts are kept; 0 keeps all.
+	maxDeliveries int
+	order         []string
+}
+
+// NewMemoryStore creates an in-process store keeping the attempts of at most maxDeliveries
+// deliveries (0 keeps all of them).
+func NewMemoryStore(maxDeliveries int) *MemoryStore {
+	return &MemoryStore{
+		attempts:      make(map[string][]Attempt),
+		dead:          make(map[string]DeadLetter),
+		maxDeliveries: maxDeliveries,
+	}
+}
+
+func (s *MemoryStore) RecordAttempt(attempt Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.attempts[attempt.DeliveryID]; !exists {
+		s.order = append(s.order, attempt.DeliveryID)
+		if s.maxDeliveries > 0 && len(s.order) > s.maxDeliveries {
+			delete(s.attempts, s.order[0])
+			s.order = s.order[1:]
+		}
+	}
+	s.attempts[attempt.DeliveryID] = append(s.attempts[attempt.DeliveryID], attempt)
+	return nil
+}
+
+func (s *MemoryStore) Attempts(deliveryID string) ([]Attempt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Attempt(nil), s.attempts[deliveryID]...), nil
+}
+
+func (s *MemoryStore) PutDeadLetter(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dead[letter.Delivery.ID] = letter
+	return nil
+}
+
+func (s *MemoryStore) DeadLetters() ([]DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	letters := make([]DeadLetter, 0, len(s.dead))
+	for _, letter := range s.dead {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.Before(letters[j].FailedAt) })
+	return letters, nil
+}
+
+func (s *MemoryStore) RemoveDeadLetter(deliveryID string) (DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letter, exists := s.dead[deliveryID]
+	if !exists {
+		return DeadLetter{}, fmt.Errorf("dead letter %s not found", deliveryID)
+	}
+	delete(s.dead, deliveryID)
+	return letter, nil
+}
+
+// FileStore is an IStore keeping the dead letters in a directory, one JSON file per delivery,
+// so they survive restarts and can be redelivered by a later process. Attempts are kept in
+// memory, like a MemoryStore.
+type FileStore struct {
+	dir      string
+	attempts *MemoryStore
+}
+
+// NewFileStore opens the dead-letter directory dir, creating it when needed, and keeps the
+// attempts of at most maxDeliveries deliveries (0 keeps all of them).
+func NewFileStore(dir string, maxDeliveries int) (*FileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("dead-letter directory cannot be empty")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter directory: %w", err)
+	}
+	return &FileStore{dir: dir, attempts: NewMemoryStore(maxDeliveries)}, nil
+}
+
+// Dir returns the dead-letter directory.
+func (s *FileStore) Dir() string { return s.dir }
+
+func (s *FileStore) RecordAttempt(attempt Attempt) error { return s.attempts.RecordAttempt(attempt) }
+
+func (s *FileStore) Attempts(deliveryID string) ([]Attempt, error) {
+	return s.attempts.Attempts(deliveryID)
+}
+
+// PutDeadLetter writes the dead letter through a temporary file, so a crash never leaves a
+// partial one.
+func (s *FileStore) PutDeadLetter(letter DeadLetter) error {
+	path, err := s.path(letter.Delivery.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter %s: %w", letter.Delivery.ID, err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".dead-letter-*")
+	if err != nil {
+		return fmt.Errorf("failed to write dead letter %s: %w", letter.Delivery.ID, err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write dead letter %s: %w", letter.Delivery.ID, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write dead letter %s: %w", letter.Delivery.ID, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write dead letter %s: %w", letter.Delivery.ID, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) DeadLetters() ([]DeadLetter, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	letters := make([]DeadLetter, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+		letter, err := s.read(filepath.Join(s.dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			// Removed by a concurrent redelivery.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.Before(letters[j].FailedAt) })
+	return letters, nil
+}
+
+// RemoveDeadLetter removes and returns a dead letter. Of two processes removing the same one,
+// only the first gets it.
+func (s *FileStore) RemoveDeadLetter(deliveryID string) (DeadLetter, error) {
+	path, err := s.path(deliveryID)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	letter, err := s.read(path)
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return DeadLetter{}, fmt.Errorf("dead letter %s not found", deliveryID)
+	}
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("failed to remove dead letter %s: %w", deliveryID, err)
+	}
+	return letter, nil
+}
+
+// path returns the file of a delivery, refusing IDs that are not a plain file name.
+func (s *FileStore) path(deliveryID string) (string, error) {
+	if deliveryID == "" || deliveryID != filepath.Base(deliveryID) || strings.HasPrefix(deliveryID, ".") {
+		return "", fmt.Errorf("invalid delivery ID %q", deliveryID)
+	}
+	return filepath.Join(s.dir, deliveryID+".json"), nil
+}
+
+func (s *FileStore) read(path string) (DeadLetter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	var letter DeadLetter
+	if err := json.Unmarshal(data, &letter); err != nil {
+		return DeadLetter{}, fmt.Errorf("corrupted dead letter %s: %w", path, err)
+	}
+	return letter, nil
+}
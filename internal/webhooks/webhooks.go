@@ -0,0 +1,552 @@
+// Package webhooks delivers listener events, and the ledger events of the outbox, to HTTP
+// endpoints. Every delivery is signed with the endpoint secret, retried with exponential
+// backoff on transient failures and moved to a dead-letter store once it cannot be delivered.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rafa-mori/smart_plane/internal/broker"
+	gl "github.com/rafa-mori/smart_plane/logger"
+	t "github.com/rafa-mori/smart_plane/types"
+)
+
+// Config configures a Manager.
+type Config struct {
+	// MaxAttempts is the number of attempts of a delivery before it is dead-lettered. Defaults to 5.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry; it doubles on every retry. Defaults to 1s.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries. Defaults to 1m.
+	MaxBackoff time.Duration
+	// Timeout bounds each HTTP request. Defaults to 10s.
+	Timeout time.Duration
+	// Workers bounds the number of concurrent deliveries. Defaults to 4.
+	Workers int
+	// QueueSize bounds the deliveries waiting for a worker; Publish dead-letters the
+	// deliveries that do not fit. Defaults to 1024.
+	QueueSize int
+	// Client sends the requests. Defaults to a client with Timeout.
+	Client *http.Client
+	// Store records attempts and dead letters. Defaults to a MemoryStore keeping 1024 deliveries.
+	Store IStore
+}
+
+// Endpoint is a registered webhook receiver.
+type Endpoint struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret signs the deliveries of the endpoint; it is never serialized.
+	Secret    string    `json:"-"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Matches reports whether the endpoint accepts an event.
+func (e *Endpoint) Matches(event string) bool {
+	if !e.Active {
+		return false
+	}
+	for _, pattern := range e.Events {
+		if t.MatchEventPattern(pattern, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is a single event sent to a single endpoint.
+type Delivery struct {
+	ID         string          `json:"id"`
+	EndpointID string          `json:"endpointId"`
+	Event      string          `json:"event"`
+	Payload    json.RawMessage `json:"payload"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// job is the next attempt of a delivery.
+type job struct {
+	endpoint Endpoint
+	delivery Delivery
+	number   int
+}
+
+// retry is a job waiting for its backoff.
+type retry struct {
+	job   job
+	last  Attempt
+	timer *time.Timer
+}
+
+// Manager holds the registered endpoints and delivers events to them.
+type Manager struct {
+	mu        sync.RWMutex
+	cfg       Config
+	endpoints map[string]*Endpoint
+	// queue holds the attempts waiting for a worker; the workers start with the first one.
+	queue     chan job
+	startOnce sync.Once
+	stopOnce  sync.Once
+	// retries holds the deliveries waiting for their next attempt, by delivery ID.
+	retriesMu sync.Mutex
+	retries   map[string]*retry
+	// wg counts the deliveries not yet delivered or dead-lettered.
+	wg sync.WaitGroup
+	// ctx is cancelled on Close, interrupting pending retries.
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+}
+
+// NewManager creates a webhook manager, applying the defaults of Config.
+func NewManager(cfg Config) *Manager {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = cfg.InitialBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1024
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Timeout}
+	}
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore(1024)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		cfg:       cfg,
+		endpoints: make(map[string]*Endpoint),
+		queue:     make(chan job, cfg.QueueSize),
+		retries:   make(map[string]*retry),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Store returns the attempt and dead-letter store of the manager.
+func (m *Manager) Store() IStore { return m.cfg.Store }
+
+// RegisterEndpoint registers a receiver for every event matching one of the patterns.
+// Patterns use the listener subscription syntax, e.g. `document.*.approved` or `validation.**`.
+func (m *Manager) RegisterEndpoint(url, secret string, events ...string) (*Endpoint, error) {
+	if url == "" {
+		return nil, fmt.Errorf("endpoint url is empty")
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("endpoint secret is empty")
+	}
+	if len(events) == 0 {
+		events = []string{"**"}
+	}
+	for _, pattern := range events {
+		if err := t.ValidateEventPattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	endpoint := &Endpoint{
+		ID:        uuid.New().String(),
+		URL:       url,
+		Events:    append([]string(nil), events...),
+		Secret:    secret,
+		Active:    true,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.endpoints[endpoint.ID] = endpoint
+	gl.Log("info", fmt.Sprintf("Registered webhook endpoint %s for %v", endpoint.URL, endpoint.Events))
+	return endpoint, nil
+}
+
+// RemoveEndpoint unregisters an endpoint. Deliveries already in flight are not cancelled.
+func (m *Manager) RemoveEndpoint(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.endpoints[id]; !exists {
+		return fmt.Errorf("endpoint %s not found", id)
+	}
+	delete(m.endpoints, id)
+	return nil
+}
+
+// SetEndpointActive enables or disables an endpoint without removing it.
+func (m *Manager) SetEndpointActive(id string, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	endpoint, exists := m.endpoints[id]
+	if !exists {
+		return fmt.Errorf("endpoint %s not found", id)
+	}
+	endpoint.Active = active
+	return nil
+}
+
+// GetEndpoints returns a copy of the registered endpoints.
+func (m *Manager) GetEndpoints() []Endpoint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	endpoints := make([]Endpoint, 0, len(m.endpoints))
+	for _, endpoint := range m.endpoints {
+		endpoints = append(endpoints, *endpoint)
+	}
+	return endpoints
+}
+
+// Publish queues an event for every matching endpoint and returns the IDs of the deliveries.
+// Deliveries run in the background on the workers; Close waits for them.
+func (m *Manager) Publish(event string, payload any) ([]string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload of event %s: %w", event, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, fmt.Errorf("webhook manager is closed")
+	}
+	ids := make([]string, 0)
+	for _, endpoint := range m.endpoints {
+		if !endpoint.Matches(event) {
+			continue
+		}
+		delivery := Delivery{
+			ID:         uuid.New().String(),
+			EndpointID: endpoint.ID,
+			Event:      event,
+			Payload:    body,
+			CreatedAt:  time.Now().UTC(),
+		}
+		ids = append(ids, delivery.ID)
+		m.enqueue(job{endpoint: *endpoint, delivery: delivery, number: 1})
+	}
+	return ids, nil
+}
+
+// Redeliver sends a dead-lettered delivery again, with a fresh set of attempts.
+func (m *Manager) Redeliver(deliveryID string) error {
+	letter, err := m.cfg.Store.RemoveDeadLetter(deliveryID)
+	if err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	endpoint, exists := m.endpoints[letter.Delivery.EndpointID]
+	if !exists || m.closed {
+		_ = m.cfg.Store.PutDeadLetter(letter)
+		return fmt.Errorf("endpoint %s of delivery %s is not available", letter.Delivery.EndpointID, deliveryID)
+	}
+	m.enqueue(job{endpoint: *endpoint, delivery: letter.Delivery, number: 1})
+	return nil
+}
+
+// Attach subscribes the manager to every event of a listener matching the pattern (`**` when
+// empty). It returns the subscription reference, to be passed to Unsubscribe on shutdown.
+func (m *Manager) Attach(listener *t.ValidationListener, pattern string) (*t.Reference, error) {
+	if listener == nil {
+		return nil, fmt.Errorf("listener is nil")
+	}
+	if pattern == "" {
+		pattern = "**"
+	}
+	return listener.SubscribeEvent(pattern, func(event string, result *t.ValidationResult) {
+		if _, err := m.Publish(event, EventPayload(event, result)); err != nil {
+			gl.Log("error", fmt.Sprintf("Failed to publish webhook event %s: %v", event, err))
+		}
+	})
+}
+
+// Publisher returns the manager as a broker publisher, so that a broker.Relay feeds it the
+// ledger events of the outbox: events reach the endpoints even when they were committed by
+// another process, or before a restart. A message is accepted once its deliveries are queued.
+// Closing the publisher does not close the manager.
+func (m *Manager) Publisher() broker.IPublisher { return outboxPublisher{m: m} }
+
+// outboxPublisher publishes the messages of a relay to the endpoints of a manager.
+type outboxPublisher struct {
+	m *Manager
+}
+
+func (p outboxPublisher) Publish(ctx context.Context, message broker.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := p.m.Publish(message.Topic, MessagePayload(message))
+	return err
+}
+
+func (p outboxPublisher) Close() error { return nil }
+
+// MessagePayload converts a broker message into the JSON body of a webhook, shaped like
+// EventPayload: the fields of the ledger event are its metadata, and messageId identifies the
+// event across redeliveries.
+func MessagePayload(message broker.Message) map[string]any {
+	payload := map[string]any{
+		"event":     message.Topic,
+		"messageId": message.ID,
+		"timestamp": message.Timestamp.UTC().Format(time.RFC3339Nano),
+		"isValid":   true,
+		"message":   message.Topic,
+	}
+	var metadata map[string]any
+	if err := json.Unmarshal(message.Payload, &metadata); err == nil {
+		payload["metadata"] = metadata
+	} else if json.Valid(message.Payload) {
+		payload["data"] = message.Payload
+	}
+	return payload
+}
+
+// Close stops accepting events and waits for pending deliveries until ctx is done, when
+// pending retries are abandoned.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	defer m.stopOnce.Do(func() { close(m.queue) })
+	select {
+	case <-done:
+		m.cancel()
+		return nil
+	case <-ctx.Done():
+		m.cancel()
+		m.abandonRetries()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// enqueue counts a new delivery and queues its first attempt, dead-lettering it when the
+// queue is full. The caller must hold m.mu.
+func (m *Manager) enqueue(j job) {
+	m.wg.Add(1)
+	m.startOnce.Do(func() {
+		for i := 0; i < m.cfg.Workers; i++ {
+			go m.worker()
+		}
+	})
+	select {
+	case m.queue <- j:
+	default:
+		m.deadLetter(j, Attempt{Error: "delivery queue is full"})
+	}
+}
+
+// worker runs the queued attempts until the queue is closed.
+func (m *Manager) worker() {
+	for j := range m.queue {
+		m.run(j)
+	}
+}
+
+// run sends an attempt of a delivery. A delivery that succeeds or fails permanently is done;
+// a transient failure is retried after the backoff, until it runs out of attempts and is
+// dead-lettered.
+func (m *Manager) run(j job) {
+	last, retryable := m.attempt(j.endpoint, j.delivery, j.number)
+	if err := m.cfg.Store.RecordAttempt(last); err != nil {
+		gl.Log("error", fmt.Sprintf("Failed to record webhook attempt of %s: %v", j.delivery.ID, err))
+	}
+	switch {
+	case last.Succeeded():
+		gl.Log("debug", fmt.Sprintf("Delivered webhook %s (%s) to %s", j.delivery.ID, j.delivery.Event, j.endpoint.URL))
+		m.wg.Done()
+	case retryable && j.number < m.cfg.MaxAttempts:
+		m.schedule(j, last)
+	default:
+		m.deadLetter(j, last)
+	}
+}
+
+// schedule queues the next attempt of a delivery after its backoff. The timer holds no
+// goroutine while it waits.
+func (m *Manager) schedule(j job, last Attempt) {
+	m.retriesMu.Lock()
+	defer m.retriesMu.Unlock()
+
+	if m.ctx.Err() != nil {
+		last.Error = "delivery abandoned: webhook manager closed"
+		m.deadLetter(j, last)
+		return
+	}
+	r := &retry{job: j, last: last}
+	r.timer = time.AfterFunc(m.backoff(j.number), func() {
+		m.retriesMu.Lock()
+		delete(m.retries, j.delivery.ID)
+		m.retriesMu.Unlock()
+
+		j.number++
+		m.queue <- j
+	})
+	m.retries[j.delivery.ID] = r
+}
+
+// abandonRetries dead-letters the deliveries waiting for a retry.
+func (m *Manager) abandonRetries() {
+	m.retriesMu.Lock()
+	defer m.retriesMu.Unlock()
+
+	for id, r := range m.retries {
+		// A timer that already fired queues its attempt, which fails on the cancelled context.
+		if !r.timer.Stop() {
+			continue
+		}
+		delete(m.retries, id)
+		r.last.Error = "delivery abandoned: webhook manager closed"
+		m.deadLetter(r.job, r.last)
+	}
+}
+
+// deadLetter moves a delivery that cannot be delivered to the dead-letter store.
+func (m *Manager) deadLetter(j job, last Attempt) {
+	defer m.wg.Done()
+
+	letter := DeadLetter{
+		Delivery:  j.delivery,
+		Attempts:  last.Number,
+		LastError: last.Error,
+		FailedAt:  time.Now().UTC(),
+	}
+	if letter.LastError == "" {
+		letter.LastError = fmt.Sprintf("unexpected status %d", last.StatusCode)
+	}
+	if err := m.cfg.Store.PutDeadLetter(letter); err != nil {
+		gl.Log("error", fmt.Sprintf("Failed to dead-letter webhook %s: %v", j.delivery.ID, err))
+		return
+	}
+	gl.Log("warn", fmt.Sprintf("Webhook %s (%s) to %s dead-lettered: %s", j.delivery.ID, j.delivery.Event, j.endpoint.URL, letter.LastError))
+}
+
+// attempt sends a delivery once and reports whether a failure is worth retrying.
+func (m *Manager) attempt(endpoint Endpoint, delivery Delivery, number int) (Attempt, bool) {
+	attempt := Attempt{
+		DeliveryID: delivery.ID,
+		EndpointID: endpoint.ID,
+		Event:      delivery.Event,
+		Number:     number,
+		Timestamp:  time.Now().UTC(),
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, m.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	timestamp := attempt.Timestamp.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "smart_plane-webhooks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, delivery.Payload))
+
+	resp, err := m.cfg.Client.Do(req)
+	attempt.Duration = time.Since(attempt.Timestamp)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, m.ctx.Err() == nil
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.StatusCode = resp.StatusCode
+	if attempt.Succeeded() {
+		return attempt, false
+	}
+	attempt.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	return attempt, retryable(resp.StatusCode)
+}
+
+// backoff returns the wait before the given retry: InitialBackoff doubled per retry, capped at MaxBackoff.
+func (m *Manager) backoff(retry int) time.Duration {
+	wait := m.cfg.InitialBackoff
+	for i := 1; i < retry; i++ {
+		wait *= 2
+		if wait >= m.cfg.MaxBackoff {
+			return m.cfg.MaxBackoff
+		}
+	}
+	return wait
+}
+
+// retryable reports whether a response status is a transient failure.
+func retryable(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// EventPayload converts a listener result into the JSON body of a webhook. Metadata values
+// that cannot be encoded are dropped; nested validation results are reduced to their outcome.
+func EventPayload(event string, result *t.ValidationResult) map[string]any {
+	payload := map[string]any{
+		"event":     event,
+		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if result == nil {
+		return payload
+	}
+	payload["isValid"] = result.GetIsValid()
+	payload["message"] = result.GetMessage()
+	if err := result.GetError(); err != nil {
+		payload["error"] = err.Error()
+	}
+
+	metadata := make(map[string]any)
+	for _, key := range result.GetAllMetadataKeys() {
+		value, _ := result.GetMetadata(key)
+		if nested, ok := value.(interface {
+			GetIsValid() bool
+			GetMessage() string
+		}); ok {
+			value = map[string]any{"isValid": nested.GetIsValid(), "message": nested.GetMessage()}
+		}
+		if _, err := json.Marshal(value); err != nil {
+			continue
+		}
+		metadata[key] = value
+	}
+	payload["metadata"] = metadata
+	return payload
+}
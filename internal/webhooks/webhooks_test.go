@@ -0,0 +1,243 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rafa-mori/smart_plane/internal/broker"
+	lg "github.com/rafa-mori/smart_plane/internal/ledger"
+	st "github.com/rafa-mori/smart_plane/types"
+)
+
+// receiver is a test endpoint recording the verified deliveries.
+type receiver struct {
+	secret string
+	// fail is the number of requests answered with 500 before succeeding.
+	fail     atomic.Int32
+	mu       sync.Mutex
+	events   []string
+	payloads []map[string]any
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	if err := VerifySignature(r.secret, req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderSignature), body, time.Minute); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.fail.Add(-1) >= 0 {
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+		return
+	}
+	var payload map[string]any
+	_ = json.Unmarshal(body, &payload)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, req.Header.Get(HeaderEvent))
+	r.payloads = append(r.payloads, payload)
+}
+
+func newTestManager(t *testing.T, maxAttempts int) (*Manager, *receiver, *httptest.Server) {
+	t.Helper()
+	r := &receiver{secret: "s3cret"}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	m := NewManager(Config{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Timeout:        time.Second,
+	})
+	return m, r, server
+}
+
+func closeManager(t *testing.T, m *Manager) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestPublishDeliversSignedEvents(t *testing.T) {
+	m, r, server := newTestManager(t, 3)
+	if _, err := m.RegisterEndpoint(server.URL, r.secret, "document.*.approved"); err != nil {
+		t.Fatalf("RegisterEndpoint: %v", err)
+	}
+
+	ids, err := m.Publish("document.42.approved", map[string]string{"id": "42"})
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("Publish = %v, %v; want one delivery", ids, err)
+	}
+	if ids, _ := m.Publish("document.42.signed", nil); len(ids) != 0 {
+		t.Fatalf("Publish of an unmatched event queued %d deliveries", len(ids))
+	}
+	closeManager(t, m)
+
+	if len(r.events) != 1 || r.events[0] != "document.42.approved" {
+		t.Fatalf("received events %v, want [document.42.approved]", r.events)
+	}
+	attempts, _ := m.Store().Attempts(ids[0])
+	if len(attempts) != 1 || !attempts[0].Succeeded() {
+		t.Fatalf("attempts = %+v, want one successful attempt", attempts)
+	}
+}
+
+func TestPublishRetriesTransientFailures(t *testing.T) {
+	m, r, server := newTestManager(t, 3)
+	r.fail.Store(2)
+	if _, err := m.RegisterEndpoint(server.URL, r.secret); err != nil {
+		t.Fatalf("RegisterEndpoint: %v", err)
+	}
+
+	ids, err := m.Publish("validation.success", nil)
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	closeManager(t, m)
+
+	attempts, _ := m.Store().Attempts(ids[0])
+	if len(attempts) != 3 || !attempts[2].Succeeded() {
+		t.Fatalf("attempts = %+v, want two failures and a success", attempts)
+	}
+	if letters, _ := m.Store().DeadLetters(); len(letters) != 0 {
+		t.Fatalf("dead letters = %+v, want none", letters)
+	}
+}
+
+func TestPublishDeadLettersAfterMaxAttempts(t *testing.T) {
+	m, r, server := newTestManager(t, 2)
+	r.fail.Store(10)
+	if _, err := m.RegisterEndpoint(server.URL, r.secret); err != nil {
+		t.Fatalf("RegisterEndpoint: %v", err)
+	}
+
+	ids, err := m.Publish("validation.error", nil)
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	closeManager(t, m)
+
+	letters, _ := m.Store().DeadLetters()
+	if len(letters) != 1 || letters[0].Delivery.ID != ids[0] || letters[0].Attempts != 2 {
+		t.Fatalf("dead letters = %+v, want delivery %s after 2 attempts", letters, ids[0])
+	}
+	if _, err := m.Publish("validation.error", nil); err == nil {
+		t.Fatal("Publish after Close succeeded")
+	}
+}
+
+func TestAttachPublishesTheMatchedEventName(t *testing.T) {
+	m, r, server := newTestManager(t, 1)
+	if _, err := m.RegisterEndpoint(server.URL, r.secret, "document.*.approved"); err != nil {
+		t.Fatalf("RegisterEndpoint: %v", err)
+	}
+	listener := st.NewValidationListener()
+	if _, err := m.Attach(listener, "document.**"); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	event := "document." + st.EscapeEventSegment("a.b*") + ".approved"
+	result := st.NewValidationResult(true, "approved", nil, nil).(*st.ValidationResult)
+	result.SetName("unrelated")
+	listener.Trigger(event, result)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := listener.Close(ctx); err != nil {
+		t.Fatalf("listener Close: %v", err)
+	}
+	closeManager(t, m)
+
+	if len(r.events) != 1 || r.events[0] != event || r.payloads[0]["event"] != event {
+		t.Fatalf("received events %v, want [%s]", r.events, event)
+	}
+}
+
+func TestFileStoreKeepsDeadLettersAcrossInstances(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dead")
+	store, err := NewFileStore(dir, 0)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	m := NewManager(Config{MaxAttempts: 1, Store: store})
+	r := &receiver{secret: "s3cret"}
+	r.fail.Store(10)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	if _, err := m.RegisterEndpoint(server.URL, r.secret); err != nil {
+		t.Fatalf("RegisterEndpoint: %v", err)
+	}
+	ids, err := m.Publish("document.42.approved", nil)
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	closeManager(t, m)
+
+	reopened, err := NewFileStore(dir, 0)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	letters, err := reopened.DeadLetters()
+	if err != nil || len(letters) != 1 || letters[0].Delivery.ID != ids[0] || letters[0].Attempts != 1 {
+		t.Fatalf("DeadLetters = %+v, %v, want delivery %s", letters, err, ids[0])
+	}
+	if _, err := reopened.RemoveDeadLetter(ids[0]); err != nil {
+		t.Fatalf("RemoveDeadLetter: %v", err)
+	}
+	if _, err := store.RemoveDeadLetter(ids[0]); err == nil {
+		t.Fatal("RemoveDeadLetter of a removed dead letter succeeded")
+	}
+	if _, err := store.RemoveDeadLetter("../escape"); err == nil {
+		t.Fatal("RemoveDeadLetter of a path succeeded")
+	}
+}
+
+func TestRelayDeliversLedgerEvents(t *testing.T) {
+	m, r, server := newTestManager(t, 1)
+	if _, err := m.RegisterEndpoint(server.URL, r.secret, "document.*.approved"); err != nil {
+		t.Fatalf("RegisterEndpoint: %v", err)
+	}
+
+	ledger := lg.NewMemoryLedger()
+	message, err := lg.NewOutboxMessage("document.42.approved", "42", map[string]string{"documentId": "42"})
+	if err != nil {
+		t.Fatalf("NewOutboxMessage: %v", err)
+	}
+	write, err := message.Write()
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := ledger.Commit("tx", []lg.Write{write}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	relay := broker.NewRelay(ledger, m.Publisher())
+	if n, err := relay.Flush(context.Background()); n != 1 || err != nil {
+		t.Fatalf("Flush = %d, %v, want 1 message", n, err)
+	}
+	if err := relay.Ack(); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	closeManager(t, m)
+
+	if len(r.payloads) != 1 || r.payloads[0]["messageId"] != message.ID {
+		t.Fatalf("received payloads %v, want message %s", r.payloads, message.ID)
+	}
+	if metadata, _ := r.payloads[0]["metadata"].(map[string]any); metadata["documentId"] != "42" {
+		t.Fatalf("received metadata %v, want the ledger event", r.payloads[0]["metadata"])
+	}
+	if pending, _ := lg.PendingOutbox(ledger, 0); len(pending) != 0 {
+		t.Fatalf("pending outbox = %v, want it acknowledged", pending)
+	}
+}
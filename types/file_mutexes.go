@@ -0,0 +1,461 @@
+package types
+
+import (
+	gl "github.com/rafa-mori/smart_plane/logger"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultFileLeaseTTL is the lease of an exclusive file lock, renewed while the lock is held.
+const DefaultFileLeaseTTL = 30 * time.Second
+
+const (
+	// fileLockPollMin and fileLockPollMax bound the backoff between attempts to take a file
+	// lock, which cannot be waited on without blocking for good.
+	fileLockPollMin = 10 * time.Microsecond
+	fileLockPollMax = 5 * time.Millisecond
+)
+
+// errFlockBusy is returned by the platform lock when the file is locked by someone else.
+var errFlockBusy = errors.New("file lock is busy")
+
+// FileLease is written into the lock file by the holder of the exclusive lock.
+type FileLease struct {
+	Owner      string    `json:"owner"`
+	PID        int       `json:"pid"`
+	Host       string    `json:"host"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Expired reports whether the lease was not renewed in time.
+func (l FileLease) Expired(now time.Time) bool { return now.After(l.ExpiresAt) }
+
+// FileMutexes is an IMutexes whose locks also exclude other processes, through advisory
+// locks (flock) on a lock file. The exclusive holder keeps a lease in the lock file and renews
+// it while the lock is held, and every lock taken clears the lease of a previous holder. A
+// waiter that finds the lock held exclusively under a lease of another host that expired
+// long ago removes the lock file and starts over, recovering locks left on shared file
+// systems where the server does not release them. Conditions and wait groups stay in-process.
+type FileMutexes struct {
+	*Mutexes
+
+	path     string
+	owner    string
+	leaseTTL time.Duration
+
+	// writer is the lock file descriptor of the exclusive holder.
+	writer *os.File
+	// renewStop stops the lease renewal of the exclusive holder.
+	renewStop chan struct{}
+	renewDone chan struct{}
+
+	// readersM guards readers and reader.
+	readersM sync.Mutex
+	// readers counts the in-process shared holders; they share one shared file lock.
+	readers int
+	reader  *os.File
+
+	// lastRecovery limits the stale lease checks to one per second, in unix nanoseconds.
+	lastRecovery atomic.Int64
+}
+
+// NewFileMutexes creates file-backed mutexes on the lock file at path, creating its
+// directory when needed. A lease TTL that is not positive defaults to DefaultFileLeaseTTL.
+func NewFileMutexes(path string, leaseTTL time.Duration) (*FileMutexes, error) {
+	if path == "" {
+		return nil, fmt.Errorf("lock file path is empty")
+	}
+	if leaseTTL <= 0 {
+		leaseTTL = DefaultFileLeaseTTL
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	if _, err := flockSupported(); err != nil {
+		return nil, err
+	}
+	return &FileMutexes{
+		Mutexes:  NewMutexesType(),
+		path:     path,
+		owner:    uuid.New().String(),
+		leaseTTL: leaseTTL,
+	}, nil
+}
+
+// Path returns the lock file path.
+func (m *FileMutexes) Path() string { return m.path }
+
+// GetLease returns the lease currently written in the lock file.
+func (m *FileMutexes) GetLease() (FileLease, error) {
+	var lease FileLease
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return lease, err
+	}
+	if len(data) == 0 {
+		return lease, fmt.Errorf("lock file %s has no lease", m.path)
+	}
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return lease, fmt.Errorf("invalid lease in %s: %w", m.path, err)
+	}
+	return lease, nil
+}
+
+// MuLock locks the mutex in this process and in every process sharing the lock file. Lock
+// file errors are only logged, and leave the mutex unlocked; use MuLockCtx to handle them.
+func (m *FileMutexes) MuLock() {
+	if err := m.MuLockCtx(context.Background()); err != nil {
+		gl.Log("error", fmt.Sprintf("Failed to lock %s: %v", m.path, err))
+	}
+}
+
+// MuLockCtx locks the mutex, giving up with the context error when ctx is done first.
+func (m *FileMutexes) MuLockCtx(ctx context.Context) error {
+	if err := m.Mutexes.MuLockCtx(ctx); err != nil {
+		return err
+	}
+	var lockErr error
+	err := retryLockCtx(ctx, func() bool {
+		var ok bool
+		ok, lockErr = m.tryLockWriter()
+		return ok || lockErr != nil
+	})
+	if err == nil {
+		err = lockErr
+	}
+	if err != nil {
+		m.Mutexes.MuUnlock()
+		return err
+	}
+	return nil
+}
+
+// MuTryLock locks the mutex only if nobody holds it, in any process.
+func (m *FileMutexes) MuTryLock() bool {
+	if !m.Mutexes.MuTryLock() {
+		return false
+	}
+	if ok, _ := m.tryLockWriter(); !ok {
+		m.Mutexes.MuUnlock()
+		return false
+	}
+	return true
+}
+
+// MuUnlock clears the lease and releases the file lock, then the in-process lock.
+func (m *FileMutexes) MuUnlock() {
+	if m.renewStop != nil {
+		close(m.renewStop)
+		<-m.renewDone
+		m.renewStop, m.renewDone = nil, nil
+	}
+	if m.writer != nil {
+		_ = m.writer.Truncate(0)
+		if err := funlock(m.writer); err != nil {
+			gl.Log("error", fmt.Sprintf("Failed to unlock %s: %v", m.path, err))
+		}
+		_ = m.writer.Close()
+		m.writer = nil
+	}
+	m.Mutexes.MuUnlock()
+}
+
+// MuRLock locks the mutex for reading in this process and in every process sharing the
+// lock file. Like MuLock, it leaves the mutex unlocked on lock file errors.
+func (m *FileMutexes) MuRLock() {
+	if err := m.MuRLockCtx(context.Background()); err != nil {
+		gl.Log("error", fmt.Sprintf("Failed to read-lock %s: %v", m.path, err))
+	}
+}
+
+// MuRLockCtx locks the mutex for reading, giving up with the context error when ctx is done first.
+func (m *FileMutexes) MuRLockCtx(ctx context.Context) error {
+	if err := m.Mutexes.MuRLockCtx(ctx); err != nil {
+		return err
+	}
+
+	// readersM is only held per attempt, so that other readers join the shared file lock,
+	// or give up on their own context, while this one waits.
+	var lockErr error
+	err := retryLockCtx(ctx, func() bool {
+		m.readersM.Lock()
+		defer m.readersM.Unlock()
+
+		if m.readers > 0 {
+			m.readers++
+			return true
+		}
+		var ok bool
+		ok, lockErr = m.tryLockReader()
+		if ok {
+			m.readers = 1
+		}
+		return ok || lockErr != nil
+	})
+	if err == nil {
+		err = lockErr
+	}
+	if err != nil {
+		m.Mutexes.MuRUnlock()
+		return err
+	}
+	return nil
+}
+
+// MuTryRLock locks the mutex for reading only if no process holds it exclusively.
+func (m *FileMutexes) MuTryRLock() bool {
+	if !m.Mutexes.MuTryRLock() {
+		return false
+	}
+
+	m.readersM.Lock()
+	defer m.readersM.Unlock()
+
+	if m.readers > 0 {
+		m.readers++
+		return true
+	}
+	if ok, _ := m.tryLockReader(); !ok {
+		m.Mutexes.MuRUnlock()
+		return false
+	}
+	m.readers = 1
+	return true
+}
+
+// MuRUnlock releases a read lock; the shared file lock is released with the last reader.
+func (m *FileMutexes) MuRUnlock() {
+	m.readersM.Lock()
+	if m.readers > 0 {
+		m.readers--
+		if m.readers == 0 && m.reader != nil {
+			if err := funlock(m.reader); err != nil {
+				gl.Log("error", fmt.Sprintf("Failed to unlock %s: %v", m.path, err))
+			}
+			_ = m.reader.Close()
+			m.reader = nil
+		}
+	}
+	m.readersM.Unlock()
+
+	m.Mutexes.MuRUnlock()
+}
+
+// tryLockWriter takes the exclusive file lock without waiting. The caller must hold the
+// in-process write lock.
+func (m *FileMutexes) tryLockWriter() (bool, error) {
+	file, err := m.tryLockFile(true)
+	if file == nil || err != nil {
+		return false, err
+	}
+	m.writer = file
+
+	now := time.Now().UTC()
+	lease := FileLease{Owner: m.owner, PID: os.Getpid(), Host: hostname(), AcquiredAt: now}
+	if err := m.writeLease(lease); err != nil {
+		gl.Log("warn", fmt.Sprintf("Failed to write lease of %s: %v", m.path, err))
+	}
+	m.renewStop = make(chan struct{})
+	m.renewDone = make(chan struct{})
+	go m.renew(lease, m.writer, m.renewStop, m.renewDone)
+	return true, nil
+}
+
+// tryLockReader takes the shared file lock without waiting. The caller must hold readersM.
+// Nobody holds the exclusive lock then, so a lease left in the file is cleared.
+func (m *FileMutexes) tryLockReader() (bool, error) {
+	file, err := m.tryLockFile(false)
+	if file == nil || err != nil {
+		return false, err
+	}
+	if err := file.Truncate(0); err != nil {
+		gl.Log("warn", fmt.Sprintf("Failed to clear lease of %s: %v", m.path, err))
+	}
+	m.reader = file
+	return true, nil
+}
+
+// tryLockFile opens the lock file and locks it without waiting. It returns a nil file when
+// the lock is busy. A lock taken on a file that was replaced meanwhile is dropped.
+func (m *FileMutexes) tryLockFile(exclusive bool) (*os.File, error) {
+	file, err := os.OpenFile(m.path, os.O_CREATE|os.O_RDWR, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", m.path, err)
+	}
+	if err := flock(file, exclusive); err != nil {
+		_ = file.Close()
+		if errors.Is(err, errFlockBusy) {
+			m.recoverStale()
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", m.path, err)
+	}
+	// A stale lock recovery may have removed the file between open and lock.
+	opened, err := file.Stat()
+	current, statErr := os.Stat(m.path)
+	if err != nil || statErr != nil || !os.SameFile(opened, current) {
+		_ = funlock(file)
+		_ = file.Close()
+		return nil, nil
+	}
+	return file, nil
+}
+
+// writeLease replaces the lease in the lock file held by the writer.
+func (m *FileMutexes) writeLease(lease FileLease) error {
+	lease.ExpiresAt = time.Now().UTC().Add(m.leaseTTL)
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	if err := m.writer.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := m.writer.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return m.writer.Sync()
+}
+
+// renew extends the lease every third of its TTL until stopped.
+func (m *FileMutexes) renew(lease FileLease, writer *os.File, stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(m.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		lease.ExpiresAt = time.Now().UTC().Add(m.leaseTTL)
+		data, err := json.Marshal(lease)
+		if err == nil {
+			if err = writer.Truncate(0); err == nil {
+				_, err = writer.WriteAt(data, 0)
+			}
+		}
+		if err != nil {
+			gl.Log("warn", fmt.Sprintf("Failed to renew lease of %s: %v", m.path, err))
+		}
+	}
+}
+
+// recoverStale removes the lock file when it is held exclusively under a lease of another
+// host that missed its renewals for a whole extra TTL. The kernel releases the locks of
+// dead processes on this host, so a lease of this host never proves a stale holder, and a
+// lock only held shared is never stale: shared holders write no lease.
+func (m *FileMutexes) recoverStale() {
+	now := time.Now()
+	last := m.lastRecovery.Load()
+	if now.Sub(time.Unix(0, last)) < time.Second || !m.lastRecovery.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	lease, err := m.GetLease()
+	if err != nil || lease.Host == hostname() || !lease.Expired(now.Add(-m.leaseTTL)) {
+		return
+	}
+	if !m.heldExclusive() {
+		return
+	}
+	// A new holder writes its lease right after taking the lock: only the same lease,
+	// read again once the exclusive holder is confirmed, is stale.
+	if again, err := m.GetLease(); err != nil || again != lease {
+		return
+	}
+	if err := os.Remove(m.path); err != nil && !os.IsNotExist(err) {
+		gl.Log("error", fmt.Sprintf("Failed to recover stale lock %s: %v", m.path, err))
+		return
+	}
+	gl.Log("warn", fmt.Sprintf("Recovered stale lock %s of process %d on %s, expired at %s", m.path, lease.PID, lease.Host, lease.ExpiresAt.Format(time.RFC3339)))
+}
+
+// heldExclusive probes the lock file with a shared lock, reporting whether someone holds
+// it exclusively.
+func (m *FileMutexes) heldExclusive() bool {
+	file, err := os.Open(m.path)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = file.Close() }()
+
+	if err := flock(file, false); err != nil {
+		return errors.Is(err, errFlockBusy)
+	}
+	_ = funlock(file)
+	return false
+}
+
+// Close releases the file locks still held by this process, for owners that are done with
+// the lock file. The in-process locks are left as they are.
+func (m *FileMutexes) Close() error {
+	if m.renewStop != nil {
+		close(m.renewStop)
+		<-m.renewDone
+		m.renewStop, m.renewDone = nil, nil
+	}
+	var err error
+	if m.writer != nil {
+		_ = m.writer.Truncate(0)
+		err = errors.Join(funlock(m.writer), m.writer.Close())
+		m.writer = nil
+	}
+
+	m.readersM.Lock()
+	defer m.readersM.Unlock()
+	if m.reader != nil {
+		err = errors.Join(err, funlock(m.reader), m.reader.Close())
+		m.reader, m.readers = nil, 0
+	}
+	return err
+}
+
+// hostname returns the host name, or an empty string when it is unknown.
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// retryLockCtx retries tryLock with an exponential backoff until it succeeds or ctx is done.
+// Nothing is left behind on cancellation: no goroutine keeps waiting for the lock.
+func retryLockCtx(ctx context.Context, tryLock func() bool) error {
+	if tryLock() {
+		return nil
+	}
+	wait := fileLockPollMin
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The lock may have been freed just now; prefer reporting the cancellation.
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if tryLock() {
+			return nil
+		}
+		if wait < fileLockPollMax {
+			wait *= 2
+			if wait > fileLockPollMax {
+				wait = fileLockPollMax
+			}
+		}
+		timer.Reset(wait)
+	}
+}
@@ -0,0 +1,235 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package types
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The lock helper process holds the lock file of FILE_MUTEXES_HELPER in the mode of
+// FILE_MUTEXES_MODE, reports it on stdout and releases it when stdin is closed.
+const (
+	helperPathEnv = "FILE_MUTEXES_HELPER"
+	helperModeEnv = "FILE_MUTEXES_MODE"
+)
+
+func TestFileMutexesHelperProcess(t *testing.T) {
+	path := os.Getenv(helperPathEnv)
+	if path == "" {
+		t.Skip("only run as a lock helper process")
+	}
+	m, err := NewFileMutexes(path, time.Second)
+	if err != nil {
+		t.Fatalf("NewFileMutexes: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exclusive := os.Getenv(helperModeEnv) == "exclusive"
+	if exclusive {
+		err = m.MuLockCtx(ctx)
+	} else {
+		err = m.MuRLockCtx(ctx)
+	}
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	_, _ = os.Stdout.WriteString("locked\n")
+	_, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+	if exclusive {
+		m.MuUnlock()
+	} else {
+		m.MuRUnlock()
+	}
+}
+
+// lockHelper is another process holding the lock file.
+type lockHelper struct {
+	cmd     *exec.Cmd
+	release func()
+}
+
+// startLockHelper starts a process holding the lock file at path, in exclusive or shared
+// mode, and waits until it holds the lock.
+func startLockHelper(t *testing.T, path string, exclusive bool) *lockHelper {
+	t.Helper()
+	mode := "shared"
+	if exclusive {
+		mode = "exclusive"
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFileMutexesHelperProcess$")
+	cmd.Env = append(os.Environ(), helperPathEnv+"="+path, helperModeEnv+"="+mode)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	helper := &lockHelper{cmd: cmd, release: func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	}}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		helper.release()
+	})
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "locked\n" {
+		t.Fatalf("lock helper reported %q: %v", line, err)
+	}
+	return helper
+}
+
+func newTestFileMutexes(t *testing.T, path string) *FileMutexes {
+	t.Helper()
+	m, err := NewFileMutexes(path, time.Second)
+	if err != nil {
+		t.Fatalf("NewFileMutexes: %v", err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+// requireLockTimesOut fails unless locking waits until the context is done.
+func requireLockTimesOut(t *testing.T, lock func(ctx context.Context) error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := lock(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("lock = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestFileMutexesExcludeOtherProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	m := newTestFileMutexes(t, path)
+
+	writer := startLockHelper(t, path, true)
+	if m.MuTryLock() || m.MuTryRLock() {
+		t.Fatal("locked a file held exclusively by another process")
+	}
+	requireLockTimesOut(t, m.MuRLockCtx)
+	if lease, err := m.GetLease(); err != nil || lease.PID != writer.cmd.Process.Pid {
+		t.Fatalf("GetLease = %+v, %v, want the lease of process %d", lease, err, writer.cmd.Process.Pid)
+	}
+
+	writer.release()
+	if err := m.MuLockCtx(context.Background()); err != nil {
+		t.Fatalf("MuLockCtx after release: %v", err)
+	}
+	if lease, err := m.GetLease(); err != nil || lease.PID != os.Getpid() {
+		t.Fatalf("GetLease = %+v, %v, want the lease of this process", lease, err)
+	}
+	m.MuUnlock()
+}
+
+func TestFileMutexesShareReadLocksBetweenProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	m := newTestFileMutexes(t, path)
+
+	first := startLockHelper(t, path, false)
+	second := startLockHelper(t, path, false)
+	if !m.MuTryRLock() {
+		t.Fatal("MuTryRLock failed while other processes only read")
+	}
+	m.MuRUnlock()
+	requireLockTimesOut(t, m.MuLockCtx)
+
+	first.release()
+	requireLockTimesOut(t, m.MuLockCtx)
+	second.release()
+	if !m.MuTryLock() {
+		t.Fatal("MuTryLock failed once the readers were gone")
+	}
+	m.MuUnlock()
+}
+
+func TestFileMutexesKeepTheLockFileOfLiveReaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		lease FileLease
+	}{
+		{name: "dead process on this host", lease: FileLease{PID: 1 << 30, Host: hostname()}},
+		{name: "other host", lease: FileLease{PID: 1, Host: "gone.example"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.lock")
+			m := newTestFileMutexes(t, path)
+			startLockHelper(t, path, false)
+			startLockHelper(t, path, false)
+
+			// A writer that crashed left its lease behind, long expired.
+			tt.lease.ExpiresAt = time.Now().Add(-time.Hour)
+			data, err := json.Marshal(tt.lease)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if err := os.WriteFile(path, data, 0o640); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+			defer cancel()
+			if err := m.MuLockCtx(ctx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("MuLockCtx = %v, want a writer excluded by the readers", err)
+			}
+			after, err := os.Stat(path)
+			if err != nil || !os.SameFile(before, after) {
+				t.Fatalf("lock file of the live readers was replaced: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileMutexesReadLockClearsALeftoverLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	data, err := json.Marshal(FileLease{PID: 1 << 30, Host: hostname(), ExpiresAt: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	startLockHelper(t, path, false)
+	m := newTestFileMutexes(t, path)
+	if _, err := m.GetLease(); err == nil {
+		t.Fatal("the lease of a crashed writer survived a read lock")
+	}
+}
+
+func TestFileMutexesCloseReleasesTheFileLocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	m := newTestFileMutexes(t, path)
+	if !m.MuTryRLock() {
+		t.Fatal("MuTryRLock failed")
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	other := newTestFileMutexes(t, path)
+	if !other.MuTryLock() {
+		t.Fatal("MuTryLock failed after the reader closed its file locks")
+	}
+	other.MuUnlock()
+}
@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package types
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// flockSupported reports whether advisory file locks are available on this platform.
+func flockSupported() (bool, error) {
+	return false, fmt.Errorf("file locks are not supported on %s", runtime.GOOS)
+}
+
+func flock(file *os.File, exclusive bool) error {
+	return fmt.Errorf("file locks are not supported on %s", runtime.GOOS)
+}
+
+func funlock(file *os.File) error { return nil }
@@ -0,0 +1,36 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package types
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// flockSupported reports whether advisory file locks are available on this platform.
+func flockSupported() (bool, error) { return true, nil }
+
+// flock takes an advisory lock on the file without waiting.
+func flock(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			return errFlockBusy
+		default:
+			return err
+		}
+	}
+}
+
+// funlock releases the advisory lock on the file.
+func funlock(file *os.File) error { return syscall.Flock(int(file.Fd()), syscall.LOCK_UN) }
@@ -0,0 +1,187 @@
+package types
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultKeyedMutexesTTL is how long an unused key entry is kept before it is cleaned up.
+const DefaultKeyedMutexesTTL = time.Minute
+
+// keyedEntry is the lock of a single key.
+type keyedEntry struct {
+	// held is set while a holder owns the key.
+	held bool
+	// queue holds the waiters of the key, oldest first. Unlock hands the key to queue[0].
+	queue []chan struct{}
+	// refs counts the holder and the waiters of the key.
+	refs int
+	// idleSince is the time refs dropped to zero.
+	idleSince time.Time
+}
+
+// KeyedMutexes is a set of exclusive locks addressed by key. Waiters of a key are served
+// in arrival order, and a key entry lives while it is referenced plus TTL, so hot keys are
+// not reallocated on every lock while unused keys do not pile up.
+type KeyedMutexes struct {
+	mu      sync.Mutex
+	entries map[string]*keyedEntry
+	// ttl is how long an unreferenced entry is kept.
+	ttl time.Duration
+	// lastSweep is the time of the last cleanup of idle entries.
+	lastSweep time.Time
+}
+
+// NewKeyedMutexes creates a keyed lock set whose idle entries are dropped after ttl
+// (DefaultKeyedMutexesTTL when ttl is not positive).
+func NewKeyedMutexes(ttl time.Duration) *KeyedMutexes {
+	if ttl <= 0 {
+		ttl = DefaultKeyedMutexesTTL
+	}
+	return &KeyedMutexes{
+		entries:   make(map[string]*keyedEntry),
+		ttl:       ttl,
+		lastSweep: time.Now(),
+	}
+}
+
+// Lock acquires the lock of a key, waiting behind earlier callers until ctx is done. It
+// returns the function releasing the lock, which is safe to call more than once.
+func (km *KeyedMutexes) Lock(ctx context.Context, key string) (func(), error) {
+	if km == nil {
+		return nil, fmt.Errorf("KeyedMutexes is nil")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	km.mu.Lock()
+	km.sweep()
+	entry, exists := km.entries[key]
+	if !exists {
+		entry = &keyedEntry{}
+		km.entries[key] = entry
+	}
+	entry.refs++
+	if !entry.held && len(entry.queue) == 0 {
+		entry.held = true
+		km.mu.Unlock()
+		return km.unlocker(key), nil
+	}
+	ch := make(chan struct{})
+	entry.queue = append(entry.queue, ch)
+	km.mu.Unlock()
+
+	select {
+	case <-ch:
+		return km.unlocker(key), nil
+	case <-ctx.Done():
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	for i, waiter := range entry.queue {
+		if waiter == ch {
+			entry.queue = append(entry.queue[:i], entry.queue[i+1:]...)
+			km.release(entry)
+			return nil, ctx.Err()
+		}
+	}
+	// The key was handed over while giving up: pass it on.
+	km.unlock(entry)
+	return nil, ctx.Err()
+}
+
+// TryLock acquires the lock of a key only if it is free and nobody is waiting for it.
+func (km *KeyedMutexes) TryLock(key string) (func(), bool) {
+	if km == nil {
+		return nil, false
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	km.sweep()
+	entry, exists := km.entries[key]
+	if !exists {
+		entry = &keyedEntry{}
+		km.entries[key] = entry
+	}
+	if entry.held || len(entry.queue) > 0 {
+		return nil, false
+	}
+	entry.held = true
+	entry.refs++
+	return km.unlocker(key), true
+}
+
+// Len returns the number of key entries, including idle entries not yet cleaned up.
+func (km *KeyedMutexes) Len() int {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	return len(km.entries)
+}
+
+// Waiting returns the number of callers waiting for a key.
+func (km *KeyedMutexes) Waiting(key string) int {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if entry, exists := km.entries[key]; exists {
+		return len(entry.queue)
+	}
+	return 0
+}
+
+// unlocker returns the release function of a held key.
+func (km *KeyedMutexes) unlocker(key string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			km.mu.Lock()
+			defer km.mu.Unlock()
+
+			if entry, exists := km.entries[key]; exists {
+				km.unlock(entry)
+			}
+		})
+	}
+}
+
+// unlock hands a held key to its oldest waiter, or frees it. The caller must hold km.mu.
+func (km *KeyedMutexes) unlock(entry *keyedEntry) {
+	if len(entry.queue) > 0 {
+		close(entry.queue[0])
+		entry.queue = entry.queue[1:]
+	} else {
+		entry.held = false
+	}
+	km.release(entry)
+}
+
+// release drops a reference to an entry. The caller must hold km.mu.
+func (km *KeyedMutexes) release(entry *keyedEntry) {
+	entry.refs--
+	if entry.refs == 0 {
+		entry.idleSince = time.Now()
+	}
+}
+
+// sweep drops the entries idle for longer than the TTL, at most once per TTL. The caller
+// must hold km.mu.
+func (km *KeyedMutexes) sweep() {
+	now := time.Now()
+	if now.Sub(km.lastSweep) < km.ttl {
+		return
+	}
+	km.lastSweep = now
+	for key, entry := range km.entries {
+		if entry.refs == 0 && now.Sub(entry.idleSince) >= km.ttl {
+			delete(km.entries, key)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWaiters polls until n callers are queued on a key.
+func waitForWaiters(t *testing.T, km *KeyedMutexes, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for km.Waiting(key) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Waiting(%q) = %d, want %d", key, km.Waiting(key), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKeyedMutexesServesWaitersInArrivalOrder(t *testing.T) {
+	km := NewKeyedMutexes(time.Minute)
+	unlock, err := km.Lock(context.Background(), "doc")
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	var mu sync.Mutex
+	order := make([]int, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := km.Lock(context.Background(), "doc")
+			if err != nil {
+				t.Errorf("Lock of waiter %d: %v", i, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			release()
+		}()
+		// Queue the waiters one at a time, so the arrival order is known.
+		waitForWaiters(t, km, "doc", i+1)
+	}
+	unlock()
+	wg.Wait()
+
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("waiters served in order %v, want arrival order", order)
+		}
+	}
+}
+
+func TestKeyedMutexesIndependentKeys(t *testing.T) {
+	km := NewKeyedMutexes(time.Minute)
+	unlock, err := km.Lock(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("Lock a: %v", err)
+	}
+	defer unlock()
+
+	release, ok := km.TryLock("b")
+	if !ok {
+		t.Fatal("TryLock b failed while only a is held")
+	}
+	release()
+	if _, ok := km.TryLock("a"); ok {
+		t.Fatal("TryLock a succeeded while a is held")
+	}
+}
+
+func TestKeyedMutexesCancelledWaiterLeavesTheQueue(t *testing.T) {
+	km := NewKeyedMutexes(time.Minute)
+	unlock, err := km.Lock(context.Background(), "doc")
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := km.Lock(ctx, "doc"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lock with expired context = %v, want deadline exceeded", err)
+	}
+	if n := km.Waiting("doc"); n != 0 {
+		t.Fatalf("Waiting = %d after the waiter gave up, want 0", n)
+	}
+
+	// Releasing twice must not hand the key out twice.
+	unlock()
+	unlock()
+	release, ok := km.TryLock("doc")
+	if !ok {
+		t.Fatal("TryLock failed after unlock")
+	}
+	if _, ok := km.TryLock("doc"); ok {
+		t.Fatal("second TryLock succeeded")
+	}
+	release()
+}
+
+func TestKeyedMutexesDropsIdleEntriesAfterTTL(t *testing.T) {
+	ttl := 20 * time.Millisecond
+	km := NewKeyedMutexes(ttl)
+	for _, key := range []string{"a", "b", "c"} {
+		release, err := km.Lock(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Lock %s: %v", key, err)
+		}
+		release()
+	}
+	held, err := km.Lock(context.Background(), "held")
+	if err != nil {
+		t.Fatalf("Lock held: %v", err)
+	}
+	defer held()
+	if n := km.Len(); n != 4 {
+		t.Fatalf("Len = %d before the TTL, want 4", n)
+	}
+
+	time.Sleep(2 * ttl)
+	release, ok := km.TryLock("d")
+	if !ok {
+		t.Fatal("TryLock d failed")
+	}
+	release()
+	// The idle entries are gone; the held key and the new one remain.
+	if n := km.Len(); n != 2 {
+		t.Fatalf("Len = %d after the TTL, want 2", n)
+	}
+}
@@ -3,7 +3,10 @@ package types
 import (
 	gl "github.com/rafa-mori/smart_plane/logger"
 
+	"container/list"
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,6 +28,10 @@ type IMutexes interface {
 	SetMuSharedCtxValidate(validate func(any) (bool, error))
 	MuWaitCondWithTimeout(timeout time.Duration) bool
 
+	MuLockCtx(ctx context.Context) error
+	MuRLockCtx(ctx context.Context) error
+	MuWaitCondCtx(ctx context.Context) error
+
 	MuAdd(delta int)
 	MuDone()
 	MuWait()
@@ -32,10 +39,6 @@ type IMutexes interface {
 
 // muCtx is the mutex context map
 type muCtx struct {
-	// MuCtxM is a mutex for the ctx map.
-	MuCtxM *sync.RWMutex
-	// MuCtxL is a mutex for sync.Cond in the ctx map.
-	MuCtxL *sync.RWMutex
 	// MuCtxCond is a condition variable for the ctx map.
 	MuCtxCond *sync.Cond
 	// MuCtxWg is a wait group for the ctx map.
@@ -45,7 +48,6 @@ type muCtx struct {
 // newMuCtx creates a new mutex context map
 func newMuCtx(mSharedCtxM *sync.RWMutex) *muCtx {
 	mu := &muCtx{
-		MuCtxM:    &sync.RWMutex{},
 		MuCtxCond: sync.NewCond(mSharedCtxM),
 		MuCtxWg:   &sync.WaitGroup{},
 	}
@@ -57,10 +59,9 @@ type Mutexes struct {
 	// muCtx is the mutex context map
 	*muCtx
 
-	// MuCtxM is a mutex for the ctx map.
-	MuCtxM *sync.RWMutex
-	// MuCtxL is a mutex for sync.Cond in the ctx map.
-	MuCtxL *sync.RWMutex
+	// muRW is the lock of MuLock and MuRLock: one read-write lock, so readers and writers
+	// exclude each other.
+	muRW ctxRWMutex
 	// MuCtxCond is a condition variable for the ctx map.
 	MuCtxCond *sync.Cond
 	// MuCtxWg is a wait group for the ctx map.
@@ -72,13 +73,24 @@ type Mutexes struct {
 	muSharedCtx any
 	// muSharedCtxValidate is the shared context validation function. This is used to validate the shared context defining if it needs to wait or not.
 	muSharedCtxValidate func(any) (bool, error)
+
+	// muWaitM guards muWaiters.
+	muWaitM sync.Mutex
+	// muWaiters holds one channel per goroutine waiting on the condition, oldest first.
+	// Signal closes the oldest, Broadcast closes them all.
+	muWaiters []chan struct{}
+
+	// metrics is the lock instrumentation, nil unless EnableMetrics was called.
+	metrics atomic.Pointer[mutexMetrics]
+	// readers counts the read locks held, tracked or not, so the metrics can tell a read lock
+	// released by another goroutine from one taken before they were enabled. The metrics
+	// count the locks they track themselves, along with their holds.
+	readers atomic.Int64
 }
 
 // NewMutexesType creates a new mutex context map struct pointer.
 func NewMutexesType() *Mutexes {
 	mu := &Mutexes{
-		MuCtxM:              &sync.RWMutex{},
-		MuCtxL:              &sync.RWMutex{},
 		MuCtxWg:             &sync.WaitGroup{},
 		muSharedM:           &sync.RWMutex{},
 		muSharedCtx:         nil,
@@ -93,16 +105,41 @@ func NewMutexesType() *Mutexes {
 func NewMutexes() IMutexes { return NewMutexesType() }
 
 // MuLock locks the mutex
-func (m *Mutexes) MuLock() { m.MuCtxM.Lock() }
+func (m *Mutexes) MuLock() {
+	if mm := m.metrics.Load(); mm != nil {
+		mm.lock(LockModeWrite, m.muRW.TryLock, m.muRW.Lock)
+		return
+	}
+	m.muRW.Lock()
+}
 
 // MuUnlock unlocks the mutex
-func (m *Mutexes) MuUnlock() { m.MuCtxM.Unlock() }
+func (m *Mutexes) MuUnlock() {
+	if mm := m.metrics.Load(); mm != nil {
+		mm.released(LockModeWrite)
+	}
+	m.muRW.Unlock()
+}
 
 // MuRLock locks the mutex for reading
-func (m *Mutexes) MuRLock() { m.MuCtxL.RLock() }
+func (m *Mutexes) MuRLock() {
+	if mm := m.metrics.Load(); mm != nil {
+		mm.lock(LockModeRead, m.muRW.TryRLock, m.muRW.RLock)
+		return
+	}
+	m.muRW.RLock()
+	m.readers.Add(1)
+}
 
 // MuRUnlock unlocks the mutex for reading
-func (m *Mutexes) MuRUnlock() { m.MuCtxL.RUnlock() }
+func (m *Mutexes) MuRUnlock() {
+	if mm := m.metrics.Load(); mm != nil {
+		mm.released(LockModeRead)
+	} else {
+		m.readers.Add(-1)
+	}
+	m.muRW.RUnlock()
+}
 
 // GetMuSharedCtx returns the shared context
 func (m *Mutexes) GetMuSharedCtx() any {
@@ -136,32 +173,61 @@ func (m *Mutexes) SetMuSharedCtxValidate(validate func(any) (bool, error)) {
 	m.muSharedCtxValidate = validate
 }
 
-// MuWaitCondWithTimeout waits for the condition variable to be signaled with a timeout
+// MuWaitCondWithTimeout waits for the condition variable to be signaled with a timeout.
+// It returns false when the timeout expires first. The caller must hold MuCtxCond.L, as
+// for MuWaitCondCtx.
 func (m *Mutexes) MuWaitCondWithTimeout(timeout time.Duration) bool {
-	timer := time.NewTimer(timeout)
-	defer timer.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return m.MuWaitCondCtx(ctx) == nil
+}
+
+// MuWaitCond waits for the condition variable to be signaled. The caller must hold
+// MuCtxCond.L, as for MuWaitCondCtx.
+func (m *Mutexes) MuWaitCond() {
+	_ = m.MuWaitCondCtx(context.Background())
+}
 
+// MuWaitCondCtx waits for the condition variable to be signaled or for ctx to be done, in
+// which case it returns the context error. As with sync.Cond.Wait, the caller must hold
+// MuCtxCond.L: the waiter is registered before the lock is released, so a signal sent after
+// the caller checked its condition is never missed, and the lock is held again on return.
+// A cancelled waiter is removed from the wait list, so it does not consume a later signal.
+func (m *Mutexes) MuWaitCondCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	ch := make(chan struct{})
-	go func() {
-		m.MuCtxCond.Wait()
-		close(ch)
-	}()
+
+	m.muWaitM.Lock()
+	m.muWaiters = append(m.muWaiters, ch)
+	m.muWaitM.Unlock()
+
+	m.MuCtxCond.L.Unlock()
+	defer m.MuCtxCond.L.Lock()
 
 	select {
 	case <-ch:
-		return true
-	case <-timer.C:
-		return false
+		return nil
+	case <-ctx.Done():
 	}
-}
 
-// MuWaitCond waits for the condition variable to be signaled
-func (m *Mutexes) MuWaitCond() {
+	m.muWaitM.Lock()
+	defer m.muWaitM.Unlock()
 
-	m.MuCtxCond.Wait()
+	for i, waiter := range m.muWaiters {
+		if waiter == ch {
+			m.muWaiters = append(m.muWaiters[:i], m.muWaiters[i+1:]...)
+			return ctx.Err()
+		}
+	}
+	// Signaled while being cancelled: the signal was delivered to this waiter.
+	return nil
 }
 
-// MuSignalCond signals the condition variable
+// MuSignalCond signals the condition variable, waking the oldest waiter. Waiters of
+// MuWaitCondCtx come first; MuCtxCond is only signaled when none is waiting.
 func (m *Mutexes) MuSignalCond() {
 	m.muSharedM.Lock()
 	defer m.muSharedM.Unlock()
@@ -175,12 +241,29 @@ func (m *Mutexes) MuSignalCond() {
 	}
 
 	gl.LogObjLogger(m, "info", "Signaling condition variable")
+
+	m.muWaitM.Lock()
+	defer m.muWaitM.Unlock()
+
+	if len(m.muWaiters) > 0 {
+		close(m.muWaiters[0])
+		m.muWaiters = m.muWaiters[1:]
+		return
+	}
 	m.MuCtxCond.Signal()
 }
 
 // MuBroadcastCond broadcasts the condition variable
 func (m *Mutexes) MuBroadcastCond() {
 	m.MuCtxCond.Broadcast()
+
+	m.muWaitM.Lock()
+	defer m.muWaitM.Unlock()
+
+	for _, waiter := range m.muWaiters {
+		close(waiter)
+	}
+	m.muWaiters = nil
 }
 
 // MuAdd adds a delta to the wait group counter
@@ -193,15 +276,206 @@ func (m *Mutexes) MuDone() { m.MuCtxWg.Done() }
 func (m *Mutexes) MuWait() { m.MuCtxWg.Wait() }
 
 func (m *Mutexes) MuTryLock() bool {
-	if m.MuCtxM.TryLock() {
+	if m.muRW.TryLock() {
+		if mm := m.metrics.Load(); mm != nil {
+			mm.acquired(LockModeWrite, goroutineID())
+		}
 		return true
 	}
 	return false
 }
 
 func (m *Mutexes) MuTryRLock() bool {
-	if m.MuCtxL.TryRLock() {
+	if m.muRW.TryRLock() {
+		if mm := m.metrics.Load(); mm != nil {
+			mm.acquired(LockModeRead, goroutineID())
+		} else {
+			m.readers.Add(1)
+		}
 		return true
 	}
 	return false
 }
+
+// MuLockCtx locks the mutex, giving up with the context error when ctx is done first.
+func (m *Mutexes) MuLockCtx(ctx context.Context) error {
+	return m.muAcquireCtx(ctx, LockModeWrite, m.muRW.TryLock, m.muRW.LockCtx)
+}
+
+// MuRLockCtx locks the mutex for reading, giving up with the context error when ctx is done
+// first. It is released with MuRUnlock.
+func (m *Mutexes) MuRLockCtx(ctx context.Context) error {
+	return m.muAcquireCtx(ctx, LockModeRead, m.muRW.TryRLock, m.muRW.RLockCtx)
+}
+
+// muAcquireCtx acquires a lock with lockCtx, recording the wait when metrics are enabled.
+func (m *Mutexes) muAcquireCtx(ctx context.Context, mode LockMode, tryLock func() bool, lockCtx func(context.Context) error) error {
+	mm := m.metrics.Load()
+	if mm == nil {
+		if err := lockCtx(ctx); err != nil {
+			return err
+		}
+		if mode == LockModeRead {
+			m.readers.Add(1)
+		}
+		return nil
+	}
+	goroutine := goroutineID()
+	mm.monitor.acquiring(mm, mode, goroutine)
+
+	start := time.Now()
+	if !tryLock() {
+		mm.contended.Add(1)
+		if err := lockCtx(ctx); err != nil {
+			return err
+		}
+	}
+	mm.wait.observe(time.Since(start))
+	mm.acquired(mode, goroutine)
+	return nil
+}
+
+// ctxRWMutex is a read-write lock whose acquisitions can give up when a context is done.
+// Waiters queue in arrival order and are handed the lock on release, without polling: a
+// queued writer holds back the readers that arrive after it, and consecutive readers at the
+// head of the queue are let in together. As with sync.RWMutex, a goroutine taking a read
+// lock it already holds deadlocks once a writer is queued.
+type ctxRWMutex struct {
+	mu      sync.Mutex
+	writer  bool
+	readers int
+	// waiters holds the *rwWaiter queued for the lock, oldest first.
+	waiters list.List
+}
+
+// rwWaiter is a caller queued on a ctxRWMutex. ready is closed once it holds the lock.
+type rwWaiter struct {
+	write bool
+	ready chan struct{}
+}
+
+// Lock locks for writing.
+func (rw *ctxRWMutex) Lock() { _ = rw.acquire(context.Background(), true) }
+
+// LockCtx locks for writing, giving up with the context error when ctx is done first.
+func (rw *ctxRWMutex) LockCtx(ctx context.Context) error { return rw.acquire(ctx, true) }
+
+// RLock locks for reading.
+func (rw *ctxRWMutex) RLock() { _ = rw.acquire(context.Background(), false) }
+
+// RLockCtx locks for reading, giving up with the context error when ctx is done first.
+func (rw *ctxRWMutex) RLockCtx(ctx context.Context) error { return rw.acquire(ctx, false) }
+
+// TryLock locks for writing only if the lock is free and nobody is queued.
+func (rw *ctxRWMutex) TryLock() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.tryLocked(true)
+}
+
+// TryRLock locks for reading only if no writer holds the lock or is queued.
+func (rw *ctxRWMutex) TryRLock() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.tryLocked(false)
+}
+
+// Unlock releases the write lock.
+func (rw *ctxRWMutex) Unlock() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if !rw.writer {
+		panic("types: Unlock of an unlocked ctxRWMutex")
+	}
+	rw.writer = false
+	rw.grantLocked()
+}
+
+// RUnlock releases a read lock.
+func (rw *ctxRWMutex) RUnlock() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.readers == 0 {
+		panic("types: RUnlock of an unlocked ctxRWMutex")
+	}
+	rw.readers--
+	rw.grantLocked()
+}
+
+// acquire takes the lock at once when possible, else queues and waits to be handed it. A
+// waiter cancelled after being handed the lock releases it again.
+func (rw *ctxRWMutex) acquire(ctx context.Context, write bool) error {
+	rw.mu.Lock()
+	if rw.tryLocked(write) {
+		rw.mu.Unlock()
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		rw.mu.Unlock()
+		return err
+	}
+	waiter := &rwWaiter{write: write, ready: make(chan struct{})}
+	element := rw.waiters.PushBack(waiter)
+	rw.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		// Handed the lock while being cancelled: pass it on.
+		if write {
+			rw.writer = false
+		} else {
+			rw.readers--
+		}
+	default:
+		rw.waiters.Remove(element)
+	}
+	// A cancelled writer may have been holding back the readers queued after it.
+	rw.grantLocked()
+	return ctx.Err()
+}
+
+// tryLocked takes the lock if it is free for the mode and nobody is queued. The caller must
+// hold rw.mu.
+func (rw *ctxRWMutex) tryLocked(write bool) bool {
+	if rw.writer || rw.waiters.Len() > 0 || (write && rw.readers > 0) {
+		return false
+	}
+	if write {
+		rw.writer = true
+	} else {
+		rw.readers++
+	}
+	return true
+}
+
+// grantLocked hands the lock to the waiters at the head of the queue that can hold it: one
+// writer once the lock is free, or every reader up to the next writer while no writer holds
+// it. The caller must hold rw.mu.
+func (rw *ctxRWMutex) grantLocked() {
+	for front := rw.waiters.Front(); front != nil && !rw.writer; front = rw.waiters.Front() {
+		waiter := front.Value.(*rwWaiter)
+		if waiter.write {
+			if rw.readers > 0 {
+				return
+			}
+			rw.writer = true
+		} else {
+			rw.readers++
+		}
+		rw.waiters.Remove(front)
+		close(waiter.ready)
+	}
+}
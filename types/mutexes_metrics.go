@@ -0,0 +1,707 @@
+package types
+
+import (
+	gl "github.com/rafa-mori/smart_plane/logger"
+
+	"bytes"
+	"fmt"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LockMode tells write locks from read locks in lock metrics.
+type LockMode string
+
+const (
+	LockModeWrite LockMode = "write"
+	LockModeRead  LockMode = "read"
+)
+
+// lockHistogramBounds are the upper bounds of the lock histogram buckets; a last bucket
+// counts everything above.
+var lockHistogramBounds = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// lockHistogram is a lock-free duration histogram.
+type lockHistogram struct {
+	buckets [9]atomic.Uint64
+	count   atomic.Uint64
+	sum     atomic.Int64
+	max     atomic.Int64
+}
+
+func (h *lockHistogram) observe(d time.Duration) {
+	i := sort.Search(len(lockHistogramBounds), func(i int) bool { return d <= lockHistogramBounds[i] })
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+	for {
+		current := h.max.Load()
+		if int64(d) <= current || h.max.CompareAndSwap(current, int64(d)) {
+			return
+		}
+	}
+}
+
+func (h *lockHistogram) snapshot() LockHistogram {
+	snapshot := LockHistogram{
+		Bounds: append([]time.Duration(nil), lockHistogramBounds...),
+		Counts: make([]uint64, len(h.buckets)),
+		Count:  h.count.Load(),
+		Sum:    time.Duration(h.sum.Load()),
+		Max:    time.Duration(h.max.Load()),
+	}
+	for i := range h.buckets {
+		snapshot.Counts[i] = h.buckets[i].Load()
+	}
+	return snapshot
+}
+
+// LockHistogram is a snapshot of a duration histogram. Counts[i] counts the durations up to
+// Bounds[i]; the last count holds the durations above the last bound.
+type LockHistogram struct {
+	Bounds []time.Duration `json:"bounds"`
+	Counts []uint64        `json:"counts"`
+	Count  uint64          `json:"count"`
+	Sum    time.Duration   `json:"sum"`
+	Max    time.Duration   `json:"max"`
+}
+
+// Mean returns the mean duration of the histogram.
+func (h LockHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// LockHolder describes a goroutine holding an instrumented lock.
+type LockHolder struct {
+	Lock      string        `json:"lock"`
+	Mode      LockMode      `json:"mode"`
+	Goroutine int64         `json:"goroutine"`
+	Caller    string        `json:"caller"`
+	Since     time.Time     `json:"since"`
+	HeldFor   time.Duration `json:"heldFor"`
+}
+
+// LockStats is a snapshot of the metrics of an instrumented lock.
+type LockStats struct {
+	Name string `json:"name"`
+	// Contended counts the acquisitions that had to wait.
+	Contended uint64        `json:"contended"`
+	Wait      LockHistogram `json:"wait"`
+	Hold      LockHistogram `json:"hold"`
+	// Holders are the current holders, in acquisition order.
+	Holders []LockHolder `json:"holders"`
+}
+
+// lockHold is the record of a current holder.
+type lockHold struct {
+	goroutine int64
+	caller    string
+	mode      LockMode
+	since     time.Time
+	// reported is set once the watchdog reported the hold.
+	reported bool
+}
+
+// mutexMetrics is the instrumentation of a Mutexes. It only exists while metrics are enabled.
+type mutexMetrics struct {
+	name string
+	// label names the lock in the lock-order graph; it is the name, suffixed with a number
+	// when another lock of the monitor already uses the name.
+	label     string
+	monitor   *LockMonitor
+	contended atomic.Uint64
+	wait      lockHistogram
+	hold      lockHistogram
+
+	mu sync.Mutex
+	// holds holds the current holders by token; tokens number the acquisitions.
+	holds     map[uint64]*lockHold
+	nextToken uint64
+	// writer is the token of the write hold, 0 when the write lock is not held or was taken
+	// before metrics were enabled.
+	writer uint64
+	// reads is the number of read holds, and byGoroutine the tokens of the holds of each
+	// goroutine, in acquisition order.
+	reads       int
+	byGoroutine map[int64][]uint64
+	// readers is the read lock count of the Mutexes, updated under mu for the tracked locks.
+	readers *atomic.Int64
+}
+
+// newMutexMetrics creates the instrumentation of a lock named name.
+func newMutexMetrics(name string, monitor *LockMonitor, readers *atomic.Int64) *mutexMetrics {
+	return &mutexMetrics{
+		name:        name,
+		monitor:     monitor,
+		readers:     readers,
+		holds:       make(map[uint64]*lockHold),
+		byGoroutine: make(map[int64][]uint64),
+	}
+}
+
+// lock acquires through acquire, measuring the wait and recording the caller as holder.
+func (mm *mutexMetrics) lock(mode LockMode, tryAcquire func() bool, acquire func()) {
+	goroutine := goroutineID()
+	mm.monitor.acquiring(mm, mode, goroutine)
+
+	start := time.Now()
+	if !tryAcquire() {
+		mm.contended.Add(1)
+		acquire()
+	}
+	mm.wait.observe(time.Since(start))
+	mm.acquired(mode, goroutine)
+}
+
+// acquired records a new holder of the lock under a new token.
+func (mm *mutexMetrics) acquired(mode LockMode, goroutine int64) {
+	hold := &lockHold{goroutine: goroutine, caller: lockCaller(), mode: mode, since: time.Now()}
+
+	mm.mu.Lock()
+	mm.nextToken++
+	token := mm.nextToken
+	mm.holds[token] = hold
+	mm.byGoroutine[goroutine] = append(mm.byGoroutine[goroutine], token)
+	if mode == LockModeWrite {
+		mm.writer = token
+	} else {
+		mm.reads++
+		mm.readers.Add(1)
+	}
+	mm.mu.Unlock()
+
+	mm.monitor.acquired(mm, mode, goroutine)
+}
+
+// released drops the hold ended by a release and records its hold time. There is a single
+// write hold. A read release ends the latest read hold of the releasing goroutine; a goroutine
+// without one releases a read lock taken by another goroutine, whose oldest read hold is
+// ended, unless there are still as many read locks held as read holds, which means the
+// released lock was taken before metrics were enabled.
+func (mm *mutexMetrics) released(mode LockMode) {
+	goroutine := goroutineID()
+
+	mm.mu.Lock()
+	var token uint64
+	if mode == LockModeWrite {
+		token, mm.writer = mm.writer, 0
+	} else {
+		readers := mm.readers.Add(-1)
+		tokens := mm.byGoroutine[goroutine]
+		for i := len(tokens) - 1; i >= 0 && token == 0; i-- {
+			if mm.holds[tokens[i]].mode == LockModeRead {
+				token = tokens[i]
+			}
+		}
+		if token == 0 && int64(mm.reads) > readers {
+			for candidate, hold := range mm.holds {
+				if hold.mode == LockModeRead && (token == 0 || candidate < token) {
+					token = candidate
+				}
+			}
+		}
+	}
+	hold := mm.drop(token)
+	mm.mu.Unlock()
+
+	if hold == nil {
+		return
+	}
+	mm.hold.observe(time.Since(hold.since))
+	mm.monitor.released(mm, mode, hold.goroutine)
+}
+
+// drop removes the hold of a token, returning nil for token 0. The caller must hold mm.mu.
+func (mm *mutexMetrics) drop(token uint64) *lockHold {
+	hold, exists := mm.holds[token]
+	if !exists {
+		return nil
+	}
+	delete(mm.holds, token)
+	if hold.mode == LockModeRead {
+		mm.reads--
+	}
+	tokens := mm.byGoroutine[hold.goroutine]
+	for i, t := range tokens {
+		if t == token {
+			tokens = append(tokens[:i], tokens[i+1:]...)
+			break
+		}
+	}
+	if len(tokens) == 0 {
+		delete(mm.byGoroutine, hold.goroutine)
+	} else {
+		mm.byGoroutine[hold.goroutine] = tokens
+	}
+	return hold
+}
+
+func (mm *mutexMetrics) stats() LockStats {
+	stats := LockStats{
+		Name:      mm.name,
+		Contended: mm.contended.Load(),
+		Wait:      mm.wait.snapshot(),
+		Hold:      mm.hold.snapshot(),
+	}
+	now := time.Now()
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	tokens := make([]uint64, 0, len(mm.holds))
+	for token := range mm.holds {
+		tokens = append(tokens, token)
+	}
+	slices.Sort(tokens)
+	for _, token := range tokens {
+		stats.Holders = append(stats.Holders, mm.holder(mm.holds[token], now))
+	}
+	return stats
+}
+
+func (mm *mutexMetrics) holder(hold *lockHold, now time.Time) LockHolder {
+	return LockHolder{
+		Lock:      mm.name,
+		Mode:      hold.mode,
+		Goroutine: hold.goroutine,
+		Caller:    hold.caller,
+		Since:     hold.since,
+		HeldFor:   now.Sub(hold.since),
+	}
+}
+
+// LockMonitorConfig configures a LockMonitor.
+type LockMonitorConfig struct {
+	// HoldThreshold is the hold time above which the watchdog reports a holder. Defaults to 5s.
+	HoldThreshold time.Duration
+	// Interval is the watchdog period. Defaults to HoldThreshold / 2.
+	Interval time.Duration
+	// OnLongHold receives the holders found by the watchdog, once per hold. Defaults to logging.
+	OnLongHold func(holder LockHolder)
+	// OnPotentialDeadlock receives every new cycle of the lock-order graph, as the node labels
+	// along the cycle. Defaults to logging.
+	OnPotentialDeadlock func(cycle []string)
+}
+
+// lockNode is a node of the lock-order graph: one mode of one instrumented lock. Read locks
+// do not exclude each other, so the modes of a lock are distinct nodes.
+type lockNode struct {
+	mm   *mutexMetrics
+	mode LockMode
+}
+
+// String returns the label of the node, e.g. `ledger:write`.
+func (n lockNode) String() string { return n.mm.label + ":" + string(n.mode) }
+
+// LockMonitor collects the metrics of instrumented Mutexes, watches for long holds and
+// builds the lock-order graph: an edge A -> B is added when a goroutine holding A acquires
+// B. A cycle in the graph means two code paths take the same locks in opposite order and can
+// deadlock. Nodes are lock instances in a mode, labeled `<name>:<mode>`; read locks are
+// tracked too, so a cycle is a potential deadlock only.
+type LockMonitor struct {
+	cfg LockMonitorConfig
+
+	mu    sync.Mutex
+	locks map[*mutexMetrics]struct{}
+	// names counts the locks registered under each name, to label them apart.
+	names map[string]int
+	// held is the stack of instrumented locks held by each goroutine.
+	held map[int64][]lockNode
+	// edges is the lock-order graph.
+	edges map[lockNode]map[lockNode]struct{}
+	// cycles holds the cycles found so far, by canonical key.
+	cycles map[string][]string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewLockMonitor creates a lock monitor. The watchdog only runs after Start.
+func NewLockMonitor(cfg LockMonitorConfig) *LockMonitor {
+	if cfg.HoldThreshold <= 0 {
+		cfg.HoldThreshold = 5 * time.Second
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = cfg.HoldThreshold / 2
+	}
+	if cfg.OnLongHold == nil {
+		cfg.OnLongHold = func(holder LockHolder) {
+			gl.Log("warn", fmt.Sprintf("Lock %s held in %s mode for %s by goroutine %d at %s", holder.Lock, holder.Mode, holder.HeldFor, holder.Goroutine, holder.Caller))
+		}
+	}
+	if cfg.OnPotentialDeadlock == nil {
+		cfg.OnPotentialDeadlock = func(cycle []string) {
+			gl.Log("warn", fmt.Sprintf("Potential deadlock, inconsistent lock order: %s", strings.Join(cycle, " -> ")))
+		}
+	}
+	return &LockMonitor{
+		cfg:    cfg,
+		locks:  make(map[*mutexMetrics]struct{}),
+		names:  make(map[string]int),
+		held:   make(map[int64][]lockNode),
+		edges:  make(map[lockNode]map[lockNode]struct{}),
+		cycles: make(map[string][]string),
+	}
+}
+
+// Start runs the watchdog until Stop.
+func (lm *LockMonitor) Start() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.stop != nil {
+		return
+	}
+	lm.stop = make(chan struct{})
+	lm.done = make(chan struct{})
+	go lm.watch(lm.stop, lm.done)
+}
+
+// Stop ends the watchdog and waits for it to return.
+func (lm *LockMonitor) Stop() {
+	lm.mu.Lock()
+	stop, done := lm.stop, lm.done
+	lm.stop, lm.done = nil, nil
+	lm.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// Stats returns the metrics of every lock of the monitor, by name.
+func (lm *LockMonitor) Stats() []LockStats {
+	lm.mu.Lock()
+	locks := make([]*mutexMetrics, 0, len(lm.locks))
+	for mm := range lm.locks {
+		locks = append(locks, mm)
+	}
+	lm.mu.Unlock()
+
+	stats := make([]LockStats, 0, len(locks))
+	for _, mm := range locks {
+		stats = append(stats, mm.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// LockOrderGraph returns the lock-order graph as sorted adjacency lists of node labels.
+func (lm *LockMonitor) LockOrderGraph() map[string][]string {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	graph := make(map[string][]string, len(lm.edges))
+	for from, tos := range lm.edges {
+		label := from.String()
+		for to := range tos {
+			graph[label] = append(graph[label], to.String())
+		}
+		sort.Strings(graph[label])
+	}
+	return graph
+}
+
+// PotentialDeadlocks returns the cycles found in the lock-order graph.
+func (lm *LockMonitor) PotentialDeadlocks() [][]string {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	keys := make([]string, 0, len(lm.cycles))
+	for key := range lm.cycles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	cycles := make([][]string, 0, len(keys))
+	for _, key := range keys {
+		cycles = append(cycles, append([]string(nil), lm.cycles[key]...))
+	}
+	return cycles
+}
+
+func (lm *LockMonitor) register(mm *mutexMetrics) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.names[mm.name]++
+	mm.label = mm.name
+	if n := lm.names[mm.name]; n > 1 {
+		mm.label = fmt.Sprintf("%s#%d", mm.name, n)
+	}
+	lm.locks[mm] = struct{}{}
+}
+
+// unregister drops a lock from the monitor, with its holds and its nodes of the lock-order
+// graph. Cycles already found are kept.
+func (lm *LockMonitor) unregister(mm *mutexMetrics) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	delete(lm.locks, mm)
+	for goroutine, held := range lm.held {
+		kept := held[:0]
+		for _, node := range held {
+			if node.mm != mm {
+				kept = append(kept, node)
+			}
+		}
+		if len(kept) == 0 {
+			delete(lm.held, goroutine)
+		} else {
+			lm.held[goroutine] = kept
+		}
+	}
+	for from, tos := range lm.edges {
+		if from.mm == mm {
+			delete(lm.edges, from)
+			continue
+		}
+		for to := range tos {
+			if to.mm == mm {
+				delete(tos, to)
+			}
+		}
+		if len(tos) == 0 {
+			delete(lm.edges, from)
+		}
+	}
+}
+
+// acquiring adds the edges from the locks held by the goroutine to the lock being acquired,
+// and reports the cycles they close.
+func (lm *LockMonitor) acquiring(mm *mutexMetrics, mode LockMode, goroutine int64) {
+	node := lockNode{mm: mm, mode: mode}
+
+	lm.mu.Lock()
+	found := make([][]string, 0)
+	if _, registered := lm.locks[mm]; !registered {
+		lm.mu.Unlock()
+		return
+	}
+	for _, held := range lm.held[goroutine] {
+		if held == node {
+			continue
+		}
+		tos, exists := lm.edges[held]
+		if !exists {
+			tos = make(map[lockNode]struct{})
+			lm.edges[held] = tos
+		}
+		if _, exists := tos[node]; exists {
+			continue
+		}
+		tos[node] = struct{}{}
+		if path := lm.path(node, held); path != nil {
+			cycle := append([]string{held.String()}, path...)
+			key := cycleKey(cycle)
+			if _, known := lm.cycles[key]; !known {
+				lm.cycles[key] = cycle
+				found = append(found, cycle)
+			}
+		}
+	}
+	lm.mu.Unlock()
+
+	for _, cycle := range found {
+		lm.cfg.OnPotentialDeadlock(cycle)
+	}
+}
+
+func (lm *LockMonitor) acquired(mm *mutexMetrics, mode LockMode, goroutine int64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	// A lock whose metrics were disabled while it was being acquired is not tracked.
+	if _, registered := lm.locks[mm]; !registered {
+		return
+	}
+	lm.held[goroutine] = append(lm.held[goroutine], lockNode{mm: mm, mode: mode})
+}
+
+func (lm *LockMonitor) released(mm *mutexMetrics, mode LockMode, goroutine int64) {
+	node := lockNode{mm: mm, mode: mode}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	held := lm.held[goroutine]
+	for i := len(held) - 1; i >= 0; i-- {
+		if held[i] == node {
+			held = append(held[:i], held[i+1:]...)
+			break
+		}
+	}
+	if len(held) == 0 {
+		delete(lm.held, goroutine)
+	} else {
+		lm.held[goroutine] = held
+	}
+}
+
+// path returns the node labels from one node to another along the graph, nil when there is
+// no path. The caller must hold lm.mu.
+func (lm *LockMonitor) path(from, to lockNode) []string {
+	visited := map[lockNode]bool{from: true}
+	var walk func(node lockNode) []string
+	walk = func(node lockNode) []string {
+		if node == to {
+			return []string{node.String()}
+		}
+		for next := range lm.edges[node] {
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			if rest := walk(next); rest != nil {
+				return append([]string{node.String()}, rest...)
+			}
+		}
+		return nil
+	}
+	return walk(from)
+}
+
+// watch reports the holds longer than the threshold on every interval.
+func (lm *LockMonitor) watch(stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(lm.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		lm.mu.Lock()
+		locks := make([]*mutexMetrics, 0, len(lm.locks))
+		for mm := range lm.locks {
+			locks = append(locks, mm)
+		}
+		lm.mu.Unlock()
+
+		now := time.Now()
+		for _, mm := range locks {
+			long := make([]LockHolder, 0)
+			mm.mu.Lock()
+			for _, hold := range mm.holds {
+				if !hold.reported && now.Sub(hold.since) >= lm.cfg.HoldThreshold {
+					hold.reported = true
+					long = append(long, mm.holder(hold, now))
+				}
+			}
+			mm.mu.Unlock()
+			for _, holder := range long {
+				lm.cfg.OnLongHold(holder)
+			}
+		}
+	}
+}
+
+// cycleKey returns the same key for every rotation of a cycle.
+func cycleKey(cycle []string) string {
+	nodes := cycle
+	if len(nodes) > 1 && nodes[0] == nodes[len(nodes)-1] {
+		nodes = nodes[:len(nodes)-1]
+	}
+	start := 0
+	for i, node := range nodes {
+		if node < nodes[start] {
+			start = i
+		}
+	}
+	rotated := append(append([]string(nil), nodes[start:]...), nodes[:start]...)
+	return strings.Join(rotated, "\x00")
+}
+
+// EnableMetrics instruments the mutexes under a name, reporting to a monitor. Locks taken
+// before the call are not tracked; releasing them is harmless.
+func (m *Mutexes) EnableMetrics(name string, monitor *LockMonitor) error {
+	if monitor == nil {
+		return fmt.Errorf("lock monitor is nil")
+	}
+	if name == "" {
+		pc, _, line, ok := runtime.Caller(1)
+		if ok {
+			name = fmt.Sprintf("%s:%d", runtime.FuncForPC(pc).Name(), line)
+		} else {
+			name = fmt.Sprintf("mutexes-%p", m)
+		}
+	}
+	mm := newMutexMetrics(name, monitor, &m.readers)
+	monitor.register(mm)
+	if previous := m.metrics.Swap(mm); previous != nil {
+		previous.monitor.unregister(previous)
+	}
+	return nil
+}
+
+// DisableMetrics removes the instrumentation of the mutexes, and its holds and lock-order
+// edges from the monitor.
+func (m *Mutexes) DisableMetrics() {
+	if previous := m.metrics.Swap(nil); previous != nil {
+		previous.monitor.unregister(previous)
+	}
+}
+
+// GetLockStats returns the metrics of the mutexes, and false when metrics are disabled.
+func (m *Mutexes) GetLockStats() (LockStats, bool) {
+	mm := m.metrics.Load()
+	if mm == nil {
+		return LockStats{}, false
+	}
+	return mm.stats(), true
+}
+
+// lockCaller returns the first caller outside this package's lock methods.
+func lockCaller() string {
+	pcs := make([]uintptr, 8)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.Contains(frame.Function, ".(*Mutexes).") && !strings.Contains(frame.Function, ".(*FileMutexes).") && !strings.Contains(frame.Function, ".(*mutexMetrics).") {
+			return fmt.Sprintf("%s:%d", frame.Function, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// goroutineID returns the ID of the current goroutine, parsed from its stack header.
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, err := strconv.ParseInt(string(buf), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return id
+}
@@ -0,0 +1,218 @@
+package types
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// instrumentedMutexes returns mutexes with metrics enabled under a new monitor.
+func instrumentedMutexes(t *testing.T, name string) (*Mutexes, *LockMonitor) {
+	t.Helper()
+	monitor := NewLockMonitor(LockMonitorConfig{})
+	m := NewMutexesType()
+	if err := m.EnableMetrics(name, monitor); err != nil {
+		t.Fatalf("EnableMetrics: %v", err)
+	}
+	return m, monitor
+}
+
+// lockStats returns the metrics of instrumented mutexes.
+func lockStats(t *testing.T, m *Mutexes) LockStats {
+	t.Helper()
+	stats, enabled := m.GetLockStats()
+	if !enabled {
+		t.Fatal("GetLockStats reported metrics disabled")
+	}
+	return stats
+}
+
+// holderGoroutines returns the goroutines of the holders of a mode.
+func holderGoroutines(stats LockStats, mode LockMode) []int64 {
+	goroutines := make([]int64, 0)
+	for _, holder := range stats.Holders {
+		if holder.Mode == mode {
+			goroutines = append(goroutines, holder.Goroutine)
+		}
+	}
+	return goroutines
+}
+
+// inGoroutine runs fn in a new goroutine, returning its ID once fn returned.
+func inGoroutine(fn func()) int64 {
+	done := make(chan int64)
+	go func() {
+		fn()
+		done <- goroutineID()
+	}()
+	return <-done
+}
+
+func TestLockMetricsCountWaitsAndHolds(t *testing.T) {
+	m, _ := instrumentedMutexes(t, "ledger")
+
+	m.MuLock()
+	acquired := make(chan struct{})
+	go func() {
+		m.MuLock()
+		close(acquired)
+		m.MuUnlock()
+	}()
+	// Wait for the second locker to block on the lock.
+	deadline := time.Now().Add(2 * time.Second)
+	for lockStats(t, m).Contended != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the second locker was not counted as contended")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := lockStats(t, m)
+	if len(stats.Holders) != 1 || stats.Holders[0].Goroutine != goroutineID() || stats.Holders[0].Mode != LockModeWrite {
+		t.Fatalf("Holders while contended = %+v, want the test goroutine in write mode", stats.Holders)
+	}
+	if !strings.Contains(stats.Holders[0].Caller, "TestLockMetricsCountWaitsAndHolds") {
+		t.Fatalf("holder caller = %s, want the test", stats.Holders[0].Caller)
+	}
+	time.Sleep(5 * time.Millisecond)
+	m.MuUnlock()
+	<-acquired
+	m.MuRLock()
+	m.MuRUnlock()
+
+	stats = lockStats(t, m)
+	if stats.Name != "ledger" || stats.Contended != 1 {
+		t.Fatalf("stats = %+v, want ledger with one contended acquisition", stats)
+	}
+	if stats.Wait.Count != 3 || stats.Hold.Count != 3 {
+		t.Fatalf("Wait.Count, Hold.Count = %d, %d, want 3, 3", stats.Wait.Count, stats.Hold.Count)
+	}
+	if stats.Wait.Max < 5*time.Millisecond || stats.Hold.Max < 5*time.Millisecond {
+		t.Fatalf("Wait.Max, Hold.Max = %s, %s, want at least 5ms", stats.Wait.Max, stats.Hold.Max)
+	}
+	var counted uint64
+	for _, count := range stats.Hold.Counts {
+		counted += count
+	}
+	if counted != stats.Hold.Count || len(stats.Holders) != 0 {
+		t.Fatalf("Hold.Counts = %v, Holders = %+v, want 3 holds and no holder", stats.Hold.Counts, stats.Holders)
+	}
+}
+
+func TestLockMetricsAttributeReleasesToTheirHolds(t *testing.T) {
+	t.Run("reader released by another goroutine", func(t *testing.T) {
+		m, _ := instrumentedMutexes(t, "handed-over")
+		reader := inGoroutine(m.MuRLock)
+		m.MuRLock()
+
+		if got := holderGoroutines(lockStats(t, m), LockModeRead); len(got) != 2 || got[0] != reader || got[1] != goroutineID() {
+			t.Fatalf("read holders = %v, want %d then %d", got, reader, goroutineID())
+		}
+		inGoroutine(m.MuRUnlock)
+		if got := holderGoroutines(lockStats(t, m), LockModeRead); len(got) != 1 || got[0] != goroutineID() {
+			t.Fatalf("read holders after the hand-over = %v, want %d", got, goroutineID())
+		}
+		m.MuRUnlock()
+		if stats := lockStats(t, m); len(stats.Holders) != 0 || stats.Hold.Count != 2 {
+			t.Fatalf("stats = %+v, want no holder and 2 holds", stats)
+		}
+	})
+
+	t.Run("reader locked before metrics", func(t *testing.T) {
+		m := NewMutexesType()
+		untracked := make(chan struct{})
+		release := make(chan struct{})
+		go func() {
+			m.MuRLock()
+			close(untracked)
+			<-release
+			m.MuRUnlock()
+			close(untracked)
+		}()
+		<-untracked
+		untracked = make(chan struct{})
+		if err := m.EnableMetrics("late", NewLockMonitor(LockMonitorConfig{})); err != nil {
+			t.Fatalf("EnableMetrics: %v", err)
+		}
+
+		m.MuRLock()
+		close(release)
+		<-untracked
+		if got := holderGoroutines(lockStats(t, m), LockModeRead); len(got) != 1 || got[0] != goroutineID() {
+			t.Fatalf("read holders after the untracked release = %v, want %d", got, goroutineID())
+		}
+		m.MuRUnlock()
+		if stats := lockStats(t, m); len(stats.Holders) != 0 || stats.Hold.Count != 1 {
+			t.Fatalf("stats = %+v, want no holder and 1 hold", stats)
+		}
+	})
+
+	t.Run("reentrant reader", func(t *testing.T) {
+		m, _ := instrumentedMutexes(t, "reentrant")
+		m.MuRLock()
+		first := lockStats(t, m).Holders[0].Since
+		time.Sleep(time.Millisecond)
+		m.MuRLock()
+		m.MuRUnlock()
+		if holders := lockStats(t, m).Holders; len(holders) != 1 || !holders[0].Since.Equal(first) {
+			t.Fatalf("holders = %+v, want the first hold, since %s", holders, first)
+		}
+		m.MuRUnlock()
+	})
+
+	t.Run("writer released by another goroutine", func(t *testing.T) {
+		m, monitor := instrumentedMutexes(t, "writer")
+		other := NewMutexesType()
+		if err := other.EnableMetrics("other", monitor); err != nil {
+			t.Fatalf("EnableMetrics: %v", err)
+		}
+		m.MuRLock()
+		m.MuRUnlock()
+		m.MuLock()
+		inGoroutine(m.MuUnlock)
+		if stats := lockStats(t, m); len(stats.Holders) != 0 || stats.Hold.Count != 2 {
+			t.Fatalf("stats = %+v, want no holder and 2 holds", stats)
+		}
+		// The released lock is no longer held by the test goroutine.
+		other.MuLock()
+		other.MuUnlock()
+		if graph := monitor.LockOrderGraph(); len(graph) != 0 {
+			t.Fatalf("LockOrderGraph = %v, want no edge", graph)
+		}
+	})
+}
+
+func TestLockMonitorFindsInconsistentLockOrders(t *testing.T) {
+	monitor := NewLockMonitor(LockMonitorConfig{})
+	cycles := make(chan []string, 1)
+	monitor.cfg.OnPotentialDeadlock = func(cycle []string) { cycles <- cycle }
+
+	a, b := NewMutexesType(), NewMutexesType()
+	for name, m := range map[string]*Mutexes{"a": a, "b": b} {
+		if err := m.EnableMetrics(name, monitor); err != nil {
+			t.Fatalf("EnableMetrics(%s): %v", name, err)
+		}
+	}
+	a.MuLock()
+	b.MuLock()
+	b.MuUnlock()
+	a.MuUnlock()
+	inGoroutine(func() {
+		b.MuLock()
+		a.MuLock()
+		a.MuUnlock()
+		b.MuUnlock()
+	})
+
+	select {
+	case cycle := <-cycles:
+		if strings.Join(cycle, " -> ") != "a:write -> b:write -> a:write" && strings.Join(cycle, " -> ") != "b:write -> a:write -> b:write" {
+			t.Fatalf("cycle = %v, want a:write and b:write", cycle)
+		}
+	default:
+		t.Fatal("no potential deadlock reported")
+	}
+	if deadlocks := monitor.PotentialDeadlocks(); len(deadlocks) != 1 {
+		t.Fatalf("PotentialDeadlocks = %v, want one cycle", deadlocks)
+	}
+}
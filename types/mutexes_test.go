@@ -0,0 +1,171 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForQueued polls until n callers are queued on the lock of m.
+func waitForQueued(t *testing.T, m *Mutexes, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		m.muRW.mu.Lock()
+		queued := m.muRW.waiters.Len()
+		m.muRW.mu.Unlock()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers queued, want %d", queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMutexesExcludeReadersAndWriters(t *testing.T) {
+	m := NewMutexesType()
+
+	m.MuLock()
+	if m.MuTryRLock() {
+		t.Fatal("MuTryRLock succeeded while the write lock was held")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.MuRLockCtx(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("MuRLockCtx while the write lock was held = %v, want DeadlineExceeded", err)
+	}
+	m.MuUnlock()
+
+	m.MuRLock()
+	if !m.MuTryRLock() {
+		t.Fatal("MuTryRLock failed while only readers held the lock")
+	}
+	if m.MuTryLock() {
+		t.Fatal("MuTryLock succeeded while readers held the lock")
+	}
+	m.MuRUnlock()
+	m.MuRUnlock()
+	if !m.MuTryLock() {
+		t.Fatal("MuTryLock failed once the readers were gone")
+	}
+	m.MuUnlock()
+}
+
+func TestMutexesServeWaitersInArrivalOrder(t *testing.T) {
+	m := NewMutexesType()
+	m.MuRLock()
+
+	order := make(chan string, 2)
+	go func() {
+		m.MuLock()
+		order <- "writer"
+		m.MuUnlock()
+	}()
+	waitForQueued(t, m, 1)
+	go func() {
+		m.MuRLock()
+		order <- "reader"
+		m.MuRUnlock()
+	}()
+	// A queued writer holds back the readers arriving after it.
+	waitForQueued(t, m, 2)
+	if m.MuTryRLock() {
+		t.Fatal("MuTryRLock succeeded while a writer was queued")
+	}
+
+	m.MuRUnlock()
+	if first, second := <-order, <-order; first != "writer" || second != "reader" {
+		t.Fatalf("waiters served as %s then %s, want the writer then the reader", first, second)
+	}
+}
+
+func TestMutexesLockCtxGivesUpOnCancellation(t *testing.T) {
+	m := NewMutexesType()
+	m.MuRLock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := make(chan error, 1)
+	go func() { writer <- m.MuLockCtx(ctx) }()
+	waitForQueued(t, m, 1)
+	reader := make(chan error, 1)
+	go func() { reader <- m.MuRLockCtx(context.Background()) }()
+	waitForQueued(t, m, 2)
+
+	cancel()
+	if err := <-writer; !errors.Is(err, context.Canceled) {
+		t.Fatalf("MuLockCtx = %v, want Canceled", err)
+	}
+	// The cancelled writer no longer holds back the reader queued after it.
+	select {
+	case err := <-reader:
+		if err != nil {
+			t.Fatalf("MuRLockCtx: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the reader queued after a cancelled writer was not let in")
+	}
+	m.MuRUnlock()
+	m.MuRUnlock()
+
+	if err := m.MuLockCtx(context.Background()); err != nil {
+		t.Fatalf("MuLockCtx once the lock was free: %v", err)
+	}
+	m.MuUnlock()
+	waitForQueued(t, m, 0)
+}
+
+func TestMutexesWaitCondCtx(t *testing.T) {
+	m := NewMutexesType()
+
+	m.MuCtxCond.L.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.MuWaitCondCtx(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("MuWaitCondCtx without a signal = %v, want DeadlineExceeded", err)
+	}
+	if m.muSharedM.TryLock() {
+		t.Fatal("MuWaitCondCtx returned without the condition lock")
+	}
+
+	// The cancelled waiter was dropped, so the signal goes to the next one.
+	woken := make(chan struct{})
+	go func() {
+		m.MuCtxCond.L.Lock()
+		close(woken)
+		m.MuCtxCond.L.Unlock()
+	}()
+	waited := make(chan error, 1)
+	go func() {
+		m.MuCtxCond.L.Lock()
+		defer m.MuCtxCond.L.Unlock()
+		waited <- m.MuWaitCondCtx(context.Background())
+	}()
+	m.MuCtxCond.L.Unlock()
+	<-woken
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		m.muWaitM.Lock()
+		waiting := len(m.muWaiters)
+		m.muWaitM.Unlock()
+		if waiting == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d condition waiters, want 1", waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	m.MuSignalCond()
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Fatalf("MuWaitCondCtx: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the signal did not wake the waiter")
+	}
+}
@@ -1,9 +1,12 @@
 package types
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"reflect"
 	"runtime"
+	"strings"
 
 	"github.com/google/uuid"
 	gl "github.com/rafa-mori/smart_plane/logger"
@@ -13,16 +16,30 @@ type IReference interface {
 	GetID() uuid.UUID
 	GetName() string
 	SetName(name string)
+	GetParentID() uuid.UUID
+	GetPath() string
+	Child(name string) *Reference
 	String() string
 	GetReference() *Reference
 }
 
+// ReferenceNamespace is the default UUIDv5 namespace of deterministic references.
+var ReferenceNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/rafa-mori/smart_plane"))
+
+// referencePathSeparator separates the names of a reference path.
+const referencePathSeparator = "/"
+
 // Reference is a struct that holds the Reference ID and name.
 type Reference struct {
-	// refID is the unique identifier for this context.
+	// ID is the unique identifier of the reference.
 	ID uuid.UUID
-	// refName is the name of the context.
+	// Name is the name of the reference, the last name of its path.
 	Name string
+	// ParentID is the ID of the parent reference, uuid.Nil for a root reference.
+	ParentID uuid.UUID
+	// Path is the path-style name of the reference: the escaped names from the root down to
+	// this reference, separated by slashes, e.g. `ApprovalContract/doc-1/signature`.
+	Path string
 }
 
 // newReference is a function that creates a new Reference instance.
@@ -39,7 +56,64 @@ func newReference(name string) *Reference {
 	return &Reference{
 		ID:   uuid.New(),
 		Name: name,
+		Path: url.PathEscape(name),
+	}
+}
+
+// NewDeterministicReference creates a root reference whose ID is the UUIDv5 of the name in
+// the namespace (ReferenceNamespace when uuid.Nil), so the same name always gets the same ID.
+func NewDeterministicReference(namespace uuid.UUID, name string) *Reference {
+	if namespace == uuid.Nil {
+		namespace = ReferenceNamespace
+	}
+	return &Reference{
+		ID:   uuid.NewSHA1(namespace, []byte(name)),
+		Name: name,
+		Path: url.PathEscape(name),
+	}
+}
+
+// NewReferencePath creates the deterministic reference of a path of names, e.g.
+// NewReferencePath(uuid.Nil, "ApprovalContract", "doc-1") for a document of a contract.
+func NewReferencePath(namespace uuid.UUID, names ...string) (*Reference, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("reference path is empty")
+	}
+	reference := NewDeterministicReference(namespace, names[0])
+	for _, name := range names[1:] {
+		reference = reference.Child(name)
+	}
+	return reference, nil
+}
+
+// ParseReference parses the text form of a reference, `<path>#<id>[#<parent id>[#<name>]]`;
+// see MarshalText.
+func ParseReference(text string) (*Reference, error) {
+	fields := strings.Split(text, "#")
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid reference %q: missing id", text)
+	}
+	if len(fields) > 4 {
+		return nil, fmt.Errorf("invalid reference %q: too many fields", text)
 	}
+	id, err := uuid.Parse(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %q: %w", text, err)
+	}
+	reference := &Reference{ID: id, Path: fields[0]}
+	if len(fields) > 2 {
+		if reference.ParentID, err = uuid.Parse(fields[2]); err != nil {
+			return nil, fmt.Errorf("invalid reference %q: parent: %w", text, err)
+		}
+	}
+	name := fields[0][strings.LastIndex(fields[0], referencePathSeparator)+1:]
+	if len(fields) > 3 {
+		name = fields[3]
+	}
+	if reference.Name, err = url.PathUnescape(name); err != nil {
+		return nil, fmt.Errorf("invalid reference %q: %w", text, err)
+	}
+	return reference, nil
 }
 
 // NewReference is a function that creates a new IReference instance.
@@ -49,9 +123,125 @@ func NewReference(name string) IReference {
 
 // String is a method that returns the string representation of the reference.
 func (r *Reference) String() string {
+	if r.ParentID != uuid.Nil {
+		return fmt.Sprintf("ID: %s, Name: %s, Path: %s, Parent: %s", r.ID.String(), r.Name, r.Path, r.ParentID.String())
+	}
 	return fmt.Sprintf("ID: %s, Name: %s", r.ID.String(), r.Name)
 }
 
+// Child returns the deterministic child reference of the given name: its ID is the UUIDv5 of
+// the name in the namespace of this reference's ID, and its path extends this one.
+func (r *Reference) Child(name string) *Reference {
+	if r == nil {
+		gl.Log("error", "Child: reference does not exist (", reflect.TypeFor[Reference]().String(), ")")
+		return nil
+	}
+	return &Reference{
+		ID:       uuid.NewSHA1(r.ID, []byte(name)),
+		Name:     name,
+		ParentID: r.ID,
+		Path:     r.GetPath() + referencePathSeparator + url.PathEscape(name),
+	}
+}
+
+// IsChildOf reports whether the reference is a direct child of parent.
+func (r *Reference) IsChildOf(parent *Reference) bool {
+	return r != nil && parent != nil && r.ParentID != uuid.Nil && r.ParentID == parent.ID
+}
+
+// GetParentID returns the ID of the parent reference, uuid.Nil for a root reference.
+func (r *Reference) GetParentID() uuid.UUID {
+	if r == nil {
+		gl.Log("error", "GetParentID: reference does not exist (", reflect.TypeFor[Reference]().String(), ")")
+		return uuid.Nil
+	}
+	return r.ParentID
+}
+
+// GetPath returns the path-style name of the reference, falling back to the escaped name.
+func (r *Reference) GetPath() string {
+	if r == nil {
+		gl.Log("error", "GetPath: reference does not exist (", reflect.TypeFor[Reference]().String(), ")")
+		return ""
+	}
+	if r.Path == "" {
+		return url.PathEscape(r.Name)
+	}
+	return r.Path
+}
+
+// GetSegments returns the unescaped names along the path of the reference.
+func (r *Reference) GetSegments() []string {
+	segments := strings.Split(r.GetPath(), referencePathSeparator)
+	for i, segment := range segments {
+		if name, err := url.PathUnescape(segment); err == nil {
+			segments[i] = name
+		}
+	}
+	return segments
+}
+
+// referenceJSON is the JSON form of a Reference.
+type referenceJSON struct {
+	ID       uuid.UUID  `json:"id"`
+	Name     string     `json:"name"`
+	ParentID *uuid.UUID `json:"parentId,omitempty"`
+	Path     string     `json:"path"`
+}
+
+// MarshalJSON encodes the reference as an object with its id, name, path and parent id.
+func (r Reference) MarshalJSON() ([]byte, error) {
+	encoded := referenceJSON{ID: r.ID, Name: r.Name, Path: r.GetPath()}
+	if r.ParentID != uuid.Nil {
+		encoded.ParentID = &r.ParentID
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes the object form of MarshalJSON, or the text form of MarshalText.
+func (r *Reference) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		return r.UnmarshalText([]byte(text))
+	}
+	var decoded referenceJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("invalid reference: %w", err)
+	}
+	*r = Reference{ID: decoded.ID, Name: decoded.Name, Path: decoded.Path}
+	if decoded.ParentID != nil {
+		r.ParentID = *decoded.ParentID
+	}
+	return nil
+}
+
+// MarshalText encodes the reference as `<path>#<id>`, which also makes references usable as
+// JSON object keys. The parent ID of a child reference follows as `#<parent id>`, and a name
+// other than the last name of the path follows it, escaped, as `#<name>`.
+func (r Reference) MarshalText() ([]byte, error) {
+	path := r.GetPath()
+	text := path + "#" + r.ID.String()
+	name := url.PathEscape(r.Name)
+	renamed := name != path[strings.LastIndex(path, referencePathSeparator)+1:]
+	if r.ParentID != uuid.Nil || renamed {
+		text += "#" + r.ParentID.String()
+	}
+	if renamed {
+		text += "#" + name
+	}
+	return []byte(text), nil
+}
+
+// UnmarshalText decodes the text form of MarshalText.
+func (r *Reference) UnmarshalText(text []byte) error {
+	parsed, err := ParseReference(string(text))
+	if err != nil {
+		return err
+	}
+	*r = *parsed
+	return nil
+}
+
 // GetID is a method that returns the ID of the reference.
 func (r *Reference) GetID() uuid.UUID {
 	if r == nil {
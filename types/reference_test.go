@@ -0,0 +1,57 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReferenceTextRoundTrips(t *testing.T) {
+	root := NewDeterministicReference(uuid.Nil, "ApprovalContract")
+	child := root.Child("doc 1#a")
+	renamed := *child
+	renamed.SetName("renamed")
+	renamedRoot := *root
+	renamedRoot.SetName("other")
+
+	tests := []struct {
+		name      string
+		reference Reference
+	}{
+		{name: "root", reference: *root},
+		{name: "child", reference: *child},
+		{name: "grandchild", reference: *child.Child("signature")},
+		{name: "renamed child", reference: renamed},
+		{name: "renamed root", reference: renamedRoot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := tt.reference.MarshalText()
+			if err != nil {
+				t.Fatalf("MarshalText: %v", err)
+			}
+			var decoded Reference
+			if err := decoded.UnmarshalText(text); err != nil {
+				t.Fatalf("UnmarshalText(%s): %v", text, err)
+			}
+			if decoded != tt.reference {
+				t.Fatalf("UnmarshalText(%s) = %+v, want %+v", text, decoded, tt.reference)
+			}
+		})
+	}
+}
+
+func TestParseReferenceReadsThePathAndID(t *testing.T) {
+	id := uuid.New()
+	reference, err := ParseReference("ApprovalContract/doc%201#" + id.String())
+	if err != nil {
+		t.Fatalf("ParseReference: %v", err)
+	}
+	want := Reference{ID: id, Name: "doc 1", Path: "ApprovalContract/doc%201"}
+	if *reference != want {
+		t.Fatalf("ParseReference = %+v, want %+v", *reference, want)
+	}
+	if _, err := ParseReference("ApprovalContract"); err == nil {
+		t.Fatal("ParseReference of a reference without id succeeded")
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"github.com/google/uuid"
 	ci "github.com/rafa-mori/smart_plane/internal/interfaces"
 
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 )
 
@@ -52,6 +56,37 @@ func (vr *ValidationResult) String() string {
 	}
 	return fmt.Sprintf("Validation is invalid: %s", vr.Message)
 }
+
+// MarshalJSON encodes the result with its reference. Metadata values that cannot be encoded
+// are dropped, and the callback is never encoded.
+func (vr *ValidationResult) MarshalJSON() ([]byte, error) {
+	if vr == nil {
+		return []byte("null"), nil
+	}
+	vr.Mutexes.MuRLock()
+	defer vr.Mutexes.MuRUnlock()
+
+	encoded := struct {
+		Reference *Reference     `json:"reference,omitempty"`
+		IsValid   bool           `json:"isValid"`
+		Message   string         `json:"message,omitempty"`
+		Error     string         `json:"error,omitempty"`
+		Metadata  map[string]any `json:"metadata,omitempty"`
+	}{Reference: vr.Reference, IsValid: vr.IsValid, Message: vr.Message}
+	if vr.Error != nil {
+		encoded.Error = vr.Error.Error()
+	}
+	for key, value := range vr.Metadata {
+		if _, err := json.Marshal(value); err != nil {
+			continue
+		}
+		if encoded.Metadata == nil {
+			encoded.Metadata = make(map[string]any, len(vr.Metadata))
+		}
+		encoded.Metadata[key] = value
+	}
+	return json.Marshal(encoded)
+}
 func (vr *ValidationResult) GetID() uuid.UUID {
 	if vr == nil {
 		return uuid.Nil
@@ -212,7 +247,15 @@ type Validation[T any] struct {
 	isValid bool
 	// hasValidate is a boolean that indicates if the value will be validated.
 	hasValidation bool
-	// validatorMap is the map of validators.
+	// collectAll is a boolean that indicates if every validator runs, instead of stopping at the first failure.
+	collectAll bool
+	// concurrency is the maximum number of validators of the same priority running at once.
+	concurrency int
+	// listener receives the lifecycle events of the validation, when set.
+	listener *ValidationListener
+	// results holds the results of the last completed run, by priority, in registration order.
+	results map[int][]ci.IValidationResult
+	// validatorMap is the map of validators, by priority. Each value is a []ci.IValidationFunc[T].
 	validatorMap sync.Map
 	// validateFunc is the function that validates the value.
 	validateFunc func(value *T, args ...any) ci.IValidationResult
@@ -292,13 +335,13 @@ func (v *Validation[T]) CheckIfWillValidate() bool {
 		return false
 	}
 
-	v.mu.RLock()
-	defer v.mu.RUnlock()
+	v.mu.Lock()
+	defer v.mu.Unlock()
 
 	hasValidator := false
 	v.validatorMap.Range(func(key, value any) bool {
 		if _, vld := key.(int); vld {
-			if _, ok := value.(ValidationFunc[T]); ok {
+			if stage, ok := value.([]ci.IValidationFunc[T]); ok && len(stage) > 0 {
 				hasValidator = true
 				return false
 			}
@@ -309,77 +352,133 @@ func (v *Validation[T]) CheckIfWillValidate() bool {
 	return hasValidator
 }
 
-// Validate is the function that validates the value.
-func (v *Validation[T]) Validate(value *T, args ...any) ci.IValidationResult {
+// willValidate reports whether the validation has validators, as last checked by
+// CheckIfWillValidate.
+func (v *Validation[T]) willValidate() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.hasValidation
+}
+
+// SetFailFast sets whether Validate stops at the first failing validator (true, the default)
+// or runs every validator and collects all failures (false).
+func (v *Validation[T]) SetFailFast(failFast bool) {
 	if v == nil {
-		return NewValidationResult(false, "validation is nil", nil, fmt.Errorf("validation is nil"))
-	}
-	if value == nil {
-		return NewValidationResult(false, "value is nil", nil, fmt.Errorf("value is nil"))
-	}
-	if !v.hasValidation {
-		return NewValidationResult(false, "validation has no validators", nil, fmt.Errorf("validation has no validators"))
+		return
 	}
-
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
-	results := make([]ci.IValidationResult, 0)
-	v.validatorMap.Range(func(key, val any) bool {
-		if validator, ok := val.(ValidationFunc[T]); ok {
-			result := validator.Func(value, args...)
-			results = append(results, result)
-			if result != nil && !result.GetIsValid() {
-				v.isValid = false
-				return false
+	v.collectAll = !failFast
+}
+
+// IsFailFast returns whether Validate stops at the first failing validator.
+func (v *Validation[T]) IsFailFast() bool {
+	if v == nil {
+		return true
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return !v.collectAll
+}
+
+// stages returns the registered validators grouped by priority, in ascending priority order.
+// Validators sharing a priority keep their registration order.
+func (v *Validation[T]) stages() [][]ci.IValidationFunc[T] {
+	priorities := make([]int, 0)
+	byPriority := make(map[int][]ci.IValidationFunc[T])
+	v.validatorMap.Range(func(key, value any) bool {
+		if priority, ok := key.(int); ok {
+			if stage, ok := value.([]ci.IValidationFunc[T]); ok && len(stage) > 0 {
+				priorities = append(priorities, priority)
+				byPriority[priority] = stage
 			}
 		}
 		return true
 	})
+	sort.Ints(priorities)
+
+	stages := make([][]ci.IValidationFunc[T], 0, len(priorities))
+	for _, priority := range priorities {
+		stages = append(stages, byPriority[priority])
+	}
+	return stages
+}
+
+// Validate is the function that validates the value.
+//
+// Validators run in ascending priority order. The results of the last completed run are
+// kept, so GetResults returns them. The returned result aggregates every failure:
+// its message joins the failure messages, its error joins the failure errors, and its
+// "failures" metadata holds the failed validator priorities.
+func (v *Validation[T]) Validate(value *T, args ...any) ci.IValidationResult {
+	return v.ValidateContext(context.Background(), value, args...)
+}
 
-	if len(results) > 0 {
-		sort.Slice(results, func(i, j int) bool {
-			return results[i].GetMessage() < results[j].GetMessage()
-		})
+// aggregateResults builds a single result carrying every failure of a validation run.
+func aggregateResults(results []ci.IValidationResult, failures []int) ci.IValidationResult {
+	if len(failures) == 0 {
+		return NewValidationResult(true, "validation is valid", map[string]any{"validators": len(results)}, nil)
 	}
 
-	v.isValid = true
+	messages := make([]string, 0, len(failures))
+	errs := make([]error, 0, len(failures))
 	for _, result := range results {
-		if result != nil && !result.GetIsValid() {
-			v.isValid = false
-			break
+		if result.GetIsValid() {
+			continue
+		}
+		msg := result.GetMessage()
+		if msg == "" && result.GetError() != nil {
+			msg = result.GetError().Error()
+		}
+		messages = append(messages, msg)
+		if result.GetError() != nil {
+			errs = append(errs, result.GetError())
+		} else {
+			errs = append(errs, errors.New(msg))
 		}
 	}
 
-	return NewValidationResult(v.isValid, "validation is valid", nil, nil)
+	return NewValidationResult(false, strings.Join(messages, "; "), map[string]any{
+		"validators": len(results),
+		"failures":   failures,
+		"messages":   messages,
+	}, errors.Join(errs...))
 }
 
 // AddValidator is a function that adds a validator to the map of validators.
+// Validators sharing a priority form a stage; see SetConcurrency.
 func (v *Validation[T]) AddValidator(validator ci.IValidationFunc[T]) error {
 	if v == nil {
 		return fmt.Errorf("validation is nil")
 	}
-
-	// Will update v.hasValidation always, if this method is called.
-	v.CheckIfWillValidate()
-
+	if validator == nil {
+		return fmt.Errorf("validator is nil")
+	}
 	if validator.GetFunction() == nil {
 		return fmt.Errorf("validator function is nil")
 	}
 	if validator.GetPriority() < 0 {
 		return fmt.Errorf("priority must be greater than or equal to 0")
 	}
-	if _, ok := v.validatorMap.LoadOrStore(validator.GetPriority(), validator); ok {
-		return fmt.Errorf("validator with priority %d already exists", validator.GetPriority())
+
+	v.mu.Lock()
+	stage := make([]ci.IValidationFunc[T], 0, 1)
+	if current, ok := v.validatorMap.Load(validator.GetPriority()); ok {
+		stage = append(stage, current.([]ci.IValidationFunc[T])...)
 	}
+	v.validatorMap.Store(validator.GetPriority(), append(stage, validator))
+	v.mu.Unlock()
 
-	// If the validator was added, we need to update v.hasValidation again, just for safety.
+	// If the validator was added, we need to update v.hasValidation.
 	v.CheckIfWillValidate()
 
 	return nil
 }
 
-// RemoveValidator is a function that removes a validator from the map of validators.
+// RemoveValidator is a function that removes every validator with the given priority.
 func (v *Validation[T]) RemoveValidator(priority int) error {
 	if v == nil {
 		return fmt.Errorf("validation is nil")
@@ -394,71 +493,94 @@ func (v *Validation[T]) RemoveValidator(priority int) error {
 	return nil
 }
 
-// GetValidator is a function that gets a validator from the map of validators.
+// GetValidator is a function that gets the first validator registered with the given priority.
 func (v *Validation[T]) GetValidator(priority int) (any, error) {
 	if v == nil {
 		return nil, fmt.Errorf("validation is nil")
 	}
-	if !v.hasValidation {
+	if !v.willValidate() {
 		return nil, fmt.Errorf("validation has no validators")
 	}
-	if validator, ok := v.validatorMap.Load(priority); ok {
-		return validator, nil
+	if stage := v.GetValidatorsByPriority(priority); len(stage) > 0 {
+		return stage[0], nil
 	}
 	return nil, fmt.Errorf("validator with priority %d does not exist", priority)
 }
 
-// GetValidators is a function that gets the map of validators.
+// GetValidatorsByPriority is a function that gets every validator registered with the given priority.
+func (v *Validation[T]) GetValidatorsByPriority(priority int) []ci.IValidationFunc[T] {
+	if v == nil {
+		return nil
+	}
+	if value, ok := v.validatorMap.Load(priority); ok {
+		stage := value.([]ci.IValidationFunc[T])
+		return append([]ci.IValidationFunc[T](nil), stage...)
+	}
+	return nil
+}
+
+// GetValidators is a function that gets the map of validators, with the first validator of each priority.
 func (v *Validation[T]) GetValidators() map[int]ci.IValidationFunc[T] {
 	if v == nil {
 		return nil
 	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
 	if !v.hasValidation {
 		return nil
 	}
 	validatorMapSnapshot := make(map[int]ci.IValidationFunc[T])
-	v.validatorMap.Range(func(key, value any) bool {
-		if validator, ok := value.(ci.IValidationFunc[T]); ok {
-			validatorMapSnapshot[validator.GetPriority()] = validator
-		}
-		return true
-	})
+	for _, stage := range v.stages() {
+		validatorMapSnapshot[stage[0].GetPriority()] = stage[0]
+	}
 	return validatorMapSnapshot
 }
 
-// GetResults is a function that gets the map of errors.
+// GetResults is a function that gets the map of results of the last completed run by
+// priority. When several validators share a priority, their results are aggregated.
+// Priorities that did not run in the last run map to nil.
 func (v *Validation[T]) GetResults() map[int]ci.IValidationResult {
 	if v == nil {
 		return nil
 	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
 	if !v.hasValidation {
 		return nil
 	}
 	results := make(map[int]ci.IValidationResult)
-	v.validatorMap.Range(func(key, value any) bool {
-		if validator, ok := value.(ci.IValidationFunc[T]); ok {
-			results[validator.GetPriority()] = validator.GetResult()
+	for _, stage := range v.stages() {
+		priority := stage[0].GetPriority()
+		stageResults := v.results[priority]
+		switch len(stageResults) {
+		case 0:
+			results[priority] = nil
+		case 1:
+			results[priority] = stageResults[0]
+		default:
+			failures := make([]int, 0)
+			for _, result := range stageResults {
+				if !result.GetIsValid() {
+					failures = append(failures, priority)
+				}
+			}
+			results[priority] = aggregateResults(stageResults, failures)
 		}
-		return true
-	})
+	}
 	return results
 }
 
-// ClearResults is a function that clears the map of errors.
+// ClearResults is a function that clears the results of the last run.
 func (v *Validation[T]) ClearResults() {
 	if v == nil {
 		return
 	}
-	if !v.hasValidation {
-		return
-	}
-	v.validatorMap.Range(func(key, value any) bool {
-		if validator, ok := value.(ValidationFunc[T]); ok {
-			validator.Result = nil
-			v.validatorMap.Store(key, validator)
-		}
-		return true
-	})
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.results = nil
 }
 
 // IsValid is a function that gets the boolean that indicates if the value is valid.
@@ -468,6 +590,9 @@ func (v *Validation[T]) IsValid() bool {
 		// But we will Log that the validation is nil.
 		return false
 	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
 	if !v.hasValidation {
 		// If the validation has no validators, we need to return false.
 		// But we will Log that the validation has no validators.
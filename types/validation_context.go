@@ -0,0 +1,315 @@
+package types
+
+import (
+	ci "github.com/rafa-mori/smart_plane/internal/interfaces"
+
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ContextValidationFunc is a validator that receives a context and can be cancelled or timed out.
+// It still satisfies IValidationFunc, running with a background context when called directly.
+type ContextValidationFunc[T any] struct {
+	*ValidationFunc[T]
+	// CtxFunc is the context-aware validation function.
+	CtxFunc func(ctx context.Context, value *T, args ...any) ci.IValidationResult
+	// Timeout is the maximum duration of a single run. Zero means no timeout.
+	Timeout time.Duration
+}
+
+func newContextValidationFunc[T any](priority int, timeout time.Duration, f func(ctx context.Context, value *T, args ...any) ci.IValidationResult) *ContextValidationFunc[T] {
+	cvf := &ContextValidationFunc[T]{
+		ValidationFunc: newValidationFunc[T](priority, nil),
+		CtxFunc:        f,
+		Timeout:        timeout,
+	}
+	cvf.Func = func(value *T, args ...any) ci.IValidationResult {
+		return cvf.run(context.Background(), value, args...)
+	}
+	return cvf
+}
+func NewContextValidationFunc[T any](priority int, timeout time.Duration, f func(ctx context.Context, value *T, args ...any) ci.IValidationResult) ci.IContextValidationFunc[T] {
+	return newContextValidationFunc[T](priority, timeout, f)
+}
+
+func (cvf *ContextValidationFunc[T]) GetContextFunction() func(ctx context.Context, value *T, args ...any) ci.IValidationResult {
+	if cvf == nil {
+		return nil
+	}
+	return cvf.CtxFunc
+}
+func (cvf *ContextValidationFunc[T]) SetContextFunction(f func(ctx context.Context, value *T, args ...any) ci.IValidationResult) {
+	if cvf == nil {
+		return
+	}
+	cvf.CtxFunc = f
+}
+func (cvf *ContextValidationFunc[T]) GetTimeout() time.Duration {
+	if cvf == nil {
+		return 0
+	}
+	return cvf.Timeout
+}
+func (cvf *ContextValidationFunc[T]) SetTimeout(timeout time.Duration) {
+	if cvf == nil {
+		return
+	}
+	cvf.Timeout = timeout
+}
+
+// run executes the context function, bounded by the validator timeout and the context.
+// When the context ends first, the run is reported as a failure carrying the context error;
+// the function keeps running in the background until it returns. A panicking function fails.
+func (cvf *ContextValidationFunc[T]) run(ctx context.Context, value *T, args ...any) ci.IValidationResult {
+	if cvf.CtxFunc == nil {
+		return NewValidationResult(false, "validator function is nil", nil, fmt.Errorf("validator function is nil"))
+	}
+	if cvf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cvf.Timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return NewValidationResult(false, fmt.Sprintf("validator with priority %d not run: %v", cvf.Priority, err), nil, err)
+	}
+
+	// A context that can never end needs no watcher; a panic then reaches the caller's recover.
+	if ctx.Done() == nil {
+		return cvf.CtxFunc(ctx, value, args...)
+	}
+
+	done := make(chan ci.IValidationResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err := fmt.Errorf("validator with priority %d panicked: %v", cvf.Priority, r)
+				done <- NewValidationResult(false, err.Error(), nil, err)
+			}
+		}()
+		done <- cvf.CtxFunc(ctx, value, args...)
+	}()
+
+	select {
+	case result := <-done:
+		return result
+	case <-ctx.Done():
+		return NewValidationResult(false, fmt.Sprintf("validator with priority %d interrupted: %v", cvf.Priority, ctx.Err()), nil, ctx.Err())
+	}
+}
+
+// SetConcurrency sets how many validators of the same priority may run at once.
+// Values lower than 2 run every validator sequentially.
+func (v *Validation[T]) SetConcurrency(concurrency int) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.concurrency = concurrency
+}
+
+// GetConcurrency returns how many validators of the same priority may run at once.
+func (v *Validation[T]) GetConcurrency() int {
+	if v == nil {
+		return 0
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.concurrency
+}
+
+// ValidateContext validates the value, honouring the cancellation of ctx.
+//
+// Priorities run in ascending order. Validators sharing a priority run concurrently,
+// up to the configured concurrency, and their results are merged in registration order,
+// so the outcome does not depend on scheduling. Once ctx is done, the remaining validators
+// are reported as failed with the context error.
+//
+// The configuration and the validators are read when the run starts; validators and
+// listeners run without the validation lock, so they may use the validation themselves,
+// and concurrent runs do not wait for each other.
+func (v *Validation[T]) ValidateContext(ctx context.Context, value *T, args ...any) ci.IValidationResult {
+	if v == nil {
+		return NewValidationResult(false, "validation is nil", nil, fmt.Errorf("validation is nil"))
+	}
+	if value == nil {
+		return NewValidationResult(false, "value is nil", nil, fmt.Errorf("value is nil"))
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	v.mu.RLock()
+	hasValidation, collectAll, concurrency, listener := v.hasValidation, v.collectAll, v.concurrency, v.listener
+	stages := v.stages()
+	v.mu.RUnlock()
+
+	if !hasValidation {
+		return NewValidationResult(false, "validation has no validators", nil, fmt.Errorf("validation has no validators"))
+	}
+
+	run := v.newRun(listener)
+	v.emit(run, ValidationListenerTypeBefore, -1, NewValidationResult(true, "validation started", nil, nil))
+
+	results := make([]ci.IValidationResult, 0)
+	failures := make([]int, 0)
+	byPriority := make(map[int][]ci.IValidationResult, len(stages))
+	for _, stage := range stages {
+		stageResults := runStage(ctx, stage, concurrency, collectAll, value, args...)
+		for i, result := range stageResults {
+			priority := stage[i].GetPriority()
+			results = append(results, result)
+			byPriority[priority] = append(byPriority[priority], result)
+			if !result.GetIsValid() {
+				failures = append(failures, priority)
+			}
+			v.emit(run, ValidationListenerTypeResult, priority, result)
+		}
+		if len(failures) > 0 && !collectAll {
+			break
+		}
+	}
+
+	valid := len(failures) == 0
+	v.mu.Lock()
+	v.isValid = valid
+	v.results = byPriority
+	v.mu.Unlock()
+
+	aggregate := aggregateResults(results, failures)
+	if valid {
+		v.emit(run, ValidationListenerTypeSuccess, -1, aggregate)
+	} else {
+		v.emit(run, ValidationListenerTypeError, -1, aggregate)
+	}
+	v.emit(run, ValidationListenerTypeAfter, -1, aggregate)
+
+	return aggregate
+}
+
+// runStage runs every validator of a priority, up to concurrency at once. Running
+// sequentially, it stops at the first failure unless collectAll is set.
+func runStage[T any](ctx context.Context, stage []ci.IValidationFunc[T], concurrency int, collectAll bool, value *T, args ...any) []ci.IValidationResult {
+	results := make([]ci.IValidationResult, len(stage))
+	if concurrency < 2 || len(stage) < 2 {
+		for i, validator := range stage {
+			results[i] = runValidator(ctx, validator, value, args...)
+			if !results[i].GetIsValid() && !collectAll {
+				return results[:i+1]
+			}
+		}
+		return results
+	}
+
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for i, validator := range stage {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, validator ci.IValidationFunc[T]) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = runValidator(ctx, validator, value, args...)
+		}(i, validator)
+	}
+	wg.Wait()
+	return results
+}
+
+// runValidator runs a single validator. A panicking validator fails.
+func runValidator[T any](ctx context.Context, validator ci.IValidationFunc[T], value *T, args ...any) (result ci.IValidationResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("validator with priority %d panicked: %v", validator.GetPriority(), r)
+			result = NewValidationResult(false, err.Error(), nil, err)
+		}
+	}()
+
+	if cvf, ok := validator.(interface {
+		run(ctx context.Context, value *T, args ...any) ci.IValidationResult
+	}); ok {
+		result = cvf.run(ctx, value, args...)
+	} else if err := ctx.Err(); err != nil {
+		result = NewValidationResult(false, fmt.Sprintf("validator with priority %d not run: %v", validator.GetPriority(), err), nil, err)
+	} else if fn := validator.GetFunction(); fn != nil {
+		result = fn(value, args...)
+	}
+	if result == nil {
+		result = NewValidationResult(true, "", nil, nil)
+	}
+	return result
+}
+
+// SetListener sets the listener that receives the lifecycle events of the validation:
+// before, result (once per validator), success or error, and after.
+func (v *Validation[T]) SetListener(listener *ValidationListener) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.listener = listener
+}
+
+// GetListener returns the listener of the validation.
+func (v *Validation[T]) GetListener() *ValidationListener {
+	if v == nil {
+		return nil
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.listener
+}
+
+// validationRun numbers the events of one run of a validation.
+type validationRun struct {
+	// listener receives the events of the run: the listener of the validation when the run
+	// started.
+	listener *ValidationListener
+	// reference is the parent of the event references of the run.
+	reference *Reference
+	events    int
+}
+
+// newRun starts the event numbering of a run notifying listener. Its reference is derived
+// from a new run ID under `validation/<type>`, so the events of a run share a parent. It is
+// nil without a listener.
+func (v *Validation[T]) newRun(listener *ValidationListener) *validationRun {
+	if listener == nil {
+		return nil
+	}
+	reference, _ := NewReferencePath(uuid.Nil, "validation", reflect.TypeFor[T]().String(), uuid.NewString())
+	return &validationRun{listener: listener, reference: reference}
+}
+
+// emit notifies the listener of a lifecycle event. The event carries the result, the
+// validator priority (-1 for events about the whole validation) and the validated type. Its
+// reference is named `validation.<type>`, with an ID derived from the run and the position
+// of the event in the run.
+func (v *Validation[T]) emit(run *validationRun, listenerType ValidationListenerType, priority int, result ci.IValidationResult) {
+	if run == nil || result == nil {
+		return
+	}
+	event := newValidationResult(result.GetIsValid(), result.GetMessage(), map[string]any{
+		"event":    string(listenerType),
+		"priority": priority,
+		"type":     reflect.TypeFor[T]().String(),
+		"result":   result,
+	}, result.GetError())
+	run.events++
+	event.Reference = run.reference.Child(strconv.Itoa(run.events))
+	event.Reference.Name = "validation." + string(listenerType)
+	run.listener.Notify(listenerType, event)
+}
@@ -0,0 +1,70 @@
+package types
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	ci "github.com/rafa-mori/smart_plane/internal/interfaces"
+)
+
+func TestValidatorsMayUseTheirValidation(t *testing.T) {
+	v := NewValidation[int]().(*Validation[int])
+	v.SetListener(NewValidationListener())
+	err := v.AddValidator(NewValidationFunc(1, func(value *int, args ...any) ci.IValidationResult {
+		// Each call takes the validation lock, which a run must not hold.
+		_ = v.GetListener()
+		_ = v.GetResults()
+		return NewValidationResult(*value > 0, "", nil, nil)
+	}))
+	if err != nil {
+		t.Fatalf("AddValidator: %v", err)
+	}
+
+	done := make(chan ci.IValidationResult, 1)
+	go func() {
+		value := 1
+		done <- v.ValidateContext(context.Background(), &value)
+	}()
+	select {
+	case result := <-done:
+		if !result.GetIsValid() {
+			t.Fatalf("ValidateContext = %v, want valid", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ValidateContext deadlocked on a validator using the validation")
+	}
+}
+
+func TestConcurrentValidationsKeepTheirOwnResults(t *testing.T) {
+	v := NewValidation[int]().(*Validation[int])
+	err := v.AddValidator(NewValidationFunc(1, func(value *int, args ...any) ci.IValidationResult {
+		return NewValidationResult(*value%2 == 0, "", nil, nil)
+	}))
+	if err != nil {
+		t.Fatalf("AddValidator: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(value int) {
+			defer wg.Done()
+			if got, want := v.Validate(&value).GetIsValid(), value%2 == 0; got != want {
+				t.Errorf("Validate(%d) = %v, want %v", value, got, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	value := 3
+	v.Validate(&value)
+	if result := v.GetResults()[1]; result == nil || result.GetIsValid() {
+		t.Fatalf("GetResults()[1] = %v, want the failure of the last run", result)
+	}
+	v.ClearResults()
+	if result := v.GetResults()[1]; result != nil {
+		t.Fatalf("GetResults()[1] after ClearResults = %v, want nil", result)
+	}
+}
@@ -0,0 +1,279 @@
+package types
+
+import (
+	gl "github.com/rafa-mori/smart_plane/logger"
+
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// DispatchPolicy defines what happens when a listener queue is full.
+type DispatchPolicy string
+
+const (
+	DispatchPolicyBlock      DispatchPolicy = "block"       // Wait for room in the queue (backpressure), except from a handler
+	DispatchPolicyDropNewest DispatchPolicy = "drop_newest" // Drop the event being dispatched
+	DispatchPolicyDropOldest DispatchPolicy = "drop_oldest" // Drop the oldest queued event
+)
+
+// DispatcherConfig configures a ValidationDispatcher.
+type DispatcherConfig struct {
+	// Workers is the number of goroutines delivering events, started with the first event.
+	// Defaults to runtime.NumCPU().
+	Workers int
+	// QueueSize is the maximum number of pending events per listener. Defaults to 1024.
+	QueueSize int
+	// Policy is applied when a listener queue is full. Defaults to DispatchPolicyBlock.
+	Policy DispatchPolicy
+}
+
+// DispatcherStats holds the counters of a ValidationDispatcher.
+type DispatcherStats struct {
+	Delivered uint64
+	Dropped   uint64
+	Panics    uint64
+	// Reentrant counts the events queued past QueueSize under DispatchPolicyBlock because
+	// they were dispatched by a handler, which cannot wait for the queues it drains.
+	Reentrant uint64
+}
+
+// dispatchJob is a single pending delivery.
+type dispatchJob struct {
+	handler func(*ValidationResult)
+	result  *ValidationResult
+}
+
+// listenerQueue is the ordered queue of pending deliveries of one listener.
+type listenerQueue struct {
+	key   string
+	items []dispatchJob
+	// scheduled is set while the queue is waiting for, or owned by, a worker.
+	scheduled bool
+}
+
+// ValidationDispatcher delivers listener events with a bounded worker pool. Events for the
+// same listener key are delivered one at a time, in dispatch order; different listeners
+// are served in parallel.
+type ValidationDispatcher struct {
+	mu sync.Mutex
+	// work is signalled when a queue becomes ready or the dispatcher closes.
+	work *sync.Cond
+	// space is signalled when an event leaves a queue.
+	space *sync.Cond
+
+	config DispatcherConfig
+	queues map[string]*listenerQueue
+	ready  []*listenerQueue
+	closed bool
+	// started is set once the workers run; they start with the first event, so idle
+	// listeners hold no goroutines.
+	started bool
+	wg      sync.WaitGroup
+	// workers holds the goroutine IDs of the workers, to tell a dispatch from a handler.
+	workers map[int64]struct{}
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	panics    atomic.Uint64
+	reentrant atomic.Uint64
+}
+
+func newValidationDispatcher(config DispatcherConfig) *ValidationDispatcher {
+	if config.Workers <= 0 {
+		config.Workers = runtime.NumCPU()
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1024
+	}
+	if config.Policy == "" {
+		config.Policy = DispatchPolicyBlock
+	}
+
+	d := &ValidationDispatcher{
+		config:  config,
+		queues:  make(map[string]*listenerQueue),
+		ready:   make([]*listenerQueue, 0),
+		workers: make(map[int64]struct{}),
+	}
+	d.work = sync.NewCond(&d.mu)
+	d.space = sync.NewCond(&d.mu)
+	return d
+}
+
+// start runs the workers, on the first dispatched event. It must be called with d.mu held.
+func (d *ValidationDispatcher) start() {
+	if d.started {
+		return
+	}
+	d.started = true
+	d.wg.Add(d.config.Workers)
+	for i := 0; i < d.config.Workers; i++ {
+		go d.worker()
+	}
+}
+
+// NewValidationDispatcher creates a dispatcher; its workers start with the first event.
+func NewValidationDispatcher(config DispatcherConfig) *ValidationDispatcher {
+	return newValidationDispatcher(config)
+}
+
+// Dispatch queues an event for a listener. It returns false when the event was dropped,
+// either by the queue policy or because the dispatcher is closed.
+//
+// Under DispatchPolicyBlock, a full queue makes Dispatch wait for a worker to take an event,
+// so it must not be called with a lock that a handler may need. A handler dispatching to a
+// full queue does not wait, since it may be the only worker that can drain it: the event is
+// queued past the bound and counted in DispatcherStats.Reentrant.
+func (d *ValidationDispatcher) Dispatch(key string, handler func(*ValidationResult), result *ValidationResult) bool {
+	if d == nil || handler == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.closed {
+		d.start()
+	}
+	q, ok := d.queues[key]
+	if !ok {
+		q = &listenerQueue{key: key}
+		d.queues[key] = q
+	}
+
+full:
+	for !d.closed && len(q.items) >= d.config.QueueSize {
+		switch d.config.Policy {
+		case DispatchPolicyDropNewest:
+			d.dropped.Add(1)
+			return false
+		case DispatchPolicyDropOldest:
+			q.items = q.items[1:]
+			d.dropped.Add(1)
+		default:
+			if d.isWorker() {
+				d.reentrant.Add(1)
+				break full
+			}
+			d.space.Wait()
+		}
+	}
+	if d.closed {
+		d.dropped.Add(1)
+		return false
+	}
+
+	q.items = append(q.items, dispatchJob{handler: handler, result: result})
+	if !q.scheduled {
+		q.scheduled = true
+		d.ready = append(d.ready, q)
+		d.work.Signal()
+	}
+	return true
+}
+
+// Close stops accepting events and waits until every queued event has been delivered,
+// or until ctx is done.
+func (d *ValidationDispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	d.closed = true
+	d.work.Broadcast()
+	d.space.Broadcast()
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("dispatcher drain interrupted: %w", ctx.Err())
+	}
+}
+
+// Stats returns the dispatcher counters.
+func (d *ValidationDispatcher) Stats() DispatcherStats {
+	if d == nil {
+		return DispatcherStats{}
+	}
+	return DispatcherStats{
+		Delivered: d.delivered.Load(),
+		Dropped:   d.dropped.Load(),
+		Panics:    d.panics.Load(),
+		Reentrant: d.reentrant.Load(),
+	}
+}
+
+// isWorker reports whether the calling goroutine is a worker of the dispatcher, running a
+// handler. It must be called with d.mu held.
+func (d *ValidationDispatcher) isWorker() bool {
+	_, ok := d.workers[goroutineID()]
+	return ok
+}
+
+// worker delivers one event at a time from the ready queues until the dispatcher is closed
+// and drained.
+func (d *ValidationDispatcher) worker() {
+	defer d.wg.Done()
+	goroutine := goroutineID()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.workers[goroutine] = struct{}{}
+	defer delete(d.workers, goroutine)
+
+	for {
+		for len(d.ready) == 0 && !d.closed {
+			d.work.Wait()
+		}
+		if len(d.ready) == 0 {
+			return
+		}
+
+		q := d.ready[0]
+		d.ready = d.ready[1:]
+		job := q.items[0]
+		q.items = q.items[1:]
+		d.space.Broadcast()
+
+		d.mu.Unlock()
+		d.deliver(q.key, job)
+		d.mu.Lock()
+
+		// Re-queue behind the other listeners, keeping this listener ordered.
+		if len(q.items) > 0 {
+			d.ready = append(d.ready, q)
+			d.work.Signal()
+		} else {
+			q.scheduled = false
+			delete(d.queues, q.key)
+		}
+	}
+}
+
+// deliver runs a handler, recovering from panics.
+func (d *ValidationDispatcher) deliver(key string, job dispatchJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.panics.Add(1)
+			gl.Log("error", fmt.Sprintf("Validation listener %s panicked: %v", key, r))
+		}
+	}()
+	job.handler(job.result)
+	d.delivered.Add(1)
+}
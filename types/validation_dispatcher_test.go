@@ -0,0 +1,36 @@
+package types
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandlersMayDispatchToTheirFullQueue(t *testing.T) {
+	d := NewValidationDispatcher(DispatcherConfig{Workers: 1, QueueSize: 1, Policy: DispatchPolicyBlock})
+	var delivered atomic.Int32
+	var handler func(*ValidationResult)
+	handler = func(result *ValidationResult) {
+		if delivered.Add(1) == 1 {
+			// The only worker runs this handler, so waiting for room would never end.
+			for i := 0; i < 3; i++ {
+				d.Dispatch("audit", handler, result)
+			}
+		}
+	}
+	d.Dispatch("audit", handler, &ValidationResult{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for delivered.Load() < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if stats := d.Stats(); stats.Delivered != 4 || stats.Dropped != 0 || stats.Reentrant != 2 {
+		t.Fatalf("Stats = %+v, want 4 delivered, 2 of them queued past the bound", stats)
+	}
+}
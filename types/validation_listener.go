@@ -1,8 +1,12 @@
 package types
 
 import (
+	"context"
+	"fmt"
 	"reflect"
+	"sort"
 
+	"github.com/google/uuid"
 	gl "github.com/rafa-mori/smart_plane/logger"
 )
 
@@ -13,6 +17,7 @@ const (
 	ValidationListenerTypeAfter   ValidationListenerType = "after"   // After validation
 	ValidationListenerTypeError   ValidationListenerType = "error"   // Error validation
 	ValidationListenerTypeSuccess ValidationListenerType = "success" // Success validation
+	ValidationListenerTypeResult  ValidationListenerType = "result"  // Single validator result
 	ValidationListenerTypeDefault ValidationListenerType = "default" // Default validation
 )
 
@@ -26,18 +31,112 @@ const (
 
 type ValidationListener struct {
 	*Mutexes
-	Filters   map[ValidationFilterType]func(*ValidationResult) bool
-	Handlers  []func(*ValidationResult)
-	Listeners map[Reference]map[ValidationListenerType]func(*ValidationResult)
+	Filters  map[ValidationFilterType]func(*ValidationResult) bool
+	Handlers []func(*ValidationResult)
+	// Listeners holds the listeners by the ID of their reference, so a reference renamed or
+	// decoded again still finds its listeners.
+	Listeners map[uuid.UUID]map[ValidationListenerType]func(*ValidationResult)
+
+	// references holds the reference each listener ID was last registered with.
+	references map[uuid.UUID]Reference
+
+	// handlerIDs holds the registration ID of each handler, in the order of Handlers, so
+	// that handlers built from the same closure keep separate delivery queues.
+	handlerIDs    []uint64
+	nextHandlerID uint64
+	// subscriptions holds the pattern subscriptions, by reference ID, and nextSubscriptionID
+	// numbers them in registration order.
+	subscriptions      map[uuid.UUID]*ValidationSubscription
+	nextSubscriptionID uint64
+	// dispatcher delivers the events to the listeners and handlers.
+	dispatcher *ValidationDispatcher
 }
 
 func NewValidationListener() *ValidationListener {
+	return NewValidationListenerWithConfig(DispatcherConfig{})
+}
+
+// NewValidationListenerWithConfig creates a listener whose events are delivered by a
+// dispatcher with the given configuration.
+func NewValidationListenerWithConfig(config DispatcherConfig) *ValidationListener {
 	return &ValidationListener{
-		Mutexes:   NewMutexesType(),
-		Listeners: make(map[Reference]map[ValidationListenerType]func(*ValidationResult)),
-		Filters:   make(map[ValidationFilterType]func(*ValidationResult) bool),
-		Handlers:  []func(*ValidationResult){},
+		Mutexes:       NewMutexesType(),
+		Listeners:     make(map[uuid.UUID]map[ValidationListenerType]func(*ValidationResult)),
+		references:    make(map[uuid.UUID]Reference),
+		Filters:       make(map[ValidationFilterType]func(*ValidationResult) bool),
+		Handlers:      []func(*ValidationResult){},
+		subscriptions: make(map[uuid.UUID]*ValidationSubscription),
+		dispatcher:    newValidationDispatcher(config),
+	}
+}
+
+// Close stops the delivery of new events and waits for the queued ones, or until ctx is done.
+func (vl *ValidationListener) Close(ctx context.Context) error {
+	if vl == nil {
+		return nil
+	}
+	return vl.dispatcher.Close(ctx)
+}
+
+// GetDispatcherStats returns the delivery counters of the listener.
+func (vl *ValidationListener) GetDispatcherStats() DispatcherStats {
+	if vl == nil {
+		return DispatcherStats{}
+	}
+	return vl.dispatcher.Stats()
+}
+
+// dispatch queues the delivery of a result to a handler, keyed for ordered delivery.
+func (vl *ValidationListener) dispatch(key string, handler func(*ValidationResult), result *ValidationResult) {
+	if vl.dispatcher == nil {
+		// Listener built without a constructor.
+		vl.Mutexes.MuLock()
+		if vl.dispatcher == nil {
+			vl.dispatcher = newValidationDispatcher(DispatcherConfig{})
+		}
+		vl.Mutexes.MuUnlock()
+	}
+	if !vl.dispatcher.Dispatch(key, handler, result) {
+		gl.Log("warn", fmt.Sprintf("Validation event dropped for listener %s", key))
+	}
+}
+
+// listenerKey identifies a registered listener for ordered delivery.
+func listenerKey(id uuid.UUID, listenerType ValidationListenerType) string {
+	return id.String() + "/" + string(listenerType)
+}
+
+// listenerReference returns the reference a listener ID was registered with. Listeners
+// assigned directly to Listeners carry only their ID.
+func (vl *ValidationListener) listenerReference(id uuid.UUID) Reference {
+	if reference, ok := vl.references[id]; ok {
+		return reference
+	}
+	return Reference{ID: id}
+}
+
+// addListener registers a listener of a type under the ID of its reference.
+func (vl *ValidationListener) addListener(reference Reference, listenerType ValidationListenerType, handler func(*ValidationResult)) {
+	if vl.Listeners == nil {
+		vl.Listeners = make(map[uuid.UUID]map[ValidationListenerType]func(*ValidationResult))
+	}
+	if vl.references == nil {
+		vl.references = make(map[uuid.UUID]Reference)
+	}
+	if _, exists := vl.Listeners[reference.ID]; !exists {
+		vl.Listeners[reference.ID] = make(map[ValidationListenerType]func(*ValidationResult))
+	}
+	vl.Listeners[reference.ID][listenerType] = handler
+	vl.references[reference.ID] = reference
+}
+
+// handlerKey identifies a global handler for ordered delivery by its registration.
+func (vl *ValidationListener) handlerKey(index int) string {
+	if index < len(vl.handlerIDs) && len(vl.handlerIDs) == len(vl.Handlers) {
+		return fmt.Sprintf("handler/%08d", vl.handlerIDs[index])
 	}
+	// Handlers assigned directly carry no registration ID.
+	return fmt.Sprintf("handler/index/%08d", index)
 }
 
 func (vl *ValidationListener) AddFilter(filterType ValidationFilterType, filter func(*ValidationResult) bool) {
@@ -78,7 +177,9 @@ func (vl *ValidationListener) AddHandler(handler func(*ValidationResult)) {
 	vl.Mutexes.MuLock()
 	defer vl.Mutexes.MuUnlock()
 
+	vl.nextHandlerID++
 	vl.Handlers = append(vl.Handlers, handler)
+	vl.handlerIDs = append(vl.handlerIDs, vl.nextHandlerID)
 }
 
 func (vl *ValidationListener) RemoveHandler(handler func(*ValidationResult)) {
@@ -92,6 +193,9 @@ func (vl *ValidationListener) RemoveHandler(handler func(*ValidationResult)) {
 	for i, h := range vl.Handlers {
 		if reflect.ValueOf(h).Pointer() == reflect.ValueOf(handler).Pointer() {
 			vl.Handlers = append(vl.Handlers[:i], vl.Handlers[i+1:]...)
+			if i < len(vl.handlerIDs) {
+				vl.handlerIDs = append(vl.handlerIDs[:i], vl.handlerIDs[i+1:]...)
+			}
 			break
 		}
 	}
@@ -105,10 +209,7 @@ func (vl *ValidationListener) AddListener(reference Reference, listenerType Vali
 	vl.Mutexes.MuLock()
 	defer vl.Mutexes.MuUnlock()
 
-	if _, exists := vl.Listeners[reference]; !exists {
-		vl.Listeners[reference] = make(map[ValidationListenerType]func(*ValidationResult))
-	}
-	vl.Listeners[reference][listenerType] = handler
+	vl.addListener(reference, listenerType, handler)
 }
 
 func (vl *ValidationListener) RemoveListener(reference Reference, listenerType ValidationListenerType) {
@@ -119,10 +220,11 @@ func (vl *ValidationListener) RemoveListener(reference Reference, listenerType V
 	vl.Mutexes.MuLock()
 	defer vl.Mutexes.MuUnlock()
 
-	if _, exists := vl.Listeners[reference]; exists {
-		delete(vl.Listeners[reference], listenerType)
-		if len(vl.Listeners[reference]) == 0 {
-			delete(vl.Listeners, reference)
+	if _, exists := vl.Listeners[reference.ID]; exists {
+		delete(vl.Listeners[reference.ID], listenerType)
+		if len(vl.Listeners[reference.ID]) == 0 {
+			delete(vl.Listeners, reference.ID)
+			delete(vl.references, reference.ID)
 		}
 	}
 }
@@ -190,8 +292,8 @@ func (vl *ValidationListener) GetListeners() map[Reference]map[ValidationListene
 	defer vl.Mutexes.MuUnlock()
 
 	listeners := make(map[Reference]map[ValidationListenerType]func(*ValidationResult))
-	for k, v := range vl.Listeners {
-		listeners[k] = v
+	for id, v := range vl.Listeners {
+		listeners[vl.listenerReference(id)] = v
 	}
 	return listeners
 }
@@ -204,8 +306,8 @@ func (vl *ValidationListener) GetListenersByName(name string) map[ValidationList
 	vl.Mutexes.MuLock()
 	defer vl.Mutexes.MuUnlock()
 
-	for k, v := range vl.Listeners {
-		if k.GetName() == name {
+	for id, v := range vl.Listeners {
+		if reference := vl.listenerReference(id); reference.GetName() == name {
 			return v
 		}
 	}
@@ -221,8 +323,9 @@ func (vl *ValidationListener) GetListenersKeys() map[string]Reference {
 	defer vl.Mutexes.MuUnlock()
 
 	keys := make(map[string]Reference)
-	for k := range vl.Listeners {
-		keys[k.GetName()] = k
+	for id := range vl.Listeners {
+		reference := vl.listenerReference(id)
+		keys[reference.GetName()] = reference
 	}
 	return keys
 }
@@ -240,10 +343,7 @@ func (vl *ValidationListener) RegisterListener(reference Reference, handler func
 		return
 	}
 
-	if _, exists := vl.Listeners[reference]; !exists {
-		vl.Listeners[reference] = make(map[ValidationListenerType]func(*ValidationResult))
-	}
-	vl.Listeners[reference][ValidationListenerTypeDefault] = handler
+	vl.addListener(reference, ValidationListenerTypeDefault, handler)
 }
 
 func (vl *ValidationListener) Trigger(event string, result *ValidationResult) {
@@ -251,10 +351,6 @@ func (vl *ValidationListener) Trigger(event string, result *ValidationResult) {
 		gl.Log("error", "RegisterListener: ValidationListener is nil")
 		return
 	}
-
-	vl.Mutexes.MuRLock()
-	defer vl.Mutexes.MuRUnlock()
-
 	if result == nil {
 		gl.Log("error", "RegisterListener: result is nil")
 		return
@@ -264,36 +360,105 @@ func (vl *ValidationListener) Trigger(event string, result *ValidationResult) {
 		return
 	}
 
-	if listenerZ := vl.GetListenersByName(event); listenerZ != nil {
-		// Check event filters
-		for _, filter := range vl.Filters {
-			if filter == nil {
-				gl.Log("error", "RegisterListener: filter is nil")
-				continue
-			}
-			if !filter(result) {
-				gl.Log("info", "RegisterListener: filter failed")
-				return
-			}
+	// Snapshot listeners and filters, so no lock is held while filtering or dispatching.
+	type keyedListener struct {
+		key      string
+		listener func(*ValidationResult)
+	}
+	vl.Mutexes.MuLock()
+	listenerZ := make([]keyedListener, 0)
+	for id, byType := range vl.Listeners {
+		if reference := vl.listenerReference(id); reference.GetName() != event {
+			continue
 		}
-		for _, listener := range listenerZ {
+		for listenerType, listener := range byType {
 			if listener == nil {
 				gl.Log("error", "RegisterListener: listener is nil")
 				continue
 			}
-			// Check listener filters
-			for _, filter := range vl.Filters {
-				if filter == nil {
-					gl.Log("error", "RegisterListener: filter is nil")
-					continue
-				}
-				if !filter(result) {
-					gl.Log("info", "RegisterListener: filter failed")
-					return
-				}
+			listenerZ = append(listenerZ, keyedListener{key: listenerKey(id, listenerType), listener: listener})
+		}
+	}
+	filters := make([]func(*ValidationResult) bool, 0, len(vl.Filters))
+	for _, filter := range vl.Filters {
+		if filter == nil {
+			gl.Log("error", "RegisterListener: filter is nil")
+			continue
+		}
+		filters = append(filters, filter)
+	}
+	subscriptions := vl.matchingSubscriptions(event)
+	vl.Mutexes.MuUnlock()
+
+	if len(listenerZ) == 0 && len(subscriptions) == 0 {
+		return
+	}
+	// Check event filters
+	for _, filter := range filters {
+		if !filter(result) {
+			gl.Log("info", "RegisterListener: filter failed")
+			return
+		}
+	}
+	sort.Slice(listenerZ, func(i, j int) bool { return listenerZ[i].key < listenerZ[j].key })
+	for _, l := range listenerZ {
+		vl.dispatch(l.key, l.listener, result)
+	}
+	vl.dispatchSubscriptions(event, subscriptions, result)
+}
+
+// Notify dispatches a lifecycle event to the listeners registered for its type, to the
+// default listeners, to the global handlers and to the subscriptions matching
+// `validation.<type>`. The event is dropped when a filter rejects it.
+func (vl *ValidationListener) Notify(listenerType ValidationListenerType, result *ValidationResult) {
+	if vl == nil {
+		gl.Log("error", "Notify: ValidationListener is nil")
+		return
+	}
+	if result == nil {
+		gl.Log("error", "Notify: result is nil")
+		return
+	}
+
+	vl.Mutexes.MuLock()
+	filters := make([]func(*ValidationResult) bool, 0, len(vl.Filters))
+	for _, filter := range vl.Filters {
+		if filter != nil {
+			filters = append(filters, filter)
+		}
+	}
+	handlers := make(map[string]func(*ValidationResult), len(vl.Handlers))
+	for i, handler := range vl.Handlers {
+		if handler != nil {
+			handlers[vl.handlerKey(i)] = handler
+		}
+	}
+	for id, listenerZ := range vl.Listeners {
+		if listener, ok := listenerZ[listenerType]; ok && listener != nil {
+			handlers[listenerKey(id, listenerType)] = listener
+		}
+		if listenerType != ValidationListenerTypeDefault {
+			if listener, ok := listenerZ[ValidationListenerTypeDefault]; ok && listener != nil {
+				handlers[listenerKey(id, ValidationListenerTypeDefault)] = listener
 			}
-			// Async dispatch
-			go listener(result)
 		}
 	}
+	event := "validation." + string(listenerType)
+	subscriptions := vl.matchingSubscriptions(event)
+	vl.Mutexes.MuUnlock()
+
+	for _, filter := range filters {
+		if !filter(result) {
+			return
+		}
+	}
+	keys := make([]string, 0, len(handlers))
+	for key := range handlers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		vl.dispatch(key, handlers[key], result)
+	}
+	vl.dispatchSubscriptions(event, subscriptions, result)
 }
@@ -0,0 +1,38 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestListenersAreKeyedByReferenceID(t *testing.T) {
+	vl := NewValidationListener()
+	reference := *NewDeterministicReference(uuid.Nil, "audit")
+	delivered := make(chan *ValidationResult, 1)
+	vl.AddListener(reference, ValidationListenerTypeSuccess, func(result *ValidationResult) { delivered <- result })
+
+	renamed := reference
+	renamed.SetName("renamed")
+	vl.AddListener(renamed, ValidationListenerTypeError, func(*ValidationResult) {})
+	if len(vl.Listeners) != 1 || len(vl.Listeners[reference.ID]) != 2 {
+		t.Fatalf("Listeners = %v, want both types under the reference ID", vl.Listeners)
+	}
+	if keys := vl.GetListenersKeys(); keys["renamed"].ID != reference.ID {
+		t.Fatalf("GetListenersKeys = %v, want the last registered name", keys)
+	}
+
+	vl.Notify(ValidationListenerTypeSuccess, &ValidationResult{})
+	select {
+	case <-delivered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("listener registered under a renamed reference was not notified")
+	}
+
+	vl.RemoveListener(reference, ValidationListenerTypeSuccess)
+	vl.RemoveListener(renamed, ValidationListenerTypeError)
+	if len(vl.Listeners) != 0 || len(vl.GetListeners()) != 0 {
+		t.Fatalf("Listeners after removal = %v, want none", vl.Listeners)
+	}
+}
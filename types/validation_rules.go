@@ -0,0 +1,410 @@
+package types
+
+import (
+	ci "github.com/rafa-mori/smart_plane/internal/interfaces"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationRuleTag is the struct tag read by NewStructTagValidators. It uses the
+// go-playground/validator syntax, plus `regexp=<pattern>`.
+const ValidationRuleTag = "validate"
+
+// ValidationRule is a declarative rule for a single field.
+type ValidationRule struct {
+	// Field is the dotted path of the field, using its JSON names (e.g. "owner.id").
+	Field string `json:"field" yaml:"field"`
+	// Required fails the rule when the field is missing or has its zero value.
+	Required bool `json:"required,omitempty" yaml:"required,omitempty"`
+	// Min and Max bound numbers by value, and strings, slices and maps by length.
+	Min *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Max *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+	// Pattern is a regular expression the field must match.
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	// Enum is the list of accepted values.
+	Enum []string `json:"enum,omitempty" yaml:"enum,omitempty"`
+	// UUID requires the field to be a UUID.
+	UUID bool `json:"uuid,omitempty" yaml:"uuid,omitempty"`
+	// DateFormat requires the field to be a date in the given Go layout, or one of
+	// the names RFC3339, RFC3339Nano, DateTime, DateOnly and TimeOnly.
+	DateFormat string `json:"dateFormat,omitempty" yaml:"dateFormat,omitempty"`
+	// Message replaces the generated failure message.
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+	// Tag is an optional raw go-playground/validator tag, combined with the fields above.
+	Tag string `json:"tag,omitempty" yaml:"tag,omitempty"`
+}
+
+// ValidationRuleSet is a named set of rules, as loaded from a JSON or YAML rule document.
+type ValidationRuleSet struct {
+	Name  string           `json:"name" yaml:"name"`
+	Rules []ValidationRule `json:"rules" yaml:"rules"`
+}
+
+var (
+	ruleValidatorOnce sync.Once
+	ruleValidator     *validator.Validate
+)
+
+// getRuleValidator returns the shared validator instance, with the `regexp` tag registered.
+func getRuleValidator() *validator.Validate {
+	ruleValidatorOnce.Do(func() {
+		ruleValidator = validator.New(validator.WithRequiredStructEnabled())
+		_ = ruleValidator.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
+			re, err := regexp.Compile(fl.Param())
+			if err != nil {
+				return false
+			}
+			return re.MatchString(fmt.Sprint(fl.Field().Interface()))
+		})
+	})
+	return ruleValidator
+}
+
+var dateFormatNames = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// compiledRule is a rule ready to be evaluated against a field value.
+type compiledRule struct {
+	field    []string
+	required bool
+	tag      string
+	pattern  *regexp.Regexp
+	rule     ValidationRule
+}
+
+// compile checks the rule and translates it to a validator tag.
+func (r ValidationRule) compile() (*compiledRule, error) {
+	if r.Field == "" {
+		return nil, fmt.Errorf("rule field cannot be empty")
+	}
+
+	tags := make([]string, 0)
+	if r.Tag != "" {
+		tags = append(tags, r.Tag)
+	}
+	if r.Min != nil {
+		tags = append(tags, "min="+strconv.FormatFloat(*r.Min, 'f', -1, 64))
+	}
+	if r.Max != nil {
+		tags = append(tags, "max="+strconv.FormatFloat(*r.Max, 'f', -1, 64))
+	}
+	if len(r.Enum) > 0 {
+		for _, e := range r.Enum {
+			if strings.ContainsAny(e, " ,") {
+				return nil, fmt.Errorf("rule %s: enum value %q cannot contain spaces or commas", r.Field, e)
+			}
+		}
+		tags = append(tags, "oneof="+strings.Join(r.Enum, " "))
+	}
+	if r.UUID {
+		tags = append(tags, "uuid")
+	}
+	if r.DateFormat != "" {
+		layout := r.DateFormat
+		if named, ok := dateFormatNames[layout]; ok {
+			layout = named
+		}
+		if strings.Contains(layout, ",") {
+			return nil, fmt.Errorf("rule %s: date format cannot contain commas", r.Field)
+		}
+		tags = append(tags, "datetime="+layout)
+	}
+
+	cr := &compiledRule{
+		field:    strings.Split(r.Field, "."),
+		required: r.Required,
+		tag:      strings.Join(tags, ","),
+		rule:     r,
+	}
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid pattern: %w", r.Field, err)
+		}
+		cr.pattern = re
+	}
+	return cr, nil
+}
+
+// check evaluates the rule against the document, as decoded from JSON.
+func (cr *compiledRule) check(doc any) error {
+	value, found := lookupField(doc, cr.field)
+	if !found || value == nil {
+		if cr.required {
+			return cr.fail("is required", nil)
+		}
+		return nil
+	}
+	if cr.tag != "" {
+		if err := getRuleValidator().Var(value, cr.tag); err != nil {
+			return cr.fail("", err)
+		}
+	}
+	if cr.pattern != nil && !cr.pattern.MatchString(fmt.Sprint(value)) {
+		return cr.fail(fmt.Sprintf("does not match pattern %s", cr.pattern.String()), nil)
+	}
+	return nil
+}
+
+// fail builds the rule failure error.
+func (cr *compiledRule) fail(reason string, err error) error {
+	if cr.rule.Message != "" {
+		return errors.New(cr.rule.Message)
+	}
+	if reason == "" {
+		reason = describeValidatorError(err)
+	}
+	return fmt.Errorf("field %s %s", cr.rule.Field, reason)
+}
+
+// describeValidatorError turns validator errors into a short, readable reason.
+func describeValidatorError(err error) string {
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return err.Error()
+	}
+	reasons := make([]string, 0, len(fieldErrors))
+	for _, fe := range fieldErrors {
+		if fe.Param() != "" {
+			reasons = append(reasons, fmt.Sprintf("failed rule %s=%s", fe.Tag(), fe.Param()))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("failed rule %s", fe.Tag()))
+		}
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// lookupField walks a JSON-decoded document by path.
+func lookupField(doc any, path []string) (any, bool) {
+	current := doc
+	for _, key := range path {
+		obj, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// ParseValidationRules parses a rule document. The format is "json" or "yaml".
+func ParseValidationRules(data []byte, format string) (*ValidationRuleSet, error) {
+	set := &ValidationRuleSet{}
+	switch strings.ToLower(format) {
+	case "json":
+		if err := json.Unmarshal(data, set); err != nil {
+			return nil, fmt.Errorf("invalid JSON rule document: %w", err)
+		}
+	case "yaml", "yml":
+		if err := yaml.Unmarshal(data, set); err != nil {
+			return nil, fmt.Errorf("invalid YAML rule document: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported rule document format: %s", format)
+	}
+	for _, rule := range set.Rules {
+		if _, err := rule.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// LoadValidationRules reads a rule document, picking the format from the file extension.
+func LoadValidationRules(path string) (*ValidationRuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule document: %w", err)
+	}
+	return ParseValidationRules(data, strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// NewRuleValidators builds one validator per rule of the set. All validators share the
+// given priority, so they form a single stage of a Validation.
+func NewRuleValidators[T any](set *ValidationRuleSet, priority int) ([]ci.IValidationFunc[T], error) {
+	if set == nil {
+		return nil, fmt.Errorf("rule set is nil")
+	}
+	validators := make([]ci.IValidationFunc[T], 0, len(set.Rules))
+	for _, rule := range set.Rules {
+		cr, err := rule.compile()
+		if err != nil {
+			return nil, err
+		}
+		validators = append(validators, NewValidationFunc[T](priority, func(value *T, args ...any) ci.IValidationResult {
+			doc, err := toDocument(value)
+			if err != nil {
+				return NewValidationResult(false, err.Error(), map[string]any{"field": cr.rule.Field}, err)
+			}
+			if err := cr.check(doc); err != nil {
+				return NewValidationResult(false, err.Error(), map[string]any{"field": cr.rule.Field}, err)
+			}
+			return NewValidationResult(true, "", map[string]any{"field": cr.rule.Field}, nil)
+		}))
+	}
+	return validators, nil
+}
+
+// toDocument converts a value to its generic JSON representation, so rules address fields by JSON name.
+func toDocument[T any](value *T) (any, error) {
+	if value == nil {
+		return nil, fmt.Errorf("value is nil")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize value: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to deserialize value: %w", err)
+	}
+	return doc, nil
+}
+
+// NewStructTagValidators builds one validator per field of T carrying a `validate` tag.
+// Nested structs are walked; the field path uses JSON names. All validators share the
+// given priority.
+func NewStructTagValidators[T any](priority int) ([]ci.IValidationFunc[T], error) {
+	typ := reflect.TypeFor[T]()
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("type %s is not a struct", typ.String())
+	}
+
+	validators := make([]ci.IValidationFunc[T], 0)
+	collectTaggedFields(typ, nil, nil, map[reflect.Type]bool{}, func(index []int, name, tag string) {
+		validators = append(validators, NewValidationFunc[T](priority, func(value *T, args ...any) ci.IValidationResult {
+			if value == nil {
+				return NewValidationResult(false, "value is nil", nil, fmt.Errorf("value is nil"))
+			}
+			fieldValue, ok := fieldByIndex(reflect.ValueOf(value).Elem(), index)
+			if !ok {
+				if hasTagOption(tag, "required") {
+					err := fmt.Errorf("field %s is required", name)
+					return NewValidationResult(false, err.Error(), map[string]any{"field": name}, err)
+				}
+				return NewValidationResult(true, "", map[string]any{"field": name}, nil)
+			}
+			if err := getRuleValidator().Var(fieldValue.Interface(), tag); err != nil {
+				err = fmt.Errorf("field %s %s", name, describeValidatorError(err))
+				return NewValidationResult(false, err.Error(), map[string]any{"field": name}, err)
+			}
+			return NewValidationResult(true, "", map[string]any{"field": name}, nil)
+		}))
+	})
+	return validators, nil
+}
+
+// collectTaggedFields walks the exported fields of a struct type, calling fn for each tagged field.
+// A struct type is not walked again below itself, so self-referential types terminate.
+func collectTaggedFields(typ reflect.Type, index []int, path []string, visiting map[reflect.Type]bool, fn func(index []int, name, tag string)) {
+	visiting[typ] = true
+	defer delete(visiting, typ)
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+		name := jsonFieldName(field)
+		if name == "-" {
+			continue
+		}
+		fieldPath := append(append([]string(nil), path...), name)
+
+		if tag, ok := field.Tag.Lookup(ValidationRuleTag); ok && tag != "" && tag != "-" {
+			fn(fieldIndex, strings.Join(fieldPath, "."), tag)
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct && fieldType != reflect.TypeFor[time.Time]() && !visiting[fieldType] {
+			collectTaggedFields(fieldType, fieldIndex, fieldPath, visiting, fn)
+		}
+	}
+}
+
+// hasTagOption reports whether a comma separated validate tag has an option, ignoring its
+// parameter: "required" matches "required" but not "required_if=...".
+func hasTagOption(tag, option string) bool {
+	for _, opt := range strings.Split(tag, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		if name == option {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex without panics on nil embedded pointers.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, idx := range index {
+		if i > 0 {
+			for v.Kind() == reflect.Pointer {
+				if v.IsNil() {
+					return reflect.Value{}, false
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(idx)
+	}
+	return v, true
+}
+
+// jsonFieldName returns the JSON name of a struct field.
+func jsonFieldName(field reflect.StructField) string {
+	if tag, ok := field.Tag.Lookup("json"); ok {
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// AddRuleValidators replaces every validator of the given priority with the rules of a rule
+// document, so an updated document takes effect without a rebuild.
+func AddRuleValidators[T any](v ci.IValidation[T], path string, priority int) error {
+	if v == nil {
+		return fmt.Errorf("validation is nil")
+	}
+	set, err := LoadValidationRules(path)
+	if err != nil {
+		return err
+	}
+	validators, err := NewRuleValidators[T](set, priority)
+	if err != nil {
+		return err
+	}
+	_ = v.RemoveValidator(priority)
+	for _, validator := range validators {
+		if err := v.AddValidator(validator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package types
+
+import (
+	gl "github.com/rafa-mori/smart_plane/logger"
+
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ValidationSubscription is a pattern subscription on a ValidationListener.
+//
+// Patterns are dot-separated event names whose segments may use glob syntax:
+// `*` matches within a single segment (so `document.*.approved` matches
+// `document.123.approved`), and a `**` segment matches any number of segments
+// (so `document.**` matches every event under `document.`).
+type ValidationSubscription struct {
+	*Reference
+	// Pattern is the event name pattern.
+	Pattern string
+	// Filters is the filter chain of the subscription; every filter must accept the event.
+	Filters []func(event string, result *ValidationResult) bool
+	// Handler receives the matching events. It is nil for subscriptions created with
+	// SubscribeEvent, whose handler also receives the event name.
+	Handler func(*ValidationResult)
+
+	eventHandler func(event string, result *ValidationResult)
+	segments     []string
+}
+
+// MarshalJSON encodes the subscription reference, pattern and filter count; handlers are
+// never encoded.
+func (s *ValidationSubscription) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(struct {
+		Reference *Reference `json:"reference,omitempty"`
+		Pattern   string     `json:"pattern"`
+		Filters   int        `json:"filters"`
+	}{Reference: s.Reference, Pattern: s.Pattern, Filters: len(s.Filters)})
+}
+
+// validateEventPattern checks the glob syntax of every segment of a pattern.
+func validateEventPattern(pattern string) ([]string, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern cannot be empty")
+	}
+	segments := strings.Split(pattern, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("pattern %s has an empty segment", pattern)
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+	}
+	return segments, nil
+}
+
+// ValidateEventPattern checks that a subscription pattern is well formed.
+func ValidateEventPattern(pattern string) error {
+	_, err := validateEventPattern(pattern)
+	return err
+}
+
+// eventSegmentEscaper percent-encodes the characters that would split an event name segment
+// or be read as glob syntax by a pattern.
+var eventSegmentEscaper = strings.NewReplacer(
+	"%", "%25", ".", "%2E", "*", "%2A", "?", "%3F", "[", "%5B", "]", "%5D", "\\", "%5C", "/", "%2F",
+)
+
+// EscapeEventSegment escapes a value, such as a document ID, for use as a single segment of
+// an event name, so that `document.*.approved` matches whatever the ID contains.
+func EscapeEventSegment(value string) string {
+	if value == "" {
+		return "%00"
+	}
+	return eventSegmentEscaper.Replace(value)
+}
+
+// MatchEventPattern reports whether an event name matches a subscription pattern.
+func MatchEventPattern(pattern, event string) bool {
+	segments, err := validateEventPattern(pattern)
+	if err != nil {
+		return false
+	}
+	return matchSegments(segments, strings.Split(event, "."))
+}
+
+// matchSegments matches event segments against pattern segments, expanding `**`.
+func matchSegments(pattern, event []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(event); i++ {
+				if matchSegments(rest, event[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(event) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], event[0]); !ok {
+			return false
+		}
+		pattern, event = pattern[1:], event[1:]
+	}
+	return len(event) == 0
+}
+
+// matches reports whether the subscription accepts an event.
+func (s *ValidationSubscription) matches(event string, result *ValidationResult) bool {
+	if !matchSegments(s.segments, strings.Split(event, ".")) {
+		return false
+	}
+	for _, filter := range s.Filters {
+		if filter != nil && !filter(event, result) {
+			return false
+		}
+	}
+	return true
+}
+
+// Subscribe registers a handler for every event matching the pattern, with an optional filter
+// chain. It returns the reference used to manage the subscription.
+func (vl *ValidationListener) Subscribe(pattern string, handler func(*ValidationResult), filters ...func(event string, result *ValidationResult) bool) (*Reference, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("handler is nil")
+	}
+	return vl.subscribe(&ValidationSubscription{Pattern: pattern, Handler: handler}, filters)
+}
+
+// SubscribeEvent is Subscribe for handlers that need the name of the matched event.
+func (vl *ValidationListener) SubscribeEvent(pattern string, handler func(event string, result *ValidationResult), filters ...func(event string, result *ValidationResult) bool) (*Reference, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("handler is nil")
+	}
+	return vl.subscribe(&ValidationSubscription{Pattern: pattern, eventHandler: handler}, filters)
+}
+
+// subscribe registers a subscription with its handler set.
+func (vl *ValidationListener) subscribe(subscription *ValidationSubscription, filters []func(string, *ValidationResult) bool) (*Reference, error) {
+	if vl == nil {
+		return nil, fmt.Errorf("ValidationListener is nil")
+	}
+	segments, err := validateEventPattern(subscription.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	subscription.Filters = append([]func(string, *ValidationResult) bool(nil), filters...)
+	subscription.segments = segments
+
+	vl.Mutexes.MuLock()
+	defer vl.Mutexes.MuUnlock()
+
+	if vl.subscriptions == nil {
+		vl.subscriptions = make(map[uuid.UUID]*ValidationSubscription)
+	}
+	// The reference is derived from the registration order, so subscriptions made in the
+	// same order get the same references, and two subscriptions of a pattern stay apart.
+	vl.nextSubscriptionID++
+	subscription.Reference, _ = NewReferencePath(uuid.Nil, "subscription", strconv.FormatUint(vl.nextSubscriptionID, 10), subscription.Pattern)
+	vl.subscriptions[subscription.ID] = subscription
+	return subscription.Reference, nil
+}
+
+// Unsubscribe removes a subscription.
+func (vl *ValidationListener) Unsubscribe(reference *Reference) {
+	if vl == nil || reference == nil {
+		gl.Log("error", "Unsubscribe: ValidationListener or reference is nil")
+		return
+	}
+	vl.Mutexes.MuLock()
+	defer vl.Mutexes.MuUnlock()
+
+	delete(vl.subscriptions, reference.ID)
+}
+
+// AddSubscriptionFilter appends a filter to the chain of a subscription.
+func (vl *ValidationListener) AddSubscriptionFilter(reference *Reference, filter func(event string, result *ValidationResult) bool) error {
+	if vl == nil || reference == nil {
+		return fmt.Errorf("ValidationListener or reference is nil")
+	}
+	if filter == nil {
+		return fmt.Errorf("filter is nil")
+	}
+	vl.Mutexes.MuLock()
+	defer vl.Mutexes.MuUnlock()
+
+	subscription, ok := vl.subscriptions[reference.ID]
+	if !ok {
+		return fmt.Errorf("subscription %s does not exist", reference.ID)
+	}
+	subscription.Filters = append(subscription.Filters, filter)
+	return nil
+}
+
+// GetSubscriptions returns a snapshot of the subscriptions, sorted by pattern.
+func (vl *ValidationListener) GetSubscriptions() []ValidationSubscription {
+	if vl == nil {
+		return nil
+	}
+	vl.Mutexes.MuLock()
+	defer vl.Mutexes.MuUnlock()
+
+	subscriptions := make([]ValidationSubscription, 0, len(vl.subscriptions))
+	for _, s := range vl.subscriptions {
+		snapshot := *s
+		snapshot.Filters = append([]func(string, *ValidationResult) bool(nil), s.Filters...)
+		subscriptions = append(subscriptions, snapshot)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if subscriptions[i].Pattern == subscriptions[j].Pattern {
+			return subscriptions[i].ID.String() < subscriptions[j].ID.String()
+		}
+		return subscriptions[i].Pattern < subscriptions[j].Pattern
+	})
+	return subscriptions
+}
+
+// matchingSubscriptions returns snapshots of the subscriptions accepting the event, keyed for
+// ordered delivery. The filter chains are copied, so they can run after the lock is released.
+// The caller must hold the listener lock.
+func (vl *ValidationListener) matchingSubscriptions(event string) map[string]*ValidationSubscription {
+	matching := make(map[string]*ValidationSubscription)
+	segments := strings.Split(event, ".")
+	for id, s := range vl.subscriptions {
+		if matchSegments(s.segments, segments) {
+			snapshot := *s
+			snapshot.Filters = append([]func(string, *ValidationResult) bool(nil), s.Filters...)
+			matching["subscription/"+id.String()] = &snapshot
+		}
+	}
+	return matching
+}
+
+// dispatchSubscriptions runs the filter chains and dispatches the event to the accepting subscriptions.
+func (vl *ValidationListener) dispatchSubscriptions(event string, subscriptions map[string]*ValidationSubscription, result *ValidationResult) {
+	keys := make([]string, 0, len(subscriptions))
+	for key := range subscriptions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := subscriptions[key]
+		if !s.matches(event, result) {
+			continue
+		}
+		handler := s.Handler
+		if s.eventHandler != nil {
+			handler = func(result *ValidationResult) { s.eventHandler(event, result) }
+		}
+		vl.dispatch(key, handler, result)
+	}
+}
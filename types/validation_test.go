@@ -0,0 +1,36 @@
+package types
+
+import (
+	"sync"
+	"testing"
+
+	ci "github.com/rafa-mori/smart_plane/internal/interfaces"
+)
+
+func TestValidationReadsRaceFreeWithChanges(t *testing.T) {
+	v := NewValidation[int]().(*Validation[int])
+	valid := func(value *int, args ...any) ci.IValidationResult {
+		return NewValidationResult(true, "", nil, nil)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			_ = v.AddValidator(NewValidationFunc(1, valid))
+			_ = v.RemoveValidator(1)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			_, _ = v.GetValidator(1)
+			_ = v.GetValidators()
+			_ = v.GetResults()
+			_ = v.IsValid()
+			v.ClearResults()
+		}
+	}()
+	wg.Wait()
+}